@@ -1,15 +1,21 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"kdg/be/lab/internal/blobstore"
+	"kdg/be/lab/internal/models"
+	"kdg/be/lab/internal/sniff"
 	"kdg/be/lab/internal/validator"
 	"net/http"
+	"strings"
 
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/justinas/nosurf"
 )
 
 type adminPanelForm struct {
@@ -26,7 +32,17 @@ type FileUploadMetadata struct {
 	StorageLocation string   `json:"storage_location"`
 	ProjectID       string   `json:"project_id"`
 	OwnerID         string   `json:"owner_id,omitempty"` // Added server-side
+	FileID          string   `json:"file_id,omitempty"`  // Added server-side once stored
 	CSRFToken       string   `json:"csrf_token"`
+	Size            int64    `json:"size"`                // Declared total size in bytes
+	SHA256          string   `json:"sha256"`              // Declared hex encoded SHA-256 of the file
+	ChunkSize       int      `json:"chunk_size"`          // Size of every chunk except the last
+	UploadID        string   `json:"upload_id,omitempty"` // Set to resume an interrupted upload
+	MimeType        string   `json:"mime_type,omitempty"` // Added server-side from the sniffed content
+	Chunk           bool     `json:"chunk"`               // Extract and chunk the text after upload
+	ChunkMethod     string   `json:"chunk_method"`        // One of the pipeline chunk methods
+	ChunkCount      int      `json:"chunk_count"`         // Units per chunk
+	ChunkOverlap    int      `json:"chunk_overlap"`       // Units shared by consecutive chunks
 }
 
 // Response from the external service
@@ -45,19 +61,7 @@ func (app *application) adminPanel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	data := app.newTemplateData(r)
-	data.Form = adminPanelForm{}
-
-	// Fetch projects
-	projects, err := app.projects.GetByUserID(userID)
-	if err != nil {
-		app.serverError(w, err)
-		return
-	}
-	
-	data.Projects = projects
-	
-	app.render(w, http.StatusOK, "admin.tmpl.html", data)
+	app.renderPanel(w, r, http.StatusOK, webhookCreateForm{Events: []string{models.EventFileProcessed}})
 }
 
 // WebSocket handler for file uploads
@@ -77,22 +81,15 @@ func (app *application) handleFileUpload(w http.ResponseWriter, r *http.Request)
 	}
 	
 	// Upgrade HTTP connection to WebSocket
-	ws, err := upgrader.Upgrade(w, r, nil)
+	ws, err := app.upgradeWebSocket(w, r)
 	if err != nil {
 			app.errorLog.Printf("WebSocket upgrade error: %v", err)
 			return
 	}
 	defer ws.Close()
-	
-	// Connect to external WebSocket service
-	otherServer := "ws://localhost" + app.chatPort.Port + "/ws/upload"
-	externalWS, _, err := websocket.DefaultDialer.Dial(otherServer, nil)
-	if err != nil {
-			app.errorLog.Printf("Failed to connect to external service: %v", err)
-			sendError(ws, "Failed to connect to document processing service")
-			return
-	}
-	defer externalWS.Close()
+
+	// Chunks carry an 8 byte index in front of the payload
+	ws.SetReadLimit(maxUploadChunkSize + 1024)
 	
 	app.infoLog.Println("WebSocket connection established for file upload")
 	
@@ -127,91 +124,244 @@ func (app *application) handleFileUpload(w http.ResponseWriter, r *http.Request)
 			sendError(ws, "At least one document role must be selected")
 			return
 	}
+
+	// Only the roles in the allowlist can be uploaded
+	for i, role := range fileMetadata.Roles {
+		if !app.allowlist.KnownRole(role) {
+			sendError(ws, fmt.Sprintf("Unknown document role %q", role))
+			return
+		}
+		fileMetadata.Roles[i] = strings.ToUpper(role)
+	}
 	
+	// Check the chunking options before anything is uploaded
+	if fileMetadata.Chunk {
+		if fileMetadata.ChunkMethod == "" {
+			fileMetadata.ChunkMethod = defaultChunkMethod
+		}
+		if fileMetadata.ChunkCount == 0 {
+			fileMetadata.ChunkCount = defaultChunkCount
+		}
+		if _, err := fileMetadata.chunkOptions().stage(); err != nil {
+			sendError(ws, err.Error())
+			return
+		}
+	}
+
 	// Remove CSRF token before forwarding (security measure)
 	fileMetadata.CSRFToken = ""
+
+	// Create or resume the upload session and receive the chunks
+	upload := app.startUpload(ws, userID, &fileMetadata)
+	if upload == nil {
+		return
+	}
+
+	tmp := app.receiveUpload(ws, upload)
+	if tmp == nil {
+		return
+	}
+	defer tmp.Remove()
+
+	// The temporary file now belongs to the stored file, not the session
+	if err := app.uploads.Delete(upload.ID); err != nil {
+		app.errorLog.Printf("Error removing upload session %s: %v", upload.ID, err)
+	}
+
+	// Check the real content against the allowlist of the selected roles
+	docType, err := sniff.Detect(tmp.File, tmp.Size)
+	if err != nil {
+		app.errorLog.Printf("Error detecting file type: %v", err)
+		sendError(ws, "Internal server error")
+		return
+	}
+	if !app.allowlist.Allowed(docType, fileMetadata.Roles) {
+		app.infoLog.Printf("Rejected %s upload %s for roles %v", docType, fileMetadata.Name, fileMetadata.Roles)
+		app.rejectFileType(ws, docType, fileMetadata.Roles)
+		return
+	}
+	fileMetadata.MimeType = sniff.MimeTypes[docType]
 	
-	// Forward file chunks to external service
-	app.forwardFileUpload(ws, externalWS, fileMetadata)
+	// Store the file and forward it to the external service
+	app.forwardFileUpload(ws, fileMetadata, tmp)
 }
 
-// Forward file upload from client to external service
-func (app *application) forwardFileUpload(clientWS, externalWS *websocket.Conn, metadata FileUploadMetadata) {
-	// Send metadata to external service
+// Forward a completely received upload to blob storage and the external service
+func (app *application) forwardFileUpload(clientWS *websocket.Conn, metadata FileUploadMetadata, tmp *blobstore.TempBlob) {
+	ctx := context.Background()
+
+	store, storageLocation, err := app.blobStore(metadata.StorageLocation)
+	if err != nil {
+		app.errorLog.Printf("Invalid storage location: %v", err)
+		sendError(clientWS, err.Error())
+		return
+	}
+
+	projectID, err := uuid.Parse(metadata.ProjectID)
+	if err != nil {
+		sendError(clientWS, "Invalid project ID")
+		return
+	}
+
+	ownerID, err := uuid.Parse(metadata.OwnerID)
+	if err != nil {
+		sendError(clientWS, "Invalid owner ID")
+		return
+	}
+
+	// Scan the document before it is stored or processed
+	verdict, err := app.scanTemp(ctx, tmp)
+	if err != nil {
+		app.errorLog.Printf("Error scanning upload %s: %v", metadata.Name, err)
+		sendError(clientWS, "The document could not be scanned, please try again later")
+		return
+	}
+
+	key, deduplicated, err := blobstore.PutTemp(ctx, store, tmp)
+	if err != nil {
+		app.errorLog.Printf("Error storing blob: %v", err)
+		sendError(clientWS, "Error storing file")
+		return
+	}
+	if deduplicated {
+		app.infoLog.Printf("Blob %s already stored, reusing it", key)
+	}
+
+	file := &models.File{
+		Name:            metadata.Name,
+		FilePath:        key,
+		MimeType:        metadata.MimeType,
+		Size:            tmp.Size,
+		Role:            strings.Join(metadata.Roles, ","),
+		StorageLocation: storageLocation,
+		ProjectID:       projectID,
+		UserID:          ownerID,
+		Status:          "uploaded",
+		ContentHash:     tmp.Hash,
+	}
+	if !verdict.Clean {
+		file.Status = "quarantined"
+	}
+
+	if err := app.files.Insert(file); err != nil {
+		app.errorLog.Printf("Error saving file record: %v", err)
+		sendError(clientWS, "Error saving file record")
+		return
+	}
+
+	app.logFileEvent(file, ownerID, models.FileUploaded, fmt.Sprintf("Stored in %s storage", storageLocation))
+
+	// Quarantined documents are kept for review but never processed
+	if !verdict.Clean {
+		app.infoLog.Printf("Quarantined file %s (%s): %s", file.ID, file.Name, verdict.Signature)
+		app.logFileEvent(file, ownerID, models.FileQuarantined, verdict.Signature)
+		sendJSON(clientWS, map[string]interface{}{
+			"status":   "error",
+			"code":     "quarantined",
+			"error":    fmt.Sprintf("The document was quarantined: %s detected", verdict.Signature),
+			"metadata": fileMetadataJSON(file, metadata.Roles),
+		})
+		return
+	}
+
+	// Queue text extraction, chunking and embedding when requested on the
+	// admin panel; progress is reported on the processing feed
+	if metadata.Chunk {
+		if err := app.enqueueProcessing(file, metadata.chunkOptions()); err != nil {
+			app.errorLog.Printf("Error queueing processing of file %s: %v", file.ID, err)
+			sendJSON(clientWS, map[string]interface{}{
+				"status":  "processing",
+				"step":    "queue",
+				"message": "The document could not be queued for processing",
+			})
+		} else {
+			sendJSON(clientWS, map[string]interface{}{
+				"status":  "queued",
+				"file_id": file.ID,
+				"message": "Queued for processing",
+			})
+		}
+	}
+
+	// Spatial files become map layers of the project. The processing
+	// service only knows the document roles, so files uploaded for their
+	// layers alone are done here.
+	if contains(metadata.Roles, spatialRole) {
+		imported := app.importSpatialFile(ctx, clientWS, file, ownerID)
+		if len(metadata.Roles) == 1 {
+			if !imported {
+				sendError(clientWS, "The file was stored but no map layer could be made from it")
+				return
+			}
+			sendJSON(clientWS, map[string]interface{}{
+				"status":   "uploaded",
+				"metadata": fileMetadataJSON(file, metadata.Roles),
+			})
+			return
+		}
+	}
+
+	metadata.FileID = file.ID.String()
+	metadata.StorageLocation = storageLocation
+
 	metadataBytes, err := json.Marshal(metadata)
 	if err != nil {
 		app.errorLog.Printf("Error marshaling metadata: %v", err)
 		sendError(clientWS, "Internal server error")
 		return
 	}
-	
-	// Buffer to accumulate file chunks
-	var fileBuffer bytes.Buffer
-	var chunkCount int
-	
-	// Receive file chunks from client and forward to external service
-	for {
-		messageType, message, err := clientWS.ReadMessage()
-		if err != nil {
-			app.errorLog.Printf("Error reading from client: %v", err)
-			return
-		}
-		
-		// Check if we've received the "EOF" marker
-		if messageType == websocket.BinaryMessage && bytes.Equal(message, []byte("EOF")) {
-			break
-		}
-		
-		// Add chunk to buffer
-		if messageType == websocket.BinaryMessage {
-			fileBuffer.Write(message)
-			chunkCount++
-			
-			// Send progress updates back to client
+
+	// Connect to external WebSocket service
+	otherServer := "ws://localhost" + app.chatPort.Port + "/ws/upload"
+	externalWS, _, err := websocket.DefaultDialer.Dial(otherServer, nil)
+	if err != nil {
+		app.errorLog.Printf("Failed to connect to external service: %v", err)
+		sendError(clientWS, "Failed to connect to document processing service")
+		return
+	}
+	defer externalWS.Close()
+
+	// Stream the stored file to the external service in chunks
+	reader, err := tmp.Reader()
+	if err != nil {
+		app.errorLog.Printf("Error rewinding temporary file: %v", err)
+		sendError(clientWS, "Internal server error")
+		return
+	}
+
+	chunkSize := 64 * 1024 // 64KB chunks
+	buf := make([]byte, chunkSize)
+	totalChunks := int((tmp.Size + int64(chunkSize) - 1) / int64(chunkSize))
+
+	for i := 0; ; i++ {
+		n, err := io.ReadFull(reader, buf)
+		if n > 0 {
+			// Send chunk to external service
+			if err := externalWS.WriteMessage(websocket.BinaryMessage, buf[:n]); err != nil {
+				app.errorLog.Printf("Error sending chunk to external service: %v", err)
+				sendError(clientWS, "Error sending file to processing service")
+				return
+			}
+
+			// Send progress to client
+			progress := int((float64(i+1) / float64(totalChunks)) * 100)
 			progressMsg := map[string]interface{}{
-				"status":      "uploading",
-				"chunk_count": chunkCount,
-				"message":     fmt.Sprintf("Received chunk %d (%d bytes)", chunkCount, len(message)),
+				"status":   "forwarding",
+				"progress": progress,
+				"message":  fmt.Sprintf("Forwarding to processing service: %d%%", progress),
 			}
 			if err := sendJSON(clientWS, progressMsg); err != nil {
 				app.errorLog.Printf("Error sending progress update: %v", err)
 			}
 		}
-	}
-	
-	// Now we have the complete file in fileBuffer
-	app.infoLog.Printf("Received complete file (%d bytes) in %d chunks", fileBuffer.Len(), chunkCount)
-	
-	// Send the file to external service in chunks
-	fileBytes := fileBuffer.Bytes()
-	chunkSize := 64 * 1024 // 64KB chunks
-	
-	// Progress tracker
-	totalChunks := (fileBuffer.Len() + chunkSize - 1) / chunkSize
-	for i := 0; i < totalChunks; i++ {
-		start := i * chunkSize
-		end := (i + 1) * chunkSize
-		if end > fileBuffer.Len() {
-			end = fileBuffer.Len()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
 		}
-		
-		// Send chunk to external service
-		if err := externalWS.WriteMessage(websocket.BinaryMessage, fileBytes[start:end]); err != nil {
-			app.errorLog.Printf("Error sending chunk to external service: %v", err)
-			sendError(clientWS, "Error sending file to processing service")
+		if err != nil {
+			app.errorLog.Printf("Error reading temporary file: %v", err)
+			sendError(clientWS, "Error reading stored file")
 			return
 		}
-		
-		// Send progress to client
-		progress := int((float64(i+1) / float64(totalChunks)) * 100)
-		progressMsg := map[string]interface{}{
-			"status":   "forwarding",
-			"progress": progress,
-			"message":  fmt.Sprintf("Forwarding to processing service: %d%%", progress),
-		}
-		if err := sendJSON(clientWS, progressMsg); err != nil {
-			app.errorLog.Printf("Error sending progress update: %v", err)
-		}
 	}
 	
 	// Send EOF marker to external service
@@ -248,57 +398,21 @@ func (app *application) forwardFileUpload(clientWS, externalWS *websocket.Conn,
 	}
 }
 
-// Handler for document processing
-func (app *application) handleDocumentProcessing(w http.ResponseWriter, r *http.Request) {
-	// Extract project ID from URL
-	projectID := r.PathValue("id")
-	if projectID == "" {
-		app.clientError(w, http.StatusBadRequest)
-		return
-	}
-	
-	// Verify user is authenticated
-	if !app.isAuthenticated(r) {
-		app.clientError(w, http.StatusUnauthorized)
-		return
-	}
-	
-	// Upgrade HTTP connection to WebSocket
-	ws, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		app.errorLog.Printf("WebSocket upgrade error: %v", err)
-		return
-	}
-	defer ws.Close()
-	
-	// Connect to external WebSocket service
-	externalWS, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://external-api-host/ws/embed_chunks/%s", projectID), nil)
-	if err != nil {
-		app.errorLog.Printf("Failed to connect to external service: %v", err)
-		sendError(ws, "Failed to connect to document processing service")
-		return
-	}
-	defer externalWS.Close()
-	
-	app.infoLog.Printf("Processing documents for project: %s", projectID)
-	
-	// Forward messages from external service to client
-	for {
-		_, message, err := externalWS.ReadMessage()
-		if err != nil {
-			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
-				app.infoLog.Println("External service closed connection normally")
-			} else {
-				app.errorLog.Printf("Error reading from external service: %v", err)
-			}
-			return
-		}
-		
-		// Forward the message to the client
-		if err := ws.WriteMessage(websocket.TextMessage, message); err != nil {
-			app.errorLog.Printf("Error sending message to client: %v", err)
-			return
-		}
+// chunkOptions returns the chunking settings sent with the upload
+func (m FileUploadMetadata) chunkOptions() chunkOptions {
+	return chunkOptions{Method: m.ChunkMethod, Count: m.ChunkCount, Overlap: m.ChunkOverlap}
+}
+
+// fileMetadataJSON describes a stored file in the shape the upload page expects
+func fileMetadataJSON(file *models.File, roles []string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":               file.ID,
+		"name":             file.Name,
+		"project_id":       file.ProjectID,
+		"roles":            roles,
+		"storage_location": file.StorageLocation,
+		"size":             file.Size,
+		"sha256":           file.ContentHash,
 	}
 }
 
@@ -320,9 +434,7 @@ func sendJSON(ws *websocket.Conn, data interface{}) error {
 	return ws.WriteMessage(websocket.TextMessage, jsonBytes)
 }
 
-// Validate CSRF token
+// Validate CSRF token against the token nosurf issued for the session cookie
 func (app *application) validateCSRFToken(r *http.Request, token string) bool {
-	// In a real application, you would validate the token against the session
-	// This is a simplified implementation
-	return token != ""
-}
\ No newline at end of file
+	return token != "" && nosurf.VerifyToken(nosurf.Token(r), token)
+}
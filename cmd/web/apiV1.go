@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kdg/be/lab/internal/models"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// apiPrefix is where the versioned REST API is served
+const apiPrefix = "/api/v1"
+
+// List page sizes
+const (
+	apiDefaultLimit = 50
+	apiMaxLimit     = 200
+)
+
+const apiTokenKey contextKey = "apiToken"
+
+// apiErrorCodes are the machine readable codes of API errors by status
+var apiErrorCodes = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusUnauthorized:        "unauthorized",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "not_found",
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusUnprocessableEntity: "invalid",
+	http.StatusInternalServerError: "internal_error",
+	http.StatusBadGateway:          "upstream_error",
+}
+
+// apiErrorBody is the shape of every API error response
+type apiErrorBody struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+type apiErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// apiList is the shape of every API list response. NextCursor is empty on
+// the last page.
+type apiList struct {
+	Data       interface{} `json:"data"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+func (app *application) apiError(w http.ResponseWriter, status int, message string) {
+	code, ok := apiErrorCodes[status]
+	if !ok {
+		code = strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+	}
+	app.writeJSON(w, status, apiErrorBody{apiErrorDetail{code, message}})
+}
+
+func (app *application) apiServerError(w http.ResponseWriter, err error) {
+	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
+	app.errorLog.Output(2, trace)
+
+	app.apiError(w, http.StatusInternalServerError, "The server encountered a problem")
+}
+
+func (app *application) apiNotFound(w http.ResponseWriter) {
+	app.apiError(w, http.StatusNotFound, "The requested resource could not be found")
+}
+
+// apiParseUUID parses an ID from the path, answering 400 when it is invalid
+func (app *application) apiParseUUID(w http.ResponseWriter, id string) (uuid.UUID, bool) {
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		app.apiError(w, http.StatusBadRequest, "Invalid ID")
+		return uuid.Nil, false
+	}
+	return parsed, true
+}
+
+// apiReadJSON decodes a JSON request body, answering 400 when it is not
+// valid JSON
+func (app *application) apiReadJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+	if err := json.NewDecoder(r.Body).Decode(dst); err != nil {
+		app.apiError(w, http.StatusBadRequest, "The body must be a JSON object of at most 1 MB")
+		return err
+	}
+	return nil
+}
+
+// apiToken returns the token the request was authenticated with
+func apiToken(r *http.Request) *models.Token {
+	token, _ := r.Context().Value(apiTokenKey).(*models.Token)
+	return token
+}
+
+// requireToken authenticates requests with a bearer personal access token
+// that grants the scope. Session cookies are not accepted, so API requests
+// need no CSRF protection.
+func (app *application) requireToken(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "no-store")
+
+			plaintext, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || !strings.HasPrefix(plaintext, models.TokenPrefix) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+				app.apiError(w, http.StatusUnauthorized, "A personal access token is required as a Bearer token")
+				return
+			}
+
+			token, err := app.tokens.Authenticate(strings.TrimSpace(plaintext))
+			if err != nil {
+				if errors.Is(err, models.ErrInvalidCredentials) {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+					app.apiError(w, http.StatusUnauthorized, "The token is unknown, revoked or expired")
+				} else {
+					app.apiServerError(w, err)
+				}
+				return
+			}
+
+			if !token.HasScope(scope) {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="api", error="insufficient_scope", scope="%s"`, scope))
+				app.apiError(w, http.StatusForbidden, fmt.Sprintf("The token lacks the %s scope", scope))
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), apiTokenKey, token)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// pageKey orders list items: by time, then by ID for items with the same time
+type pageKey struct {
+	Time time.Time
+	ID   string
+}
+
+// before reports whether k comes before o in ascending order
+func (k pageKey) before(o pageKey) bool {
+	if !k.Time.Equal(o.Time) {
+		return k.Time.Before(o.Time)
+	}
+	return k.ID < o.ID
+}
+
+func (k pageKey) cursor() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(k.Time.UTC().Format(time.RFC3339Nano) + "|" + k.ID))
+}
+
+func parseCursor(cursor string) (pageKey, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return pageKey{}, err
+	}
+	ts, id, ok := strings.Cut(string(b), "|")
+	if !ok {
+		return pageKey{}, errors.New("missing separator")
+	}
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return pageKey{}, err
+	}
+	return pageKey{t, id}, nil
+}
+
+// apiPage returns the page of items requested by the cursor and limit query
+// parameters. Items are ordered by key, newest first when desc is set. The
+// cursor holds the key of the last item returned, so pages stay consistent
+// when items are added or removed in between.
+func apiPage[T any](r *http.Request, items []T, key func(T) pageKey, desc bool) (apiList, error) {
+	limit := apiDefaultLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > apiMaxLimit {
+			return apiList{}, fmt.Errorf("limit must be between 1 and %d", apiMaxLimit)
+		}
+		limit = n
+	}
+
+	sorted := make([]T, len(items))
+	copy(sorted, items)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if desc {
+			return key(sorted[j]).before(key(sorted[i]))
+		}
+		return key(sorted[i]).before(key(sorted[j]))
+	})
+
+	if s := r.URL.Query().Get("cursor"); s != "" {
+		after, err := parseCursor(s)
+		if err != nil {
+			return apiList{}, errors.New("invalid cursor")
+		}
+		start := sort.Search(len(sorted), func(i int) bool {
+			if desc {
+				return key(sorted[i]).before(after)
+			}
+			return after.before(key(sorted[i]))
+		})
+		sorted = sorted[start:]
+	}
+
+	page := apiList{Data: sorted}
+	if len(sorted) > limit {
+		sorted = sorted[:limit]
+		page = apiList{Data: sorted, NextCursor: key(sorted[limit-1]).cursor()}
+	}
+	return page, nil
+}
+
+// writeAPIPage answers with a page of items, or 400 for a bad cursor or limit
+func writeAPIPage[T any](app *application, w http.ResponseWriter, r *http.Request, items []T, key func(T) pageKey, desc bool) {
+	page, err := apiPage(r, items, key, desc)
+	if err != nil {
+		app.apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	app.writeJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kdg/be/lab/internal/geocode"
+	"kdg/be/lab/internal/mapstyle"
+	"kdg/be/lab/internal/models"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// apiQuestionRequest asks the assistant a question in a chat
+type apiQuestionRequest struct {
+	Question  string    `json:"question"`
+	ProjectID uuid.UUID `json:"project_id"`
+	DBUsed    bool      `json:"db_used"`   // Query the project's database
+	DocsUsed  bool      `json:"docs_used"` // Search the project's documents
+}
+
+// apiAnswer is the outcome of a question: the answer, the status updates
+// of the pipeline in order and the geo objects with their drawing
+type apiAnswer struct {
+	MessageID   int                        `json:"message_id,omitempty"` // Stored answer; absent when nothing was stored
+	Answer      string                     `json:"answer"`
+	Statuses    []string                   `json:"statuses"`
+	GeoObjects  map[string]GeoObject       `json:"geo_objects,omitempty"`
+	Citations   []models.Citation          `json:"citations"`
+	Places      []geocode.Mention          `json:"places,omitempty"`
+	Styles      map[string]*mapstyle.Style `json:"styles,omitempty"`
+	Legend      []mapstyle.Legend          `json:"legend,omitempty"`
+	Interrupted bool                       `json:"interrupted,omitempty"`
+}
+
+// add gathers a processed response of the pipeline
+func (a *apiAnswer) add(resp FinalResponse) {
+	if resp.Status != "" {
+		a.Statuses = append(a.Statuses, resp.Status)
+	}
+	if len(resp.GeoObjects) > 0 {
+		a.GeoObjects = resp.GeoObjects
+		a.Styles = resp.Styles
+		a.Legend = resp.Legend
+	}
+	if len(resp.Places) > 0 {
+		a.Places = resp.Places
+	}
+}
+
+// sseWriter writes server-sent events
+type sseWriter struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (s *sseWriter) event(name string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", name, b); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// apiChatAsk asks the assistant a question through the same pipeline as the
+// chat page and stores the question and answer. It answers with JSON once
+// the pipeline is done, or with Accept: text/event-stream streams a
+// "response" event for each update of the pipeline, then a "done" event
+// with the answer. Closing the connection interrupts the pipeline.
+func (app *application) apiChatAsk(w http.ResponseWriter, r *http.Request) {
+	chat := app.apiChatForToken(w, r)
+	if chat == nil {
+		return
+	}
+
+	var req apiQuestionRequest
+	if err := app.apiReadJSON(w, r, &req); err != nil {
+		return
+	}
+	req.Question = strings.TrimSpace(req.Question)
+	if req.Question == "" {
+		app.apiError(w, http.StatusUnprocessableEntity, "A question is required")
+		return
+	}
+	if req.ProjectID == uuid.Nil {
+		app.apiError(w, http.StatusUnprocessableEntity, "A project_id is required")
+		return
+	}
+	if !app.apiCheckProject(w, r, req.ProjectID) {
+		return
+	}
+	if _, err := app.projectDatabase.GetDbIDFromProject(req.ProjectID); err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiError(w, http.StatusUnprocessableEntity, "The project has no database connected")
+		} else {
+			app.apiServerError(w, err)
+		}
+		return
+	}
+
+	// Answers take longer than the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		app.errorLog.Printf("Could not extend write deadline: %v", err)
+	}
+
+	question := chatQuestion{
+		ChatID:    chat.ID,
+		ProjectID: req.ProjectID,
+		UserID:    apiToken(r).UserID,
+		Text:      req.Question,
+		DBUsed:    req.DBUsed,
+		DocsUsed:  req.DocsUsed,
+	}
+	result := apiAnswer{Statuses: []string{}, Citations: []models.Citation{}}
+
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		answer, err := app.askChat(question, r.Context().Done(), func(resp FinalResponse) error {
+			result.add(resp)
+			return nil
+		})
+		if err != nil {
+			app.apiChatError(w, err)
+			return
+		}
+		if answer.Answer == "" && !answer.Interrupted {
+			message := "The assistant returned no answer"
+			if n := len(result.Statuses); n > 0 {
+				message += ": " + result.Statuses[n-1]
+			}
+			app.apiError(w, http.StatusBadGateway, message)
+			return
+		}
+
+		app.writeJSON(w, http.StatusCreated, finishAnswer(result, answer))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	sse := &sseWriter{w, rc}
+
+	answer, err := app.askChat(question, r.Context().Done(), func(resp FinalResponse) error {
+		result.add(resp)
+		return sse.event("response", resp)
+	})
+	if err != nil {
+		app.errorLog.Printf("Error forwarding message: %v", err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, errChatUpstream) {
+			status = http.StatusBadGateway
+		}
+		sse.event("error", apiErrorBody{apiErrorDetail{apiErrorCodes[status], "The chat server could not answer"}})
+		return
+	}
+	if err := sse.event("done", finishAnswer(result, answer)); err != nil {
+		app.errorLog.Println("write error:", err)
+	}
+}
+
+// finishAnswer completes the gathered responses with the stored answer
+func finishAnswer(result apiAnswer, answer *chatAnswer) apiAnswer {
+	result.MessageID = answer.MessageID
+	result.Answer = answer.Answer
+	result.Interrupted = answer.Interrupted
+	if answer.Citations != nil {
+		result.Citations = answer.Citations
+	}
+	if result.GeoObjects == nil {
+		result.GeoObjects = answer.GeoObjects
+	}
+	return result
+}
+
+// apiChatError answers with the error of a question that could not be asked
+func (app *application) apiChatError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errChatUpstream) {
+		app.errorLog.Printf("Error forwarding message: %v", err)
+		app.apiError(w, http.StatusBadGateway, "The chat server could not be reached")
+		return
+	}
+	app.apiServerError(w, err)
+}
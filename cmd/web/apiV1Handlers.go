@@ -0,0 +1,533 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kdg/be/lab/internal/models"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Resources of the REST API. They are kept apart from the models so that
+// the API does not change when the models do.
+
+type apiProject struct {
+	ID            uuid.UUID `json:"id"`
+	Name          string    `json:"name"`
+	Description   string    `json:"description"`
+	OwnerID       uuid.UUID `json:"owner_id"`
+	DocumentCount int       `json:"document_count"`
+	DefaultCRS    int       `json:"default_crs,omitempty"` // EPSG code
+	Created       time.Time `json:"created"`
+	Updated       time.Time `json:"updated"`
+}
+
+type apiChat struct {
+	ID           uuid.UUID `json:"id"`
+	Created      time.Time `json:"created"`
+	LastActivity time.Time `json:"last_activity"`
+}
+
+type apiMessage struct {
+	ID         int               `json:"id"`
+	ChatID     uuid.UUID         `json:"chat_id"`
+	Sender     string            `json:"sender"`
+	Content    string            `json:"content"`
+	Timestamp  time.Time         `json:"timestamp"`
+	Citations  []models.Citation `json:"citations"`
+	GeoObjects json.RawMessage   `json:"geo_objects"` // Feature collections by name, or null
+}
+
+type apiFile struct {
+	ID          uuid.UUID  `json:"id"`
+	ProjectID   uuid.UUID  `json:"project_id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	MimeType    string     `json:"mime_type"`
+	Size        int64      `json:"size"`
+	Role        string     `json:"role"`
+	Status      string     `json:"status"`
+	Version     int        `json:"version"`
+	ContentHash string     `json:"content_hash"`
+	UploadedAt  time.Time  `json:"uploaded_at"`
+	ProcessedAt *time.Time `json:"processed_at"`
+}
+
+type apiSchemaInfo struct {
+	ID         uuid.UUID `json:"id"`
+	Name       string    `json:"name"`
+	DatabaseID uuid.UUID `json:"database_id"`
+}
+
+type apiProjectRequest struct {
+	Name string `json:"name"`
+}
+
+func toAPIProject(p *models.Project) apiProject {
+	return apiProject{p.ID, p.Name, p.Description, p.UserID, p.DocumentCount, p.DefaultCRS, p.Created, p.Updated}
+}
+
+func toAPIChat(c *models.Chat) apiChat {
+	return apiChat{c.ID, c.Created, c.LastActivity}
+}
+
+func toAPIFile(f *models.File) apiFile {
+	file := apiFile{
+		ID:          f.ID,
+		ProjectID:   f.ProjectID,
+		Name:        f.Name,
+		Description: f.Description,
+		MimeType:    f.MimeType,
+		Size:        f.Size,
+		Role:        f.Role,
+		Status:      f.Status,
+		Version:     f.Version,
+		ContentHash: f.ContentHash,
+		UploadedAt:  f.UploadedAt,
+	}
+	if f.ProcessedAt.Valid {
+		file.ProcessedAt = &f.ProcessedAt.Time
+	}
+	return file
+}
+
+// apiRoutes lists the operations of the REST API
+func (app *application) apiRoutes() []apiRoute {
+	featureCollection := apiSchema{
+		"type":        "object",
+		"description": "GeoJSON FeatureCollection in WGS84 (RFC 7946)",
+	}
+
+	return []apiRoute{
+		{Method: http.MethodGet, Path: "/openapi.json", Tag: "meta", Summary: "This OpenAPI document",
+			Response: apiSchema{"type": "object"}, Handler: app.apiOpenAPI},
+
+		{Method: http.MethodGet, Path: "/projects", Tag: "projects", Summary: "List the user's projects",
+			Scope: models.ScopeRead, Response: apiProject{}, List: true, Handler: app.apiProjects},
+		{Method: http.MethodPost, Path: "/projects", Tag: "projects", Summary: "Create a project",
+			Scope: models.ScopeWrite, Request: apiProjectRequest{}, Response: apiProject{}, Status: http.StatusCreated,
+			Handler: app.apiProjectCreate},
+		{Method: http.MethodGet, Path: "/projects/:id", Tag: "projects", Summary: "Get a project",
+			Scope: models.ScopeRead, Response: apiProject{}, Handler: app.apiProject},
+
+		{Method: http.MethodGet, Path: "/projects/:id/files", Tag: "files", Summary: "List the documents of a project",
+			Scope: models.ScopeRead, Response: apiFile{}, List: true, Handler: app.apiProjectFiles},
+		{Method: http.MethodGet, Path: "/files/:id", Tag: "files", Summary: "Get a document",
+			Scope: models.ScopeRead, Response: apiFile{}, Handler: app.apiFile},
+		{Method: http.MethodDelete, Path: "/files/:id", Tag: "files", Summary: "Delete a document and its earlier versions",
+			Scope: models.ScopeWrite, Status: http.StatusNoContent, Handler: app.apiFileDelete},
+
+		{Method: http.MethodGet, Path: "/projects/:id/schemas", Tag: "schemas", Summary: "List the registered schemas of a project's database",
+			Scope: models.ScopeRead, Response: apiSchemaInfo{}, List: true, Handler: app.apiProjectSchemas},
+		{Method: http.MethodGet, Path: "/schemas/:id/tables", Tag: "schemas", Summary: "List the tables of a schema with their columns",
+			Scope: models.ScopeRead, Response: TableInfo{}, List: true, Handler: app.apiSchemaTables},
+
+		{Method: http.MethodGet, Path: "/projects/:id/layers", Tag: "layers", Summary: "List the map layers of a project",
+			Scope: models.ScopeRead, Response: models.Layer{}, List: true, Handler: app.apiProjectLayers},
+		{Method: http.MethodGet, Path: "/layers/:id", Tag: "layers", Summary: "Get a map layer",
+			Scope: models.ScopeRead, Response: models.Layer{}, Handler: app.apiLayer},
+		{Method: http.MethodGet, Path: "/layers/:id/features", Tag: "layers", Summary: "Get the features of a map layer",
+			Scope: models.ScopeRead, Response: featureCollection, Handler: app.apiLayerFeatures},
+
+		{Method: http.MethodGet, Path: "/chats", Tag: "chats", Summary: "List the user's chats",
+			Scope: models.ScopeRead, Response: apiChat{}, List: true, Handler: app.apiChats},
+		{Method: http.MethodPost, Path: "/chats", Tag: "chats", Summary: "Start a chat",
+			Scope: models.ScopeWrite, Response: apiChat{}, Status: http.StatusCreated, Handler: app.apiChatCreate},
+		{Method: http.MethodGet, Path: "/chats/:id", Tag: "chats", Summary: "Get a chat",
+			Scope: models.ScopeRead, Response: apiChat{}, Handler: app.apiChat},
+		{Method: http.MethodGet, Path: "/chats/:id/messages", Tag: "chats", Summary: "List the messages of a chat, oldest first",
+			Scope: models.ScopeRead, Response: apiMessage{}, List: true, Handler: app.apiChatMessages},
+		{Method: http.MethodPost, Path: "/chats/:id/messages", Tag: "chats", Summary: "Ask the assistant a question and wait for the answer",
+			Scope: models.ScopeWrite, Request: apiQuestionRequest{}, Response: apiAnswer{}, Status: http.StatusCreated, Stream: true,
+			Handler: app.apiChatAsk},
+	}
+}
+
+// apiProjectAccess parses a project ID and checks that the token's user may
+// see the project, answering with an API error otherwise
+func (app *application) apiProjectAccess(w http.ResponseWriter, r *http.Request, id string) (uuid.UUID, bool) {
+	projectID, ok := app.apiParseUUID(w, id)
+	if !ok {
+		return uuid.Nil, false
+	}
+	return projectID, app.apiCheckProject(w, r, projectID)
+}
+
+// apiCheckProject answers 404 when the token's user may not see a project,
+// so that the API does not reveal which projects exist
+func (app *application) apiCheckProject(w http.ResponseWriter, r *http.Request, projectID uuid.UUID) bool {
+	hasAccess, err := app.projects.HasAccess(projectID, apiToken(r).UserID)
+	if err != nil {
+		app.apiServerError(w, err)
+		return false
+	}
+	if !hasAccess {
+		app.apiNotFound(w)
+		return false
+	}
+	return true
+}
+
+func (app *application) apiProjects(w http.ResponseWriter, r *http.Request) {
+	projects, err := app.projects.GetByUserID(apiToken(r).UserID)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	items := make([]apiProject, len(projects))
+	for i, p := range projects {
+		items[i] = toAPIProject(p)
+	}
+	writeAPIPage(app, w, r, items, func(p apiProject) pageKey { return pageKey{p.Created, p.ID.String()} }, true)
+}
+
+func (app *application) apiProjectCreate(w http.ResponseWriter, r *http.Request) {
+	var req apiProjectRequest
+	if err := app.apiReadJSON(w, r, &req); err != nil {
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len([]rune(req.Name)) > 100 {
+		app.apiError(w, http.StatusUnprocessableEntity, "A project needs a name of at most 100 characters")
+		return
+	}
+
+	// Projects are created by the processing service, as from the panel
+	created, err := app.externalAPI.CreateExternalProject(apiToken(r).UserID, req.Name)
+	if err != nil {
+		app.errorLog.Printf("Failed to create project in external system: %v", err)
+		app.apiError(w, http.StatusBadGateway, "The processing service could not create the project")
+		return
+	}
+
+	projectID, err := uuid.Parse(created.ProjectID)
+	if err != nil {
+		app.apiServerError(w, fmt.Errorf("processing service returned project ID %q: %w", created.ProjectID, err))
+		return
+	}
+	project, err := app.projects.Get(projectID)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+	app.emitProjectCreated(project.ID, project.Name, apiToken(r).UserID)
+
+	app.writeJSON(w, http.StatusCreated, toAPIProject(project))
+}
+
+func (app *application) apiProject(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	projectID, ok := app.apiProjectAccess(w, r, params.ByName("id"))
+	if !ok {
+		return
+	}
+
+	project, err := app.projects.Get(projectID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiNotFound(w)
+		} else {
+			app.apiServerError(w, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, toAPIProject(project))
+}
+
+func (app *application) apiProjectFiles(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	projectID, ok := app.apiProjectAccess(w, r, params.ByName("id"))
+	if !ok {
+		return
+	}
+
+	files, err := app.files.GetByProject(projectID)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	items := make([]apiFile, len(files))
+	for i, f := range files {
+		items[i] = toAPIFile(f)
+	}
+	writeAPIPage(app, w, r, items, func(f apiFile) pageKey { return pageKey{f.UploadedAt, f.ID.String()} }, true)
+}
+
+// apiFileForToken loads the file in the path and checks that the token's
+// user may see it
+func (app *application) apiFileForToken(w http.ResponseWriter, r *http.Request) *models.File {
+	params := httprouter.ParamsFromContext(r.Context())
+	fileID, ok := app.apiParseUUID(w, params.ByName("id"))
+	if !ok {
+		return nil
+	}
+
+	file, err := app.files.GetByID(fileID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiNotFound(w)
+		} else {
+			app.apiServerError(w, err)
+		}
+		return nil
+	}
+
+	if file.UserID != apiToken(r).UserID && !app.apiCheckProject(w, r, file.ProjectID) {
+		return nil
+	}
+	return file
+}
+
+func (app *application) apiFile(w http.ResponseWriter, r *http.Request) {
+	file := app.apiFileForToken(w, r)
+	if file == nil {
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, toAPIFile(file))
+}
+
+func (app *application) apiFileDelete(w http.ResponseWriter, r *http.Request) {
+	file := app.apiFileForToken(w, r)
+	if file == nil {
+		return
+	}
+
+	if err := app.deleteFile(r, file, apiToken(r).UserID); err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) apiProjectSchemas(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	projectID, ok := app.apiProjectAccess(w, r, params.ByName("id"))
+	if !ok {
+		return
+	}
+
+	items := []apiSchemaInfo{}
+	databaseID, err := app.projectDatabase.GetDbIDFromProject(projectID)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.apiServerError(w, err)
+		return
+	}
+	if databaseID != nil {
+		schemas, err := app.schemas.ListSchemasByDatabaseID(*databaseID)
+		if err != nil {
+			app.apiServerError(w, err)
+			return
+		}
+		for _, s := range schemas {
+			items = append(items, apiSchemaInfo{s.ID, s.Name, s.DatabaseID})
+		}
+	}
+
+	writeAPIPage(app, w, r, items, func(s apiSchemaInfo) pageKey { return pageKey{ID: s.Name + "\x00" + s.ID.String()} }, false)
+}
+
+func (app *application) apiSchemaTables(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	schemaID, ok := app.apiParseUUID(w, params.ByName("id"))
+	if !ok {
+		return
+	}
+
+	projectID, err := app.schemas.GetProjectID(schemaID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiNotFound(w)
+		} else {
+			app.apiServerError(w, err)
+		}
+		return
+	}
+	if !app.apiCheckProject(w, r, projectID) {
+		return
+	}
+
+	tables, err := app.externalAPI.GetSchemaTables(schemaID)
+	if err != nil {
+		app.errorLog.Print(err)
+		app.apiError(w, http.StatusBadGateway, "The processing service could not list the tables")
+		return
+	}
+
+	writeAPIPage(app, w, r, tables, func(t TableInfo) pageKey { return pageKey{ID: t.TableName + "\x00" + t.TableID} }, false)
+}
+
+func (app *application) apiProjectLayers(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	projectID, ok := app.apiProjectAccess(w, r, params.ByName("id"))
+	if !ok {
+		return
+	}
+
+	layers, err := app.layers.GetByProject(projectID)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	writeAPIPage(app, w, r, layers, func(l *models.Layer) pageKey { return pageKey{l.Created, l.ID.String()} }, true)
+}
+
+// apiLayerForToken loads the layer in the path and checks that the token's
+// user may see its project
+func (app *application) apiLayerForToken(w http.ResponseWriter, r *http.Request) *models.Layer {
+	params := httprouter.ParamsFromContext(r.Context())
+	layerID, ok := app.apiParseUUID(w, params.ByName("id"))
+	if !ok {
+		return nil
+	}
+
+	layer, err := app.layers.Get(layerID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiNotFound(w)
+		} else {
+			app.apiServerError(w, err)
+		}
+		return nil
+	}
+
+	if !app.apiCheckProject(w, r, layer.ProjectID) {
+		return nil
+	}
+	return layer
+}
+
+func (app *application) apiLayer(w http.ResponseWriter, r *http.Request) {
+	layer := app.apiLayerForToken(w, r)
+	if layer == nil {
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, layer)
+}
+
+// apiLayerFeatures returns a layer's features. Database layers are capped
+// like on the map, which the X-Features-Truncated header reports.
+func (app *application) apiLayerFeatures(w http.ResponseWriter, r *http.Request) {
+	layer := app.apiLayerForToken(w, r)
+	if layer == nil {
+		return
+	}
+
+	fc, truncated, err := app.layerFeatures(r.Context(), layer, nil)
+	if err != nil {
+		if errors.Is(err, errLayerSource) {
+			app.apiError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		app.apiServerError(w, err)
+		return
+	}
+
+	if truncated {
+		w.Header().Set("X-Features-Truncated", "true")
+	}
+	app.writeJSON(w, http.StatusOK, fc)
+}
+
+func (app *application) apiChats(w http.ResponseWriter, r *http.Request) {
+	chats, err := app.chats.RetrieveByUserId(apiToken(r).UserID)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	items := make([]apiChat, len(chats))
+	for i, c := range chats {
+		items[i] = toAPIChat(c)
+	}
+	writeAPIPage(app, w, r, items, func(c apiChat) pageKey { return pageKey{c.Created, c.ID.String()} }, true)
+}
+
+func (app *application) apiChatCreate(w http.ResponseWriter, r *http.Request) {
+	chatID, err := app.chats.Insert(apiToken(r).UserID)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	chat, err := app.chats.GetByID(chatID)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, toAPIChat(chat))
+}
+
+// apiChatForToken loads the chat in the path and checks that it belongs to
+// the token's user
+func (app *application) apiChatForToken(w http.ResponseWriter, r *http.Request) *models.Chat {
+	params := httprouter.ParamsFromContext(r.Context())
+	chatID, ok := app.apiParseUUID(w, params.ByName("id"))
+	if !ok {
+		return nil
+	}
+
+	chat, err := app.chats.GetByID(chatID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiNotFound(w)
+		} else {
+			app.apiServerError(w, err)
+		}
+		return nil
+	}
+	if chat.UserID != apiToken(r).UserID {
+		app.apiNotFound(w)
+		return nil
+	}
+	return chat
+}
+
+func (app *application) apiChat(w http.ResponseWriter, r *http.Request) {
+	chat := app.apiChatForToken(w, r)
+	if chat == nil {
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, toAPIChat(chat))
+}
+
+func (app *application) apiChatMessages(w http.ResponseWriter, r *http.Request) {
+	chat := app.apiChatForToken(w, r)
+	if chat == nil {
+		return
+	}
+
+	messages, err := app.messages.GetByChatID(chat.ID)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	items := make([]apiMessage, len(messages))
+	for i, m := range messages {
+		citations := m.Citations
+		if len(citations) > 0 {
+			// Cited files may have been deleted since the answer was stored
+			citations = app.checkStoredCitations(citations, chat.UserID)
+		} else {
+			citations = []models.Citation{}
+		}
+		items[i] = apiMessage{m.ID, m.ChatID, m.SenderType, m.Content, m.Timestamp, citations, m.GeoObjects}
+	}
+	writeAPIPage(app, w, r, items, func(m apiMessage) pageKey {
+		return pageKey{m.Timestamp, fmt.Sprintf("%020d", m.ID)}
+	}, false)
+}
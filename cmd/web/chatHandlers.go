@@ -1,9 +1,15 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"kdg/be/lab/internal/crs"
+	"kdg/be/lab/internal/geoexport"
+	"kdg/be/lab/internal/geojson"
+	"kdg/be/lab/internal/models"
 	"net/http"
 
+	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -36,6 +42,13 @@ func (app *application) chat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Cited files may have been deleted since the answer was stored
+	for _, m := range messages {
+		if len(m.Citations) > 0 {
+			m.Citations = app.checkStoredCitations(m.Citations, userID)
+		}
+	}
+
 	// Fetch user's projects for the dropdown
 	projects, err := app.projects.GetByUserID(userID)
 	if err != nil {
@@ -43,13 +56,25 @@ func (app *application) chat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Map views saved with this chat, shown to its owner
+	var views []*models.MapView
+	if chat, err := app.chats.GetByID(uuid); err == nil && chat.UserID == userID {
+		if views, err = app.mapViews.GetByChat(uuid); err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
 	app.infoLog.Print(chats)
 	app.infoLog.Print(messages)
 
 	data := app.newTemplateData(r)
 	data.Chats = chats
 	data.Messages = messages
+	data.MapViews = views
 	data.Projects = projects
+	data.CRSList = crs.Supported()
+	data.ExportFormats = geoexport.Formats
 	data.UserID = userID.String() // Pass user ID to template for JavaScript
 
 	app.render(w, http.StatusOK, "home.tmpl.html", data)
@@ -72,6 +97,24 @@ func (app *application) mapView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A saved view opens on the map of its project, if it has one
+	var view *models.MapView
+	if v := r.URL.Query().Get("view"); v != "" {
+		if view = app.mapViewForUser(w, r, v); view == nil {
+			return
+		}
+		if view.ProjectID.Valid {
+			app.projectMapView(w, r, view.ProjectID.UUID.String(), view)
+			return
+		}
+	}
+
+	// A project map loads its layers from /api/geojson
+	if v := r.URL.Query().Get("project"); v != "" {
+		app.projectMapView(w, r, v, nil)
+		return
+	}
+
 	// Get the dummy GeoJSON data
 	app.infoLog.Printf("Retrieving GeoJSON data...")
 	geoJsonMap, err := app.geoData.Dummy()
@@ -113,6 +156,11 @@ func (app *application) mapView(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
 	data.GeoData = geoJsonString  // Pass as string instead of map
 	data.Chats = chats
+	data.UserID = userID.String()
+	if data.MapView, err = mapViewJSON(view); err != nil {
+		app.serverError(w, err)
+		return
+	}
 
 	app.infoLog.Printf("Rendering map template with GeoJSON data")
 	app.render(w, http.StatusOK, "map.tmpl.html", data)
@@ -125,12 +173,67 @@ func (app *application) geoJsonHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get the dummy GeoJSON data
-	geoJsonMap, err := app.geoData.Dummy()
+	query := r.URL.Query()
+
+	// Without a project the example data is served as before
+	if query.Get("project") == "" {
+		geoJsonMap, err := app.geoData.Dummy()
+		if err != nil {
+			app.serverError(w, fmt.Errorf("error loading GeoJSON data: %w", err))
+			return
+		}
+
+		app.writeJSON(w, http.StatusOK, geoJsonMap)
+		return
+	}
+
+	projectID, ok := app.projectForUser(w, r, query.Get("project"))
+	if !ok {
+		return
+	}
+
+	// Layers are named by ID or by name
+	var layer *models.Layer
+	var err error
+	if layerID, parseErr := uuid.Parse(query.Get("layer")); parseErr == nil {
+		layer, err = app.layers.Get(layerID)
+		if err == nil && layer.ProjectID != projectID {
+			err = models.ErrNoRecord
+		}
+	} else {
+		layer, err = app.layers.GetByName(projectID, query.Get("layer"))
+	}
 	if err != nil {
-		app.serverError(w, fmt.Errorf("error loading GeoJSON data: %w", err))
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, geoJsonMap)
-}
\ No newline at end of file
+	var bbox *geojson.BBox
+	if v := query.Get("bbox"); v != "" {
+		b, err := geojson.ParseBBox(v)
+		if err != nil {
+			app.writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		bbox = &b
+	}
+
+	fc, truncated, err := app.layerFeatures(r.Context(), layer, bbox)
+	if err != nil {
+		if errors.Is(err, errLayerSource) {
+			app.writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+
+	if truncated {
+		w.Header().Set("X-Features-Truncated", "true")
+	}
+	app.writeJSON(w, http.StatusOK, fc)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kdg/be/lab/internal/model"
+	"kdg/be/lab/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// errChatUpstream is returned when the chat server cannot be reached
+var errChatUpstream = errors.New("chat server unavailable")
+
+// chatQuestion is a question asked in one of a user's chats
+type chatQuestion struct {
+	ChatID    uuid.UUID
+	ProjectID uuid.UUID
+	UserID    uuid.UUID
+	Text      string
+	DBUsed    bool
+	DocsUsed  bool
+}
+
+// chatAnswer is what the chat pipeline answered. MessageID is 0 when there
+// was no answer to store.
+type chatAnswer struct {
+	Answer      string
+	Citations   []models.Citation
+	GeoObjects  map[string]GeoObject
+	MessageID   int
+	Interrupted bool
+}
+
+// askChat forwards a question through the ChatPort and passes each
+// processed response to send, until the chat server is done or interrupt is
+// closed. Once the answer is complete, send gets the places it names. A
+// complete or partial answer is stored with the question, after which send
+// gets the ID of the stored answer. Processing stops sending once send
+// fails, but the answer is still stored.
+func (app *application) askChat(q chatQuestion, interrupt <-chan struct{}, send func(FinalResponse) error) (*chatAnswer, error) {
+	dbID, err := app.projectDatabase.GetDbIDFromProject(q.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	var documents []model.ChatDocument
+	if q.DocsUsed {
+		documents = app.chatDocuments(q.ProjectID, q.Text)
+	}
+
+	// Forward the message with the new schema fields
+	promptResponse, err := app.chatPort.ForwardMessageWithStream(
+		q.Text,
+		q.DBUsed,
+		q.DocsUsed,
+		dbID.String(),
+		q.UserID.String(),
+		q.ChatID.String(),
+		q.ProjectID.String(),
+		documents,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errChatUpstream, err)
+	}
+
+	// GeoJSON without a crs member is assumed to use the project default
+	projectCRS := app.projectCRS(q.ProjectID)
+
+	answer := &chatAnswer{}
+
+	// Process incoming prompt responses
+processLoop:
+	for {
+		select {
+		case prompt, ok := <-promptResponse:
+			if !ok {
+				// Channel closed, no more messages
+				break processLoop
+			}
+
+			app.infoLog.Print(prompt)
+			finalResp := app.processPrompt(prompt, projectCRS)
+			if len(finalResp.Citations) > 0 {
+				finalResp.Citations = app.checkCitations(finalResp.Citations, q.ProjectID)
+				answer.Citations = finalResp.Citations
+			}
+			if geo := storedGeoObjects(finalResp); geo != nil {
+				answer.GeoObjects = geo
+			}
+
+			// Store the final answer if present
+			if finalResp.Response != "" {
+				answer.Answer = finalResp.Response
+			} else if finalResp.Answer != "" {
+				answer.Answer = finalResp.Answer
+			}
+
+			if err := send(finalResp); err != nil {
+				app.errorLog.Println("write error:", err)
+				break processLoop
+			}
+		case <-interrupt:
+			// Handle interruption
+			app.infoLog.Println("Processing interrupted")
+			answer.Interrupted = true
+
+			// Send one final message indicating interruption
+			finalResp := FinalResponse{
+				Status:      "Generation interrupted by user.",
+				Answer:      answer.Answer, // Include any partial answer for backward compatibility
+				Response:    answer.Answer, // Include for new schema
+				Interrupted: true,
+				Citations:   answer.Citations,
+			}
+
+			if err := send(finalResp); err != nil {
+				app.errorLog.Println("write error:", err)
+			}
+
+			break processLoop
+		}
+	}
+
+	// The chat server blocks until its responses are read
+	go func() {
+		for range promptResponse {
+		}
+	}()
+
+	// Places are looked up once, in the whole answer rather than in every
+	// streamed response
+	if answer.Answer != "" && !answer.Interrupted {
+		if places := app.answerPlaces(answer.Answer); len(places) > 0 {
+			if err := send(FinalResponse{Places: places}); err != nil {
+				app.errorLog.Println("write error:", err)
+			}
+		}
+	}
+
+	// Only save to database if we have a complete answer
+	if answer.Answer != "" {
+		if _, err := app.messages.Insert(q.ChatID, "You", q.Text, nil, nil); err != nil {
+			app.errorLog.Printf("Error storing message: %v", err)
+		}
+
+		var geoJSON json.RawMessage
+		if answer.GeoObjects != nil {
+			geoJSON, _ = json.Marshal(answer.GeoObjects)
+		}
+		messageID, err := app.messages.Insert(q.ChatID, "AI", answer.Answer, answer.Citations, geoJSON)
+		if err != nil {
+			app.errorLog.Printf("Error storing answer: %v", err)
+		} else {
+			answer.MessageID = messageID
+			if err := send(FinalResponse{MessageID: messageID}); err != nil {
+				app.errorLog.Println("write error:", err)
+			}
+			if len(answer.GeoObjects) > 0 {
+				app.emitPrivateWebhook(q.ProjectID, q.UserID, models.EventChatAnswer, map[string]interface{}{
+					"chat_id":     q.ChatID,
+					"message_id":  messageID,
+					"question":    q.Text,
+					"answer":      answer.Answer,
+					"geo_objects": answer.GeoObjects,
+				})
+			}
+		}
+		app.chats.UpdateLastActivity(q.ChatID)
+	}
+
+	return answer, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"kdg/be/lab/internal/blobstore"
+	"kdg/be/lab/internal/models"
+	"kdg/be/lab/internal/pipeline"
+	"kdg/be/lab/internal/sniff"
+
+	"github.com/google/uuid"
+)
+
+// Default chunking options used when the admin panel sends none
+const (
+	defaultChunkMethod  = pipeline.MethodParagraph
+	defaultChunkCount   = 5
+	defaultChunkOverlap = 1
+)
+
+// chunkOptions are the chunking settings chosen on the admin panel. They are
+// stored with the processing jobs of a document.
+type chunkOptions struct {
+	Method  string `json:"method"`
+	Count   int    `json:"count"`
+	Overlap int    `json:"overlap"`
+}
+
+// stage builds the chunking stage for the options
+func (o chunkOptions) stage() (pipeline.Stage, error) {
+	return pipeline.NewChunkingStage(o.Method, o.Count, o.Overlap)
+}
+
+// lastChunkOptions returns the chunking options a file was last processed
+// with and true, or the defaults and false when it never was
+func (app *application) lastChunkOptions(fileID uuid.UUID) (chunkOptions, bool, error) {
+	options := chunkOptions{Method: defaultChunkMethod, Count: defaultChunkCount, Overlap: defaultChunkOverlap}
+
+	optionsJSON, err := app.jobs.LatestOptions(fileID)
+	if errors.Is(err, models.ErrNoRecord) {
+		return options, false, nil
+	}
+	if err != nil {
+		return options, false, err
+	}
+
+	err = json.Unmarshal(optionsJSON, &options)
+	return options, true, err
+}
+
+// extractDocument copies a stored file to a temporary file and extracts its
+// text sections
+func (app *application) extractDocument(ctx context.Context, file *models.File) ([]pipeline.Section, error) {
+	store, _, err := app.blobStore(file.StorageLocation)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := store.Get(ctx, file.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := blobstore.WriteTemp(app.uploadDir, blob)
+	blob.Close()
+	if err != nil {
+		return nil, err
+	}
+	defer tmp.Remove()
+
+	doc := &pipeline.Document{
+		Name:   file.Name,
+		Type:   sniff.TypeOf(file.MimeType),
+		Source: tmp.File,
+		Size:   tmp.Size,
+	}
+	if err := pipeline.New(pipeline.NewExtractStage()).Run(ctx, doc); err != nil {
+		return nil, err
+	}
+
+	return doc.Sections, nil
+}
+
+// chunkSections chunks extracted sections and saves the chunks, returning
+// the number of chunks stored
+func (app *application) chunkSections(ctx context.Context, file *models.File, sections []pipeline.Section, options chunkOptions) (int, error) {
+	stage, err := options.stage()
+	if err != nil {
+		return 0, err
+	}
+
+	doc := &pipeline.Document{Name: file.Name, Type: sniff.TypeOf(file.MimeType)}
+	doc.SetSections(sections)
+	if err := pipeline.New(stage).Run(ctx, doc); err != nil {
+		return 0, err
+	}
+
+	chunks := make([]*models.DocumentChunk, 0, len(doc.Chunks))
+	for _, c := range doc.Chunks {
+		chunks = append(chunks, &models.DocumentChunk{
+			ProjectID:   file.ProjectID,
+			Index:       c.Index,
+			Content:     c.Text,
+			Page:        c.Page,
+			PageEnd:     c.PageEnd,
+			HeadingPath: c.HeadingPath,
+			StartOffset: c.Start,
+			EndOffset:   c.End,
+			TokenCount:  c.TokenCount,
+			Method:      options.Method,
+		})
+	}
+
+	if err := app.chunks.ReplaceForFile(file.ID, chunks); err != nil {
+		return 0, err
+	}
+
+	return len(chunks), nil
+}
+
+// isUnchunkable reports whether a pipeline error means the document simply
+// has no text to chunk
+func isUnchunkable(err error) bool {
+	return errors.Is(err, pipeline.ErrNoExtractor) || errors.Is(err, pipeline.ErrNoText)
+}
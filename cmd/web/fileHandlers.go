@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"kdg/be/lab/internal/blobstore"
+	"kdg/be/lab/internal/models"
+	"kdg/be/lab/internal/sniff"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// blobStore returns the configured store for a storage location chosen on
+// the upload form, together with the normalized location name
+func (app *application) blobStore(location string) (blobstore.Store, string, error) {
+	location = strings.ToLower(strings.TrimSpace(location))
+	if location == "" {
+		location = "local"
+	}
+
+	store, ok := app.blobStores[location]
+	if !ok {
+		return nil, "", fmt.Errorf("storage location %q is not configured", location)
+	}
+
+	return store, location, nil
+}
+
+// fileForUser loads the file from the :id route parameter and checks that the
+// current user has access to its project. It writes the error response and
+// returns nil when the file cannot be used.
+func (app *application) fileForUser(w http.ResponseWriter, r *http.Request) *models.File {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	fileID, ok := app.parseUUID(w, params.ByName("id"))
+	if !ok {
+		return nil
+	}
+
+	userID := app.userIdFromSession(r)
+	if userID == uuid.Nil {
+		app.clientError(w, http.StatusUnauthorized)
+		return nil
+	}
+
+	file, err := app.files.GetByID(fileID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return nil
+	}
+
+	if file.UserID != userID {
+		hasAccess, err := app.projects.HasAccess(file.ProjectID, userID)
+		if err != nil {
+			app.serverError(w, err)
+			return nil
+		}
+		if !hasAccess {
+			app.clientError(w, http.StatusForbidden)
+			return nil
+		}
+	}
+
+	return file
+}
+
+// fileDownload streams a stored document back to the user
+func (app *application) fileDownload(w http.ResponseWriter, r *http.Request) {
+	file := app.fileForUser(w, r)
+	if file == nil {
+		return
+	}
+
+	// Quarantined documents stay in storage for review only
+	if file.Status == "quarantined" {
+		app.setFlashAndRedirect(w, r, fmt.Sprintf("Document %s is quarantined and cannot be downloaded", file.Name),
+			fmt.Sprintf("/project/view/%s", file.ProjectID), http.StatusSeeOther)
+		return
+	}
+
+	store, _, err := app.blobStore(file.StorageLocation)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	blob, err := store.Get(r.Context(), file.FilePath)
+	if err != nil {
+		if errors.Is(err, blobstore.ErrNotFound) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+	defer blob.Close()
+
+	// Large documents take longer than the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		app.errorLog.Printf("Could not extend write deadline: %v", err)
+	}
+
+	disposition := "attachment"
+	if r.URL.Query().Get("inline") == "1" {
+		disposition = "inline"
+	}
+
+	w.Header().Set(contentTypeHeader, file.MimeType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": file.Name}))
+	w.Header().Set("Content-Length", strconv.FormatInt(file.Size, 10))
+	if file.ContentHash != "" {
+		w.Header().Set("ETag", `"`+file.ContentHash+`"`)
+	}
+
+	if _, err := io.Copy(w, blob); err != nil {
+		app.errorLog.Printf("Error streaming file %s: %v", file.ID, err)
+	}
+}
+
+// fileView opens a document at a cited page. PDFs are shown by the browser,
+// other documents as their extracted chunks.
+func (app *application) fileView(w http.ResponseWriter, r *http.Request) {
+	file := app.fileForUser(w, r)
+	if file == nil {
+		return
+	}
+
+	if file.Status == "quarantined" {
+		app.setFlashAndRedirect(w, r, fmt.Sprintf("Document %s is quarantined and cannot be viewed", file.Name),
+			fmt.Sprintf("/project/view/%s", file.ProjectID), http.StatusSeeOther)
+		return
+	}
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+
+	if file.MimeType == sniff.MimeTypes[sniff.PDF] {
+		url := fmt.Sprintf("/files/%s/download?inline=1", file.ID)
+		if page > 0 {
+			url += fmt.Sprintf("#page=%d", page)
+		}
+		http.Redirect(w, r, url, http.StatusSeeOther)
+		return
+	}
+
+	chunks, err := app.chunks.GetByFile(file.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.File = file
+	data.Chunks = chunks
+	data.Page = page
+	app.render(w, http.StatusOK, "document.tmpl.html", data)
+}
+
+// fileDeletePost removes a document with its versions, chunks and
+// embeddings, and the blobs no other file uses
+func (app *application) fileDeletePost(w http.ResponseWriter, r *http.Request) {
+	file := app.fileForUser(w, r)
+	if file == nil {
+		return
+	}
+
+	if err := app.deleteFile(r, file, app.userIdFromSession(r)); err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.setFlashAndRedirect(w, r, fmt.Sprintf("Document %s deleted", file.Name),
+		fmt.Sprintf("/project/view/%s", file.ProjectID), http.StatusSeeOther)
+}
+
+// deleteFile removes a document with its earlier versions, embeddings and
+// cached layers. Its jobs are cancelled in the same transaction as the
+// file; an embed step already running removes its vectors when it ends.
+func (app *application) deleteFile(r *http.Request, file *models.File, userID uuid.UUID) error {
+	versions, err := app.files.Versions(file.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := app.files.Delete(file.ID); err != nil {
+		return err
+	}
+
+	app.releaseBlob(r, file)
+	for _, v := range versions {
+		app.releaseBlob(r, &models.File{StorageLocation: v.StorageLocation, FilePath: v.FilePath})
+	}
+
+	app.deleteEmbeddings(r.Context(), file)
+	app.invalidateFileLayers(file.ID)
+	app.logFileEvent(file, userID, models.FileDeleted,
+		fmt.Sprintf("Deleted with %d earlier versions", len(versions)))
+	return nil
+}
+
+// fileReplacePost stores a new upload as the next version of an existing
+// document and, when the earlier version was processed, processes it again
+// with the options of the last run
+func (app *application) fileReplacePost(w http.ResponseWriter, r *http.Request) {
+	file := app.fileForUser(w, r)
+	if file == nil {
+		return
+	}
+
+	// The CSRF middleware has already parsed the multipart form
+	upload, header, err := r.FormFile("document")
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	defer upload.Close()
+
+	tmp, err := blobstore.WriteTemp(app.uploadDir, upload)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	defer tmp.Remove()
+
+	if tmp.Size > app.maxUploadSize {
+		app.setFlashAndRedirect(w, r, fmt.Sprintf("File is too large: %s exceeds the limit of %s",
+			formatFileSize(tmp.Size), formatFileSize(app.maxUploadSize)),
+			fmt.Sprintf("/project/view/%s", file.ProjectID), http.StatusSeeOther)
+		return
+	}
+
+	// The earlier version is kept, so it still counts toward the quota
+	used, err := app.files.ProjectUsage(file.ProjectID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	pending, err := app.uploads.PendingBytes(file.ProjectID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if used+pending+tmp.Size > app.projectQuota {
+		app.setFlashAndRedirect(w, r, fmt.Sprintf("Project storage quota exceeded: %s used of %s",
+			formatFileSize(used+pending), formatFileSize(app.projectQuota)),
+			fmt.Sprintf("/project/view/%s", file.ProjectID), http.StatusSeeOther)
+		return
+	}
+
+	// The new content must be allowed for the roles of the document. Roles
+	// from before the allowlist existed are not checked; documents without
+	// a known role are checked against the default role.
+	docType, err := sniff.Detect(tmp.File, tmp.Size)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	roles := []string{}
+	for _, role := range strings.Split(file.Role, ",") {
+		if app.allowlist.KnownRole(role) {
+			roles = append(roles, role)
+		}
+	}
+	if len(roles) == 0 {
+		roles = []string{sniff.DefaultRole}
+	}
+	if !app.allowlist.Allowed(docType, roles) {
+		app.setFlashAndRedirect(w, r, fmt.Sprintf("Files of type %s are not accepted for role %s", docType, strings.Join(roles, ", ")),
+			fmt.Sprintf("/project/view/%s", file.ProjectID), http.StatusSeeOther)
+		return
+	}
+
+	verdict, err := app.scanTemp(r.Context(), tmp)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	store, storageLocation, err := app.blobStore(file.StorageLocation)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	key, _, err := blobstore.PutTemp(r.Context(), store, tmp)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	options, processed, err := app.lastChunkOptions(file.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	file.Name = filepath.Base(header.Filename)
+	file.FilePath = key
+	file.StorageLocation = storageLocation
+	file.Size = tmp.Size
+	file.ContentHash = tmp.Hash
+	file.Status = "uploaded"
+	if !verdict.Clean {
+		app.infoLog.Printf("Quarantined file %s (%s): %s", file.ID, file.Name, verdict.Signature)
+		file.Status = "quarantined"
+	}
+	file.MimeType = sniff.MimeTypes[docType]
+
+	// The earlier version keeps its blob
+	if err := app.files.UpdateContent(file); err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Chunks and embeddings of the earlier version must not be searched
+	if err := app.files.ClearProcessing(file.ID); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.deleteEmbeddings(r.Context(), file)
+	app.invalidateFileLayers(file.ID)
+
+	userID := app.userIdFromSession(r)
+	app.logFileEvent(file, userID, models.FileReplaced, fmt.Sprintf("Uploaded version %d", file.Version))
+
+	if !verdict.Clean {
+		app.logFileEvent(file, userID, models.FileQuarantined, verdict.Signature)
+		app.setFlashAndRedirect(w, r, fmt.Sprintf("Document %s was quarantined: %s detected", file.Name, verdict.Signature),
+			fmt.Sprintf("/project/view/%s", file.ProjectID), http.StatusSeeOther)
+		return
+	}
+
+	// Documents uploaded without processing stay unprocessed
+	if processed {
+		if err := app.enqueueProcessing(file, options); err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	app.setFlashAndRedirect(w, r, fmt.Sprintf("Document %s replaced with version %d", file.Name, file.Version),
+		fmt.Sprintf("/project/view/%s", file.ProjectID), http.StatusSeeOther)
+}
+
+type fileReprocessForm struct {
+	Method  string `form:"method"`
+	Count   int    `form:"count"`
+	Overlap int    `form:"overlap"`
+}
+
+// fileReprocessPost discards the chunks and embeddings of a document and
+// processes it again with new chunking options
+func (app *application) fileReprocessPost(w http.ResponseWriter, r *http.Request) {
+	file := app.fileForUser(w, r)
+	if file == nil {
+		return
+	}
+
+	redirectURL := fmt.Sprintf("/project/view/%s", file.ProjectID)
+
+	if file.Status == "quarantined" {
+		app.setFlashAndRedirect(w, r, fmt.Sprintf("Document %s is quarantined and cannot be processed", file.Name),
+			redirectURL, http.StatusSeeOther)
+		return
+	}
+
+	var form fileReprocessForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	options := chunkOptions{Method: form.Method, Count: form.Count, Overlap: form.Overlap}
+	if _, err := options.stage(); err != nil {
+		app.setFlashAndRedirect(w, r, fmt.Sprintf("Invalid chunking settings: %v", err), redirectURL, http.StatusSeeOther)
+		return
+	}
+
+	if err := app.files.ClearProcessing(file.ID); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.deleteEmbeddings(r.Context(), file)
+
+	if err := app.enqueueProcessing(file, options); err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.logFileEvent(file, app.userIdFromSession(r), models.FileReprocessed,
+		fmt.Sprintf("Chunking: %s, size %d, overlap %d", options.Method, options.Count, options.Overlap))
+
+	app.setFlashAndRedirect(w, r, fmt.Sprintf("Document %s queued for processing", file.Name), redirectURL, http.StatusSeeOther)
+}
+
+// deleteEmbeddings removes the vectors of a file from the local index
+func (app *application) deleteEmbeddings(ctx context.Context, file *models.File) {
+	if app.vectors == nil {
+		return
+	}
+	if err := app.vectors.Store.DeleteFile(ctx, file.ProjectID, file.ID); err != nil {
+		app.errorLog.Printf("Error removing embeddings of file %s: %v", file.ID, err)
+	}
+}
+
+// logFileEvent records a lifecycle event of a file in the project's audit
+// trail. A nil userID marks events of the processing workers.
+func (app *application) logFileEvent(file *models.File, userID uuid.UUID, event, detail string) {
+	e := &models.FileEvent{
+		FileID:    file.ID,
+		ProjectID: file.ProjectID,
+		UserID:    uuid.NullUUID{UUID: userID, Valid: userID != uuid.Nil},
+		FileName:  file.Name,
+		Version:   file.Version,
+		Event:     event,
+		Detail:    detail,
+	}
+	if err := app.fileEvents.Insert(e); err != nil {
+		app.errorLog.Printf("Error logging %s event of file %s: %v", event, file.ID, err)
+	}
+}
+
+// releaseBlob deletes the blob behind a file once no file record refers to it
+func (app *application) releaseBlob(r *http.Request, file *models.File) {
+	count, err := app.files.CountByBlob(file.StorageLocation, file.FilePath)
+	if err != nil {
+		app.errorLog.Printf("Error counting blob references: %v", err)
+		return
+	}
+	if count > 0 {
+		return
+	}
+
+	store, _, err := app.blobStore(file.StorageLocation)
+	if err != nil {
+		app.errorLog.Print(err)
+		return
+	}
+
+	if err := store.Delete(r.Context(), file.FilePath); err != nil {
+		app.errorLog.Printf("Error deleting blob %s: %v", file.FilePath, err)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kdg/be/lab/internal/crs"
+	"kdg/be/lab/internal/geoexport"
+	"kdg/be/lab/internal/geojson"
+	"kdg/be/lab/internal/models"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// writeExport answers with the layers in the format and reference system
+// asked for by the format and crs query parameters, GeoJSON in WGS84 by
+// default
+func (app *application) writeExport(w http.ResponseWriter, r *http.Request, name string, layers []geoexport.Layer) {
+	query := r.URL.Query()
+
+	formatName := query.Get("format")
+	if formatName == "" {
+		formatName = "geojson"
+	}
+	format, err := geoexport.LookupFormat(formatName)
+	if err != nil {
+		app.writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	target := crs.WGS84
+	if v := query.Get("crs"); v != "" {
+		if code, convErr := strconv.Atoi(v); convErr == nil {
+			target, err = crs.Lookup(code)
+		} else {
+			target, err = crs.Parse(v)
+		}
+		if err != nil {
+			app.writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	// Written to a buffer first so that a failure still gets an error status
+	var buf bytes.Buffer
+	if err := format.Write(&buf, layers, target); err != nil {
+		if errors.Is(err, geoexport.ErrFormat) {
+			app.writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + format.Extension}))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Write(buf.Bytes())
+}
+
+// messageExport exports the geo objects stored with an answer, one layer
+// per object
+func (app *application) messageExport(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		app.notFound(w)
+		return
+	}
+
+	msg, err := app.messages.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	chat, err := app.chats.GetByID(msg.ChatID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+	if chat.UserID != app.userIdFromSession(r) {
+		app.notFound(w)
+		return
+	}
+
+	if len(msg.GeoObjects) == 0 {
+		app.writeJSON(w, http.StatusNotFound, map[string]string{"error": "The answer has no geo objects"})
+		return
+	}
+
+	var objects map[string]GeoObject
+	if err := json.Unmarshal(msg.GeoObjects, &objects); err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	names := make([]string, 0, len(objects))
+	for name := range objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	layers := make([]geoexport.Layer, 0, len(names))
+	for _, name := range names {
+		doc, err := json.Marshal(GeoObject{Type: "FeatureCollection", Features: objects[name].Features})
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		fc, err := geojson.Decode(doc)
+		if err != nil {
+			app.serverError(w, fmt.Errorf("stored geo object %q of message %d: %w", name, msg.ID, err))
+			return
+		}
+		layers = append(layers, geoexport.Layer{Name: name, Features: fc})
+	}
+
+	app.writeExport(w, r, fmt.Sprintf("answer-%d", msg.ID), layers)
+}
+
+// layerExport exports all features of a map layer. Table and query layers
+// stop at the usual feature limit.
+func (app *application) layerExport(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	layer := app.layerForUser(w, r, params.ByName("id"))
+	if layer == nil {
+		return
+	}
+
+	var fc *geojson.FeatureCollection
+	var truncated bool
+	var err error
+	switch layer.Source {
+	case models.LayerTable, models.LayerQuery:
+		fc, truncated, err = app.databaseLayerFeatures(r.Context(), layer, nil)
+	default:
+		fc, err = app.sourceFeatures(r.Context(), layer)
+	}
+	if err != nil {
+		if errors.Is(err, errLayerSource) {
+			app.writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+
+	if truncated {
+		w.Header().Set("X-Features-Truncated", "true")
+	}
+	app.writeExport(w, r, layer.Name, []geoexport.Layer{{Name: layer.Name, Features: fc}})
+}
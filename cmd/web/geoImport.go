@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"kdg/be/lab/internal/models"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// spatialRole marks uploads that become map layers
+const spatialRole = "SPATIAL"
+
+// importSpatialFile adds a map layer to the project for every layer of an
+// uploaded spatial file and reports them on the upload connection. It
+// returns false when nothing was imported.
+func (app *application) importSpatialFile(ctx context.Context, clientWS *websocket.Conn, file *models.File, ownerID uuid.UUID) bool {
+	layers, err := app.importLayers(ctx, file, ownerID)
+	if err != nil {
+		msg := "The file could not be imported as a map layer"
+		if errors.Is(err, errLayerSource) {
+			msg = fmt.Sprintf("%s: %v", msg, err)
+		} else {
+			app.errorLog.Printf("Error importing file %s as map layers: %v", file.ID, err)
+		}
+		app.logFileEvent(file, ownerID, models.FileFailed, msg)
+		sendJSON(clientWS, map[string]interface{}{
+			"status":  "layers",
+			"step":    "import",
+			"message": msg,
+		})
+		return false
+	}
+
+	names := make([]string, len(layers))
+	total := 0
+	for i, l := range layers {
+		names[i] = l.Name
+		total += l.FeatureCount
+	}
+	msg := fmt.Sprintf("Imported %d features as map layer %s", total, strings.Join(names, ", "))
+	app.logFileEvent(file, ownerID, models.FileImported, msg)
+	sendJSON(clientWS, map[string]interface{}{
+		"status":  "layers",
+		"message": msg,
+		"layers":  layers,
+	})
+	return true
+}
+
+// importLayers reads a spatial file and stores a file layer for each of
+// its layers, named after the file and, for files with several, the layer
+func (app *application) importLayers(ctx context.Context, file *models.File, ownerID uuid.UUID) ([]*models.Layer, error) {
+	read, err := app.readLayerFile(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+
+	base := strings.TrimSuffix(file.Name, path.Ext(file.Name))
+	var layers []*models.Layer
+	for _, l := range read {
+		name := base
+		if len(read) > 1 && l.Name != "" {
+			name = base + " - " + l.Name
+		}
+		layer := &models.Layer{
+			ProjectID: file.ProjectID,
+			Name:      cleanLayerName(name),
+			Source:    models.LayerFile,
+			FileID:    uuid.NullUUID{UUID: file.ID, Valid: true},
+			Sublayer:  l.Name,
+			CreatedBy: ownerID,
+		}
+		describeLayer(layer, l.Features)
+		if err := app.insertLayerUnique(layer); err != nil {
+			return layers, err
+		}
+		app.layerCache.features.put(layer.ID.String(), l.Features)
+		layers = append(layers, layer)
+	}
+	return layers, nil
+}
+
+// cleanLayerName turns a file name into a valid layer name
+func cleanLayerName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune(" _.-", r):
+			return r
+		}
+		return '_'
+	}, strings.TrimSpace(name))
+	name = strings.TrimLeft(name, " _.-")
+	if name == "" {
+		name = "layer"
+	}
+	if len(name) > 56 {
+		name = strings.TrimSpace(name[:56])
+	}
+	return name
+}
+
+// insertLayerUnique stores a layer, numbering its name when the project
+// already has a layer with that name
+func (app *application) insertLayerUnique(layer *models.Layer) error {
+	base := layer.Name
+	for n := 2; ; n++ {
+		err := app.layers.Insert(layer)
+		if !errors.Is(err, models.ErrDuplicateName) || n > 100 {
+			return err
+		}
+		layer.Name = fmt.Sprintf("%s %d", base, n)
+	}
+}
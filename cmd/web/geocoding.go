@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"kdg/be/lab/internal/geocode"
+	"kdg/be/lab/internal/geojson"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Geocoding limits
+const (
+	maxGeocodeResults = 20
+	placesTimeout     = 2 * time.Second
+)
+
+// openGazetteer opens the gazetteer and loads the CSV files to import into
+// it. It returns nil when geocoding is disabled.
+func openGazetteer(path, imports string, infoLog *log.Logger) (*geocode.Gazetteer, error) {
+	if path == "" {
+		return nil, nil
+	}
+	g, err := geocode.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range strings.Split(imports, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			g.Close()
+			return nil, err
+		}
+		n, err := g.Import(context.Background(), filepath.Base(name), f)
+		f.Close()
+		if err != nil {
+			g.Close()
+			return nil, err
+		}
+		infoLog.Printf("Imported %d places from %s into the gazetteer", n, name)
+	}
+	return g, nil
+}
+
+// placeCollection returns places as GeoJSON points
+func placeCollection(places []*geocode.Place) *geojson.FeatureCollection {
+	fc := geojson.NewFeatureCollection()
+	for _, p := range places {
+		properties, _ := json.Marshal(struct {
+			*geocode.Place
+			Label string `json:"label"`
+		}{p, p.Label()})
+		fc.Features = append(fc.Features, &geojson.Feature{
+			ID:         json.RawMessage(strconv.FormatInt(p.ID, 10)),
+			Geometry:   &geojson.Geometry{Type: geojson.Point, Point: geojson.Position{p.Lon, p.Lat}},
+			Properties: properties,
+		})
+	}
+	return fc
+}
+
+// geocodingDisabled answers requests when no gazetteer is configured
+func (app *application) geocodingDisabled(w http.ResponseWriter) bool {
+	if app.gazetteer != nil {
+		return false
+	}
+	app.writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Geocoding is not enabled"})
+	return true
+}
+
+// geocodeLimit reads the limit query parameter
+func geocodeLimit(r *http.Request, fallback int) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit < 1 {
+		return fallback
+	}
+	return min(limit, maxGeocodeResults)
+}
+
+// geocodeSearch finds places by name, best match first
+func (app *application) geocodeSearch(w http.ResponseWriter, r *http.Request) {
+	if app.geocodingDisabled(w) {
+		return
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		app.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "The q parameter is required"})
+		return
+	}
+
+	places, err := app.gazetteer.Search(r.Context(), q, geocodeLimit(r, 5))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.writeJSON(w, http.StatusOK, placeCollection(places))
+}
+
+// geocodeReverse finds the places nearest to a position, optionally of one
+// kind, nearest first
+func (app *application) geocodeReverse(w http.ResponseWriter, r *http.Request) {
+	if app.geocodingDisabled(w) {
+		return
+	}
+	query := r.URL.Query()
+	lon, lonErr := strconv.ParseFloat(query.Get("lon"), 64)
+	lat, latErr := strconv.ParseFloat(query.Get("lat"), 64)
+	if lonErr != nil || latErr != nil || math.Abs(lon) > 180 || math.Abs(lat) > 90 {
+		app.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "lon and lat must be WGS84 degrees"})
+		return
+	}
+
+	places, err := app.gazetteer.Reverse(r.Context(), lon, lat, query.Get("kind"), geocodeLimit(r, 1))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.writeJSON(w, http.StatusOK, placeCollection(places))
+}
+
+// answerPlaces finds the places named in a chat answer so that the chat
+// can link them to the map
+func (app *application) answerPlaces(answer string) []geocode.Mention {
+	if app.gazetteer == nil || answer == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), placesTimeout)
+	defer cancel()
+
+	mentions, err := app.gazetteer.Mentions(ctx, answer)
+	if err != nil {
+		app.errorLog.Printf("Error finding places in answer: %v", err)
+		return nil
+	}
+	return mentions
+}
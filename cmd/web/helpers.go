@@ -6,7 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"runtime/debug"
+	"strings"
 	"time"
 
 	"github.com/go-playground/form/v4"
@@ -15,8 +17,46 @@ import (
 	"github.com/justinas/nosurf"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+// upgradeWebSocket upgrades a request to a WebSocket connection. Handshakes
+// from an origin other than this server or the configured allowlist are
+// rejected.
+func (app *application) upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
+	upgrader := websocket.Upgrader{
+		CheckOrigin: app.checkOrigin,
+	}
+	return upgrader.Upgrade(w, r, nil)
+}
+
+// checkOrigin accepts requests without an Origin header, which browsers always
+// send, requests from the server's own host and the allowed origins
+func (app *application) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	if app.allowedOrigins[strings.ToLower(strings.TrimSuffix(origin, "/"))] {
+		return true
+	}
+
+	app.errorLog.Printf("Rejected WebSocket handshake for %s from origin %q (remote %s)", r.URL.Path, origin, r.RemoteAddr)
+	return false
+}
+
+// parseOrigins turns a comma separated list of origins into a lookup set
+func parseOrigins(list string) map[string]bool {
+	origins := map[string]bool{}
+	for _, origin := range strings.Split(list, ",") {
+		origin = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(origin), "/"))
+		if origin != "" {
+			origins[origin] = true
+		}
+	}
+	return origins
 }
 
 func (app *application) serverError(w http.ResponseWriter, err error) {
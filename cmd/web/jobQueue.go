@@ -0,0 +1,523 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kdg/be/lab/internal/models"
+	"kdg/be/lab/internal/pipeline"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Processing job settings
+const (
+	defaultJobAttempts = 3
+	// A job is cancelled after jobTimeout; a job still locked after
+	// jobLockTimeout was abandoned by a worker and is claimed again
+	jobTimeout      = 20 * time.Minute
+	jobLockTimeout  = 30 * time.Minute
+	jobPollInterval = 5 * time.Second
+	// Delay before the first retry, doubled for every later one
+	jobRetryDelay = 30 * time.Second
+)
+
+// nextJobStep lists the step that follows each step
+var nextJobStep = map[string]string{
+	models.JobExtract: models.JobChunk,
+	models.JobChunk:   models.JobEmbed,
+	models.JobEmbed:   models.JobIndex,
+}
+
+// fileStatusForStep is the File.Status shown while a step runs
+var fileStatusForStep = map[string]string{
+	models.JobExtract: "extracting",
+	models.JobChunk:   "chunking",
+	models.JobEmbed:   "embedding",
+	models.JobIndex:   "indexing",
+}
+
+// jobEvent reports the progress of a processing job to the progress feed
+type jobEvent struct {
+	JobID     uuid.UUID `json:"job_id"`
+	FileID    uuid.UUID `json:"file_id"`
+	ProjectID uuid.UUID `json:"project_id"`
+	Step      string    `json:"step"`
+	// Status is the job state, or "retrying" after a failed attempt
+	Status  string `json:"status"`
+	Attempt int    `json:"attempt"`
+	Message string `json:"message"`
+	Error   string `json:"error,omitempty"`
+	// Processed is set once the last step of the document is done
+	Processed bool `json:"processed,omitempty"`
+}
+
+func newJobEvent(job *models.Job, status, message string) jobEvent {
+	return jobEvent{
+		JobID:     job.ID,
+		FileID:    job.FileID,
+		ProjectID: job.ProjectID,
+		Step:      job.Step,
+		Status:    status,
+		Attempt:   job.Attempts,
+		Message:   message,
+	}
+}
+
+// jobHub fans job events out to the progress feeds of a project
+type jobHub struct {
+	mu          sync.Mutex
+	subscribers map[uuid.UUID]map[chan jobEvent]struct{}
+}
+
+func newJobHub() *jobHub {
+	return &jobHub{subscribers: map[uuid.UUID]map[chan jobEvent]struct{}{}}
+}
+
+func (h *jobHub) subscribe(projectID uuid.UUID) chan jobEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan jobEvent, 64)
+	if h.subscribers[projectID] == nil {
+		h.subscribers[projectID] = map[chan jobEvent]struct{}{}
+	}
+	h.subscribers[projectID][ch] = struct{}{}
+	return ch
+}
+
+func (h *jobHub) unsubscribe(projectID uuid.UUID, ch chan jobEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.subscribers[projectID], ch)
+	if len(h.subscribers[projectID]) == 0 {
+		delete(h.subscribers, projectID)
+	}
+}
+
+// publish never blocks; a feed that falls behind misses events but still
+// sees the job state when it reconnects
+func (h *jobHub) publish(event jobEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers[event.ProjectID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// permanentError marks job failures that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// enqueueProcessing queues the first processing step of a stored file
+func (app *application) enqueueProcessing(file *models.File, options chunkOptions) error {
+	optionsJSON, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+
+	job := &models.Job{
+		FileID:      file.ID,
+		ProjectID:   file.ProjectID,
+		Step:        models.JobExtract,
+		Options:     optionsJSON,
+		MaxAttempts: defaultJobAttempts,
+	}
+	if err := app.jobs.Enqueue(job); err != nil {
+		return err
+	}
+
+	app.jobEvents.publish(newJobEvent(job, models.JobQueued, "Queued for processing"))
+	app.wakeJobWorkers()
+	return nil
+}
+
+// wakeJobWorkers lets an idle worker look for work without waiting for the
+// next poll
+func (app *application) wakeJobWorkers() {
+	select {
+	case app.jobWake <- struct{}{}:
+	default:
+	}
+}
+
+// runJobWorkers starts n workers that process queued jobs
+func (app *application) runJobWorkers(n int) {
+	for i := 0; i < n; i++ {
+		go app.jobWorker()
+	}
+}
+
+func (app *application) jobWorker() {
+	for {
+		job, err := app.jobs.Claim(jobLockTimeout)
+		if err != nil {
+			if !errors.Is(err, models.ErrNoRecord) {
+				app.errorLog.Printf("Error claiming job: %v", err)
+			}
+			select {
+			case <-app.jobWake:
+			case <-time.After(jobPollInterval):
+			}
+			continue
+		}
+
+		app.runJob(job)
+	}
+}
+
+// runJob runs one claimed job and records its outcome
+func (app *application) runJob(job *models.Job) {
+	file, err := app.files.GetByID(job.FileID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			// The file was deleted while the job was queued
+			if err := app.jobs.Cancel(job.ID); err != nil {
+				app.errorLog.Printf("Error cancelling job %s: %v", job.ID, err)
+			}
+			app.jobEvents.publish(newJobEvent(job, models.JobCancelled, "The document was deleted"))
+			return
+		}
+		app.retryJob(job, err)
+		return
+	}
+
+	app.setFileStatus(file.ID, fileStatusForStep[job.Step])
+	app.jobEvents.publish(newJobEvent(job, models.JobRunning, fmt.Sprintf("Running %s step", job.Step)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+	defer cancel()
+
+	next, message, err := app.runJobStep(ctx, job, file)
+	if err != nil {
+		app.retryJob(job, err)
+		return
+	}
+
+	var nextJob *models.Job
+	if next != "" {
+		nextJob = &models.Job{
+			FileID:      job.FileID,
+			ProjectID:   job.ProjectID,
+			Step:        next,
+			Options:     job.Options,
+			MaxAttempts: job.MaxAttempts,
+		}
+	}
+
+	if err := app.jobs.Complete(job.ID, nextJob); err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.infoLog.Printf("Job %s was cancelled while it ran", job.ID)
+			if job.Step == models.JobEmbed {
+				app.discardDeletedEmbeddings(file)
+			}
+			return
+		}
+		app.errorLog.Printf("Error completing job %s: %v", job.ID, err)
+		return
+	}
+
+	event := newJobEvent(job, models.JobDone, message)
+	if nextJob == nil {
+		app.setFileStatus(file.ID, "processed")
+		app.logFileEvent(file, uuid.Nil, models.FileProcessed, message)
+		app.emitWebhook(file.ProjectID, models.EventFileProcessed, map[string]interface{}{
+			"file_id":   file.ID,
+			"name":      file.Name,
+			"mime_type": file.MimeType,
+			"size":      file.Size,
+			"message":   message,
+		})
+		event.Processed = true
+	}
+	app.jobEvents.publish(event)
+}
+
+// runJobStep runs the work of a step and returns the step that follows,
+// empty when the document is done, and a message for the progress feed
+func (app *application) runJobStep(ctx context.Context, job *models.Job, file *models.File) (string, string, error) {
+	switch job.Step {
+	case models.JobExtract:
+		sections, err := app.extractDocument(ctx, file)
+		if isUnchunkable(err) {
+			return "", "No text to extract from this document", nil
+		}
+		if err != nil {
+			return "", "", err
+		}
+
+		sectionsJSON, err := json.Marshal(sections)
+		if err != nil {
+			return "", "", permanentError{err}
+		}
+		if err := app.texts.Save(file.ID, file.ProjectID, sectionsJSON); err != nil {
+			return "", "", err
+		}
+		return nextJobStep[job.Step], fmt.Sprintf("Extracted %d sections", len(sections)), nil
+
+	case models.JobChunk:
+		var options chunkOptions
+		if err := json.Unmarshal(job.Options, &options); err != nil {
+			return "", "", permanentError{err}
+		}
+		if _, err := options.stage(); err != nil {
+			return "", "", permanentError{err}
+		}
+
+		sectionsJSON, err := app.texts.Get(file.ID)
+		if err != nil {
+			return "", "", err
+		}
+		var sections []pipeline.Section
+		if err := json.Unmarshal(sectionsJSON, &sections); err != nil {
+			return "", "", permanentError{err}
+		}
+
+		count, err := app.chunkSections(ctx, file, sections, options)
+		if err != nil {
+			return "", "", err
+		}
+		return nextJobStep[job.Step], fmt.Sprintf("Stored %d chunks (%s, %d per chunk)", count, options.Method, options.Count), nil
+
+	case models.JobEmbed:
+		// Embed locally when a vector index is configured, otherwise ask
+		// the processing service
+		var message string
+		var err error
+		if app.vectors != nil {
+			message, err = app.embedFileChunks(ctx, file)
+		} else {
+			message, err = app.embedChunks(ctx, job)
+		}
+		if err != nil {
+			return "", "", err
+		}
+		return nextJobStep[job.Step], message, nil
+
+	case models.JobIndex:
+		if app.discardDeletedEmbeddings(file) {
+			return "", "The document was deleted", nil
+		}
+		count, err := app.chunks.CountByFile(file.ID)
+		if err != nil {
+			return "", "", err
+		}
+		return "", fmt.Sprintf("Indexed %d chunks", count), nil
+	}
+
+	return "", "", permanentError{fmt.Errorf("unknown job step %q", job.Step)}
+}
+
+// discardDeletedEmbeddings removes the embeddings of a file that was
+// deleted while it was being embedded, as the deletion may have run before
+// they were stored. It reports whether the file was deleted.
+func (app *application) discardDeletedEmbeddings(file *models.File) bool {
+	if _, err := app.files.GetByID(file.ID); !errors.Is(err, models.ErrNoRecord) {
+		return false
+	}
+	app.deleteEmbeddings(context.Background(), file)
+	return true
+}
+
+// embedChunks asks the processing service to embed the chunks of a file and
+// relays its progress messages until it reports completion
+func (app *application) embedChunks(ctx context.Context, job *models.Job) (string, error) {
+	url := fmt.Sprintf("ws://localhost%s/ws/embed_chunks/%s", app.chatPort.Port, job.ProjectID)
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("connecting to the embedding service: %w", err)
+	}
+	defer ws.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		ws.SetReadDeadline(deadline)
+	}
+
+	request := map[string]interface{}{
+		"file_id":    job.FileID,
+		"project_id": job.ProjectID,
+	}
+	if err := sendJSON(ws, request); err != nil {
+		return "", err
+	}
+
+	message := "Embedded chunks"
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				return message, nil
+			}
+			return "", fmt.Errorf("reading from the embedding service: %w", err)
+		}
+
+		var response FileUploadResponse
+		if err := json.Unmarshal(data, &response); err != nil {
+			app.errorLog.Printf("Invalid message from the embedding service: %v", err)
+			continue
+		}
+
+		switch response.Status {
+		case "error":
+			return "", fmt.Errorf("embedding service: %s", response.Error)
+		case "completed", "done":
+			if response.Message != "" {
+				message = response.Message
+			}
+			return message, nil
+		}
+
+		if response.Message != "" {
+			app.jobEvents.publish(newJobEvent(job, models.JobRunning, response.Message))
+		}
+	}
+}
+
+// retryJob queues a failed job again with an increasing delay, or fails it
+// for good once it is out of attempts
+func (app *application) retryJob(job *models.Job, err error) {
+	app.errorLog.Printf("Job %s (%s of file %s) attempt %d failed: %v", job.ID, job.Step, job.FileID, job.Attempts, err)
+
+	var permanent permanentError
+	if errors.As(err, &permanent) || job.Attempts >= job.MaxAttempts {
+		if err := app.jobs.Fail(job.ID, err.Error()); err != nil {
+			app.errorLog.Printf("Error failing job %s: %v", job.ID, err)
+		}
+		app.setFileStatus(job.FileID, "error")
+		if file, ferr := app.files.GetByID(job.FileID); ferr == nil {
+			app.logFileEvent(file, uuid.Nil, models.FileFailed, fmt.Sprintf("The %s step failed: %v", job.Step, err))
+		}
+
+		event := newJobEvent(job, models.JobFailed, fmt.Sprintf("The %s step failed", job.Step))
+		event.Error = err.Error()
+		app.jobEvents.publish(event)
+		return
+	}
+
+	delay := jobRetryDelay << (job.Attempts - 1)
+	if err := app.jobs.Retry(job.ID, err.Error(), time.Now().Add(delay)); err != nil {
+		app.errorLog.Printf("Error requeueing job %s: %v", job.ID, err)
+	}
+
+	event := newJobEvent(job, "retrying", fmt.Sprintf("The %s step failed, retrying in %s", job.Step, delay))
+	event.Error = err.Error()
+	app.jobEvents.publish(event)
+}
+
+func (app *application) setFileStatus(fileID uuid.UUID, status string) {
+	if err := app.files.UpdateStatus(fileID, status); err != nil {
+		app.errorLog.Printf("Error updating status of file %s: %v", fileID, err)
+	}
+}
+
+// handleDocumentProcessing streams the processing progress of a project's
+// documents. It first sends the state of the latest job of every file, so a
+// client can attach at any time, then every event as it happens.
+func (app *application) handleDocumentProcessing(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	projectID, ok := app.parseUUID(w, params.ByName("id"))
+	if !ok {
+		return
+	}
+
+	userID := app.userIdFromSession(r)
+	if userID == uuid.Nil {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	hasAccess, err := app.projects.HasAccess(projectID, userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if !hasAccess {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	ws, err := app.upgradeWebSocket(w, r)
+	if err != nil {
+		app.errorLog.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+	defer ws.Close()
+
+	// Subscribe before reading the snapshot so no event is missed
+	events := app.jobEvents.subscribe(projectID)
+	defer app.jobEvents.unsubscribe(projectID, events)
+
+	jobs, err := app.jobs.LatestByProject(projectID)
+	if err != nil {
+		app.errorLog.Printf("Error loading jobs of project %s: %v", projectID, err)
+		sendError(ws, "Internal server error")
+		return
+	}
+	for _, job := range jobs {
+		if err := sendJSON(ws, jobSnapshot(job)); err != nil {
+			return
+		}
+	}
+
+	// The client sends nothing, reading only detects that it went away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case event := <-events:
+			if err := sendJSON(ws, event); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// jobSnapshot describes the stored state of a job as an event
+func jobSnapshot(job *models.Job) jobEvent {
+	switch job.Status {
+	case models.JobQueued:
+		if job.Attempts > 0 {
+			event := newJobEvent(job, "retrying", fmt.Sprintf("Waiting to retry the %s step", job.Step))
+			event.Error = job.LastError
+			return event
+		}
+		return newJobEvent(job, job.Status, fmt.Sprintf("Waiting for the %s step", job.Step))
+	case models.JobRunning:
+		return newJobEvent(job, job.Status, fmt.Sprintf("Running %s step", job.Step))
+	case models.JobFailed:
+		event := newJobEvent(job, job.Status, fmt.Sprintf("The %s step failed", job.Step))
+		event.Error = job.LastError
+		return event
+	case models.JobDone:
+		// The latest job of a file is only done once the last step ran
+		event := newJobEvent(job, job.Status, fmt.Sprintf("Finished %s step", job.Step))
+		event.Processed = true
+		return event
+	}
+	return newJobEvent(job, job.Status, fmt.Sprintf("The %s step was %s", job.Step, job.Status))
+}
@@ -9,9 +9,16 @@ import (
 	"os"
 	"time"
 
+	"kdg/be/lab/internal/blobstore"
 	"kdg/be/lab/internal/db"
+	"kdg/be/lab/internal/fileprocessor"
+	"kdg/be/lab/internal/geocode"
+	"kdg/be/lab/internal/mapstyle"
 	"kdg/be/lab/internal/model"
 	"kdg/be/lab/internal/models"
+	"kdg/be/lab/internal/scanner"
+	"kdg/be/lab/internal/sniff"
+	"kdg/be/lab/internal/vectorstore"
 
 	"github.com/BurntSushi/toml"
 	"github.com/alexedwards/scs/sqlite3store"
@@ -23,23 +30,50 @@ import (
 )
 
 type application struct {
-	errorLog        *log.Logger
-	infoLog         *log.Logger
-	models          *model.Models
-	chatPort        *model.ChatPort
-	geoData         *models.GeoData
-	users           *models.UserModel
-	chats           *models.ChatModel
-	messages        *models.MessageModel
-	projects        *models.ProjectModel
-	projectDatabase *models.ProjectDatabaseModel
-	schemas         *models.SchemaModel
-	files           *models.FileModel
-	templateCache   map[string]*template.Template
-	formDecoder     *form.Decoder
-	sessionManager  *scs.SessionManager
-	i18nBundle      *i18n.Bundle
-	externalAPI     *ExternalAPIClient
+	errorLog          *log.Logger
+	infoLog           *log.Logger
+	models            *model.Models
+	chatPort          *model.ChatPort
+	geoData           *models.GeoData
+	users             *models.UserModel
+	chats             *models.ChatModel
+	messages          *models.MessageModel
+	projects          *models.ProjectModel
+	projectDatabase   *models.ProjectDatabaseModel
+	schemas           *models.SchemaModel
+	files             *models.FileModel
+	fileEvents        *models.FileEventModel
+	layers            *models.LayerModel
+	mapViews          *models.MapViewModel
+	tokens            *models.TokenModel
+	webhooks          *models.WebhookModel
+	webhookDeliveries *models.WebhookDeliveryModel
+	webhookWake       chan struct{}
+	webhookClient     *http.Client
+	projectDBs        *projectDBPool
+	layerCache        *layerCache
+	uploads           *models.UploadModel
+	chunks            *models.ChunkModel
+	texts             *models.TextModel
+	jobs              *models.JobModel
+	jobEvents         *jobHub
+	jobWake           chan struct{}
+	templateCache     map[string]*template.Template
+	formDecoder       *form.Decoder
+	sessionManager    *scs.SessionManager
+	i18nBundle        *i18n.Bundle
+	externalAPI       *ExternalAPIClient
+	blobStores        map[string]blobstore.Store
+	uploadDir         string
+	maxUploadSize     int64
+	projectQuota      int64
+	allowlist         sniff.Allowlist
+	scanner           scanner.Scanner
+	allowedOrigins    map[string]bool
+	vectors           *vectorstore.Index
+	gazetteer         *geocode.Gazetteer
+	geoStyles         map[string]*mapstyle.Style
+	layerRole         string
 }
 
 func main() {
@@ -55,8 +89,35 @@ func main() {
 	dbName := flag.String("db-name", "devdb", "PostgreSQL database name")
 	dbSSLMode := flag.String("db-sslmode", "disable", "PostgreSQL SSL mode")
 
+	wsOrigins := flag.String("ws-origins", "", "Comma separated origins allowed to open WebSockets besides this server, e.g. https://maps.example.com")
+
 	sessionDBPath := flag.String("session-db", "data/sessions.db", "SQLite database for sessions")
 
+	blobDir := flag.String("blob-dir", "data/blobs", "Directory for locally stored documents")
+	uploadDir := flag.String("upload-dir", "data/uploads", "Directory for uploads in progress")
+	maxUploadSize := flag.Int64("max-upload-size", 4<<30, "Maximum size of a single uploaded file in bytes")
+	projectQuota := flag.Int64("project-quota", 20<<30, "Maximum total size of the documents in a project in bytes")
+	uploadTypes := flag.String("upload-types", "", "TOML file listing the accepted document types per role (built-in defaults when empty)")
+	clamdAddr := flag.String("clamd-addr", "", "ClamAV clamd TCP address for scanning uploads, e.g. localhost:3310 (scanning disabled when empty)")
+	embedder := flag.String("embedder", "external", "Where chunks are embedded: external (processing service), ollama or hash")
+	embedModel := flag.String("embed-model", "nomic-embed-text", "Ollama model used for embeddings")
+	ollamaURL := flag.String("ollama-url", "", "Ollama server URL for embeddings (default server when empty)")
+	vectorStore := flag.String("vector-store", "auto", "Store for local embeddings: auto (pgvector when installed, else flat), pgvector or flat")
+	vectorDir := flag.String("vector-dir", "data/vectors", "Directory of the flat vector index")
+	jobWorkers := flag.Int("job-workers", 2, "Number of background workers processing documents")
+	clamdTimeout := flag.Duration("clamd-timeout", 2*time.Minute, "Timeout for scanning a single upload")
+	s3Endpoint := flag.String("s3-endpoint", "", "S3-compatible endpoint, e.g. http://localhost:9000 for MinIO (disabled when empty)")
+	s3Region := flag.String("s3-region", "us-east-1", "S3 region")
+	s3Bucket := flag.String("s3-bucket", "documents", "S3 bucket for documents")
+	s3AccessKey := flag.String("s3-access-key", "", "S3 access key")
+	s3SecretKey := flag.String("s3-secret-key", "", "S3 secret key")
+	s3PathStyle := flag.Bool("s3-path-style", true, "Use path-style S3 addressing (required for MinIO)")
+	gazetteerPath := flag.String("gazetteer", "", "SQLite gazetteer for geocoding, e.g. data/gazetteer.db (disabled when empty)")
+	gazetteerImport := flag.String("gazetteer-import", "", "Comma separated CSV files of places or addresses to load into the gazetteer at startup")
+	layerRole := flag.String("layer-role", "", "Read-only role that table and query layers run as on project databases; the connection user must be a member of it (connection user when empty)")
+	tiktokenDir := flag.String("tiktoken-dir", "data/tiktoken", "Directory of tiktoken encoding files for tiktoken chunking, e.g. cl100k_base.tiktoken; encodings are never downloaded")
+	geoStylesPath := flag.String("geo-styles", "", "JSON file of map styles by chat geo object key, e.g. Polygon (built-in defaults when empty)")
+
 	flag.Parse()
 
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
@@ -78,6 +139,79 @@ func main() {
 	}
 	defer postgres.Close()
 
+	if err := db.MigratePostgres(postgres); err != nil {
+		errorLog.Fatal(err)
+	}
+
+	// Document storage backends, keyed by the upload form's storage location
+	blobStores := map[string]blobstore.Store{}
+
+	localStore, err := blobstore.NewLocalStore(*blobDir)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	blobStores["local"] = localStore
+
+	if *s3Endpoint != "" {
+		s3Store, err := blobstore.NewS3Store(blobstore.S3Config{
+			Endpoint:  *s3Endpoint,
+			Region:    *s3Region,
+			Bucket:    *s3Bucket,
+			AccessKey: *s3AccessKey,
+			SecretKey: *s3SecretKey,
+			PathStyle: *s3PathStyle,
+		})
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		blobStores["s3"] = s3Store
+	}
+
+	// Document types accepted per document role
+	allowlist := sniff.DefaultAllowlist()
+	if *uploadTypes != "" {
+		allowlist, err = sniff.LoadAllowlist(*uploadTypes)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+	}
+
+	// Malware scanning of uploaded documents
+	var uploadScanner scanner.Scanner = scanner.Nop{}
+	if *clamdAddr != "" {
+		uploadScanner = scanner.NewClamd(*clamdAddr, *clamdTimeout)
+	}
+
+	// Local embedding and vector search of document chunks
+	vectors, err := openVectorIndex(postgres, *embedder, *embedModel, *ollamaURL, *vectorStore, *vectorDir, infoLog)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	// Offline geocoding of place names
+	gazetteer, err := openGazetteer(*gazetteerPath, *gazetteerImport, infoLog)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	if gazetteer != nil {
+		defer gazetteer.Close()
+	}
+
+	// Encodings of tiktoken chunking, read from disk once at startup
+	fileprocessor.UseEncodingDir(*tiktokenDir)
+	if err := fileprocessor.PreloadEncoding(fileprocessor.DefaultEncoding); err != nil {
+		infoLog.Printf("tiktoken chunking is unavailable: %v", err)
+	}
+
+	// Styles of the feature sets in chat answers
+	var geoStyles map[string]*mapstyle.Style
+	if *geoStylesPath != "" {
+		geoStyles, err = mapstyle.LoadFile(*geoStylesPath)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+	}
+
 	// Connect to SQLite for sessions
 	sessionDB, err := db.OpenSQLiteDB(*sessionDBPath)
 	if err != nil {
@@ -102,25 +236,61 @@ func main() {
 	sessionManager.Cookie.Secure = true
 
 	app := &application{
-		errorLog:        errorLog,
-		infoLog:         infoLog,
-		models:          &model.Models{Model: *ollama},
-		chatPort:        &model.ChatPort{Port: *chatPort},
-		geoData:         &models.GeoData{},
-		users:           models.NewUserModel(postgres),
-		chats:           models.NewChatModel(postgres),
-		messages:        models.NewMessageModel(postgres),
-		projects:        models.NewProjectModel(postgres),
-		projectDatabase: models.NewProjectDatabaseModel(postgres),
-		schemas:         models.NewSchemaModel(postgres),
-		files:           models.NewFileModel(postgres),
-		templateCache:   templateCache,
-		formDecoder:     formDecoder,
-		sessionManager:  sessionManager,
-		i18nBundle:      i18nBundle,
-		externalAPI:     NewExternalAPIClient(*externalAPIBaseURL),
+		errorLog:          errorLog,
+		infoLog:           infoLog,
+		models:            &model.Models{Model: *ollama},
+		chatPort:          &model.ChatPort{Port: *chatPort},
+		geoData:           &models.GeoData{},
+		users:             models.NewUserModel(postgres),
+		chats:             models.NewChatModel(postgres),
+		messages:          models.NewMessageModel(postgres),
+		projects:          models.NewProjectModel(postgres),
+		projectDatabase:   models.NewProjectDatabaseModel(postgres),
+		schemas:           models.NewSchemaModel(postgres),
+		files:             models.NewFileModel(postgres),
+		fileEvents:        models.NewFileEventModel(postgres),
+		layers:            models.NewLayerModel(postgres),
+		mapViews:          models.NewMapViewModel(postgres),
+		tokens:            models.NewTokenModel(postgres),
+		webhooks:          models.NewWebhookModel(postgres),
+		webhookDeliveries: models.NewWebhookDeliveryModel(postgres),
+		webhookWake:       make(chan struct{}, 1),
+		webhookClient:     newWebhookClient(),
+		projectDBs:        newProjectDBPool(),
+		layerCache:        newLayerCache(),
+		uploads:           models.NewUploadModel(postgres),
+		chunks:            models.NewChunkModel(postgres),
+		texts:             models.NewTextModel(postgres),
+		jobs:              models.NewJobModel(postgres),
+		jobEvents:         newJobHub(),
+		jobWake:           make(chan struct{}, 1),
+		templateCache:     templateCache,
+		formDecoder:       formDecoder,
+		sessionManager:    sessionManager,
+		i18nBundle:        i18nBundle,
+		externalAPI:       NewExternalAPIClient(*externalAPIBaseURL),
+		blobStores:        blobStores,
+		uploadDir:         *uploadDir,
+		maxUploadSize:     *maxUploadSize,
+		projectQuota:      *projectQuota,
+		allowlist:         allowlist,
+		scanner:           uploadScanner,
+		allowedOrigins:    parseOrigins(*wsOrigins),
+		vectors:           vectors,
+		gazetteer:         gazetteer,
+		geoStyles:         geoStyles,
+		layerRole:         *layerRole,
 	}
 
+	// Discard upload sessions that were never resumed
+	go app.cleanupUploads()
+
+	// Process queued documents in the background
+	app.runJobWorkers(*jobWorkers)
+
+	// Post queued webhook deliveries
+	go app.webhookWorker()
+
 	tlsConfig := &tls.Config{
 		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
 	}
@@ -139,6 +309,10 @@ func main() {
 	infoLog.Printf("Using ollama model: %s", *ollama)
 	infoLog.Printf("Using sqlite as session database: %s", *sessionDBPath)
 	infoLog.Printf("Starting chat server on %s", *chatPort)
+	infoLog.Printf("Storing documents in %s (S3 enabled: %t)", *blobDir, *s3Endpoint != "")
+	infoLog.Printf("Scanning uploads with clamd: %t", *clamdAddr != "")
+	infoLog.Printf("Embedding chunks with: %s", *embedder)
+	infoLog.Printf("Geocoding with gazetteer: %t", gazetteer != nil)
 	err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
 	errorLog.Fatal(err)
 }
@@ -0,0 +1,661 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"kdg/be/lab/internal/crs"
+	"kdg/be/lab/internal/geoexport"
+	"kdg/be/lab/internal/geoimport"
+	"kdg/be/lab/internal/geojson"
+	"kdg/be/lab/internal/models"
+	"kdg/be/lab/internal/sniff"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/lib/pq"
+)
+
+// Layer limits
+const (
+	maxLayerFeatures  = 5000
+	maxLayerFileSize  = 50 << 20
+	layerQueryTimeout = 30 * time.Second
+)
+
+// errLayerSource is wrapped by errors caused by a layer's configuration
+// rather than by the server
+var errLayerSource = errors.New("layer source unavailable")
+
+// layerName restricts names so they are safe in URLs
+var layerName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 _.-]{0,63}$`)
+
+// projectDBPool keeps one connection pool per project database used by
+// table and query layers
+type projectDBPool struct {
+	mu  sync.Mutex
+	dbs map[uuid.UUID]*sql.DB
+}
+
+func newProjectDBPool() *projectDBPool {
+	return &projectDBPool{dbs: map[uuid.UUID]*sql.DB{}}
+}
+
+func (p *projectDBPool) get(pd *models.ProjectDatabase) (*sql.DB, error) {
+	if pd.DbType != "postgres" {
+		return nil, fmt.Errorf("%w: map layers need a postgres database, not %s", errLayerSource, pd.DbType)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if db, ok := p.dbs[pd.ID]; ok {
+		return db, nil
+	}
+
+	db, err := sql.Open("postgres", pd.ConnectionString)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(4)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+
+	p.dbs[pd.ID] = db
+	return db, nil
+}
+
+// layerFeatures loads the features of a layer, limited to bbox when one is
+// given. truncated reports whether features were dropped at the limit.
+func (app *application) layerFeatures(ctx context.Context, layer *models.Layer, bbox *geojson.BBox) (fc *geojson.FeatureCollection, truncated bool, err error) {
+	switch layer.Source {
+	case models.LayerTable, models.LayerQuery:
+		return app.databaseLayerFeatures(ctx, layer, bbox)
+	}
+
+	fc, err = app.sourceFeatures(ctx, layer)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The cached collection is shared, so limit a copy
+	if bbox != nil {
+		fc = fc.Filter(*bbox)
+	} else {
+		fc = &geojson.FeatureCollection{Features: fc.Features}
+	}
+	truncated = fc.Limit(maxLayerFeatures)
+	return fc, truncated, nil
+}
+
+// fileLayerFeatures reads the layer of a spatial file uploaded to the
+// project
+func (app *application) fileLayerFeatures(ctx context.Context, layer *models.Layer) (*geojson.FeatureCollection, error) {
+	if !layer.FileID.Valid {
+		return nil, fmt.Errorf("%w: no file set", errLayerSource)
+	}
+
+	file, err := app.files.GetByID(layer.FileID.UUID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return nil, fmt.Errorf("%w: the file was deleted", errLayerSource)
+		}
+		return nil, err
+	}
+
+	layers, err := app.readLayerFile(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	l, err := geoimport.Find(layers, layer.Sublayer)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errLayerSource, err)
+	}
+	return l.Features, nil
+}
+
+// readLayerFile reads every layer of a spatial file, reprojected to WGS84
+// and validated
+func (app *application) readLayerFile(ctx context.Context, file *models.File) ([]*geoimport.Layer, error) {
+	if file.Status == "quarantined" {
+		return nil, fmt.Errorf("%w: the file is quarantined", errLayerSource)
+	}
+
+	store, _, err := app.blobStore(file.StorageLocation)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := store.Get(ctx, file.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	data, err := io.ReadAll(io.LimitReader(blob, maxLayerFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxLayerFileSize {
+		return nil, fmt.Errorf("%w: the file is too large for a layer", errLayerSource)
+	}
+
+	layers, err := geoimport.Read(data, sniff.TypeOf(file.MimeType), app.projectCRS(file.ProjectID))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errLayerSource, err)
+	}
+	return layers, nil
+}
+
+// parseFeatures decodes GeoJSON and reprojects it to WGS84 from the system
+// named by its crs member, the project default or a guessed national grid,
+// before repairing and validating it. The reprojection is reported as the
+// first fix.
+func parseFeatures(data []byte, projectCRS *crs.CRS) (*geojson.FeatureCollection, []string, error) {
+	fc, err := geojson.Decode(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fixes, err := geoimport.Normalize(fc, nil, projectCRS)
+	if err != nil {
+		return nil, fixes, err
+	}
+	return fc, fixes, nil
+}
+
+// describeLayer records the size, extent and attribute columns of a
+// layer's features
+func describeLayer(layer *models.Layer, fc *geojson.FeatureCollection) {
+	layer.FeatureCount = len(fc.Features)
+	layer.BBox = nil
+	if box, ok := fc.Bounds(); ok {
+		layer.BBox = box[:]
+	}
+	layer.Schema = []models.LayerField{}
+	for _, f := range geoexport.InferSchema(fc.Features) {
+		layer.Schema = append(layer.Schema, models.LayerField{Name: f.Name, Type: f.Type.String()})
+	}
+}
+
+// projectCRS returns the reference system a project assumes for GeoJSON
+// without a crs member, or nil when it has none
+func (app *application) projectCRS(projectID uuid.UUID) *crs.CRS {
+	project, err := app.projects.Get(projectID)
+	if err != nil {
+		app.errorLog.Printf("Error loading project %s: %v", projectID, err)
+		return nil
+	}
+	if project.DefaultCRS == 0 {
+		return nil
+	}
+
+	c, err := crs.Lookup(project.DefaultCRS)
+	if err != nil {
+		app.errorLog.Printf("Project %s: %v", projectID, err)
+		return nil
+	}
+	return c
+}
+
+// databaseLayerFeatures reads features from a PostGIS table or query on the
+// project database. Queries run in a read-only transaction and the bbox
+// filter is applied by PostGIS.
+func (app *application) databaseLayerFeatures(ctx context.Context, layer *models.Layer, bbox *geojson.BBox) (*geojson.FeatureCollection, bool, error) {
+	pd, err := app.projectDatabase.GetByProjectID(layer.ProjectID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return nil, false, fmt.Errorf("%w: the project has no database", errLayerSource)
+		}
+		return nil, false, err
+	}
+
+	db, err := app.projectDBs.get(pd)
+	if err != nil {
+		return nil, false, err
+	}
+
+	geom := pq.QuoteIdentifier(layer.GeometryColumn)
+
+	var source string
+	if layer.Source == models.LayerTable {
+		source = quoteTableName(layer.TableName)
+	} else {
+		query, err := cleanLayerQuery(layer.Query)
+		if err != nil {
+			return nil, false, fmt.Errorf("%w: %v", errLayerSource, err)
+		}
+		source = "(" + query + ")"
+	}
+
+	stmt := fmt.Sprintf(`
+		SELECT ST_AsGeoJSON(ST_Transform(t.%[1]s, 4326)), to_jsonb(t) - %[2]s
+		FROM %[3]s AS t
+		WHERE t.%[1]s IS NOT NULL`, geom, pq.QuoteLiteral(layer.GeometryColumn), source)
+
+	args := []interface{}{}
+	if bbox != nil {
+		stmt += ` AND ST_Intersects(ST_Transform(t.` + geom + `, 4326), ST_MakeEnvelope($1, $2, $3, $4, 4326))`
+		args = append(args, bbox[0], bbox[1], bbox[2], bbox[3])
+	}
+	// The limit is always bound so that the statement goes through the
+	// extended protocol, which runs exactly one statement
+	args = append(args, maxLayerFeatures+1)
+	stmt += fmt.Sprintf(" LIMIT $%d", len(args))
+
+	ctx, cancel := context.WithTimeout(ctx, layerQueryTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", errLayerSource, err)
+	}
+	defer tx.Rollback()
+
+	timeout := fmt.Sprintf("SET LOCAL statement_timeout = %d", layerQueryTimeout.Milliseconds())
+	if _, err := tx.ExecContext(ctx, timeout); err != nil {
+		return nil, false, fmt.Errorf("%w: %v", errLayerSource, err)
+	}
+	if app.layerRole != "" {
+		if _, err := tx.ExecContext(ctx, "SET LOCAL ROLE "+pq.QuoteIdentifier(app.layerRole)); err != nil {
+			return nil, false, fmt.Errorf("%w: %v", errLayerSource, err)
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", errLayerSource, err)
+	}
+	defer rows.Close()
+
+	fc := geojson.NewFeatureCollection()
+	for rows.Next() {
+		var geometry, properties []byte
+		if err := rows.Scan(&geometry, &properties); err != nil {
+			return nil, false, err
+		}
+		g := &geojson.Geometry{}
+		if err := json.Unmarshal(geometry, g); err != nil {
+			return nil, false, fmt.Errorf("%w: %v", errLayerSource, err)
+		}
+		fc.Features = append(fc.Features, &geojson.Feature{
+			Geometry:   g,
+			Properties: properties,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("%w: %v", errLayerSource, err)
+	}
+
+	truncated := fc.Limit(maxLayerFeatures)
+	return fc, truncated, nil
+}
+
+// cleanLayerQuery trims a query layer's SQL and rejects text that could end
+// the subquery it is wrapped in: semicolons, unbalanced parentheses,
+// comments, dollar quotes and escape strings outside string literals and
+// quoted identifiers
+func cleanLayerQuery(query string) (string, error) {
+	query = strings.TrimRight(strings.TrimSpace(query), "; \t\r\n")
+	if query == "" {
+		return "", errors.New("the query is empty")
+	}
+	if strings.Contains(query, ";") {
+		return "", errors.New("the query must be a single statement without semicolons")
+	}
+
+	depth := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		next := byte(0)
+		if i+1 < len(query) {
+			next = query[i+1]
+		}
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' && i > 0 && (query[i-1] == 'e' || query[i-1] == 'E'):
+			// Backslashes escape quotes in E'' strings, which this scan
+			// does not follow
+			return "", errors.New("the query must not contain escape strings")
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '-' && next == '-', c == '/' && next == '*', c == '*' && next == '/':
+			return "", errors.New("the query must not contain comments")
+		case c == '$' && (next < '0' || next > '9'):
+			// $$ and $tag$ start dollar-quoted strings; only $n is allowed
+			return "", errors.New("the query must not contain dollar quotes")
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth < 0 {
+				return "", errors.New("the query has unbalanced parentheses")
+			}
+		}
+	}
+	if quote != 0 {
+		return "", errors.New("the query has an unterminated quote")
+	}
+	if depth != 0 {
+		return "", errors.New("the query has unbalanced parentheses")
+	}
+	return query, nil
+}
+
+// quoteTableName quotes a table name with an optional schema
+func quoteTableName(name string) string {
+	parts := strings.SplitN(name, ".", 2)
+	for i, p := range parts {
+		parts[i] = pq.QuoteIdentifier(p)
+	}
+	return strings.Join(parts, ".")
+}
+
+// projectForUser parses a project ID and checks that the current user has
+// access. It writes the error response and returns false when not; projects
+// of which the user is not a member are not found.
+func (app *application) projectForUser(w http.ResponseWriter, r *http.Request, id string) (uuid.UUID, bool) {
+	projectID, ok := app.parseUUID(w, id)
+	if !ok {
+		return uuid.Nil, false
+	}
+
+	hasAccess, err := app.projects.HasAccess(projectID, app.userIdFromSession(r))
+	if err != nil {
+		app.serverError(w, err)
+		return uuid.Nil, false
+	}
+	if !hasAccess {
+		app.notFound(w)
+		return uuid.Nil, false
+	}
+
+	return projectID, true
+}
+
+// layerForUser loads the layer with the given ID and checks that the user
+// may see its project. It writes the error response and returns nil
+// otherwise.
+func (app *application) layerForUser(w http.ResponseWriter, r *http.Request, id string) *models.Layer {
+	layerID, ok := app.parseUUID(w, id)
+	if !ok {
+		return nil
+	}
+
+	layer, err := app.layers.Get(layerID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return nil
+	}
+
+	hasAccess, err := app.projects.HasAccess(layer.ProjectID, app.userIdFromSession(r))
+	if err != nil {
+		app.serverError(w, err)
+		return nil
+	}
+	if !hasAccess {
+		app.clientError(w, http.StatusForbidden)
+		return nil
+	}
+
+	return layer
+}
+
+// projectLayers lists the map layers of a project
+func (app *application) projectLayers(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	projectID, ok := app.projectForUser(w, r, params.ByName("id"))
+	if !ok {
+		return
+	}
+
+	layers, err := app.layers.GetByProject(projectID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, layers)
+}
+
+// layerRequest is the body of a layer creation request. Features holds the
+// GeoJSON of a saved chat result.
+type layerRequest struct {
+	Name           string          `json:"name"`
+	Source         string          `json:"source"`
+	FileID         string          `json:"file_id"`
+	Sublayer       string          `json:"sublayer"`
+	TableName      string          `json:"table_name"`
+	GeometryColumn string          `json:"geometry_column"`
+	Query          string          `json:"query"`
+	Features       json.RawMessage `json:"features"`
+	Attributes     []string        `json:"attributes"`
+}
+
+// projectLayerCreatePost registers a new map layer for a project
+func (app *application) projectLayerCreatePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	projectID, ok := app.projectForUser(w, r, params.ByName("id"))
+	if !ok {
+		return
+	}
+
+	var req layerRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLayerFileSize)).Decode(&req); err != nil {
+		app.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON body"})
+		return
+	}
+
+	layer := &models.Layer{
+		ProjectID: projectID,
+		Name:      strings.TrimSpace(req.Name),
+		Source:    req.Source,
+		CreatedBy: app.userIdFromSession(r),
+	}
+
+	if msg := app.configureLayer(r.Context(), layer, &req); msg != "" {
+		app.writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": msg})
+		return
+	}
+
+	if err := app.layers.Insert(layer); err != nil {
+		if errors.Is(err, models.ErrDuplicateName) {
+			app.writeJSON(w, http.StatusConflict, map[string]string{"error": "A layer with this name already exists"})
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, layer)
+}
+
+// configureLayer checks a layer request and copies its source settings to
+// the layer. It returns a message for the user when the request is invalid.
+func (app *application) configureLayer(ctx context.Context, layer *models.Layer, req *layerRequest) string {
+	if !layerName.MatchString(layer.Name) {
+		return "Layer names use letters, digits, spaces, dots, dashes and underscores"
+	}
+	if msg := checkTileAttributes(req.Attributes); msg != "" {
+		return msg
+	}
+	layer.Attributes = req.Attributes
+
+	switch layer.Source {
+	case models.LayerFile:
+		fileID, err := uuid.Parse(req.FileID)
+		if err != nil {
+			return "A file layer needs a file_id"
+		}
+		file, err := app.files.GetByID(fileID)
+		if err != nil || file.ProjectID != layer.ProjectID {
+			return "The file is not part of this project"
+		}
+		if !geoimport.Supported(sniff.TypeOf(file.MimeType)) {
+			return "File layers need a GeoJSON, KML, Shapefile, GeoPackage or CSV file"
+		}
+		layer.FileID = uuid.NullUUID{UUID: fileID, Valid: true}
+		layer.Sublayer = req.Sublayer
+		fc, err := app.fileLayerFeatures(ctx, layer)
+		if err != nil {
+			return fmt.Sprintf("The file could not be read as a layer: %v", err)
+		}
+		describeLayer(layer, fc)
+
+	case models.LayerTable:
+		if req.TableName == "" || req.GeometryColumn == "" {
+			return "A table layer needs table_name and geometry_column"
+		}
+		layer.TableName = req.TableName
+		layer.GeometryColumn = req.GeometryColumn
+		if msg := app.countDatabaseLayer(ctx, layer); msg != "" {
+			return msg
+		}
+
+	case models.LayerQuery:
+		if strings.TrimSpace(req.Query) == "" || req.GeometryColumn == "" {
+			return "A query layer needs query and geometry_column"
+		}
+		query, err := cleanLayerQuery(req.Query)
+		if err != nil {
+			return fmt.Sprintf("The query cannot be used: %v", err)
+		}
+		layer.Query = query
+		layer.GeometryColumn = req.GeometryColumn
+		if msg := app.countDatabaseLayer(ctx, layer); msg != "" {
+			return msg
+		}
+
+	case models.LayerChat:
+		fc, _, err := parseFeatures(req.Features, app.projectCRS(layer.ProjectID))
+		if err != nil {
+			return fmt.Sprintf("A chat layer needs valid GeoJSON features: %v", err)
+		}
+		data, err := json.Marshal(fc)
+		if err != nil {
+			return "A chat layer needs valid GeoJSON features"
+		}
+		layer.Data = data
+		describeLayer(layer, fc)
+
+	default:
+		return "Source must be file, table, query or chat"
+	}
+
+	return ""
+}
+
+// countDatabaseLayer reads a table or query layer once, to check that it
+// works and to record its size. Layers beyond the feature limit count one
+// more than the limit.
+func (app *application) countDatabaseLayer(ctx context.Context, layer *models.Layer) string {
+	fc, truncated, err := app.databaseLayerFeatures(ctx, layer, nil)
+	if err != nil {
+		if errors.Is(err, errLayerSource) {
+			return fmt.Sprintf("The layer could not be read: %v", err)
+		}
+		app.errorLog.Printf("Error reading layer %s: %v", layer.Name, err)
+		return "The layer could not be read"
+	}
+	describeLayer(layer, fc)
+	if truncated {
+		layer.FeatureCount++
+	}
+	return ""
+}
+
+// projectLayerDeletePost removes a map layer
+func (app *application) projectLayerDeletePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	projectID, ok := app.projectForUser(w, r, params.ByName("id"))
+	if !ok {
+		return
+	}
+
+	layerID, ok := app.parseUUID(w, params.ByName("layer"))
+	if !ok {
+		return
+	}
+
+	layer, err := app.layers.Get(layerID)
+	if err != nil || layer.ProjectID != projectID {
+		if err == nil || errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	if err := app.layers.Delete(layer.ID); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.layerCache.invalidate(layer.ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// projectMapView shows the map with the layers of a project, as saved in
+// view when it is not nil
+func (app *application) projectMapView(w http.ResponseWriter, r *http.Request, id string, view *models.MapView) {
+	projectID, ok := app.projectForUser(w, r, id)
+	if !ok {
+		return
+	}
+
+	project, err := app.projects.Get(projectID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	layers, err := app.layers.GetByProject(projectID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	userID := app.userIdFromSession(r)
+	chats, err := app.chats.RetrieveByUserId(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	views, err := app.mapViews.GetByProject(projectID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Project = project
+	data.Layers = layers
+	data.MapViews = views
+	data.UserID = userID.String()
+	if data.MapView, err = mapViewJSON(view); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	data.CRSList = crs.Supported()
+	data.ExportFormats = geoexport.Formats
+	data.GeoData = `{"type":"FeatureCollection","features":[]}`
+	data.Chats = chats
+
+	app.render(w, http.StatusOK, "map.tmpl.html", data)
+}
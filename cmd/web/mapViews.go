@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kdg/be/lab/internal/geojson"
+	"kdg/be/lab/internal/mapstyle"
+	"kdg/be/lab/internal/models"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Map view limits
+const (
+	maxViewSketches = 500
+	maxViewNameLen  = 100
+)
+
+// viewBasemaps are the basemaps of the map page
+var viewBasemaps = map[string]bool{"osm": true, "satellite": true, "topo": true}
+
+// mapViewRequest is the body of a view creation or update request
+type mapViewRequest struct {
+	Name      string                     `json:"name"`
+	ProjectID string                     `json:"project_id"`
+	ChatID    string                     `json:"chat_id"`
+	CenterLon float64                    `json:"center_lon"`
+	CenterLat float64                    `json:"center_lat"`
+	Zoom      float64                    `json:"zoom"`
+	Basemap   string                     `json:"basemap"`
+	LayerIDs  []uuid.UUID                `json:"layer_ids"`
+	Styles    map[string]mapstyle.Symbol `json:"styles"`
+	Sketches  json.RawMessage            `json:"sketches"`
+}
+
+// mapViewResponse adds the link that opens a view
+type mapViewResponse struct {
+	*models.MapView
+	URL string `json:"url"`
+}
+
+func mapViewURL(v *models.MapView) string {
+	return "/map?view=" + v.ID.String()
+}
+
+// mapViewJSON encodes a view for the map page, or returns "" for nil
+func mapViewJSON(v *models.MapView) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	b, err := json.Marshal(mapViewResponse{v, mapViewURL(v)})
+	return string(b), err
+}
+
+// canViewMap reports whether a user may open a view: its creator, the
+// members of its project and the owner of its chat may
+func (app *application) canViewMap(v *models.MapView, userID uuid.UUID) (bool, error) {
+	if v.CreatedBy == userID {
+		return true, nil
+	}
+	if v.ProjectID.Valid {
+		hasAccess, err := app.projects.HasAccess(v.ProjectID.UUID, userID)
+		if err != nil || hasAccess {
+			return hasAccess, err
+		}
+	}
+	if v.ChatID.Valid {
+		chat, err := app.chats.GetByID(v.ChatID.UUID)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				return false, nil
+			}
+			return false, err
+		}
+		return chat.UserID == userID, nil
+	}
+	return false, nil
+}
+
+// mapViewForUser loads the view with the given ID and checks that the user
+// may open it. It writes the error response and returns nil otherwise.
+func (app *application) mapViewForUser(w http.ResponseWriter, r *http.Request, id string) *models.MapView {
+	viewID, ok := app.parseUUID(w, id)
+	if !ok {
+		return nil
+	}
+
+	view, err := app.mapViews.Get(viewID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return nil
+	}
+
+	canView, err := app.canViewMap(view, app.userIdFromSession(r))
+	if err != nil {
+		app.serverError(w, err)
+		return nil
+	}
+	if !canView {
+		app.clientError(w, http.StatusForbidden)
+		return nil
+	}
+
+	return view
+}
+
+// configureMapView checks a view request and copies it to the view. It
+// returns a message for the user when the request is invalid.
+func (app *application) configureMapView(view *models.MapView, req *mapViewRequest, userID uuid.UUID) (string, error) {
+	view.Name = strings.TrimSpace(req.Name)
+	if view.Name == "" || len([]rune(view.Name)) > maxViewNameLen {
+		return fmt.Sprintf("A view needs a name of at most %d characters", maxViewNameLen), nil
+	}
+	if math.Abs(req.CenterLon) > 180 || math.Abs(req.CenterLat) > 90 || req.Zoom < 0 || req.Zoom > 24 {
+		return "The center must be WGS84 degrees and the zoom between 0 and 24", nil
+	}
+	view.CenterLon, view.CenterLat, view.Zoom = req.CenterLon, req.CenterLat, req.Zoom
+
+	view.Basemap = req.Basemap
+	if view.Basemap == "" {
+		view.Basemap = "osm"
+	}
+	if !viewBasemaps[view.Basemap] {
+		return "Unknown basemap", nil
+	}
+
+	view.ProjectID = uuid.NullUUID{}
+	if req.ProjectID != "" {
+		projectID, err := uuid.Parse(req.ProjectID)
+		if err != nil {
+			return "Invalid project_id", nil
+		}
+		hasAccess, err := app.projects.HasAccess(projectID, userID)
+		if err != nil {
+			return "", err
+		}
+		if !hasAccess {
+			return "You do not have access to this project", nil
+		}
+		view.ProjectID = uuid.NullUUID{UUID: projectID, Valid: true}
+	}
+
+	view.ChatID = uuid.NullUUID{}
+	if req.ChatID != "" {
+		chatID, err := uuid.Parse(req.ChatID)
+		if err != nil {
+			return "Invalid chat_id", nil
+		}
+		chat, err := app.chats.GetByID(chatID)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			return "", err
+		}
+		if chat == nil || chat.UserID != userID {
+			return "The chat is not yours", nil
+		}
+		view.ChatID = uuid.NullUUID{UUID: chatID, Valid: true}
+	}
+
+	// Layers and their styles must come from the view's project
+	projectLayers := map[uuid.UUID]bool{}
+	if view.ProjectID.Valid {
+		layers, err := app.layers.GetByProject(view.ProjectID.UUID)
+		if err != nil {
+			return "", err
+		}
+		for _, l := range layers {
+			projectLayers[l.ID] = true
+		}
+	}
+	view.LayerIDs = []uuid.UUID{}
+	for _, id := range req.LayerIDs {
+		if !projectLayers[id] {
+			return "Visible layers must belong to the view's project", nil
+		}
+		view.LayerIDs = append(view.LayerIDs, id)
+	}
+	for id, style := range req.Styles {
+		layerID, err := uuid.Parse(id)
+		if err != nil || !projectLayers[layerID] {
+			return "Styles must be keyed by layers of the view's project", nil
+		}
+		if err := style.Check(); err != nil {
+			return "Styles have hex colours and weights, opacities and radii in range", nil
+		}
+	}
+	styles, err := json.Marshal(req.Styles)
+	if err != nil {
+		return "", err
+	}
+	if req.Styles == nil {
+		styles = []byte("{}")
+	}
+	view.Styles = styles
+
+	view.Sketches = nil
+	if len(req.Sketches) > 0 && string(req.Sketches) != "null" {
+		fc, err := geojson.Decode(req.Sketches)
+		if err != nil {
+			return fmt.Sprintf("Sketches must be GeoJSON: %v", err), nil
+		}
+		fc.Repair()
+		if err := fc.Validate(); err != nil {
+			return fmt.Sprintf("Sketches must be valid GeoJSON: %v", err), nil
+		}
+		if len(fc.Features) > maxViewSketches {
+			return fmt.Sprintf("A view holds at most %d sketches", maxViewSketches), nil
+		}
+		sketches, err := json.Marshal(fc)
+		if err != nil {
+			return "", err
+		}
+		view.Sketches = sketches
+	}
+
+	return "", nil
+}
+
+// mapViewCreatePost saves the state of the map as a new view
+func (app *application) mapViewCreatePost(w http.ResponseWriter, r *http.Request) {
+	var req mapViewRequest
+	if err := app.readJSON(w, r, &req); err != nil {
+		return
+	}
+
+	userID := app.userIdFromSession(r)
+	view := &models.MapView{CreatedBy: userID}
+	msg, err := app.configureMapView(view, &req, userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if msg != "" {
+		app.writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": msg})
+		return
+	}
+
+	if err := app.mapViews.Insert(view); err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, mapViewResponse{view, mapViewURL(view)})
+}
+
+// mapViewGet returns a saved view
+func (app *application) mapViewGet(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	view := app.mapViewForUser(w, r, params.ByName("id"))
+	if view == nil {
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, mapViewResponse{view, mapViewURL(view)})
+}
+
+// mapViewUpdatePost replaces a view with the current state of the map.
+// Only the creator of a view may change it.
+func (app *application) mapViewUpdatePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	view := app.mapViewForUser(w, r, params.ByName("id"))
+	if view == nil {
+		return
+	}
+	userID := app.userIdFromSession(r)
+	if view.CreatedBy != userID {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	var req mapViewRequest
+	if err := app.readJSON(w, r, &req); err != nil {
+		return
+	}
+
+	msg, err := app.configureMapView(view, &req, userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if msg != "" {
+		app.writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": msg})
+		return
+	}
+
+	if err := app.mapViews.Update(view); err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, mapViewResponse{view, mapViewURL(view)})
+}
+
+// mapViewDeletePost removes a view. Only its creator may remove it.
+func (app *application) mapViewDeletePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	view := app.mapViewForUser(w, r, params.ByName("id"))
+	if view == nil {
+		return
+	}
+	if view.CreatedBy != app.userIdFromSession(r) {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	if err := app.mapViews.Delete(view.ID); err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// projectMapViews lists the views attached to a project
+func (app *application) projectMapViews(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	projectID, ok := app.projectForUser(w, r, params.ByName("id"))
+	if !ok {
+		return
+	}
+
+	views, err := app.mapViews.GetByProject(projectID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, views)
+}
+
+// chatMapViews lists the views attached to one of the user's chats
+func (app *application) chatMapViews(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	chatID, ok := app.parseUUID(w, params.ByName("id"))
+	if !ok {
+		return
+	}
+
+	chat, err := app.chats.GetByID(chatID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+	if chat.UserID != app.userIdFromSession(r) {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	views, err := app.mapViews.GetByChat(chatID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, views)
+}
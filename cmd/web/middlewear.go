@@ -57,6 +57,18 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// Room left in an upload form's body for the fields besides the file
+const uploadFormOverhead = 1 << 20
+
+// limitUploadBody caps the body of an upload form at the upload size limit.
+// It must run before noSurf, which parses the whole form.
+func (app *application) limitUploadBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, app.maxUploadSize+uploadFormOverhead)
+		next.ServeHTTP(w, r)
+	})
+}
+
 func noSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
 	csrfHandler.SetBaseCookie(http.Cookie{
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/justinas/alice"
+)
+
+// apiRoute is an operation of the REST API. The router and the OpenAPI
+// document are both built from the list of routes.
+type apiRoute struct {
+	Method   string
+	Path     string // httprouter pattern below apiPrefix
+	Tag      string
+	Summary  string
+	Scope    string      // Token scope required; empty for public routes
+	Request  interface{} // Example of the JSON body, nil for none
+	Response interface{} // Example of the result, nil for 204 No Content
+	List     bool        // Response is an item of a paginated list
+	Stream   bool        // Also streams server-sent events on Accept: text/event-stream
+	Status   int         // Success status, 200 when zero
+	Handler  http.HandlerFunc
+}
+
+// apiSchema is a literal OpenAPI schema for results that have no Go type
+// to describe them
+type apiSchema map[string]interface{}
+
+// apiRouteHandler returns the route's handler behind its token check
+func (app *application) apiRouteHandler(route apiRoute) http.Handler {
+	if route.Scope == "" {
+		return route.Handler
+	}
+	return alice.New(app.requireToken(route.Scope)).ThenFunc(route.Handler)
+}
+
+// apiOpenAPI serves the OpenAPI 3 document of the REST API
+func (app *application) apiOpenAPI(w http.ResponseWriter, r *http.Request) {
+	app.writeJSON(w, http.StatusOK, openAPIDocument(app.apiRoutes()))
+}
+
+// openAPIGenerator derives schemas from Go types, collecting the named
+// structs as components
+type openAPIGenerator struct {
+	schemas map[string]interface{}
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	uuidType     = reflect.TypeOf(uuid.UUID{})
+	nullUUIDType = reflect.TypeOf(uuid.NullUUID{})
+	nullTimeType = reflect.TypeOf(sql.NullTime{})
+	rawJSONType  = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaName names the component of a struct, dropping the api prefix of
+// response types
+func schemaName(t reflect.Type) string {
+	name := strings.TrimPrefix(t.Name(), "api")
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+func (g *openAPIGenerator) schema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timeType:
+		return apiSchema{"type": "string", "format": "date-time"}
+	case uuidType:
+		return apiSchema{"type": "string", "format": "uuid"}
+	case nullUUIDType:
+		return apiSchema{"type": "string", "format": "uuid", "nullable": true}
+	case nullTimeType:
+		return apiSchema{"type": "string", "format": "date-time", "nullable": true}
+	case rawJSONType:
+		return apiSchema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := g.schema(t.Elem())
+		if _, ok := s["$ref"]; ok {
+			return apiSchema{"allOf": []interface{}{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.String:
+		return apiSchema{"type": "string"}
+	case reflect.Bool:
+		return apiSchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return apiSchema{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return apiSchema{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return apiSchema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return apiSchema{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return apiSchema{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name := schemaName(t)
+		if _, ok := g.schemas[name]; !ok {
+			g.schemas[name] = apiSchema{} // Placeholder for recursive types
+			g.schemas[name] = g.object(t)
+		}
+		return apiSchema{"$ref": "#/components/schemas/" + name}
+	}
+	return apiSchema{}
+}
+
+// object describes the JSON members of a struct
+func (g *openAPIGenerator) object(t reflect.Type) map[string]interface{} {
+	properties := apiSchema{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			embedded := f.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			inner := g.object(embedded)
+			for k, v := range inner["properties"].(apiSchema) {
+				properties[k] = v
+			}
+			if r, ok := inner["required"].([]string); ok {
+				required = append(required, r...)
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = g.schema(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	s := apiSchema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// result returns the schema of a route's result
+func (g *openAPIGenerator) result(v interface{}) map[string]interface{} {
+	if s, ok := v.(apiSchema); ok {
+		return s
+	}
+	return g.schema(reflect.TypeOf(v))
+}
+
+// openAPIPath converts an httprouter pattern to an OpenAPI path and its
+// parameters
+func openAPIPath(pattern string) (string, []interface{}) {
+	var params []interface{}
+	segments := strings.Split(pattern, "/")
+	for i, s := range segments {
+		if name, ok := strings.CutPrefix(s, ":"); ok {
+			segments[i] = "{" + name + "}"
+			params = append(params, apiSchema{
+				"name": name, "in": "path", "required": true,
+				"schema": apiSchema{"type": "string", "format": "uuid"},
+			})
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// openAPIDocument describes the routes as an OpenAPI 3 document
+func openAPIDocument(routes []apiRoute) map[string]interface{} {
+	g := &openAPIGenerator{schemas: map[string]interface{}{}}
+	errorResponse := apiSchema{"$ref": "#/components/responses/Error"}
+
+	paths := apiSchema{}
+	for _, route := range routes {
+		path, params := openAPIPath(route.Path)
+		if route.List {
+			params = append(params,
+				apiSchema{"name": "cursor", "in": "query", "description": "next_cursor of the previous page",
+					"schema": apiSchema{"type": "string"}},
+				apiSchema{"name": "limit", "in": "query", "description": "Items per page",
+					"schema": apiSchema{"type": "integer", "minimum": 1, "maximum": apiMaxLimit, "default": apiDefaultLimit}},
+			)
+		}
+
+		status := route.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := apiSchema{"description": http.StatusText(status)}
+		if route.Response != nil {
+			s := g.result(route.Response)
+			if route.List {
+				s = apiSchema{
+					"type":     "object",
+					"required": []string{"data"},
+					"properties": apiSchema{
+						"data":        apiSchema{"type": "array", "items": s},
+						"next_cursor": apiSchema{"type": "string", "description": "Absent on the last page"},
+					},
+				}
+			}
+			success["content"] = apiSchema{"application/json": apiSchema{"schema": s}}
+		}
+		if route.Stream {
+			content, _ := success["content"].(apiSchema)
+			content["text/event-stream"] = apiSchema{
+				"schema": apiSchema{
+					"type": "string",
+					"description": "A response event with the JSON of each pipeline update, then a done event with the result " +
+						"or an error event. Answered with 200 instead.",
+				},
+			}
+		}
+
+		op := apiSchema{
+			"summary":     route.Summary,
+			"operationId": strings.ToLower(route.Method) + strings.NewReplacer("/", "_", ":", "", "-", "_").Replace(route.Path),
+			"tags":        []string{route.Tag},
+			"responses": apiSchema{
+				strconv.Itoa(status): success,
+				"default":            errorResponse,
+			},
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if route.Request != nil {
+			op["requestBody"] = apiSchema{
+				"required": true,
+				"content":  apiSchema{"application/json": apiSchema{"schema": g.result(route.Request)}},
+			}
+		}
+		if route.Scope != "" {
+			op["security"] = []interface{}{apiSchema{"bearerAuth": []string{}}}
+			op["description"] = "Requires a token with the " + route.Scope + " scope."
+		}
+
+		item, ok := paths[path].(apiSchema)
+		if !ok {
+			item = apiSchema{}
+			paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = op
+	}
+
+	return apiSchema{
+		"openapi": "3.0.3",
+		"info": apiSchema{
+			"title":       "Lab API",
+			"version":     "1",
+			"description": "Projects, chats, documents, schemas and map layers. Authenticate with a personal access token from the settings page.",
+		},
+		"servers": []interface{}{apiSchema{"url": apiPrefix}},
+		"paths":   paths,
+		"components": apiSchema{
+			"schemas": g.schemas,
+			"responses": apiSchema{
+				"Error": apiSchema{
+					"description": "Error",
+					"content":     apiSchema{"application/json": apiSchema{"schema": g.schema(reflect.TypeOf(apiErrorBody{}))}},
+				},
+			},
+			"securitySchemes": apiSchema{
+				"bearerAuth": apiSchema{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"kdg/be/lab/internal/crs"
 	"kdg/be/lab/internal/models"
 	"kdg/be/lab/internal/validator"
 	"net/http"
@@ -16,6 +17,9 @@ type projectCreateForm struct {
 	validator.Validator `form:"-"`
 }
 
+// projectEventLimit is the number of audit trail entries on the project page
+const projectEventLimit = 50
+
 // RegisteredSchema represents a schema that has been saved in metadata
 type RegisteredSchema struct {
 	ID   uuid.UUID `json:"id"`
@@ -24,14 +28,9 @@ type RegisteredSchema struct {
 
 // projectView shows details for a specific project with metadata schemas
 func (app *application) projectView(w http.ResponseWriter, r *http.Request) {
-	// Get project ID from URL
 	params := httprouter.ParamsFromContext(r.Context())
-	projectIDStr := params.ByName("id")
-
-	// Parse the UUID
-	projectID, err := uuid.Parse(projectIDStr)
-	if err != nil {
-		app.notFound(w)
+	projectID, ok := app.projectForUser(w, r, params.ByName("id"))
+	if !ok {
 		return
 	}
 
@@ -42,15 +41,15 @@ func (app *application) projectView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get the user ID from session
-	userID := app.userIdFromSession(r)
-	if userID == uuid.Nil {
-		app.clientError(w, http.StatusUnauthorized)
+	// Get files for this project
+	files, err := app.files.GetByProject(projectID)
+	if err != nil {
+		app.serverError(w, err)
 		return
 	}
 
-	// Get files for this project
-	files, err := app.files.GetByProject(projectID)
+	// Latest lifecycle events of the project's documents
+	fileEvents, err := app.fileEvents.GetByProject(projectID, projectEventLimit)
 	if err != nil {
 		app.serverError(w, err)
 		return
@@ -100,13 +99,30 @@ func (app *application) projectView(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Map layers with their size, extent and attributes
+	layers, err := app.layers.GetByProject(projectID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	views, err := app.mapViews.GetByProject(projectID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
 	data := app.newTemplateData(r)
 	data.Project = project
 	data.Files = files
+	data.Layers = layers
+	data.MapViews = views
+	data.FileEvents = fileEvents
 	data.ProjectDatabase = projectDatabase
 	data.SchemaList = schemaList
 	data.RegisteredSchemas = registeredSchemas
 	data.HasDocuments = len(files) > 0
+	data.CRSList = crs.Supported()
 	data.Form = projectForms{} // Initialize empty form
 
 	app.render(w, http.StatusOK, "project.tmpl.html", data)
@@ -145,12 +161,16 @@ func (app *application) projectCreatePost(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	_, err = app.externalAPI.CreateExternalProject(userID, form.Name)
+	created, err := app.externalAPI.CreateExternalProject(userID, form.Name)
 	if err != nil {
 		app.errorLog.Printf("Failed to create project in external system: %v", err)
 		return
 	}
 
+	if projectID, err := uuid.Parse(created.ProjectID); err == nil {
+		app.emitProjectCreated(projectID, form.Name, userID)
+	}
+
 	app.sessionManager.Put(r.Context(), "flash", "Project created successfully")
 
 	http.Redirect(w, r, "/panel", http.StatusSeeOther)
@@ -320,4 +340,44 @@ func (app *application) renderFormWithErrors(w http.ResponseWriter, r *http.Requ
 
 	// Render the template
 	app.render(w, http.StatusUnprocessableEntity, "project.tmpl.html", data)
-}
\ No newline at end of file
+}
+
+type projectCRSForm struct {
+	CRS int `form:"crs"`
+}
+
+// projectCRSPost sets the reference system assumed for project GeoJSON
+// without a crs member
+func (app *application) projectCRSPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	projectID, ok := app.projectForUser(w, r, params.ByName("id"))
+	if !ok {
+		return
+	}
+
+	redirectURL := fmt.Sprintf("/project/view/%s", projectID)
+
+	var form projectCRSForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	message := "GeoJSON without a crs member is now read as WGS84"
+	if form.CRS != 0 {
+		c, err := crs.Lookup(form.CRS)
+		if err != nil {
+			app.setFlashAndRedirect(w, r, fmt.Sprintf("EPSG:%d is not a supported reference system", form.CRS),
+				redirectURL, http.StatusSeeOther)
+			return
+		}
+		message = fmt.Sprintf("Projected GeoJSON without a crs member is now read as %s (%s)", c, c.Name)
+	}
+
+	if err := app.projects.SetDefaultCRS(projectID, form.CRS); err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.setFlashAndRedirect(w, r, message, redirectURL, http.StatusSeeOther)
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"kdg/be/lab/internal/model"
+	"kdg/be/lab/internal/models"
+	"kdg/be/lab/internal/vectorstore"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Search result limits
+const (
+	defaultSearchResults = 5
+	maxSearchResults     = 50
+)
+
+// openVectorIndex sets up local embedding. It returns nil when chunks are
+// embedded by the external processing service.
+func openVectorIndex(postgres *sql.DB, embedder, embedModel, ollamaURL, store, vectorDir string, infoLog *log.Logger) (*vectorstore.Index, error) {
+	var e vectorstore.Embedder
+	switch embedder {
+	case "", "external":
+		return nil, nil
+	case "ollama":
+		ollamaEmbedder, err := vectorstore.NewOllamaEmbedder(embedModel, ollamaURL)
+		if err != nil {
+			return nil, err
+		}
+		e = ollamaEmbedder
+	case "hash":
+		e = vectorstore.NewHashEmbedder(0)
+	default:
+		return nil, fmt.Errorf("unknown embedder %q", embedder)
+	}
+
+	var s vectorstore.Store
+	switch store {
+	case "auto", "pgvector":
+		pgStore, err := vectorstore.NewPGVectorStore(postgres)
+		if err == nil {
+			infoLog.Printf("Storing embeddings with pgvector")
+			s = pgStore
+			break
+		}
+		if store == "pgvector" || !errors.Is(err, vectorstore.ErrUnavailable) {
+			return nil, err
+		}
+		fallthrough
+	case "flat":
+		flatStore, err := vectorstore.NewFlatStore(vectorDir)
+		if err != nil {
+			return nil, err
+		}
+		infoLog.Printf("Storing embeddings in %s", vectorDir)
+		s = flatStore
+	default:
+		return nil, fmt.Errorf("unknown vector store %q", store)
+	}
+
+	return vectorstore.New(e, s), nil
+}
+
+// embedFileChunks embeds the stored chunks of a file into the vector index,
+// replacing the vectors of earlier chunks
+func (app *application) embedFileChunks(ctx context.Context, file *models.File) (string, error) {
+	chunks, err := app.chunks.GetByFile(file.ID)
+	if err != nil {
+		return "", err
+	}
+
+	if err := app.vectors.Store.DeleteFile(ctx, file.ProjectID, file.ID); err != nil {
+		return "", err
+	}
+
+	entries := make([]vectorstore.Entry, len(chunks))
+	texts := make([]string, len(chunks))
+	for i, c := range chunks {
+		entries[i] = vectorstore.Entry{ChunkID: c.ID, FileID: file.ID, ProjectID: file.ProjectID}
+		texts[i] = c.Content
+	}
+
+	if err := app.vectors.Add(ctx, entries, texts); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Embedded %d chunks", len(chunks)), nil
+}
+
+// Number of chunks sent to the chat server with a question
+const chatContextChunks = 8
+
+// chatDocuments finds the chunks of a project to answer a question with. A
+// failed search is logged and the question is asked without them.
+func (app *application) chatDocuments(projectID uuid.UUID, question string) []model.ChatDocument {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	results, err := app.searchChunks(ctx, projectID, question, chatContextChunks, vectorstore.Filter{}, searchHybrid)
+	if err != nil {
+		app.errorLog.Printf("Error searching documents of project %s for a chat: %v", projectID, err)
+		return nil
+	}
+
+	documents := make([]model.ChatDocument, len(results))
+	for i, r := range results {
+		documents[i] = model.ChatDocument{
+			ChunkID:     r.ChunkID.String(),
+			FileID:      r.FileID.String(),
+			FileName:    r.FileName,
+			Page:        r.Page,
+			HeadingPath: r.HeadingPath,
+			Content:     r.Content,
+			Score:       r.Score,
+		}
+	}
+	return documents
+}
+
+// searchResult is a chunk found by a project search
+type searchResult struct {
+	ChunkID     uuid.UUID `json:"chunk_id"`
+	FileID      uuid.UUID `json:"file_id"`
+	FileName    string    `json:"file_name"`
+	Page        int       `json:"page,omitempty"`
+	PageEnd     int       `json:"page_end,omitempty"`
+	HeadingPath []string  `json:"heading_path,omitempty"`
+	Content     string    `json:"content"`
+	Score       float32   `json:"score"`
+}
+
+// Search modes
+const (
+	searchHybrid  = "hybrid"
+	searchKeyword = "keyword"
+	searchVector  = "vector"
+)
+
+// Rank fusion settings. Each ranker contributes 1/(rrfK+rank) per chunk and
+// returns searchCandidates times the requested results to fuse.
+const (
+	rrfK             = 60
+	searchCandidates = 4
+)
+
+// errVectorSearchDisabled is returned for vector searches without a local
+// vector index
+var errVectorSearchDisabled = errors.New("local document search is not enabled")
+
+// searchChunks returns the k chunks of a project that best match the query.
+// Hybrid searches fuse the BM25 and vector rankings with reciprocal rank
+// fusion and fall back to keywords when there is no vector index.
+func (app *application) searchChunks(ctx context.Context, projectID uuid.UUID, query string, k int, filter vectorstore.Filter, mode string) ([]searchResult, error) {
+	if mode == searchHybrid && app.vectors == nil {
+		mode = searchKeyword
+	}
+	if mode == searchVector && app.vectors == nil {
+		return nil, errVectorSearchDisabled
+	}
+
+	candidates := k
+	if mode == searchHybrid {
+		candidates = k * searchCandidates
+	}
+
+	var rankings [][]uuid.UUID
+	scores := map[uuid.UUID]float32{}
+
+	if mode != searchVector {
+		matches, err := app.chunks.KeywordSearch(projectID, query, candidates, filter.FileIDs)
+		if err != nil {
+			return nil, err
+		}
+		ranking := make([]uuid.UUID, len(matches))
+		for i, m := range matches {
+			ranking[i] = m.ChunkID
+			scores[m.ChunkID] = float32(m.Score)
+		}
+		rankings = append(rankings, ranking)
+	}
+
+	if mode != searchKeyword {
+		found, err := app.vectors.Search(ctx, projectID, query, candidates, filter)
+		if err != nil {
+			return nil, err
+		}
+		ranking := make([]uuid.UUID, len(found))
+		for i, r := range found {
+			ranking[i] = r.ChunkID
+			scores[r.ChunkID] = r.Score
+		}
+		rankings = append(rankings, ranking)
+	}
+
+	ids := rankings[0]
+	if len(rankings) > 1 {
+		ids, scores = reciprocalRankFusion(rankings...)
+	}
+	if len(ids) > k {
+		ids = ids[:k]
+	}
+	if len(ids) == 0 {
+		return []searchResult{}, nil
+	}
+
+	chunks, err := app.chunks.GetByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	fileNames := map[uuid.UUID]string{}
+	results := make([]searchResult, 0, len(chunks))
+	for _, c := range chunks {
+		name, ok := fileNames[c.FileID]
+		if !ok {
+			file, err := app.files.GetByID(c.FileID)
+			if errors.Is(err, models.ErrNoRecord) {
+				// The vectors of a deleted file can outlive it briefly
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			name = file.Name
+			fileNames[c.FileID] = name
+		}
+
+		results = append(results, searchResult{
+			ChunkID:     c.ID,
+			FileID:      c.FileID,
+			FileName:    name,
+			Page:        c.Page,
+			PageEnd:     c.PageEnd,
+			HeadingPath: c.HeadingPath,
+			Content:     c.Content,
+			Score:       scores[c.ID],
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	return results, nil
+}
+
+// reciprocalRankFusion merges rankings into one, best first, together with
+// the fused score of every chunk
+func reciprocalRankFusion(rankings ...[]uuid.UUID) ([]uuid.UUID, map[uuid.UUID]float32) {
+	scores := map[uuid.UUID]float32{}
+	ids := []uuid.UUID{}
+	for _, ranking := range rankings {
+		for rank, id := range ranking {
+			if _, ok := scores[id]; !ok {
+				ids = append(ids, id)
+			}
+			scores[id] += 1 / float32(rrfK+rank+1)
+		}
+	}
+
+	sort.SliceStable(ids, func(i, j int) bool {
+		return scores[ids[i]] > scores[ids[j]]
+	})
+	return ids, scores
+}
+
+// projectSearch returns the chunks of a project's documents that best match
+// the q parameter. k sets the number of results, file and role limit the
+// search to one document or to the documents with a role, and mode picks
+// hybrid, keyword or vector search.
+func (app *application) projectSearch(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	projectID, ok := app.parseUUID(w, params.ByName("id"))
+	if !ok {
+		return
+	}
+
+	userID := app.userIdFromSession(r)
+	hasAccess, err := app.projects.HasAccess(projectID, userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if !hasAccess {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	switch mode {
+	case "":
+		mode = searchHybrid
+	case searchHybrid, searchKeyword, searchVector:
+	default:
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	k := defaultSearchResults
+	if v := r.URL.Query().Get("k"); v != "" {
+		k, err = strconv.Atoi(v)
+		if err != nil || k < 1 || k > maxSearchResults {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	var filter vectorstore.Filter
+	if v := r.URL.Query().Get("file"); v != "" {
+		fileID, err := uuid.Parse(v)
+		if err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		filter.FileIDs = append(filter.FileIDs, fileID)
+	}
+
+	if role := strings.ToUpper(r.URL.Query().Get("role")); role != "" {
+		files, err := app.files.GetByProject(projectID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		roleFiles := []uuid.UUID{}
+		for _, file := range files {
+			if !contains(strings.Split(file.Role, ","), role) {
+				continue
+			}
+			if len(filter.FileIDs) == 0 || filter.FileIDs[0] == file.ID {
+				roleFiles = append(roleFiles, file.ID)
+			}
+		}
+		if len(roleFiles) == 0 {
+			app.writeJSON(w, http.StatusOK, []searchResult{})
+			return
+		}
+		filter.FileIDs = roleFiles
+	}
+
+	results, err := app.searchChunks(r.Context(), projectID, query, k, filter, mode)
+	if err != nil {
+		if errors.Is(err, errVectorSearchDisabled) {
+			app.writeJSON(w, http.StatusServiceUnavailable, map[string]string{
+				"error": "Local document search is not enabled",
+			})
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, results)
+}
+
+// maxSnippetLength caps the quoted text kept with a citation
+const maxSnippetLength = 200
+
+// checkCitations completes citations from the chunks they reference, marks
+// those whose file was deleted or quarantined as unavailable and drops those
+// of files in another project than the one the answer was given for
+func (app *application) checkCitations(citations []models.Citation, projectID uuid.UUID) []models.Citation {
+	return app.filterCitations(citations, func(file *models.File) bool {
+		return file.ProjectID == projectID
+	})
+}
+
+// checkStoredCitations checks the citations of a stored answer like
+// checkCitations. Chats are not tied to a project, so citations of files in
+// projects the user cannot access are dropped instead.
+func (app *application) checkStoredCitations(citations []models.Citation, userID uuid.UUID) []models.Citation {
+	access := map[uuid.UUID]bool{}
+	return app.filterCitations(citations, func(file *models.File) bool {
+		ok, seen := access[file.ProjectID]
+		if !seen {
+			var err error
+			ok, err = app.projects.HasAccess(file.ProjectID, userID)
+			if err != nil {
+				app.errorLog.Printf("Error checking access to cited file %s: %v", file.ID, err)
+			}
+			access[file.ProjectID] = ok
+		}
+		return ok
+	})
+}
+
+// filterCitations completes and checks citations, dropping those of files
+// that keep rejects
+func (app *application) filterCitations(citations []models.Citation, keep func(*models.File) bool) []models.Citation {
+	checked := make([]models.Citation, 0, len(citations))
+	files := map[uuid.UUID]*models.File{}
+
+	for _, c := range citations {
+		if c.ChunkID != uuid.Nil && (c.FileID == uuid.Nil || c.Snippet == "") {
+			chunks, err := app.chunks.GetByIDs([]uuid.UUID{c.ChunkID})
+			if err != nil {
+				app.errorLog.Printf("Error loading cited chunk %s: %v", c.ChunkID, err)
+			} else if len(chunks) == 1 {
+				if c.FileID == uuid.Nil {
+					c.FileID = chunks[0].FileID
+				}
+				if c.Page == 0 {
+					c.Page = chunks[0].Page
+				}
+				if c.Snippet == "" {
+					c.Snippet = chunks[0].Content
+				}
+			}
+		}
+		c.Snippet = truncateSnippet(c.Snippet)
+
+		file, ok := files[c.FileID]
+		if !ok && c.FileID != uuid.Nil {
+			var err error
+			file, err = app.files.GetByID(c.FileID)
+			if err != nil {
+				if !errors.Is(err, models.ErrNoRecord) {
+					app.errorLog.Printf("Error checking cited file %s: %v", c.FileID, err)
+				}
+				file = nil
+			}
+			files[c.FileID] = file
+		}
+		if file != nil && !keep(file) {
+			continue
+		}
+
+		c.Available = file != nil && file.Status != "quarantined"
+		if file != nil {
+			c.FileName = file.Name
+		}
+		checked = append(checked, c)
+	}
+
+	return checked
+}
+
+// truncateSnippet shortens a snippet to maxSnippetLength runes
+func truncateSnippet(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	runes := []rune(s)
+	if len(runes) <= maxSnippetLength {
+		return s
+	}
+	return string(runes[:maxSnippetLength]) + "…"
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/alice"
@@ -11,8 +12,19 @@ func (app *application) routes() http.Handler {
 	router := httprouter.New()
 
 	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, apiPrefix+"/") {
+			app.apiNotFound(w)
+			return
+		}
 		app.notFound(w)
 	})
+	router.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, apiPrefix+"/") {
+			app.apiError(w, http.StatusMethodNotAllowed, "The method is not allowed for this resource")
+			return
+		}
+		app.clientError(w, http.StatusMethodNotAllowed)
+	})
 
 	fileServer := http.FileServer(http.Dir("./ui/static"))
 	router.Handler(http.MethodGet, "/static/*filepath", http.StripPrefix("/static", fileServer))
@@ -25,6 +37,8 @@ func (app *application) routes() http.Handler {
 	router.Handler(http.MethodPost, "/user/signup", dynamic.ThenFunc(app.userSignupPost))
 
 	protected := dynamic.Append(app.requireAuthentication)
+	// Upload forms are limited in size before the CSRF check parses them
+	uploads := alice.New(app.sessionManager.LoadAndSave, app.limitUploadBody, noSurf, app.requireAuthentication)
 
 	router.Handler(http.MethodGet, "/", protected.ThenFunc(app.home))
 	router.Handler(http.MethodPost, "/chat", protected.ThenFunc(app.newChatPost))
@@ -33,6 +47,12 @@ func (app *application) routes() http.Handler {
 	router.Handler(http.MethodGet, "/api/geojson", protected.ThenFunc(app.geoJsonHandler))
 	router.Handler(http.MethodGet, "/ws/chat/:id", chatIDMiddleware(protected.ThenFunc(app.handleConnections)))
 	router.Handler(http.MethodPost, "/user/logout", protected.ThenFunc(app.userLogoutPost))
+	router.Handler(http.MethodGet, "/user/settings", protected.ThenFunc(app.userSettings))
+	router.Handler(http.MethodPost, "/user/tokens", protected.ThenFunc(app.tokenCreatePost))
+	router.Handler(http.MethodPost, "/user/tokens/:id/delete", protected.ThenFunc(app.tokenDeletePost))
+	router.Handler(http.MethodPost, "/webhooks", protected.ThenFunc(app.webhookCreatePost))
+	router.Handler(http.MethodPost, "/webhooks/:id/delete", protected.ThenFunc(app.webhookDeletePost))
+	router.Handler(http.MethodPost, "/webhooks/deliveries/:id/replay", protected.ThenFunc(app.webhookReplayPost))
 
 	// Schema and table API endpoints
 	router.Handler(http.MethodGet, "/api/schema/:id/tables", protected.ThenFunc(app.getSchemaTablesHandler))
@@ -45,10 +65,42 @@ func (app *application) routes() http.Handler {
 	router.Handler(http.MethodPost, "/project/create", protected.ThenFunc(app.projectCreatePost))
 	router.Handler(http.MethodPost, "/project/db/setup", protected.ThenFunc(app.projectDatabaseSetupPost))
 	router.Handler(http.MethodGet, "/project/view/:id", protected.ThenFunc(app.projectView))
+	router.Handler(http.MethodPost, "/project/crs/:id", protected.ThenFunc(app.projectCRSPost))
+
+	// Stored document routes
+	router.Handler(http.MethodGet, "/files/:id/download", protected.ThenFunc(app.fileDownload))
+	router.Handler(http.MethodGet, "/files/:id/view", protected.ThenFunc(app.fileView))
+	router.Handler(http.MethodPost, "/files/:id/delete", protected.ThenFunc(app.fileDeletePost))
+	router.Handler(http.MethodPost, "/files/:id/replace", uploads.ThenFunc(app.fileReplacePost))
+	router.Handler(http.MethodPost, "/files/:id/reprocess", protected.ThenFunc(app.fileReprocessPost))
+	router.Handler(http.MethodGet, "/api/projects/:id/search", protected.ThenFunc(app.projectSearch))
+	router.Handler(http.MethodGet, "/api/projects/:id/layers", protected.ThenFunc(app.projectLayers))
+	router.Handler(http.MethodPost, "/api/projects/:id/layers", protected.ThenFunc(app.projectLayerCreatePost))
+	router.Handler(http.MethodPost, "/api/projects/:id/layers/:layer/delete", protected.ThenFunc(app.projectLayerDeletePost))
+	router.Handler(http.MethodPost, "/api/projects/:id/layers/:layer/attributes", protected.ThenFunc(app.projectLayerAttributesPost))
+	router.Handler(http.MethodPost, "/api/projects/:id/layers/:layer/style", protected.ThenFunc(app.projectLayerStylePost))
+	router.Handler(http.MethodGet, "/api/projects/:id/map-views", protected.ThenFunc(app.projectMapViews))
+	router.Handler(http.MethodGet, "/api/chats/:id/map-views", protected.ThenFunc(app.chatMapViews))
+	router.Handler(http.MethodPost, "/api/map-views", protected.ThenFunc(app.mapViewCreatePost))
+	router.Handler(http.MethodGet, "/api/map-views/:id", protected.ThenFunc(app.mapViewGet))
+	router.Handler(http.MethodPost, "/api/map-views/:id", protected.ThenFunc(app.mapViewUpdatePost))
+	router.Handler(http.MethodPost, "/api/map-views/:id/delete", protected.ThenFunc(app.mapViewDeletePost))
+	router.Handler(http.MethodGet, "/tiles/:layer/:z/:x/:y", protected.ThenFunc(app.layerTile))
+	router.Handler(http.MethodGet, "/api/layers/:id/export", protected.ThenFunc(app.layerExport))
+	router.Handler(http.MethodPost, "/api/layers/:id/query", protected.ThenFunc(app.layerSpatialQuery))
+	router.Handler(http.MethodGet, "/api/geocode", protected.ThenFunc(app.geocodeSearch))
+	router.Handler(http.MethodGet, "/api/geocode/reverse", protected.ThenFunc(app.geocodeReverse))
+	router.Handler(http.MethodGet, "/api/messages/:id/export", protected.ThenFunc(app.messageExport))
 
 	router.Handler(http.MethodGet, "/panel", protected.ThenFunc(app.adminPanel))
 	router.Handler(http.MethodGet, "/ws/upload", chatIDMiddleware(protected.ThenFunc(app.handleFileUpload)))
-	router.Handler(http.MethodGet, "/ws/process/{id}", chatIDMiddleware(protected.ThenFunc(app.handleDocumentProcessing)))
+	router.Handler(http.MethodGet, "/ws/process/:id", protected.ThenFunc(app.handleDocumentProcessing))
+
+	// Versioned REST API, authenticated with personal access tokens instead
+	// of the session
+	for _, route := range app.apiRoutes() {
+		router.Handler(route.Method, apiPrefix+route.Path, app.apiRouteHandler(route))
+	}
 
 	standard := alice.New(app.recoverPanic, app.logRequest)
 
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"kdg/be/lab/internal/models"
 	"kdg/be/lab/internal/validator"
 	"net/http"
 
@@ -107,6 +108,11 @@ func (app *application) databaseSchemaPost(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	app.emitWebhook(projectID, models.EventSchemaRegistered, map[string]interface{}{
+		"database_id": dbID,
+		"schemas":     schemaForm.SchemaName,
+	})
+
 	app.sessionManager.Put(r.Context(), "flash", "Schema successfully registered in metadata")
 	http.Redirect(w, r, fmt.Sprintf("/project/view/%s", projectID), http.StatusSeeOther)
 }
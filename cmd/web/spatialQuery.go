@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kdg/be/lab/internal/geojson"
+	"kdg/be/lab/internal/models"
+	"kdg/be/lab/internal/spatial"
+	"math"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Spatial query operations
+const (
+	queryIntersects     = "intersects"
+	queryWithin         = "within"
+	queryContains       = "contains"
+	queryWithinDistance = "within_distance"
+
+	// maxQueryDistance bounds distances and buffers, in metres, as both are
+	// computed in a plane that is only accurate locally
+	maxQueryDistance = 50000
+)
+
+// layerIndex is the spatial index of a cached feature collection
+type layerIndex struct {
+	features *geojson.FeatureCollection
+	index    *spatial.Index
+}
+
+// featureIndex returns the spatial index of the features of a file or chat
+// layer, building it when the features were decoded again
+func (app *application) featureIndex(ctx context.Context, layer *models.Layer) (*layerIndex, error) {
+	fc, err := app.sourceFeatures(ctx, layer)
+	if err != nil {
+		return nil, err
+	}
+	key := layer.ID.String()
+	if idx, ok := app.layerCache.indexes.get(key); ok && idx.features == fc {
+		return idx, nil
+	}
+	idx := &layerIndex{features: fc, index: spatial.NewIndex(fc)}
+	app.layerCache.indexes.put(key, idx)
+	return idx, nil
+}
+
+type spatialQueryRequest struct {
+	Op       string          `json:"op"`
+	Geometry json.RawMessage `json:"geometry"`
+	Distance float64         `json:"distance"`
+	Buffer   float64         `json:"buffer"`
+}
+
+type spatialQueryInfo struct {
+	Op        string            `json:"op"`
+	Matched   int               `json:"matched"`
+	Truncated bool              `json:"truncated"`
+	Buffer    *geojson.Geometry `json:"buffer,omitempty"`
+	Area      float64           `json:"area_m2"`
+	Length    float64           `json:"length_m"`
+}
+
+// spatialQueryResult is a FeatureCollection with the query as a foreign
+// member
+type spatialQueryResult struct {
+	Type     string             `json:"type"`
+	BBox     []float64          `json:"bbox,omitempty"`
+	Features []*geojson.Feature `json:"features"`
+	Query    spatialQueryInfo   `json:"query"`
+}
+
+// parseQueryGeometry reads a geometry drawn on the map, repairing what
+// Leaflet gets wrong such as the winding order of rings
+func parseQueryGeometry(data json.RawMessage) (*geojson.Geometry, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, errors.New("A geometry is required")
+	}
+	g := &geojson.Geometry{}
+	if err := json.Unmarshal(data, g); err != nil {
+		return nil, fmt.Errorf("Invalid geometry: %v", err)
+	}
+	fc := &geojson.FeatureCollection{Features: []*geojson.Feature{{Geometry: g, Properties: json.RawMessage("{}")}}}
+	fc.Repair()
+	if err := fc.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid geometry: %v", err)
+	}
+	if _, ok := g.Bounds(); !ok {
+		return nil, errors.New("The geometry is empty")
+	}
+	return g, nil
+}
+
+// layerSpatialQuery returns the features of a layer that meet a geometry
+// drawn on the map. Features are matched when they intersect it, lie within
+// it, contain it or come within a distance of it; a buffer widens the
+// geometry for intersects and within.
+func (app *application) layerSpatialQuery(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	layer := app.layerForUser(w, r, params.ByName("id"))
+	if layer == nil {
+		return
+	}
+
+	var req spatialQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		app.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON body"})
+		return
+	}
+	if req.Op == "" {
+		req.Op = queryIntersects
+	}
+
+	g, err := parseQueryGeometry(req.Geometry)
+	if err != nil {
+		app.writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+
+	var reach float64
+	switch req.Op {
+	case queryIntersects, queryWithin:
+		reach = req.Buffer
+	case queryContains:
+		if req.Buffer != 0 {
+			app.writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "A buffer cannot be used with contains"})
+			return
+		}
+	case queryWithinDistance:
+		if req.Distance <= 0 {
+			app.writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "within_distance needs a distance above 0"})
+			return
+		}
+		reach = req.Distance
+	default:
+		app.writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": fmt.Sprintf("Unknown operation %q", req.Op)})
+		return
+	}
+	if reach < 0 || reach > maxQueryDistance || math.IsNaN(reach) {
+		app.writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": fmt.Sprintf("Distances must be between 0 and %d metres", maxQueryDistance)})
+		return
+	}
+
+	query := spatial.Prepare(g)
+	match := func(f *geojson.Geometry) bool {
+		switch req.Op {
+		case queryWithin:
+			return query.WithinBuffer(f, reach)
+		case queryContains:
+			return query.Within(f)
+		default:
+			if reach > 0 {
+				return query.WithinDistance(f, reach)
+			}
+			return query.Intersects(f)
+		}
+	}
+
+	candidates, truncated, err := app.queryCandidates(r.Context(), layer, spatial.ExpandBBox(query.BBox(), reach))
+	if err != nil {
+		if errors.Is(err, errLayerSource) {
+			app.writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+
+	result := &geojson.FeatureCollection{Features: []*geojson.Feature{}}
+	for _, f := range candidates {
+		if f.Geometry != nil && match(f.Geometry) {
+			result.Features = append(result.Features, f)
+		}
+	}
+	if result.Limit(maxLayerFeatures) {
+		truncated = true
+	}
+
+	info := spatialQueryInfo{
+		Op:        req.Op,
+		Matched:   len(result.Features),
+		Truncated: truncated,
+		Area:      spatial.Area(g),
+		Length:    spatial.Length(g),
+	}
+	if req.Op != queryContains && reach > 0 {
+		info.Buffer = spatial.Buffer(g, reach)
+		info.Area = spatial.Area(info.Buffer)
+	}
+
+	res := spatialQueryResult{Type: "FeatureCollection", Features: result.Features, Query: info}
+	if box, ok := result.Bounds(); ok {
+		res.BBox = box[:]
+	}
+	app.writeJSON(w, http.StatusOK, res)
+}
+
+// queryCandidates returns the features of a layer whose bounding box meets
+// a box: from the spatial index for file and chat layers, and from the
+// database, which applies the box, for table and query layers
+func (app *application) queryCandidates(ctx context.Context, layer *models.Layer, box geojson.BBox) ([]*geojson.Feature, bool, error) {
+	switch layer.Source {
+	case models.LayerTable, models.LayerQuery:
+		fc, truncated, err := app.databaseLayerFeatures(ctx, layer, &box)
+		if err != nil {
+			return nil, false, err
+		}
+		return fc.Features, truncated, nil
+	}
+
+	idx, err := app.featureIndex(ctx, layer)
+	if err != nil {
+		return nil, false, err
+	}
+	found := idx.index.Search(box)
+	features := make([]*geojson.Feature, len(found))
+	for i, n := range found {
+		features[i] = idx.features.Features[n]
+	}
+	return features, false, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kdg/be/lab/internal/geojson"
+	"kdg/be/lab/internal/mapstyle"
+	"kdg/be/lab/internal/models"
+	"net/http"
+	"sort"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// featureProperties decodes the properties of features, skipping those
+// that are not objects
+func featureProperties(features []*geojson.Feature) []map[string]interface{} {
+	properties := make([]map[string]interface{}, 0, len(features))
+	for _, f := range features {
+		var p map[string]interface{}
+		if json.Unmarshal(f.Properties, &p) == nil && p != nil {
+			properties = append(properties, p)
+		}
+	}
+	return properties
+}
+
+// layerStyleFields returns a message when a style refers to properties the
+// layer does not have, or that its vector tiles leave out
+func layerStyleFields(layer *models.Layer, style *mapstyle.Style) string {
+	for _, name := range []string{style.Property, style.LabelField} {
+		if name == "" {
+			continue
+		}
+		if len(layer.Schema) > 0 {
+			found := false
+			for _, f := range layer.Schema {
+				found = found || f.Name == name
+			}
+			if !found {
+				return fmt.Sprintf("The layer has no attribute %q", name)
+			}
+		}
+		if len(layer.Attributes) > 0 && !contains(layer.Attributes, name) {
+			return fmt.Sprintf("Add %q to the layer's tile attributes to style by it", name)
+		}
+	}
+	return ""
+}
+
+// projectLayerStylePost replaces the drawing rules of a layer. Categorized
+// and graduated styles without categories or classes get them from the
+// layer's features; null restores the default style.
+func (app *application) projectLayerStylePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	projectID, ok := app.projectForUser(w, r, params.ByName("id"))
+	if !ok {
+		return
+	}
+
+	layerID, ok := app.parseUUID(w, params.ByName("layer"))
+	if !ok {
+		return
+	}
+
+	layer, err := app.layers.Get(layerID)
+	if err != nil || layer.ProjectID != projectID {
+		if err == nil || errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	var style *mapstyle.Style
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&style); err != nil {
+		app.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON body"})
+		return
+	}
+
+	if style != nil {
+		if err := style.Validate(); err != nil {
+			app.writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+			return
+		}
+		if msg := layerStyleFields(layer, style); msg != "" {
+			app.writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": msg})
+			return
+		}
+
+		if (style.Kind == mapstyle.KindCategorized && len(style.Categories) == 0) ||
+			(style.Kind == mapstyle.KindGraduated && len(style.Classes) == 0) {
+			fc, _, err := app.layerFeatures(r.Context(), layer, nil)
+			if err != nil {
+				if errors.Is(err, errLayerSource) {
+					app.writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+					return
+				}
+				app.serverError(w, err)
+				return
+			}
+			style.Complete(featureProperties(fc.Features))
+		}
+	}
+
+	if err := app.layers.SetStyle(layer.ID, style); err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	layer.Style = style
+	app.writeJSON(w, http.StatusOK, layer)
+}
+
+// layerLegend returns the legend of a layer drawn with its own style
+func layerLegend(layer *models.Layer) *mapstyle.Legend {
+	if layer.Style == nil {
+		return nil
+	}
+	legend := layer.Style.Legend(layer.Name)
+	return &legend
+}
+
+// geoObjectStyles returns the style of each set of chat features and their
+// legends, in key order. Styles configured for a key are completed from
+// its features; other keys get a default style.
+func (app *application) geoObjectStyles(geoObjects map[string]GeoObject) (map[string]*mapstyle.Style, []mapstyle.Legend) {
+	keys := make([]string, 0, len(geoObjects))
+	for key := range geoObjects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	styles := make(map[string]*mapstyle.Style, len(keys))
+	legends := make([]mapstyle.Legend, 0, len(keys))
+	for i, key := range keys {
+		style := mapstyle.ForKey(key, i)
+		if configured, ok := app.geoStyles[key]; ok {
+			// The configured style is shared, so complete a copy
+			copied := *configured
+			style = &copied
+			var features []*geojson.Feature
+			if err := json.Unmarshal(geoObjects[key].Features, &features); err == nil {
+				style.Complete(featureProperties(features))
+			}
+		}
+		styles[key] = style
+		legends = append(legends, style.Legend(key))
+	}
+	return styles, legends
+}
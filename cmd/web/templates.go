@@ -1,8 +1,11 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
+	"kdg/be/lab/internal/crs"
+	"kdg/be/lab/internal/geoexport"
 	"kdg/be/lab/internal/models"
 	"path/filepath"
 	"time"
@@ -29,6 +32,23 @@ type templateData struct {
 	RegisteredSchemas []RegisteredSchema
 	Files             []*models.File
 	HasDocuments      bool
+	File              *models.File
+	FileEvents        []*models.FileEvent
+	Layers            []*models.Layer
+	MapView           string // Saved view opened on the map, as JSON
+	MapViews          []*models.MapView
+	CRSList           []*crs.CRS
+	ExportFormats     []*geoexport.Format
+	Chunks            []*models.DocumentChunk
+	Page              int
+	Tokens            []*models.Token
+	TokenLifetimes    []int  // Days a new token may stay valid
+	NewToken          string // Plaintext of a token just created, shown once
+	Webhooks          []*models.Webhook
+	WebhookDeliveries []*models.WebhookDelivery
+	WebhookEvents     []string
+	WebhookForm       any
+	NewWebhookSecret  string // Signing secret of a webhook just created, shown once
 	UserID            string // Added UserID field
 }
 
@@ -40,6 +60,12 @@ func humanDate(t time.Time) string {
 	return t.UTC().Format("02 Jan 2006 at 15:04")
 }
 
+// toJSON encodes a value for a data attribute
+func toJSON(v any) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
 // Check if a value exists in a slice of strings
 func contains(slice []string, value string) bool {
 	for _, item := range slice {
@@ -55,7 +81,30 @@ var functions = template.FuncMap{
 	"formatFileSize":   formatFileSize,
 	"roleBadgeClass":   roleBadgeClass,
 	"statusBadgeClass": statusBadgeClass,
+	"eventBadgeClass":  eventBadgeClass,
 	"contains":         contains,
+	"citationURL":      citationURL,
+	"add1":             func(i int) int { return i + 1 },
+	"formatBBox":       formatBBox,
+	"layerLegend":      layerLegend,
+	"toJSON":           toJSON,
+}
+
+// formatBBox writes a layer extent as west, south, east, north in degrees
+func formatBBox(box []float64) string {
+	if len(box) != 4 {
+		return ""
+	}
+	return fmt.Sprintf("%.5f, %.5f, %.5f, %.5f", box[0], box[1], box[2], box[3])
+}
+
+// citationURL links a citation to the cited place in its document
+func citationURL(c models.Citation) string {
+	url := fmt.Sprintf("/files/%s/view", c.FileID)
+	if c.Page > 0 {
+		url += fmt.Sprintf("?page=%d", c.Page)
+	}
+	return url + fmt.Sprintf("#chunk-%s", c.ChunkID)
 }
 
 // Role badge helper function
@@ -67,6 +116,8 @@ func roleBadgeClass(role string) string {
 		return "badge badge-secondary"
 	case "SCHEMA":
 		return "badge badge-accent"
+	case "SPATIAL":
+		return "badge badge-info"
 	default:
 		return "badge badge-ghost"
 	}
@@ -81,11 +132,27 @@ func statusBadgeClass(status string) string {
 		return "badge badge-info"
 	case "error":
 		return "badge badge-error"
+	case "quarantined":
+		return "badge badge-error badge-outline"
 	default:
 		return "badge badge-warning"
 	}
 }
 
+// Badge for a document lifecycle event
+func eventBadgeClass(event string) string {
+	switch event {
+	case models.FileProcessed, models.FileImported:
+		return "badge badge-success"
+	case models.FileFailed, models.FileQuarantined, models.FileDeleted:
+		return "badge badge-error"
+	case models.FileReplaced, models.FileReprocessed:
+		return "badge badge-info"
+	default:
+		return "badge badge-ghost"
+	}
+}
+
 // Add this function
 func formatFileSize(size int64) string {
 	if size < 1024 {
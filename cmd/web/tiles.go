@@ -0,0 +1,310 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kdg/be/lab/internal/geojson"
+	"kdg/be/lab/internal/models"
+	"kdg/be/lab/internal/mvt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Tile cache limits
+const (
+	tileCacheBytes     = 64 << 20
+	featureCacheLayers = 8
+	// Tiles of table and query layers expire as changes to the project
+	// database cannot be seen
+	databaseTileTTL   = 5 * time.Minute
+	maxTileAttributes = 50
+)
+
+// lru is a least recently used cache bounded by the total size of its
+// values
+type lru[V any] struct {
+	mu     sync.Mutex
+	max    int
+	size   int
+	sizeOf func(V) int
+	order  *list.List
+	items  map[string]*list.Element
+}
+
+type lruEntry[V any] struct {
+	key   string
+	value V
+	size  int
+}
+
+func newLRU[V any](max int, sizeOf func(V) int) *lru[V] {
+	return &lru[V]{max: max, sizeOf: sizeOf, order: list.New(), items: map[string]*list.Element{}}
+}
+
+func (c *lru[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.order.MoveToFront(el)
+		return el.Value.(*lruEntry[V]).value, true
+	}
+	var zero V
+	return zero, false
+}
+
+func (c *lru[V]) put(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	entry := &lruEntry[V]{key: key, value: value, size: c.sizeOf(value)}
+	c.items[key] = c.order.PushFront(entry)
+	c.size += entry.size
+
+	for c.size > c.max && c.order.Len() > 1 {
+		c.remove(c.order.Back())
+	}
+}
+
+// removePrefix drops every entry whose key starts with prefix
+func (c *lru[V]) removePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, el := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(el)
+		}
+	}
+}
+
+func (c *lru[V]) remove(el *list.Element) {
+	entry := el.Value.(*lruEntry[V])
+	c.order.Remove(el)
+	delete(c.items, entry.key)
+	c.size -= entry.size
+}
+
+type cachedTile struct {
+	data    []byte
+	etag    string
+	expires time.Time // zero for tiles that stay valid until invalidated
+}
+
+// layerCache holds encoded tiles and, for file and chat layers, the
+// decoded features they are cut from and their spatial index. Keys start
+// with the layer ID.
+type layerCache struct {
+	tiles    *lru[*cachedTile]
+	features *lru[*geojson.FeatureCollection]
+	indexes  *lru[*layerIndex]
+}
+
+func newLayerCache() *layerCache {
+	return &layerCache{
+		tiles:    newLRU(tileCacheBytes, func(t *cachedTile) int { return len(t.data) }),
+		features: newLRU(featureCacheLayers, func(*geojson.FeatureCollection) int { return 1 }),
+		indexes:  newLRU(featureCacheLayers, func(*layerIndex) int { return 1 }),
+	}
+}
+
+// invalidate drops everything cached for a layer
+func (c *layerCache) invalidate(layerID uuid.UUID) {
+	c.tiles.removePrefix(layerID.String())
+	c.features.removePrefix(layerID.String())
+	c.indexes.removePrefix(layerID.String())
+}
+
+// invalidateFileLayers drops the cached tiles of the layers showing a file
+// after it was replaced or deleted
+func (app *application) invalidateFileLayers(fileID uuid.UUID) {
+	layers, err := app.layers.GetByFile(fileID)
+	if err != nil {
+		app.errorLog.Printf("Error finding layers of file %s: %v", fileID, err)
+		return
+	}
+	for _, layer := range layers {
+		app.layerCache.invalidate(layer.ID)
+	}
+}
+
+// sourceFeatures returns all features of a file or chat layer, decoding
+// them once and keeping them while the layer's tiles are requested. The
+// result is shared and must not be changed.
+func (app *application) sourceFeatures(ctx context.Context, layer *models.Layer) (*geojson.FeatureCollection, error) {
+	key := layer.ID.String()
+	if fc, ok := app.layerCache.features.get(key); ok {
+		return fc, nil
+	}
+
+	var fc *geojson.FeatureCollection
+	var err error
+	switch layer.Source {
+	case models.LayerFile:
+		fc, err = app.fileLayerFeatures(ctx, layer)
+	case models.LayerChat:
+		fc, err = geojson.Decode(layer.Data)
+	default:
+		err = fmt.Errorf("%w: unknown source %q", errLayerSource, layer.Source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	app.layerCache.features.put(key, fc)
+
+	// Layers made before summaries were kept have no extent yet
+	if len(fc.Features) != layer.FeatureCount || (layer.BBox == nil && len(fc.Features) > 0) {
+		describeLayer(layer, fc)
+		if err := app.layers.SetSummary(layer); err != nil {
+			app.errorLog.Printf("Error updating summary of layer %s: %v", layer.ID, err)
+		}
+	}
+	return fc, nil
+}
+
+// renderTile encodes the features of a layer within a tile
+func (app *application) renderTile(ctx context.Context, layer *models.Layer, tile mvt.Tile) ([]byte, error) {
+	bbox := tile.Bounds()
+
+	var fc *geojson.FeatureCollection
+	var err error
+	switch layer.Source {
+	case models.LayerTable, models.LayerQuery:
+		fc, _, err = app.databaseLayerFeatures(ctx, layer, &bbox)
+	default:
+		fc, err = app.sourceFeatures(ctx, layer)
+		if err == nil {
+			fc = fc.Filter(bbox)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	l := mvt.NewLayer(layer.Name, tile, layer.Attributes)
+	for _, f := range fc.Features {
+		l.Add(f)
+	}
+	return mvt.Encode(l), nil
+}
+
+// layerTile serves a layer as a Mapbox Vector Tile. Tiles are cached and
+// carry an ETag so that browsers revalidate them cheaply.
+func (app *application) layerTile(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	tile, err := mvt.ParseTile(params.ByName("z"), params.ByName("x"), params.ByName("y"))
+	if err != nil {
+		app.notFound(w)
+		return
+	}
+
+	layer := app.layerForUser(w, r, params.ByName("layer"))
+	if layer == nil {
+		return
+	}
+
+	key := fmt.Sprintf("%s/%d/%d/%d", layer.ID, tile.Z, tile.X, tile.Y)
+	cached, ok := app.layerCache.tiles.get(key)
+	if !ok || (!cached.expires.IsZero() && time.Now().After(cached.expires)) {
+		data, err := app.renderTile(r.Context(), layer, tile)
+		if err != nil {
+			if errors.Is(err, errLayerSource) {
+				app.writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+				return
+			}
+			app.serverError(w, err)
+			return
+		}
+
+		sum := sha256.Sum256(data)
+		cached = &cachedTile{data: data, etag: `"` + hex.EncodeToString(sum[:8]) + `"`}
+		if layer.Source == models.LayerTable || layer.Source == models.LayerQuery {
+			cached.expires = time.Now().Add(databaseTileTTL)
+		}
+		app.layerCache.tiles.put(key, cached)
+	}
+
+	w.Header().Set("ETag", cached.etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if strings.Contains(r.Header.Get("If-None-Match"), cached.etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+	w.Write(cached.data)
+}
+
+// projectLayerAttributesPost replaces the properties written to a layer's
+// vector tiles
+func (app *application) projectLayerAttributesPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	projectID, ok := app.projectForUser(w, r, params.ByName("id"))
+	if !ok {
+		return
+	}
+
+	layerID, ok := app.parseUUID(w, params.ByName("layer"))
+	if !ok {
+		return
+	}
+
+	layer, err := app.layers.Get(layerID)
+	if err != nil || layer.ProjectID != projectID {
+		if err == nil || errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	var req struct {
+		Attributes []string `json:"attributes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		app.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON body"})
+		return
+	}
+	if msg := checkTileAttributes(req.Attributes); msg != "" {
+		app.writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": msg})
+		return
+	}
+
+	if err := app.layers.SetAttributes(layer.ID, req.Attributes); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.layerCache.invalidate(layer.ID)
+
+	layer.Attributes = req.Attributes
+	app.writeJSON(w, http.StatusOK, layer)
+}
+
+// checkTileAttributes returns a message when an attribute allowlist is
+// unusable
+func checkTileAttributes(attributes []string) string {
+	if len(attributes) > maxTileAttributes {
+		return fmt.Sprintf("At most %d attributes can be written to tiles", maxTileAttributes)
+	}
+	for _, a := range attributes {
+		if strings.TrimSpace(a) == "" || len(a) > 64 {
+			return "Attribute names must be 1 to 64 characters"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"kdg/be/lab/internal/models"
+	"kdg/be/lab/internal/validator"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// tokenLifetimes are the days a new token may stay valid
+var tokenLifetimes = []int{7, 30, 90, 365}
+
+type tokenCreateForm struct {
+	Name                string   `form:"name"`
+	Scopes              []string `form:"scopes"`
+	ExpiresIn           int      `form:"expires_in"`
+	validator.Validator `form:"-"`
+}
+
+// renderSettings shows the settings page with the user's tokens
+func (app *application) renderSettings(w http.ResponseWriter, r *http.Request, status int, form tokenCreateForm) {
+	tokens, err := app.tokens.GetByUser(app.userIdFromSession(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = form
+	data.Tokens = tokens
+	data.TokenLifetimes = tokenLifetimes
+	data.NewToken = app.sessionManager.PopString(r.Context(), "newToken")
+	app.render(w, status, "settings.tmpl.html", data)
+}
+
+// userSettings shows the user's personal access tokens
+func (app *application) userSettings(w http.ResponseWriter, r *http.Request) {
+	app.renderSettings(w, r, http.StatusOK, tokenCreateForm{Scopes: []string{models.ScopeRead}, ExpiresIn: 90})
+}
+
+// tokenCreatePost creates a personal access token. Its plaintext is shown
+// once, on the settings page the user is sent back to.
+func (app *application) tokenCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form tokenCreateForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 100), "name", "This field cannot be more than 100 characters long")
+	form.CheckField(len(form.Scopes) > 0, "scopes", "Select at least one scope")
+	for _, scope := range form.Scopes {
+		form.CheckField(scope == models.ScopeRead || scope == models.ScopeWrite, "scopes", "Unknown scope")
+	}
+	form.CheckField(validator.PermittedInt(form.ExpiresIn, tokenLifetimes...), "expires_in", "Choose one of the listed lifetimes")
+
+	if !form.Valid() {
+		app.renderSettings(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	expires := time.Now().AddDate(0, 0, form.ExpiresIn)
+	_, plaintext, err := app.tokens.Insert(app.userIdFromSession(r), form.Name, form.Scopes, expires)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "newToken", plaintext)
+	app.setFlashAndRedirect(w, r, fmt.Sprintf("Token %s created. Copy it now, it will not be shown again.", form.Name),
+		"/user/settings", http.StatusSeeOther)
+}
+
+// tokenDeletePost revokes one of the user's tokens
+func (app *application) tokenDeletePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	tokenID, ok := app.parseUUID(w, params.ByName("id"))
+	if !ok {
+		return
+	}
+
+	if err := app.tokens.Delete(tokenID, app.userIdFromSession(r)); err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.setFlashAndRedirect(w, r, "Token revoked", "/user/settings", http.StatusSeeOther)
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"kdg/be/lab/internal/blobstore"
+	"kdg/be/lab/internal/models"
+	"kdg/be/lab/internal/scanner"
+	"kdg/be/lab/internal/sniff"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Chunk sizes a client may declare for an upload
+const (
+	minUploadChunkSize = 16 * 1024
+	maxUploadChunkSize = 8 * 1024 * 1024
+)
+
+// How long an upload session may sit idle before it is discarded
+const staleUploadAge = 24 * time.Hour
+
+// startUpload validates the declared size and hash, enforces the size limits
+// and either resumes the session named by UploadID or creates a new one. It
+// tells the client which chunk to send next and returns nil when the upload
+// cannot proceed.
+func (app *application) startUpload(ws *websocket.Conn, userID uuid.UUID, metadata *FileUploadMetadata) *models.Upload {
+	projectID, err := uuid.Parse(metadata.ProjectID)
+	if err != nil {
+		sendError(ws, "Invalid project ID")
+		return nil
+	}
+
+	hasAccess, err := app.projects.HasAccess(projectID, userID)
+	if err != nil {
+		app.errorLog.Printf("Error checking project access: %v", err)
+		sendError(ws, "Internal server error")
+		return nil
+	}
+	if !hasAccess {
+		sendError(ws, "You do not have access to this project")
+		return nil
+	}
+
+	metadata.SHA256 = strings.ToLower(metadata.SHA256)
+	if decoded, err := hex.DecodeString(metadata.SHA256); err != nil || len(decoded) != 32 {
+		sendError(ws, "A SHA-256 hash of the file must be declared before uploading")
+		return nil
+	}
+
+	if metadata.Size <= 0 {
+		sendError(ws, "The file size must be declared before uploading")
+		return nil
+	}
+
+	if metadata.Size > app.maxUploadSize {
+		sendError(ws, fmt.Sprintf("File is too large: %s exceeds the limit of %s",
+			formatFileSize(metadata.Size), formatFileSize(app.maxUploadSize)))
+		return nil
+	}
+
+	if metadata.ChunkSize < minUploadChunkSize || metadata.ChunkSize > maxUploadChunkSize {
+		sendError(ws, fmt.Sprintf("Chunk size must be between %s and %s",
+			formatFileSize(minUploadChunkSize), formatFileSize(maxUploadChunkSize)))
+		return nil
+	}
+
+	// Refuse files whose extension is clearly not allowed before any upload
+	if docType := sniff.ByExtension(metadata.Name); docType != sniff.Unknown && !app.allowlist.Allowed(docType, metadata.Roles) {
+		app.rejectFileType(ws, docType, metadata.Roles)
+		return nil
+	}
+
+	if _, _, err := app.blobStore(metadata.StorageLocation); err != nil {
+		sendError(ws, err.Error())
+		return nil
+	}
+
+	// Resume an earlier session when the client still has its ID
+	if metadata.UploadID != "" {
+		upload, err := app.resumeUpload(userID, metadata)
+		if err == nil {
+			app.infoLog.Printf("Resuming upload %s at chunk %d", upload.ID, upload.NextChunk)
+			sendJSON(ws, map[string]interface{}{
+				"status":     "ready",
+				"upload_id":  upload.ID,
+				"next_chunk": upload.NextChunk,
+				"chunk_size": upload.ChunkSize,
+				"message":    fmt.Sprintf("Resuming upload at chunk %d", upload.NextChunk),
+			})
+			return upload
+		}
+		app.infoLog.Printf("Cannot resume upload %s, starting over: %v", metadata.UploadID, err)
+	}
+
+	// The same document was already uploaded to this project
+	if existing, err := app.files.GetByHash(projectID, metadata.SHA256); err == nil {
+		if existing.Status == "quarantined" {
+			sendError(ws, fmt.Sprintf("This document was quarantined as %s and cannot be uploaded", existing.Name))
+			return nil
+		}
+		sendJSON(ws, map[string]interface{}{
+			"status":   "duplicate",
+			"message":  fmt.Sprintf("This document is already stored as %s", existing.Name),
+			"metadata": fileMetadataJSON(existing, metadata.Roles),
+		})
+		return nil
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		app.errorLog.Printf("Error checking for duplicate file: %v", err)
+		sendError(ws, "Internal server error")
+		return nil
+	}
+
+	// Enforce the project quota, counting uploads that are still in progress
+	used, err := app.files.ProjectUsage(projectID)
+	if err != nil {
+		app.errorLog.Printf("Error computing project usage: %v", err)
+		sendError(ws, "Internal server error")
+		return nil
+	}
+	pending, err := app.uploads.PendingBytes(projectID)
+	if err != nil {
+		app.errorLog.Printf("Error computing pending uploads: %v", err)
+		sendError(ws, "Internal server error")
+		return nil
+	}
+	if used+pending+metadata.Size > app.projectQuota {
+		sendError(ws, fmt.Sprintf("Project storage quota exceeded: %s used of %s",
+			formatFileSize(used+pending), formatFileSize(app.projectQuota)))
+		return nil
+	}
+
+	upload := &models.Upload{
+		ID:              uuid.New(),
+		ProjectID:       projectID,
+		OwnerID:         userID,
+		Name:            filepath.Base(metadata.Name),
+		Roles:           strings.Join(metadata.Roles, ","),
+		StorageLocation: metadata.StorageLocation,
+		Size:            metadata.Size,
+		SHA256:          metadata.SHA256,
+		ChunkSize:       metadata.ChunkSize,
+	}
+	upload.TempPath = filepath.Join(app.uploadDir, upload.ID.String()+".part")
+
+	if err := os.MkdirAll(app.uploadDir, 0755); err != nil {
+		app.errorLog.Printf("Error creating upload directory: %v", err)
+		sendError(ws, "Internal server error")
+		return nil
+	}
+
+	f, err := os.Create(upload.TempPath)
+	if err != nil {
+		app.errorLog.Printf("Error creating upload file: %v", err)
+		sendError(ws, "Internal server error")
+		return nil
+	}
+	f.Close()
+
+	if err := app.uploads.Insert(upload); err != nil {
+		os.Remove(upload.TempPath)
+		app.errorLog.Printf("Error saving upload session: %v", err)
+		sendError(ws, "Internal server error")
+		return nil
+	}
+
+	sendJSON(ws, map[string]interface{}{
+		"status":     "ready",
+		"upload_id":  upload.ID,
+		"next_chunk": 0,
+		"chunk_size": upload.ChunkSize,
+		"message":    "Ready to receive chunks",
+	})
+
+	return upload
+}
+
+// resumeUpload loads a session and checks it matches the declared file
+func (app *application) resumeUpload(userID uuid.UUID, metadata *FileUploadMetadata) (*models.Upload, error) {
+	uploadID, err := uuid.Parse(metadata.UploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	upload, err := app.uploads.Get(uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	if upload.OwnerID != userID || upload.ProjectID.String() != metadata.ProjectID {
+		return nil, errors.New("upload belongs to another user or project")
+	}
+	if upload.SHA256 != metadata.SHA256 || upload.Size != metadata.Size || upload.ChunkSize != metadata.ChunkSize {
+		return nil, errors.New("declared file does not match the upload session")
+	}
+
+	if _, err := os.Stat(upload.TempPath); err != nil {
+		app.discardUpload(upload)
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+// receiveUpload writes chunks to the session's temporary file until the
+// client sends EOF, then verifies the size and hash. Every chunk frame is an
+// 8 byte big-endian chunk index followed by the payload. Chunks must arrive in
+// order; repeated chunks are acknowledged again so a client can resend after a
+// lost acknowledgement.
+func (app *application) receiveUpload(ws *websocket.Conn, upload *models.Upload) *blobstore.TempBlob {
+	f, err := os.OpenFile(upload.TempPath, os.O_WRONLY, 0)
+	if err != nil {
+		app.errorLog.Printf("Error opening upload file: %v", err)
+		sendError(ws, "Internal server error")
+		return nil
+	}
+
+	totalChunks := int((upload.Size + int64(upload.ChunkSize) - 1) / int64(upload.ChunkSize))
+	next := upload.NextChunk
+
+	for {
+		messageType, message, err := ws.ReadMessage()
+		if err != nil {
+			// The session is kept so the client can resume later
+			app.infoLog.Printf("Upload %s interrupted at chunk %d: %v", upload.ID, next, err)
+			f.Close()
+			return nil
+		}
+
+		if messageType != websocket.BinaryMessage {
+			continue
+		}
+
+		if bytes.Equal(message, []byte("EOF")) {
+			break
+		}
+
+		if len(message) <= 8 {
+			sendError(ws, "Malformed chunk")
+			continue
+		}
+
+		index := int(binary.BigEndian.Uint64(message[:8]))
+		payload := message[8:]
+
+		if index < next {
+			sendJSON(ws, map[string]interface{}{"status": "ack", "chunk": index, "next_chunk": next})
+			continue
+		}
+		if index > next || index >= totalChunks {
+			sendJSON(ws, map[string]interface{}{
+				"status":     "error",
+				"error":      fmt.Sprintf("Unexpected chunk %d, expected %d", index, next),
+				"next_chunk": next,
+			})
+			continue
+		}
+
+		// Every chunk except the last must be exactly chunk_size long
+		offset := int64(index) * int64(upload.ChunkSize)
+		expected := int64(upload.ChunkSize)
+		if remaining := upload.Size - offset; remaining < expected {
+			expected = remaining
+		}
+		if int64(len(payload)) != expected {
+			f.Close()
+			sendError(ws, fmt.Sprintf("Chunk %d has %d bytes, expected %d", index, len(payload), expected))
+			return nil
+		}
+
+		if _, err := f.WriteAt(payload, offset); err != nil {
+			f.Close()
+			app.errorLog.Printf("Error writing chunk %d of upload %s: %v", index, upload.ID, err)
+			sendError(ws, "Error storing chunk")
+			return nil
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			app.errorLog.Printf("Error syncing upload %s: %v", upload.ID, err)
+			sendError(ws, "Error storing chunk")
+			return nil
+		}
+
+		next = index + 1
+		if err := app.uploads.AcknowledgeChunk(upload.ID, next); err != nil {
+			f.Close()
+			app.errorLog.Printf("Error recording chunk %d of upload %s: %v", index, upload.ID, err)
+			sendError(ws, "Internal server error")
+			return nil
+		}
+
+		progress := int(float64(next) / float64(totalChunks) * 100)
+		sendJSON(ws, map[string]interface{}{
+			"status":     "ack",
+			"chunk":      index,
+			"next_chunk": next,
+			"progress":   progress,
+			"message":    fmt.Sprintf("Received chunk %d of %d", next, totalChunks),
+		})
+	}
+
+	if err := f.Close(); err != nil {
+		app.errorLog.Printf("Error closing upload file: %v", err)
+		sendError(ws, "Internal server error")
+		return nil
+	}
+
+	if next < totalChunks {
+		sendJSON(ws, map[string]interface{}{
+			"status":     "error",
+			"error":      fmt.Sprintf("Upload incomplete: received %d of %d chunks", next, totalChunks),
+			"next_chunk": next,
+		})
+		return nil
+	}
+
+	// Verify the content against the hash declared up front
+	tmp, err := blobstore.OpenTemp(upload.TempPath)
+	if err != nil {
+		app.errorLog.Printf("Error hashing upload %s: %v", upload.ID, err)
+		sendError(ws, "Internal server error")
+		return nil
+	}
+
+	if tmp.Size != upload.Size || tmp.Hash != upload.SHA256 {
+		tmp.Remove()
+		app.discardUpload(upload)
+		sendError(ws, "Checksum mismatch: the received file does not match the declared SHA-256 hash")
+		return nil
+	}
+
+	sendJSON(ws, map[string]interface{}{
+		"status":  "verified",
+		"message": "Upload complete, checksum verified",
+	})
+
+	return tmp
+}
+
+// scanTemp runs the configured scanner over a received file
+func (app *application) scanTemp(ctx context.Context, tmp *blobstore.TempBlob) (scanner.Verdict, error) {
+	reader, err := tmp.Reader()
+	if err != nil {
+		return scanner.Verdict{}, err
+	}
+	return app.scanner.Scan(ctx, reader)
+}
+
+// rejectFileType tells the client that a document type is not accepted for
+// the selected roles and which types are
+func (app *application) rejectFileType(ws *websocket.Conn, docType string, roles []string) {
+	allowed := app.allowlist.Types(roles)
+
+	message := fmt.Sprintf("Files of type %s are not accepted for role %s", docType, strings.Join(roles, ", "))
+	if docType == sniff.Unknown {
+		message = fmt.Sprintf("The file type is not recognised and cannot be uploaded as %s", strings.Join(roles, ", "))
+	}
+	if len(allowed) > 0 {
+		message += fmt.Sprintf(". Accepted types: %s", strings.Join(allowed, ", "))
+	}
+
+	sendJSON(ws, map[string]interface{}{
+		"status":        "error",
+		"code":          "unsupported_file_type",
+		"error":         message,
+		"detected_type": docType,
+		"allowed_types": allowed,
+	})
+}
+
+// discardUpload deletes an upload session and its temporary file
+func (app *application) discardUpload(upload *models.Upload) {
+	if err := os.Remove(upload.TempPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		app.errorLog.Printf("Error removing upload file %s: %v", upload.TempPath, err)
+	}
+	if err := app.uploads.Delete(upload.ID); err != nil {
+		app.errorLog.Printf("Error removing upload session %s: %v", upload.ID, err)
+	}
+}
+
+// cleanupUploads periodically discards sessions that were abandoned
+func (app *application) cleanupUploads() {
+	for {
+		stale, err := app.uploads.Stale(time.Now().Add(-staleUploadAge))
+		if err != nil {
+			app.errorLog.Printf("Error listing stale uploads: %v", err)
+		}
+
+		for _, upload := range stale {
+			app.infoLog.Printf("Discarding stale upload %s", upload.ID)
+			app.discardUpload(upload)
+		}
+
+		time.Sleep(time.Hour)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"kdg/be/lab/internal/models"
+	"kdg/be/lab/internal/validator"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// webhookDeliveryLimit is the number of recent deliveries on the panel
+const webhookDeliveryLimit = 50
+
+type webhookCreateForm struct {
+	ProjectID           string   `form:"project_id"` // Empty for all projects
+	URL                 string   `form:"url"`
+	Events              []string `form:"events"`
+	validator.Validator `form:"-"`
+}
+
+// validWebhookURL accepts absolute http and https URLs, except those naming
+// localhost or a non-public IP address. Names are checked again after DNS
+// resolution when delivering.
+func validWebhookURL(value string) bool {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || u.User != nil {
+		return false
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return publicAddress(addr)
+	}
+	return true
+}
+
+// renderPanel shows the admin panel with the user's webhooks
+func (app *application) renderPanel(w http.ResponseWriter, r *http.Request, status int, webhookForm webhookCreateForm) {
+	userID := app.userIdFromSession(r)
+
+	data := app.newTemplateData(r)
+	data.Form = adminPanelForm{
+		ChunkMethod: defaultChunkMethod,
+		ChunkCount:  strconv.Itoa(defaultChunkCount),
+	}
+	data.WebhookForm = webhookForm
+
+	projects, err := app.projects.GetByUserID(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	data.Projects = projects
+
+	data.Webhooks, err = app.webhooks.GetByUser(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	data.WebhookDeliveries, err = app.webhookDeliveries.Recent(userID, webhookDeliveryLimit)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	data.WebhookEvents = models.WebhookEvents
+	data.NewWebhookSecret = app.sessionManager.PopString(r.Context(), "newWebhookSecret")
+
+	app.render(w, status, "admin.tmpl.html", data)
+}
+
+// webhookCreatePost subscribes a URL to events of a project, or of all the
+// user's projects. The signing secret is shown once on the panel.
+func (app *application) webhookCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form webhookCreateForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	userID := app.userIdFromSession(r)
+
+	var projectID uuid.NullUUID
+	if form.ProjectID != "" {
+		id, err := uuid.Parse(form.ProjectID)
+		if err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		ok, err := app.projects.HasAccess(id, userID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		if !ok {
+			app.notFound(w)
+			return
+		}
+		projectID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+
+	form.CheckField(validator.NotBlank(form.URL), "url", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.URL, 2000), "url", "This field cannot be more than 2000 characters long")
+	form.CheckField(validWebhookURL(form.URL), "url", "Enter a public http or https URL")
+	form.CheckField(len(form.Events) > 0, "events", "Select at least one event")
+	for _, event := range form.Events {
+		form.CheckField(contains(models.WebhookEvents, event), "events", "Unknown event")
+	}
+
+	if !form.Valid() {
+		app.renderPanel(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	hook, err := app.webhooks.Insert(projectID, form.URL, form.Events, userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "newWebhookSecret", hook.Secret)
+	app.setFlashAndRedirect(w, r, fmt.Sprintf("Webhook for %s created. Copy its signing secret now, it will not be shown again.", hook.URL),
+		"/panel", http.StatusSeeOther)
+}
+
+// webhookDeletePost removes a webhook and its delivery log
+func (app *application) webhookDeletePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	hookID, ok := app.parseUUID(w, params.ByName("id"))
+	if !ok {
+		return
+	}
+
+	if err := app.webhooks.Delete(hookID, app.userIdFromSession(r)); err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.setFlashAndRedirect(w, r, "Webhook deleted", "/panel", http.StatusSeeOther)
+}
+
+// webhookReplayPost queues a delivery again with its original payload
+func (app *application) webhookReplayPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	deliveryID, ok := app.parseUUID(w, params.ByName("id"))
+	if !ok {
+		return
+	}
+
+	delivery, err := app.webhookDeliveries.Get(deliveryID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+	if !app.canManageWebhook(w, r, delivery.WebhookID) {
+		return
+	}
+
+	if _, err := app.webhookDeliveries.Replay(delivery); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.wakeWebhookWorker()
+
+	app.setFlashAndRedirect(w, r, fmt.Sprintf("Delivery of %s queued again", delivery.Event), "/panel", http.StatusSeeOther)
+}
+
+// canManageWebhook reports whether the user created the webhook or is a
+// member of its project, and answers 404 otherwise
+func (app *application) canManageWebhook(w http.ResponseWriter, r *http.Request, hookID uuid.UUID) bool {
+	userID := app.userIdFromSession(r)
+
+	hook, err := app.webhooks.Get(hookID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return false
+	}
+	if hook.CreatedBy == userID {
+		return true
+	}
+
+	if hook.ProjectID.Valid {
+		ok, err := app.projects.HasAccess(hook.ProjectID.UUID, userID)
+		if err != nil {
+			app.serverError(w, err)
+			return false
+		}
+		if ok {
+			return true
+		}
+	}
+
+	app.notFound(w)
+	return false
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"kdg/be/lab/internal/models"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Webhook delivery settings
+const (
+	webhookAttempts     = 6
+	webhookTimeout      = 10 * time.Second
+	webhookLockTimeout  = 5 * time.Minute
+	webhookPollInterval = 5 * time.Second
+	// Delay before the first retry, doubled for every later one
+	webhookRetryDelay = 30 * time.Second
+)
+
+// errWebhookAddress is returned when a webhook resolves to an address on
+// the server's own networks
+var errWebhookAddress = errors.New("webhooks cannot be delivered to private, loopback or link-local addresses")
+
+// sharedAddressSpace is the carrier-grade NAT range, which net/netip does
+// not count as private
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddress reports whether an address is reachable on the internet
+// rather than on the server's own networks
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() && addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// dialPublicOnly refuses connections to non-public addresses. It runs
+// after DNS resolution, so names that resolve to internal hosts are caught.
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil || !publicAddress(ap.Addr()) {
+		return errWebhookAddress
+	}
+	return nil
+}
+
+// newWebhookClient returns the client deliveries are posted with. It only
+// connects to public addresses, bypasses proxies and does not follow
+// redirects, which count as failed deliveries.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout, Control: dialPublicOnly}
+	return &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: webhookTimeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// webhookPayload is the body posted to a webhook
+type webhookPayload struct {
+	ID        uuid.UUID   `json:"id"` // Same for every delivery of the event
+	Event     string      `json:"event"`
+	Created   time.Time   `json:"created"`
+	ProjectID uuid.UUID   `json:"project_id"`
+	Data      interface{} `json:"data"`
+}
+
+// signWebhook signs a payload as "sha256=" followed by the hex HMAC-SHA256
+// of the timestamp, a dot and the body, keyed with the webhook's secret
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// emitWebhook queues a delivery of an event to every webhook subscribed to
+// it. Failures are logged; they never fail the action that caused the event.
+func (app *application) emitWebhook(projectID uuid.UUID, event string, data interface{}) {
+	app.queueWebhooks(projectID, uuid.NullUUID{}, event, data)
+}
+
+// emitPrivateWebhook queues a delivery of an event about a user's own data,
+// such as a chat, only to the webhooks that user created
+func (app *application) emitPrivateWebhook(projectID, userID uuid.UUID, event string, data interface{}) {
+	app.queueWebhooks(projectID, uuid.NullUUID{UUID: userID, Valid: true}, event, data)
+}
+
+func (app *application) queueWebhooks(projectID uuid.UUID, createdBy uuid.NullUUID, event string, data interface{}) {
+	hooks, err := app.webhooks.ForEvent(projectID, event, createdBy)
+	if err != nil {
+		app.errorLog.Printf("Error finding webhooks for %s: %v", event, err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	payload := webhookPayload{
+		ID:        uuid.New(),
+		Event:     event,
+		Created:   time.Now().UTC(),
+		ProjectID: projectID,
+		Data:      data,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		app.errorLog.Printf("Error encoding %s webhook: %v", event, err)
+		return
+	}
+
+	for _, hook := range hooks {
+		delivery := &models.WebhookDelivery{
+			WebhookID:   hook.ID,
+			ProjectID:   projectID,
+			EventID:     payload.ID,
+			Event:       event,
+			Payload:     body,
+			MaxAttempts: webhookAttempts,
+		}
+		if err := app.webhookDeliveries.Enqueue(delivery); err != nil {
+			app.errorLog.Printf("Error queueing %s webhook %s: %v", event, hook.ID, err)
+		}
+	}
+	app.wakeWebhookWorker()
+}
+
+func (app *application) wakeWebhookWorker() {
+	select {
+	case app.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// webhookWorker posts queued deliveries
+func (app *application) webhookWorker() {
+	for {
+		delivery, err := app.webhookDeliveries.Claim(webhookLockTimeout)
+		if err != nil {
+			if !errors.Is(err, models.ErrNoRecord) {
+				app.errorLog.Printf("Error claiming webhook delivery: %v", err)
+			}
+			select {
+			case <-app.webhookWake:
+			case <-time.After(webhookPollInterval):
+			}
+			continue
+		}
+
+		app.deliverWebhook(delivery)
+	}
+}
+
+// deliverWebhook posts one claimed delivery and records the outcome. Any
+// 2xx response counts as delivered.
+func (app *application) deliverWebhook(delivery *models.WebhookDelivery) {
+	hook, err := app.webhooks.Get(delivery.WebhookID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.failWebhook(delivery, 0, errors.New("the webhook was deleted"))
+			return
+		}
+		app.retryWebhook(delivery, 0, err)
+		return
+	}
+
+	// Members who leave a project stop receiving its events
+	member, err := app.projects.HasAccess(delivery.ProjectID, hook.CreatedBy)
+	if err != nil {
+		app.retryWebhook(delivery, 0, err)
+		return
+	}
+	if !member {
+		app.failWebhook(delivery, 0, errors.New("the webhook's creator is no longer a member of the project"))
+		return
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		app.failWebhook(delivery, 0, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "kdg-webhooks/1")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", delivery.ID.String())
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", signWebhook(hook.Secret, timestamp, delivery.Payload))
+
+	resp, err := app.webhookClient.Do(req)
+	if err != nil {
+		app.retryWebhook(delivery, 0, err)
+		return
+	}
+	// Only the status is logged; response bodies are never shown
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		app.retryWebhook(delivery, resp.StatusCode, fmt.Errorf("the receiver answered %d %s", resp.StatusCode, http.StatusText(resp.StatusCode)))
+		return
+	}
+
+	if err := app.webhookDeliveries.Succeed(delivery.ID, resp.StatusCode); err != nil {
+		app.errorLog.Printf("Error recording webhook delivery %s: %v", delivery.ID, err)
+	}
+}
+
+// retryWebhook queues a failed delivery again with an increasing delay, or
+// fails it for good once it is out of attempts
+func (app *application) retryWebhook(delivery *models.WebhookDelivery, code int, err error) {
+	if delivery.Attempts >= delivery.MaxAttempts {
+		app.failWebhook(delivery, code, err)
+		return
+	}
+
+	app.errorLog.Printf("Webhook delivery %s (%s) attempt %d failed: %v", delivery.ID, delivery.Event, delivery.Attempts, err)
+	delay := webhookRetryDelay << (delivery.Attempts - 1)
+	if err := app.webhookDeliveries.Retry(delivery.ID, code, err.Error(), time.Now().Add(delay)); err != nil {
+		app.errorLog.Printf("Error requeueing webhook delivery %s: %v", delivery.ID, err)
+	}
+}
+
+func (app *application) failWebhook(delivery *models.WebhookDelivery, code int, err error) {
+	app.errorLog.Printf("Webhook delivery %s (%s) failed: %v", delivery.ID, delivery.Event, err)
+	if err := app.webhookDeliveries.Fail(delivery.ID, code, err.Error()); err != nil {
+		app.errorLog.Printf("Error failing webhook delivery %s: %v", delivery.ID, err)
+	}
+}
+
+// emitProjectCreated announces a new project to the creator's webhooks of
+// all projects
+func (app *application) emitProjectCreated(projectID uuid.UUID, name string, userID uuid.UUID) {
+	app.emitWebhook(projectID, models.EventProjectCreated, map[string]interface{}{
+		"name":       name,
+		"created_by": userID,
+	})
+}
@@ -2,7 +2,12 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
+	"kdg/be/lab/internal/crs"
+	"kdg/be/lab/internal/geocode"
+	"kdg/be/lab/internal/geojson"
+	"kdg/be/lab/internal/mapstyle"
+	"kdg/be/lab/internal/models"
 	"net/http"
 	"sync"
 
@@ -37,6 +42,7 @@ type ChatFinalResponse struct {
 type GeoObject struct {
 	Type     string          `json:"type"`
 	Features json.RawMessage `json:"features"`
+	CRS      json.RawMessage `json:"crs,omitempty"` // Dropped once reprojected to WGS84
 }
 
 // ChatGeoJsonResponse for geographic data
@@ -46,12 +52,17 @@ type ChatGeoJsonResponse struct {
 
 // FinalResponse combines all response types for the client
 type FinalResponse struct {
-	Status      string               `json:"status,omitempty"`
-	Answer      string               `json:"answer,omitempty"`      // For backward compatibility
-	Response    string               `json:"response,omitempty"`    // New schema
-	GeoJSON     json.RawMessage      `json:"geoJSON,omitempty"`     // For backward compatibility
-	GeoObjects  map[string]GeoObject `json:"geo_objects,omitempty"` // New schema
-	Interrupted bool                 `json:"interrupted,omitempty"`
+	Status      string                     `json:"status,omitempty"`
+	Answer      string                     `json:"answer,omitempty"`      // For backward compatibility
+	Response    string                     `json:"response,omitempty"`    // New schema
+	GeoJSON     json.RawMessage            `json:"geoJSON,omitempty"`     // For backward compatibility
+	GeoObjects  map[string]GeoObject       `json:"geo_objects,omitempty"` // New schema
+	Interrupted bool                       `json:"interrupted,omitempty"`
+	Citations   []models.Citation          `json:"citations,omitempty"`
+	Places      []geocode.Mention          `json:"places,omitempty"` // Place names in the answer found in the gazetteer
+	Styles      map[string]*mapstyle.Style `json:"styles,omitempty"` // Drawing of each geo object
+	Legend      []mapstyle.Legend          `json:"legend,omitempty"`
+	MessageID   int                        `json:"message_id,omitempty"` // Sent once the answer is stored
 }
 
 func (app *application) handleConnections(w http.ResponseWriter, r *http.Request) {
@@ -69,9 +80,10 @@ func (app *application) handleConnections(w http.ResponseWriter, r *http.Request
 	app.infoLog.Printf("Chat ID: %s", chatID)
 
 	// Proceed with WebSocket upgrade and handling...
-	ws, err := upgrader.Upgrade(w, r, nil)
+	ws, err := app.upgradeWebSocket(w, r)
 	if err != nil {
-		app.serverError(w, err)
+		// The upgrader has already written the error response
+		app.errorLog.Printf("WebSocket upgrade error: %v", err)
 		return
 	}
 	defer ws.Close()
@@ -147,96 +159,24 @@ func (app *application) handleConnections(w http.ResponseWriter, r *http.Request
 					interruptMutex.Unlock()
 				}()
 
-				// Get the database ID and user ID from request or default to empty string
-
-				dbID, err := app.projectDatabase.GetDbIDFromProject(projectUUID)
-				if err != nil {
-					app.errorLog.Print(err)
-					return
-				}
-
-				userID := userID.String()
-
-				app.infoLog.Printf(
-					message,
-					req.DBUsed,
-					req.DocsUsed,
-					dbID.String(),
-					userID,
-					chatID, // Use the chatID from the URL
-					dbID.String(),)
-
-				// Forward the message with the new schema fields
-				promptResponse, err := app.chatPort.ForwardMessageWithStream(
-					message,
-					req.DBUsed,
-					req.DocsUsed,
-					dbID.String(),
-					userID,
-					chatID, // Use the chatID from the URL
-					projectUUID.String(),
-				)
+				_, err := app.askChat(chatQuestion{
+					ChatID:    chatUUID,
+					ProjectID: projectUUID,
+					UserID:    userID,
+					Text:      message,
+					DBUsed:    req.DBUsed,
+					DocsUsed:  req.DocsUsed,
+				}, interrupt, func(resp FinalResponse) error {
+					return sendWSJSON(ws, resp)
+				})
 				if err != nil {
 					app.errorLog.Printf("Error forwarding message: %v", err)
-					if err := sendWSJSON(ws, FinalResponse{Status: "Error: " + err.Error()}); err != nil {
-						app.errorLog.Println("write error:", err)
-					}
-					return
-				}
-
-				// Variable to store the final answer for insertion into the database
-				var finalAnswer string
-
-				// Process incoming prompt responses
-			processLoop:
-				for {
-					select {
-					case prompt, ok := <-promptResponse:
-						if !ok {
-							// Channel closed, no more messages
-							break processLoop
-						}
-
-						app.infoLog.Print(prompt)
-						finalResp := app.processPrompt(prompt)
-
-						// Store the final answer if present
-						if finalResp.Response != "" {
-							finalAnswer = finalResp.Response
-						} else if finalResp.Answer != "" {
-							finalAnswer = finalResp.Answer
-						}
-
-						if err := sendWSJSON(ws, finalResp); err != nil {
-							app.errorLog.Println("write error:", err)
-							break processLoop
-						}
-					case <-interrupt:
-						// Handle interruption
-						app.infoLog.Println("Processing interrupted")
-
-						// Send one final message indicating interruption
-						finalResp := FinalResponse{
-							Status:      "Generation interrupted by user.",
-							Answer:      finalAnswer, // Include any partial answer for backward compatibility
-							Response:    finalAnswer, // Include for new schema
-							Interrupted: true,
-						}
-
-						if err := sendWSJSON(ws, finalResp); err != nil {
+					if errors.Is(err, errChatUpstream) {
+						if err := sendWSJSON(ws, FinalResponse{Status: "Error: " + err.Error()}); err != nil {
 							app.errorLog.Println("write error:", err)
 						}
-
-						break processLoop
 					}
 				}
-
-				// Only save to database if we have a complete answer
-				if finalAnswer != "" {
-					app.messages.Insert(chatUUID, "You", message)
-					app.messages.Insert(chatUUID, "AI", finalAnswer)
-					app.chats.UpdateLastActivity(chatUUID)
-				}
 			}()
 		}
 	}()
@@ -252,103 +192,76 @@ func parseWSRequest(msg []byte) (WebSocketRequest, error) {
 	return req, err
 }
 
-// validateGeoJSON checks if the provided data represents valid GeoJSON
-func validateGeoJSON(data []byte) (bool, string) {
-	// First try to parse the JSON
-	var geoJSON map[string]interface{}
-	if err := json.Unmarshal(data, &geoJSON); err != nil {
-		return false, fmt.Sprintf("Invalid JSON: %v", err)
-	}
-
-	// Check for type field
-	typeVal, hasType := geoJSON["type"]
-	if !hasType {
-		return false, "Missing 'type' property"
-	}
-
-	geoJSONType, ok := typeVal.(string)
-	if !ok {
-		return false, "'type' property is not a string"
-	}
-
-	// Validate based on the GeoJSON type
-	switch geoJSONType {
-	case "FeatureCollection":
-		// Check if features exist and it's an array
-		featuresVal, hasFeatures := geoJSON["features"]
-		if !hasFeatures {
-			return false, "FeatureCollection missing 'features' property"
-		}
-
-		features, ok := featuresVal.([]interface{})
-		if !ok {
-			return false, "FeatureCollection 'features' is not an array"
-		}
-
-		if len(features) == 0 {
-			return false, "FeatureCollection has empty 'features' array"
-		}
-
-		return true, fmt.Sprintf("Valid FeatureCollection with %d features", len(features))
-
-	case "Feature":
-		// Check if geometry exists
-		geometryVal, hasGeometry := geoJSON["geometry"]
-		if !hasGeometry {
-			return false, "Feature missing 'geometry' property"
+// cleanGeoObjects reprojects, validates and repairs the features of each
+// geo object. Objects that cannot be repaired are dropped. It also returns
+// all features merged into one FeatureCollection, or nil when there are
+// none.
+func (app *application) cleanGeoObjects(geoObjects map[string]GeoObject, projectCRS *crs.CRS) (map[string]GeoObject, json.RawMessage) {
+	cleaned := make(map[string]GeoObject, len(geoObjects))
+	unified := geojson.NewFeatureCollection()
+
+	for shapeType, geoObject := range geoObjects {
+		doc, err := json.Marshal(GeoObject{
+			Type:     "FeatureCollection",
+			Features: geoObject.Features,
+			CRS:      geoObject.CRS,
+		})
+		if err != nil {
+			app.errorLog.Printf("Error marshaling features for %s: %v", shapeType, err)
+			continue
 		}
 
-		geometry, ok := geometryVal.(map[string]interface{})
-		if !ok {
-			return false, "Feature 'geometry' is not an object"
+		fc, fixes, err := parseFeatures(doc, projectCRS)
+		for _, fix := range fixes {
+			app.infoLog.Printf("Repaired GeoJSON for '%s': %s", shapeType, fix)
 		}
-
-		geometryType, hasGeometryType := geometry["type"]
-		if !hasGeometryType {
-			return false, "Feature geometry missing 'type' property"
-		}
-
-		return true, fmt.Sprintf("Valid Feature with geometry type: %v", geometryType)
-
-	case "Point", "LineString", "Polygon", "MultiPoint", "MultiLineString", "MultiPolygon":
-		// Check if coordinates exist and it's an array
-		coordsVal, hasCoords := geoJSON["coordinates"]
-		if !hasCoords {
-			return false, fmt.Sprintf("%s missing 'coordinates' property", geoJSONType)
+		if err != nil {
+			app.errorLog.Printf("GeoJSON validation failed for '%s': %v", shapeType, err)
+			continue
 		}
 
-		_, ok := coordsVal.([]interface{})
-		if !ok {
-			return false, fmt.Sprintf("%s 'coordinates' is not an array", geoJSONType)
+		features, err := json.Marshal(fc.Features)
+		if err != nil {
+			app.errorLog.Printf("Error marshaling features for %s: %v", shapeType, err)
+			continue
 		}
+		geoObject.Features = features
+		geoObject.CRS = nil
+		cleaned[shapeType] = geoObject
+		unified.Features = append(unified.Features, fc.Features...)
+	}
 
-		return true, fmt.Sprintf("Valid %s geometry", geoJSONType)
-
-	case "GeometryCollection":
-		// Check if geometries exist and it's an array
-		geometriesVal, hasGeometries := geoJSON["geometries"]
-		if !hasGeometries {
-			return false, "GeometryCollection missing 'geometries' property"
-		}
+	if len(unified.Features) == 0 {
+		app.errorLog.Printf("No valid features found in GeoObjects, not sending GeoJSON to frontend")
+		return cleaned, nil
+	}
 
-		geometries, ok := geometriesVal.([]interface{})
-		if !ok {
-			return false, "GeometryCollection 'geometries' is not an array"
-		}
+	geoJSONBytes, err := json.Marshal(unified)
+	if err != nil {
+		app.errorLog.Printf("Error marshaling unified GeoJSON: %v", err)
+		return cleaned, nil
+	}
+	app.infoLog.Printf("Created unified GeoJSON with %d features", len(unified.Features))
+	return cleaned, geoJSONBytes
+}
 
-		if len(geometries) == 0 {
-			return false, "GeometryCollection has empty 'geometries' array"
+// storedGeoObjects returns the geo objects of a response to store with the
+// answer. A bare GeoJSON document is stored as a single object.
+func storedGeoObjects(resp FinalResponse) map[string]GeoObject {
+	if len(resp.GeoObjects) > 0 {
+		return resp.GeoObjects
+	}
+	if len(resp.GeoJSON) > 0 {
+		var obj GeoObject
+		if err := json.Unmarshal(resp.GeoJSON, &obj); err == nil {
+			return map[string]GeoObject{"features": obj}
 		}
-
-		return true, fmt.Sprintf("Valid GeometryCollection with %d geometries", len(geometries))
-
-	default:
-		return false, fmt.Sprintf("Unknown GeoJSON type: %s", geoJSONType)
 	}
+	return nil
 }
 
 // Process the prompt and return a FinalResponse
-func (app *application) processPrompt(prompt string) FinalResponse {
+func (app *application) processPrompt(prompt string, projectCRS *crs.CRS) FinalResponse {
 	var response FinalResponse
 
 	// Log the raw response for debugging
@@ -359,12 +272,14 @@ func (app *application) processPrompt(prompt string) FinalResponse {
 		Status     string               `json:"status,omitempty"`
 		Response   string               `json:"response,omitempty"`
 		GeoObjects map[string]GeoObject `json:"geo_objects,omitempty"`
+		Citations  []models.Citation    `json:"citations,omitempty"`
 	}
 
 	if err := json.Unmarshal([]byte(prompt), &combinedResponse); err == nil {
 		// If any field is populated, build the response
-		if combinedResponse.Status != "" || combinedResponse.Response != "" || len(combinedResponse.GeoObjects) > 0 {
+		if combinedResponse.Status != "" || combinedResponse.Response != "" || len(combinedResponse.GeoObjects) > 0 || len(combinedResponse.Citations) > 0 {
 			response.Status = combinedResponse.Status
+			response.Citations = combinedResponse.Citations
 
 			if combinedResponse.Response != "" {
 				response.Answer = combinedResponse.Response   // For backward compatibility
@@ -372,59 +287,9 @@ func (app *application) processPrompt(prompt string) FinalResponse {
 			}
 
 			if len(combinedResponse.GeoObjects) > 0 {
-				response.GeoObjects = combinedResponse.GeoObjects
-
-				// Create a simplified single FeatureCollection from geo_objects
-				var allFeatures []json.RawMessage
-
-				// Log the GeoObjects details
 				app.infoLog.Printf("Processing GeoObjects with %d items", len(combinedResponse.GeoObjects))
-
-				for shapeType, geoObject := range combinedResponse.GeoObjects {
-					app.infoLog.Printf("Processing '%s' with type '%s'", shapeType, geoObject.Type)
-
-					// Ensure we can unmarshal the Features property
-					var features []json.RawMessage
-					if err := json.Unmarshal(geoObject.Features, &features); err != nil {
-						app.errorLog.Printf("Error unmarshaling features for %s: %v", shapeType, err)
-						continue
-					}
-
-					app.infoLog.Printf("Successfully parsed %d features for '%s'", len(features), shapeType)
-
-					// Add each feature to our collection
-					for _, feature := range features {
-						allFeatures = append(allFeatures, feature)
-					}
-				}
-
-				// Create a unified FeatureCollection
-				unifiedGeoJSON := map[string]interface{}{
-					"type":     "FeatureCollection",
-					"features": allFeatures,
-				}
-
-				// Only proceed if we have actual features
-				if len(allFeatures) > 0 {
-					// Marshal to JSON for validation
-					geoJSONBytes, err := json.Marshal(unifiedGeoJSON)
-					if err != nil {
-						app.errorLog.Printf("Error marshaling unified GeoJSON: %v", err)
-					} else {
-						// Validate the GeoJSON before sending it
-						isValid, message := validateGeoJSON(geoJSONBytes)
-						if isValid {
-							app.infoLog.Printf("GeoJSON validation passed: %s", message)
-							response.GeoJSON = geoJSONBytes
-							app.infoLog.Printf("Created unified GeoJSON with %d features", len(allFeatures))
-						} else {
-							app.errorLog.Printf("GeoJSON validation failed: %s", message)
-							// Don't include invalid GeoJSON in the response
-						}
-					}
-				} else {
-					app.errorLog.Printf("No valid features found in GeoObjects, not sending GeoJSON to frontend")
-				}
+				response.GeoObjects, response.GeoJSON = app.cleanGeoObjects(combinedResponse.GeoObjects, projectCRS)
+				response.Styles, response.Legend = app.geoObjectStyles(response.GeoObjects)
 			}
 
 			return response
@@ -451,47 +316,8 @@ func (app *application) processPrompt(prompt string) FinalResponse {
 	// Try to parse as a ChatGeoJsonResponse (just geo_objects)
 	var geoJsonResp ChatGeoJsonResponse
 	if err := json.Unmarshal([]byte(prompt), &geoJsonResp); err == nil && len(geoJsonResp.GeoObjects) > 0 {
-		response.GeoObjects = geoJsonResp.GeoObjects
-
-		// Process GeoObjects into a proper FeatureCollection
-		var allFeatures []json.RawMessage
-
-		for shapeType, geoObject := range geoJsonResp.GeoObjects {
-			var features []json.RawMessage
-			if err := json.Unmarshal(geoObject.Features, &features); err != nil {
-				app.errorLog.Printf("Error unmarshaling features for %s: %v", shapeType, err)
-				continue
-			}
-
-			for _, feature := range features {
-				allFeatures = append(allFeatures, feature)
-			}
-		}
-
-		// Only proceed if we have actual features
-		if len(allFeatures) > 0 {
-			// Create unified FeatureCollection
-			unifiedGeoJSON := map[string]interface{}{
-				"type":     "FeatureCollection",
-				"features": allFeatures,
-			}
-
-			// Marshal to JSON
-			geoJSONBytes, err := json.Marshal(unifiedGeoJSON)
-			if err == nil {
-				// Validate before sending
-				isValid, message := validateGeoJSON(geoJSONBytes)
-				if isValid {
-					app.infoLog.Printf("GeoJSON validation passed: %s", message)
-					response.GeoJSON = geoJSONBytes
-					app.infoLog.Printf("Created unified GeoJSON from ChatGeoJsonResponse with %d features", len(allFeatures))
-				} else {
-					app.errorLog.Printf("GeoJSON validation failed: %s", message)
-				}
-			} else {
-				app.errorLog.Printf("Error marshaling unified GeoJSON: %v", err)
-			}
-		}
+		response.GeoObjects, response.GeoJSON = app.cleanGeoObjects(geoJsonResp.GeoObjects, projectCRS)
+		response.Styles, response.Legend = app.geoObjectStyles(response.GeoObjects)
 
 		return response
 	}
@@ -505,14 +331,16 @@ func (app *application) processPrompt(prompt string) FinalResponse {
 				geoJSONType == "MultiPoint" || geoJSONType == "MultiLineString" || geoJSONType == "MultiPolygon" ||
 				geoJSONType == "GeometryCollection" {
 
-				// Validate before sending
-				isValid, message := validateGeoJSON([]byte(prompt))
-				if isValid {
-					app.infoLog.Printf("Direct GeoJSON validation passed: %s", message)
-					response.GeoJSON = []byte(prompt)
+				// Validate and repair before sending
+				fc, fixes, err := parseFeatures([]byte(prompt), projectCRS)
+				for _, fix := range fixes {
+					app.infoLog.Printf("Repaired direct GeoJSON: %s", fix)
+				}
+				if err != nil {
+					app.errorLog.Printf("Direct GeoJSON validation failed: %v", err)
+				} else if geoJSONBytes, err := json.Marshal(fc); err == nil {
+					response.GeoJSON = geoJSONBytes
 					return response
-				} else {
-					app.errorLog.Printf("Direct GeoJSON validation failed: %s", message)
 				}
 			}
 		}
@@ -0,0 +1,163 @@
+// Package blobstore stores uploaded documents by content hash on the local
+// filesystem or in an S3-compatible object store.
+package blobstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	ErrNotFound = errors.New("blobstore: blob not found")
+
+	ErrInvalidKey = errors.New("blobstore: invalid key")
+)
+
+// Store is implemented by every storage backend
+type Store interface {
+	// Put writes size bytes from r under key, replacing any existing blob
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get opens the blob stored under key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Exists reports whether a blob is stored under key
+	Exists(ctx context.Context, key string) (bool, error)
+	// Delete removes the blob, deleting a missing blob is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// KeyForHash returns the content-addressed key for a hex encoded SHA-256 hash
+func KeyForHash(hash string) string {
+	return fmt.Sprintf("sha256/%s/%s", hash[:2], hash)
+}
+
+// validKey rejects keys that could escape the store root
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// TempBlob is a file on disk that hashes everything written to it
+type TempBlob struct {
+	File *os.File
+	Size int64
+	Hash string
+
+	hasher hash.Hash
+}
+
+// CreateTemp creates an empty temporary blob in dir
+func CreateTemp(dir string) (*TempBlob, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp(dir, "upload-*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &TempBlob{File: f, hasher: sha256.New()}, nil
+}
+
+// WriteTemp streams r into a temporary file in dir while hashing it
+func WriteTemp(dir string, r io.Reader) (*TempBlob, error) {
+	t, err := CreateTemp(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(t, r); err != nil {
+		t.Remove()
+		return nil, err
+	}
+
+	t.Finish()
+	return t, nil
+}
+
+// OpenTemp opens an existing file as a temporary blob and hashes its content
+func OpenTemp(path string) (*TempBlob, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &TempBlob{File: f, hasher: sha256.New()}
+	n, err := io.Copy(t.hasher, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	t.Size = n
+	t.Finish()
+	return t, nil
+}
+
+// Write appends p to the file and the running hash
+func (t *TempBlob) Write(p []byte) (int, error) {
+	n, err := t.File.Write(p)
+	t.hasher.Write(p[:n])
+	t.Size += int64(n)
+	return n, err
+}
+
+// Finish records the hex encoded SHA-256 of everything written so far
+func (t *TempBlob) Finish() string {
+	t.Hash = hex.EncodeToString(t.hasher.Sum(nil))
+	return t.Hash
+}
+
+// Reader rewinds the temporary file and returns it for reading
+func (t *TempBlob) Reader() (io.Reader, error) {
+	if _, err := t.File.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return t.File, nil
+}
+
+// Remove closes and deletes the temporary file
+func (t *TempBlob) Remove() {
+	t.File.Close()
+	os.Remove(t.File.Name())
+}
+
+// PutTemp stores a temporary blob under its content-addressed key, skipping
+// the write when an identical blob is already present. It returns the key and
+// whether the content was deduplicated.
+func PutTemp(ctx context.Context, s Store, t *TempBlob) (string, bool, error) {
+	key := KeyForHash(t.Hash)
+
+	exists, err := s.Exists(ctx, key)
+	if err != nil {
+		return "", false, err
+	}
+	if exists {
+		return key, true, nil
+	}
+
+	r, err := t.Reader()
+	if err != nil {
+		return "", false, err
+	}
+
+	if err := s.Put(ctx, key, r, t.Size); err != nil {
+		return "", false, err
+	}
+
+	return key, false, nil
+}
@@ -0,0 +1,305 @@
+// Package crs identifies coordinate reference systems by their EPSG code
+// and transforms coordinates between them and WGS84. It supports the
+// national grids of the Benelux, France, Germany and Great Britain, the
+// pan-European ETRS89 systems, UTM and Web Mercator.
+package crs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupported is returned for reference systems this package cannot
+// transform
+var ErrUnsupported = errors.New("crs: unsupported reference system")
+
+// CRS is a coordinate reference system
+type CRS struct {
+	Code int
+	Name string
+
+	geog  *geographic
+	el    ellipsoid
+	proj  projection // nil for geographic systems
+	shift *helmert   // nil when the datum is WGS84 or ETRS89
+
+	// area is the projected extent of the system's area of use as
+	// west, south, east, north, used by Guess. Systems without one are
+	// never guessed.
+	area *[4]float64
+}
+
+// geographic names a datum and the geographic system based on it, for WKT
+type geographic struct {
+	code  int
+	name  string
+	datum string
+}
+
+var (
+	geogWGS84      = &geographic{4326, "WGS 84", "WGS_1984"}
+	geogETRS89     = &geographic{4258, "ETRS89", "European_Terrestrial_Reference_System_1989"}
+	geogRGF93      = &geographic{4171, "RGF93", "Reseau_Geodesique_Francais_1993"}
+	geogBD72       = &geographic{4313, "BD72", "Reseau_National_Belge_1972"}
+	geogAmersfoort = &geographic{4289, "Amersfoort", "Amersfoort"}
+	geogDHDN       = &geographic{4314, "DHDN", "Deutsches_Hauptdreiecksnetz"}
+	geogOSGB36     = &geographic{4277, "OSGB 1936", "OSGB_1936"}
+)
+
+// WGS84 is the geographic system GeoJSON uses, with longitude first
+var WGS84 = &CRS{Code: 4326, Name: "WGS 84", geog: geogWGS84, el: wgs84Ellipsoid}
+
+var (
+	bd72       = &helmert{-106.8686, 52.2978, -103.7239, 0.3366, -0.457, 1.8422, -1.2747}
+	amersfoort = &helmert{565.417, 50.3319, 465.552, -0.398957, 0.343988, -1.8774, 4.0725}
+	dhdn       = &helmert{598.1, 73.7, 418.2, 0.202, 0.045, -2.455, 6.7}
+	osgb36     = &helmert{446.448, -125.157, 542.06, 0.15, 0.247, 0.842, -20.489}
+)
+
+// registry holds the systems with a fixed definition; UTM zones are built
+// by Lookup
+var registry = map[int]*CRS{
+	4326: WGS84,
+	4258: {Code: 4258, Name: "ETRS89", geog: geogETRS89, el: grs80},
+	3857: {Code: 3857, Name: "WGS 84 / Pseudo-Mercator", geog: geogWGS84, el: wgs84Ellipsoid, proj: webMercator{}},
+	31370: {
+		Code: 31370, Name: "BD72 / Belgian Lambert 72", geog: geogBD72, el: international, shift: bd72,
+		proj: newLambertConic(international, 90, 4.36748666666667, 51.1666672333333, 49.8333339, 150000.013, 5400088.438),
+		area: &[4]float64{0, 0, 300000, 300000},
+	},
+	3812: {
+		Code: 3812, Name: "ETRS89 / Belgian Lambert 2008", geog: geogETRS89, el: grs80,
+		proj: newLambertConic(grs80, 50.797815, 4.35921583333333, 49.8333333333333, 51.1666666666667, 649328, 665262),
+		area: &[4]float64{500000, 500000, 800000, 800000},
+	},
+	28992: {
+		Code: 28992, Name: "Amersfoort / RD New", geog: geogAmersfoort, el: bessel, shift: amersfoort,
+		proj: newObliqueStereographic(bessel, 52.1561605555556, 5.38763888888889, 0.9999079, 155000, 463000),
+		area: &[4]float64{-10000, 300000, 300000, 650000},
+	},
+	2154: {
+		Code: 2154, Name: "RGF93 / Lambert-93", geog: geogRGF93, el: grs80,
+		proj: newLambertConic(grs80, 46.5, 3, 49, 44, 700000, 6600000),
+		area: &[4]float64{0, 6000000, 1300000, 7200000},
+	},
+	3035: {
+		Code: 3035, Name: "ETRS89 / LAEA Europe", geog: geogETRS89, el: grs80,
+		proj: newLambertAzimuthal(grs80, 52, 10, 4321000, 3210000),
+	},
+	27700: {
+		Code: 27700, Name: "OSGB36 / British National Grid", geog: geogOSGB36, el: airy, shift: osgb36,
+		proj: newTransverseMercator(airy, 49, -2, 0.9996012717, 400000, -100000),
+	},
+}
+
+func init() {
+	// DHDN / 3-degree Gauss-Krüger zones 2 to 5
+	for zone := 2; zone <= 5; zone++ {
+		code := 31464 + zone
+		registry[code] = &CRS{
+			Code: code, Name: fmt.Sprintf("DHDN / 3-degree Gauss-Kruger zone %d", zone), geog: geogDHDN, el: bessel, shift: dhdn,
+			proj: newTransverseMercator(bessel, 0, float64(3*zone), 1, float64(zone)*1e6+500000, 0),
+		}
+	}
+}
+
+// Lookup returns the system with an EPSG code
+func Lookup(code int) (*CRS, error) {
+	if c, ok := registry[code]; ok {
+		return c, nil
+	}
+
+	// WGS 84 / UTM zones north and south, and ETRS89 / UTM zones
+	switch {
+	case code >= 32601 && code <= 32660:
+		return utm(code, geogWGS84, wgs84Ellipsoid, code-32600, false), nil
+	case code >= 32701 && code <= 32760:
+		return utm(code, geogWGS84, wgs84Ellipsoid, code-32700, true), nil
+	case code >= 25828 && code <= 25838:
+		return utm(code, geogETRS89, grs80, code-25800, false), nil
+	}
+
+	return nil, fmt.Errorf("%w: EPSG:%d", ErrUnsupported, code)
+}
+
+func utm(code int, geog *geographic, el ellipsoid, zone int, south bool) *CRS {
+	hemisphere, y0 := "N", 0.0
+	if south {
+		hemisphere, y0 = "S", 10000000
+	}
+	return &CRS{
+		Code: code,
+		Name: fmt.Sprintf("%s / UTM zone %d%s", geog.name, zone, hemisphere),
+		geog: geog,
+		el:   el,
+		proj: newTransverseMercator(el, 0, float64(6*zone-183), 0.9996, 500000, y0),
+	}
+}
+
+// Supported lists the systems with a fixed definition by code; UTM zones
+// are left out
+func Supported() []*CRS {
+	list := make([]*CRS, 0, len(registry))
+	for _, c := range registry {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Code < list[j].Code })
+	return list
+}
+
+func (c *CRS) String() string {
+	return fmt.Sprintf("EPSG:%d", c.Code)
+}
+
+// IsWGS84 reports whether coordinates in the system can be used as GeoJSON
+// positions without transformation. ETRS89 counts, as it differs from
+// WGS84 by less than a metre.
+func (c *CRS) IsWGS84() bool {
+	return c.proj == nil && c.shift == nil
+}
+
+// namePattern matches the ways an EPSG code is written in crs members:
+// EPSG:31370, urn:ogc:def:crs:EPSG::31370 and
+// http://www.opengis.net/def/crs/EPSG/0/31370
+var namePattern = regexp.MustCompile(`(?i)EPSG(?::+|/0/|/)(\d+)$`)
+
+// Parse reads the name of a reference system
+func Parse(name string) (*CRS, error) {
+	name = strings.TrimSpace(name)
+	if strings.HasSuffix(strings.ToUpper(name), "CRS84") {
+		return WGS84, nil
+	}
+	m := namePattern.FindStringSubmatch(name)
+	if m == nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupported, name)
+	}
+	code, err := strconv.Atoi(m[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupported, name)
+	}
+	return Lookup(code)
+}
+
+// FromMember reads the crs member of the 2008 GeoJSON specification, which
+// RFC 7946 dropped but many tools still write. It names the system
+// ({"type":"name","properties":{"name":"EPSG:31370"}}) or, in older
+// documents, gives its code ({"type":"EPSG","properties":{"code":31370}}).
+func FromMember(member json.RawMessage) (*CRS, error) {
+	var v struct {
+		Type       string `json:"type"`
+		Properties struct {
+			Name string      `json:"name"`
+			Code json.Number `json:"code"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(member, &v); err != nil {
+		return nil, fmt.Errorf("%w: invalid crs member", ErrUnsupported)
+	}
+
+	switch strings.ToLower(v.Type) {
+	case "name":
+		return Parse(v.Properties.Name)
+	case "epsg":
+		code, err := v.Properties.Code.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid EPSG code", ErrUnsupported)
+		}
+		return Lookup(int(code))
+	}
+	return nil, fmt.Errorf("%w: crs member of type %q", ErrUnsupported, v.Type)
+}
+
+// Guess returns the national grid whose area of use holds the extent
+// given as west, south, east, north, or nil when none or more than one
+// does. Use it only for coordinates that cannot be degrees.
+func Guess(extent [4]float64) *CRS {
+	var found *CRS
+	for _, c := range registry {
+		if c.area == nil {
+			continue
+		}
+		a := c.area
+		if extent[0] >= a[0] && extent[1] >= a[1] && extent[2] <= a[2] && extent[3] <= a[3] {
+			if found != nil {
+				return nil
+			}
+			found = c
+		}
+	}
+	return found
+}
+
+// ToWGS84 transforms coordinates in the system to longitude and latitude
+// in degrees
+func (c *CRS) ToWGS84(x, y float64) (lon, lat float64) {
+	if c.proj == nil {
+		lon, lat = x*deg, y*deg
+	} else {
+		lon, lat = c.proj.inverse(x, y)
+	}
+	if c.shift != nil {
+		gx, gy, gz := c.el.toGeocentric(lon, lat)
+		gx, gy, gz = c.shift.apply(gx, gy, gz, false)
+		lon, lat = wgs84Ellipsoid.fromGeocentric(gx, gy, gz)
+	}
+	return lon / deg, lat / deg
+}
+
+// FromWGS84 transforms longitude and latitude in degrees to coordinates in
+// the system
+func (c *CRS) FromWGS84(lon, lat float64) (x, y float64) {
+	lon, lat = lon*deg, lat*deg
+	if c.shift != nil {
+		gx, gy, gz := wgs84Ellipsoid.toGeocentric(lon, lat)
+		gx, gy, gz = c.shift.apply(gx, gy, gz, true)
+		lon, lat = c.el.fromGeocentric(gx, gy, gz)
+	}
+	if c.proj == nil {
+		return lon / deg, lat / deg
+	}
+	return c.proj.forward(lon, lat)
+}
+
+// Transform returns a function converting coordinates from one system to
+// another, for use with geojson's FeatureCollection.Transform
+func Transform(from, to *CRS) func(x, y float64) (float64, float64) {
+	return func(x, y float64) (float64, float64) {
+		lon, lat := from.ToWGS84(x, y)
+		if to == WGS84 {
+			return lon, lat
+		}
+		return to.FromWGS84(lon, lat)
+	}
+}
+
+// isDegrees reports whether an extent fits within longitude and latitude
+// ranges
+func isDegrees(extent [4]float64) bool {
+	return extent[0] >= -180 && extent[2] <= 180 && extent[1] >= -90 && extent[3] <= 90
+}
+
+// Detect picks the system of a document's coordinates: the crs member if
+// there is one, else WGS84 when the extent fits in degrees, else fallback,
+// else a national grid guessed from the extent. An empty extent, with its
+// infinite bounds, counts as degrees.
+func Detect(member json.RawMessage, fallback *CRS, extent [4]float64) (*CRS, error) {
+	if len(member) > 0 && string(member) != "null" {
+		return FromMember(member)
+	}
+	if isDegrees(extent) || math.IsInf(extent[0], 0) {
+		return WGS84, nil
+	}
+	if fallback != nil {
+		return fallback, nil
+	}
+	if c := Guess(extent); c != nil {
+		return c, nil
+	}
+	return nil, fmt.Errorf("%w: coordinates are not degrees and no crs is given", ErrUnsupported)
+}
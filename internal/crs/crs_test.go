@@ -0,0 +1,140 @@
+package crs
+
+import (
+	"math"
+	"testing"
+)
+
+// clarke1866 is the ellipsoid of the EPSG and Snyder worked examples
+var clarke1866 = ellipsoid{name: "Clarke 1866", a: 6378206.4, rf: 294.9786982}
+
+func dms(d, m, s float64) float64 {
+	sign := 1.0
+	if d < 0 {
+		sign, d = -1, -d
+	}
+	return sign * (d + m/60 + s/3600)
+}
+
+func lookup(t *testing.T, code int) projection {
+	t.Helper()
+	c, err := Lookup(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.proj
+}
+
+// meridianArc integrates the length of the meridian from the equator to a
+// latitude, independently of the series the projections use
+func meridianArc(el ellipsoid, lat float64) float64 {
+	const steps = 10000
+	es := el.es()
+	f := func(phi float64) float64 {
+		s := math.Sin(phi)
+		return el.a * (1 - es) / math.Pow(1-es*s*s, 1.5)
+	}
+	h := lat / steps
+	sum := f(0) + f(lat)
+	for i := 1; i < steps; i++ {
+		if i%2 == 1 {
+			sum += 4 * f(float64(i)*h)
+		} else {
+			sum += 2 * f(float64(i)*h)
+		}
+	}
+	return sum * h / 3
+}
+
+// TestControlPoints projects geodetic coordinates on each system's own
+// datum and back. Points come from EPSG Guidance Note 7-2, Snyder's Map
+// Projections: A Working Manual and the systems' natural origins.
+func TestControlPoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		proj     projection
+		lon, lat float64 // degrees
+		x, y     float64
+		tol      float64 // metres
+	}{
+		{"31370 GN7-2", lookup(t, 31370), dms(5, 48, 26.533), dms(50, 40, 46.461), 251763.20, 153034.13, 0.05},
+		{"28992 GN7-2", lookup(t, 28992), 6, 53, 196105.283, 557057.739, 0.005},
+		{"28992 origin", lookup(t, 28992), dms(5, 23, 15.5), dms(52, 9, 22.178), 155000, 463000, 0.01},
+		{"3035 GN7-2", lookup(t, 3035), 5, 50, 3962799.45, 2999718.85, 0.005},
+		{"3035 origin", lookup(t, 3035), 10, 52, 4321000, 3210000, 0.001},
+		{"2154 origin", lookup(t, 2154), 3, 46.5, 700000, 6600000, 0.001},
+		// As in the current GN7-2, which uses the JHS series
+		{"27700 GN7-2", lookup(t, 27700), dms(0, 30, 0), dms(50, 30, 0), 577274.98, 69740.49, 0.005},
+		{"27700 origin", lookup(t, 27700), -2, 49, 400000, -100000, 0.001},
+		{
+			"Lambert 2SP GN7-2 Texas South Central",
+			newLambertConic(clarke1866, dms(27, 50, 0), -99, dms(28, 23, 0), dms(30, 17, 0), 609601.2192, 0),
+			-96, 28.5, 903277.7965, 77650.94219, 0.005,
+		},
+		{
+			"UTM zone 18N Snyder", newTransverseMercator(clarke1866, 0, -75, 0.9996, 500000, 0),
+			dms(-73, 30, 0), dms(40, 30, 0), 627106.5, 4484124.4, 0.1,
+		},
+		{"32631 equator", lookup(t, 32631), 3, 0, 500000, 0, 0.001},
+		{"32731 equator", lookup(t, 32731), 3, 0, 500000, 10000000, 0.001},
+		{"32631 meridian", lookup(t, 32631), 3, 52, 500000, 0.9996 * meridianArc(wgs84Ellipsoid, 52*deg), 0.001},
+		{"32733 meridian", lookup(t, 32733), 15, -30, 500000, 10000000 - 0.9996*meridianArc(wgs84Ellipsoid, 30*deg), 0.001},
+		{"25832 meridian", lookup(t, 25832), 9, 48, 500000, 0.9996 * meridianArc(grs80, 48*deg), 0.001},
+		{"31467 meridian", lookup(t, 31467), 9, 50, 3500000, meridianArc(bessel, 50*deg), 0.001},
+		{"31468 meridian", lookup(t, 31468), 12, 48, 4500000, meridianArc(bessel, 48*deg), 0.001},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x, y := tt.proj.forward(tt.lon*deg, tt.lat*deg)
+			if math.Abs(x-tt.x) > tt.tol || math.Abs(y-tt.y) > tt.tol {
+				t.Errorf("forward = %.4f, %.4f; want %.4f, %.4f", x, y, tt.x, tt.y)
+			}
+
+			// A tolerance in metres is about tol/111km in degrees
+			lon, lat := tt.proj.inverse(tt.x, tt.y)
+			lon, lat = lon/deg, lat/deg
+			tolDeg := 2 * tt.tol / 111000
+			if math.Abs(lon-tt.lon) > tolDeg || math.Abs(lat-tt.lat) > tolDeg {
+				t.Errorf("inverse = %.9f, %.9f; want %.9f, %.9f", lon, lat, tt.lon, tt.lat)
+			}
+		})
+	}
+}
+
+// TestRoundTrip checks that every supported system, UTM zones and datum
+// shifts included, returns WGS84 coordinates to where they started. The
+// inverse datum shifts are approximate, to about a millimetre.
+func TestRoundTrip(t *testing.T) {
+	const tol = 5e-8
+	systems := Supported()
+	for _, code := range []int{32631, 32733, 25831, 25838} {
+		c, err := Lookup(code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		systems = append(systems, c)
+	}
+
+	for _, c := range systems {
+		lon, lat := 4.5, 51.0
+		switch {
+		case c.Code >= 31466 && c.Code <= 31469:
+			lon = float64(3*(c.Code-31464)) + 0.7
+		case c.Code >= 25828 && c.Code <= 25838:
+			lon = float64(6*(c.Code-25800)-183) - 1.2
+		case c.Code == 32733:
+			lon, lat = 14.2, -25
+		case c.Code == 27700:
+			lon, lat = -1.5, 53
+		case c.Code == 2154:
+			lon, lat = 2.35, 48.85
+		}
+
+		x, y := c.FromWGS84(lon, lat)
+		gotLon, gotLat := c.ToWGS84(x, y)
+		if math.Abs(gotLon-lon) > tol || math.Abs(gotLat-lat) > tol {
+			t.Errorf("%s: %f, %f came back as %.12f, %.12f", c, lon, lat, gotLon, gotLat)
+		}
+	}
+}
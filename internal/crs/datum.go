@@ -0,0 +1,94 @@
+package crs
+
+import "math"
+
+// ellipsoid is a reference ellipsoid given by its semi-major axis and
+// inverse flattening
+type ellipsoid struct {
+	name string
+	a    float64
+	rf   float64
+}
+
+var (
+	wgs84Ellipsoid = ellipsoid{name: "WGS 84", a: 6378137, rf: 298.257223563}
+	grs80          = ellipsoid{name: "GRS 1980", a: 6378137, rf: 298.257222101}
+	international  = ellipsoid{name: "International 1924", a: 6378388, rf: 297}
+	bessel         = ellipsoid{name: "Bessel 1841", a: 6377397.155, rf: 299.1528128}
+	airy           = ellipsoid{name: "Airy 1830", a: 6377563.396, rf: 299.3249646}
+)
+
+func (el ellipsoid) f() float64 {
+	return 1 / el.rf
+}
+
+// es is the first eccentricity squared
+func (el ellipsoid) es() float64 {
+	f := el.f()
+	return f * (2 - f)
+}
+
+func (el ellipsoid) e() float64 {
+	return math.Sqrt(el.es())
+}
+
+// helmert is a seven parameter datum shift to WGS84 in the position vector
+// convention used by PROJ's towgs84: translations in metres, rotations in
+// arc seconds and scale in parts per million
+type helmert struct {
+	tx, ty, tz float64
+	rx, ry, rz float64
+	s          float64
+}
+
+const arcSecond = math.Pi / (180 * 3600)
+
+// apply shifts geocentric coordinates; inverse undoes the shift, which is
+// accurate to well below a millimetre for the small rotations in use
+func (h *helmert) apply(x, y, z float64, inverse bool) (float64, float64, float64) {
+	sign := 1.0
+	if inverse {
+		sign = -1
+		x, y, z = x-h.tx, y-h.ty, z-h.tz
+	}
+
+	rx, ry, rz := sign*h.rx*arcSecond, sign*h.ry*arcSecond, sign*h.rz*arcSecond
+	m := 1 + sign*h.s*1e-6
+
+	x, y, z = m*(x-rz*y+ry*z), m*(rz*x+y-rx*z), m*(-ry*x+rx*y+z)
+	if !inverse {
+		x, y, z = x+h.tx, y+h.ty, z+h.tz
+	}
+	return x, y, z
+}
+
+// toGeocentric converts geodetic coordinates in radians to earth-centred
+// cartesian coordinates, at zero height
+func (el ellipsoid) toGeocentric(lon, lat float64) (x, y, z float64) {
+	es := el.es()
+	sinLat := math.Sin(lat)
+	n := el.a / math.Sqrt(1-es*sinLat*sinLat)
+	x = n * math.Cos(lat) * math.Cos(lon)
+	y = n * math.Cos(lat) * math.Sin(lon)
+	z = n * (1 - es) * sinLat
+	return x, y, z
+}
+
+// fromGeocentric converts cartesian coordinates back to geodetic
+// coordinates in radians, iterating on the latitude
+func (el ellipsoid) fromGeocentric(x, y, z float64) (lon, lat float64) {
+	es := el.es()
+	p := math.Hypot(x, y)
+	lon = math.Atan2(y, x)
+	lat = math.Atan2(z, p*(1-es))
+	for i := 0; i < 10; i++ {
+		sinLat := math.Sin(lat)
+		n := el.a / math.Sqrt(1-es*sinLat*sinLat)
+		next := math.Atan2(z+es*n*sinLat, p)
+		if math.Abs(next-lat) < 1e-12 {
+			return lon, next
+		}
+		lat = next
+	}
+	return lon, lat
+}
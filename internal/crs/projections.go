@@ -0,0 +1,373 @@
+package crs
+
+import "math"
+
+// projection converts between geodetic coordinates in radians on its
+// ellipsoid and projected coordinates in metres
+type projection interface {
+	forward(lon, lat float64) (x, y float64)
+	inverse(x, y float64) (lon, lat float64)
+	describe() *definition
+}
+
+// definition is a projection method and its parameters as written in WKT
+type definition struct {
+	method string
+	params []parameter
+}
+
+type parameter struct {
+	name  string
+	value float64
+}
+
+func (d *definition) describe() *definition {
+	return d
+}
+
+const deg = math.Pi / 180
+
+// lambertConic is the Lambert conformal conic projection with two standard
+// parallels (EPSG method 9802)
+type lambertConic struct {
+	definition
+	el         ellipsoid
+	lon0       float64
+	x0, y0     float64
+	n, f, rho0 float64
+}
+
+func newLambertConic(el ellipsoid, lat0, lon0, lat1, lat2, x0, y0 float64) *lambertConic {
+	p := &lambertConic{el: el, lon0: lon0 * deg, x0: x0, y0: y0}
+	p.definition = definition{"Lambert_Conformal_Conic_2SP", []parameter{
+		{"latitude_of_origin", lat0}, {"central_meridian", lon0},
+		{"standard_parallel_1", lat1}, {"standard_parallel_2", lat2},
+		{"false_easting", x0}, {"false_northing", y0},
+	}}
+	lat0, lat1, lat2 = lat0*deg, lat1*deg, lat2*deg
+
+	m1, m2 := p.m(lat1), p.m(lat2)
+	t1, t2 := p.t(lat1), p.t(lat2)
+	if math.Abs(lat1-lat2) < 1e-10 {
+		p.n = math.Sin(lat1)
+	} else {
+		p.n = (math.Log(m1) - math.Log(m2)) / (math.Log(t1) - math.Log(t2))
+	}
+	p.f = m1 / (p.n * math.Pow(t1, p.n))
+	p.rho0 = p.rho(lat0)
+	return p
+}
+
+func (p *lambertConic) m(lat float64) float64 {
+	sinLat := math.Sin(lat)
+	return math.Cos(lat) / math.Sqrt(1-p.el.es()*sinLat*sinLat)
+}
+
+func (p *lambertConic) t(lat float64) float64 {
+	e := p.el.e()
+	sinLat := math.Sin(lat)
+	return math.Tan(math.Pi/4-lat/2) / math.Pow((1-e*sinLat)/(1+e*sinLat), e/2)
+}
+
+func (p *lambertConic) rho(lat float64) float64 {
+	if math.Abs(math.Abs(lat)-math.Pi/2) < 1e-10 {
+		return 0
+	}
+	return p.el.a * p.f * math.Pow(p.t(lat), p.n)
+}
+
+func (p *lambertConic) forward(lon, lat float64) (float64, float64) {
+	rho := p.rho(lat)
+	theta := p.n * (lon - p.lon0)
+	return p.x0 + rho*math.Sin(theta), p.y0 + p.rho0 - rho*math.Cos(theta)
+}
+
+func (p *lambertConic) inverse(x, y float64) (float64, float64) {
+	dx, dy := x-p.x0, p.rho0-(y-p.y0)
+	sign := 1.0
+	if p.n < 0 {
+		sign = -1
+	}
+	rho := sign * math.Hypot(dx, dy)
+	theta := math.Atan2(sign*dx, sign*dy)
+	lon := theta/p.n + p.lon0
+
+	if rho == 0 {
+		return lon, sign * math.Pi / 2
+	}
+	t := math.Pow(rho/(p.el.a*p.f), 1/p.n)
+	return lon, latitudeFromT(t, p.el.e())
+}
+
+// latitudeFromT inverts the isometric function t of a conformal latitude
+func latitudeFromT(t, e float64) float64 {
+	lat := math.Pi/2 - 2*math.Atan(t)
+	for i := 0; i < 15; i++ {
+		sinLat := math.Sin(lat)
+		next := math.Pi/2 - 2*math.Atan(t*math.Pow((1-e*sinLat)/(1+e*sinLat), e/2))
+		if math.Abs(next-lat) < 1e-12 {
+			return next
+		}
+		lat = next
+	}
+	return lat
+}
+
+// transverseMercator uses Krüger's series to sixth order in n, accurate to
+// a few millimetres within 4000 km of the central meridian (EPSG method
+// 9807)
+type transverseMercator struct {
+	definition
+	e      float64
+	lon0   float64
+	k0     float64
+	x0, y0 float64
+	a      float64
+	xi0    float64
+	alpha  [6]float64
+	beta   [6]float64
+}
+
+func newTransverseMercator(el ellipsoid, lat0, lon0, k0, x0, y0 float64) *transverseMercator {
+	p := &transverseMercator{e: el.e(), lon0: lon0 * deg, k0: k0, x0: x0, y0: y0}
+	p.definition = definition{"Transverse_Mercator", []parameter{
+		{"latitude_of_origin", lat0}, {"central_meridian", lon0}, {"scale_factor", k0},
+		{"false_easting", x0}, {"false_northing", y0},
+	}}
+
+	f := el.f()
+	n := f / (2 - f)
+	n2 := n * n
+	n3, n4, n5, n6 := n2*n, n2*n2, n2*n2*n, n2*n2*n2
+
+	p.a = el.a / (1 + n) * (1 + n2/4 + n4/64 + n6/256)
+	p.alpha = [6]float64{
+		n/2 - 2*n2/3 + 5*n3/16 + 41*n4/180 - 127*n5/288 + 7891*n6/37800,
+		13*n2/48 - 3*n3/5 + 557*n4/1440 + 281*n5/630 - 1983433*n6/1935360,
+		61*n3/240 - 103*n4/140 + 15061*n5/26880 + 167603*n6/181440,
+		49561*n4/161280 - 179*n5/168 + 6601661*n6/7257600,
+		34729*n5/80640 - 3418889*n6/1995840,
+		212378941 * n6 / 319334400,
+	}
+	p.beta = [6]float64{
+		n/2 - 2*n2/3 + 37*n3/96 - n4/360 - 81*n5/512 + 96199*n6/604800,
+		n2/48 + n3/15 - 437*n4/1440 + 46*n5/105 - 1118711*n6/3870720,
+		17*n3/480 - 37*n4/840 - 209*n5/4480 + 5569*n6/90720,
+		4397*n4/161280 - 11*n5/504 - 830251*n6/7257600,
+		4583*n5/161280 - 108847*n6/3991680,
+		20648693 * n6 / 638668800,
+	}
+
+	p.xi0, _ = p.gauss(lat0*deg, 0)
+	return p
+}
+
+// gauss returns the scaled coordinates xi and eta of a point relative to
+// the central meridian
+func (p *transverseMercator) gauss(lat, dlon float64) (xi, eta float64) {
+	sinLat := math.Sin(lat)
+	t := math.Sinh(math.Atanh(sinLat) - p.e*math.Atanh(p.e*sinLat))
+	xiP := math.Atan2(t, math.Cos(dlon))
+	etaP := math.Atanh(math.Sin(dlon) / math.Sqrt(1+t*t))
+
+	xi, eta = xiP, etaP
+	for j, a := range p.alpha {
+		k := 2 * float64(j+1)
+		xi += a * math.Sin(k*xiP) * math.Cosh(k*etaP)
+		eta += a * math.Cos(k*xiP) * math.Sinh(k*etaP)
+	}
+	return xi, eta
+}
+
+func (p *transverseMercator) forward(lon, lat float64) (float64, float64) {
+	xi, eta := p.gauss(lat, lon-p.lon0)
+	return p.x0 + p.k0*p.a*eta, p.y0 + p.k0*p.a*(xi-p.xi0)
+}
+
+func (p *transverseMercator) inverse(x, y float64) (float64, float64) {
+	xi := (y-p.y0)/(p.k0*p.a) + p.xi0
+	eta := (x - p.x0) / (p.k0 * p.a)
+
+	xiP, etaP := xi, eta
+	for j, b := range p.beta {
+		k := 2 * float64(j+1)
+		xiP -= b * math.Sin(k*xi) * math.Cosh(k*eta)
+		etaP -= b * math.Cos(k*xi) * math.Sinh(k*eta)
+	}
+
+	chi := math.Asin(math.Sin(xiP) / math.Cosh(etaP))
+	lon := p.lon0 + math.Atan2(math.Sinh(etaP), math.Cos(xiP))
+	return lon, latitudeFromConformal(chi, p.e)
+}
+
+// latitudeFromConformal inverts the conformal latitude chi
+func latitudeFromConformal(chi, e float64) float64 {
+	return latitudeFromT(math.Tan(math.Pi/4-chi/2), e)
+}
+
+// obliqueStereographic is the double stereographic projection used for
+// the Dutch RD grid (EPSG method 9809)
+type obliqueStereographic struct {
+	definition
+	e       float64
+	lon0    float64
+	k0      float64
+	x0, y0  float64
+	r, n, c float64
+	chi0    float64
+}
+
+func newObliqueStereographic(el ellipsoid, lat0, lon0, k0, x0, y0 float64) *obliqueStereographic {
+	p := &obliqueStereographic{e: el.e(), lon0: lon0 * deg, k0: k0, x0: x0, y0: y0}
+	p.definition = definition{"Oblique_Stereographic", []parameter{
+		{"latitude_of_origin", lat0}, {"central_meridian", lon0}, {"scale_factor", k0},
+		{"false_easting", x0}, {"false_northing", y0},
+	}}
+	lat0 *= deg
+
+	es := el.es()
+	sin0 := math.Sin(lat0)
+	rho0 := el.a * (1 - es) / math.Pow(1-es*sin0*sin0, 1.5)
+	nu0 := el.a / math.Sqrt(1-es*sin0*sin0)
+	p.r = math.Sqrt(rho0 * nu0)
+	p.n = math.Sqrt(1 + es*math.Pow(math.Cos(lat0), 4)/(1-es))
+
+	w1 := math.Pow(p.s(sin0), p.n)
+	sinChi0 := (w1 - 1) / (w1 + 1)
+	p.c = (p.n + sin0) * (1 - sinChi0) / ((p.n - sin0) * (1 + sinChi0))
+	w2 := p.c * w1
+	p.chi0 = math.Asin((w2 - 1) / (w2 + 1))
+	return p
+}
+
+func (p *obliqueStereographic) s(sinLat float64) float64 {
+	return (1 + sinLat) / (1 - sinLat) * math.Pow((1-p.e*sinLat)/(1+p.e*sinLat), p.e)
+}
+
+func (p *obliqueStereographic) forward(lon, lat float64) (float64, float64) {
+	dlon := p.n * (lon - p.lon0)
+	w := p.c * math.Pow(p.s(math.Sin(lat)), p.n)
+	chi := math.Asin((w - 1) / (w + 1))
+
+	b := 1 + math.Sin(chi)*math.Sin(p.chi0) + math.Cos(chi)*math.Cos(p.chi0)*math.Cos(dlon)
+	x := p.x0 + 2*p.r*p.k0*math.Cos(chi)*math.Sin(dlon)/b
+	y := p.y0 + 2*p.r*p.k0*(math.Sin(chi)*math.Cos(p.chi0)-math.Cos(chi)*math.Sin(p.chi0)*math.Cos(dlon))/b
+	return x, y
+}
+
+func (p *obliqueStereographic) inverse(x, y float64) (float64, float64) {
+	dx, dy := x-p.x0, y-p.y0
+	rk := 2 * p.r * p.k0
+
+	g := rk * math.Tan(math.Pi/4-p.chi0/2)
+	h := 2*rk*math.Tan(p.chi0) + g
+	i := math.Atan(dx / (h + dy))
+	j := math.Atan(dx/(g-dy)) - i
+
+	chi := p.chi0 + 2*math.Atan((dy-dx*math.Tan(j/2))/rk)
+	lon := (j+2*i)/p.n + p.lon0
+
+	sinChi := math.Sin(chi)
+	psi := 0.5 * math.Log((1+sinChi)/(p.c*(1-sinChi))) / p.n
+	lat := 2*math.Atan(math.Exp(psi)) - math.Pi/2
+	for k := 0; k < 15; k++ {
+		sinLat := math.Sin(lat)
+		psiI := math.Log(math.Tan(lat/2+math.Pi/4) * math.Pow((1-p.e*sinLat)/(1+p.e*sinLat), p.e/2))
+		next := lat - (psiI-psi)*math.Cos(lat)*(1-p.e*p.e*sinLat*sinLat)/(1-p.e*p.e)
+		if math.Abs(next-lat) < 1e-12 {
+			return lon, next
+		}
+		lat = next
+	}
+	return lon, lat
+}
+
+// lambertAzimuthal is the Lambert azimuthal equal-area projection on the
+// ellipsoid (EPSG method 9820)
+type lambertAzimuthal struct {
+	definition
+	el           ellipsoid
+	lon0         float64
+	x0, y0       float64
+	qp, rq, d    float64
+	sinB0, cosB0 float64
+}
+
+func newLambertAzimuthal(el ellipsoid, lat0, lon0, x0, y0 float64) *lambertAzimuthal {
+	p := &lambertAzimuthal{el: el, lon0: lon0 * deg, x0: x0, y0: y0}
+	p.definition = definition{"Lambert_Azimuthal_Equal_Area", []parameter{
+		{"latitude_of_center", lat0}, {"longitude_of_center", lon0},
+		{"false_easting", x0}, {"false_northing", y0},
+	}}
+	lat0 *= deg
+
+	p.qp = p.q(math.Pi / 2)
+	b0 := math.Asin(p.q(lat0) / p.qp)
+	p.sinB0, p.cosB0 = math.Sin(b0), math.Cos(b0)
+	p.rq = el.a * math.Sqrt(p.qp/2)
+
+	sin0 := math.Sin(lat0)
+	p.d = el.a * (math.Cos(lat0) / math.Sqrt(1-el.es()*sin0*sin0)) / (p.rq * p.cosB0)
+	return p
+}
+
+func (p *lambertAzimuthal) q(lat float64) float64 {
+	e, es := p.el.e(), p.el.es()
+	sinLat := math.Sin(lat)
+	return (1 - es) * (sinLat/(1-es*sinLat*sinLat) - 1/(2*e)*math.Log((1-e*sinLat)/(1+e*sinLat)))
+}
+
+func (p *lambertAzimuthal) forward(lon, lat float64) (float64, float64) {
+	b := math.Asin(p.q(lat) / p.qp)
+	dlon := lon - p.lon0
+	bb := p.rq * math.Sqrt(2/(1+p.sinB0*math.Sin(b)+p.cosB0*math.Cos(b)*math.Cos(dlon)))
+	x := p.x0 + bb*p.d*math.Cos(b)*math.Sin(dlon)
+	y := p.y0 + bb/p.d*(p.cosB0*math.Sin(b)-p.sinB0*math.Cos(b)*math.Cos(dlon))
+	return x, y
+}
+
+func (p *lambertAzimuthal) inverse(x, y float64) (float64, float64) {
+	dx, dy := x-p.x0, y-p.y0
+	rho := math.Hypot(dx/p.d, p.d*dy)
+	if rho == 0 {
+		return p.lon0, p.latitude(math.Asin(p.sinB0))
+	}
+	c := 2 * math.Asin(rho/(2*p.rq))
+	sinC, cosC := math.Sin(c), math.Cos(c)
+
+	b := math.Asin(cosC*p.sinB0 + p.d*dy*sinC*p.cosB0/rho)
+	lon := p.lon0 + math.Atan2(dx*sinC, p.d*rho*p.cosB0*cosC-p.d*p.d*dy*p.sinB0*sinC)
+	return lon, p.latitude(b)
+}
+
+// latitude converts an authalic latitude to the geodetic latitude
+func (p *lambertAzimuthal) latitude(b float64) float64 {
+	es := p.el.es()
+	e4, e6 := es*es, es*es*es
+	return b + (es/3+31*e4/180+517*e6/5040)*math.Sin(2*b) +
+		(23*e4/360+251*e6/3780)*math.Sin(4*b) +
+		(761*e6/45360)*math.Sin(6*b)
+}
+
+// webMercator is the spherical Mercator of web maps (EPSG:3857)
+type webMercator struct{}
+
+const webMercatorRadius = 6378137
+
+var webMercatorDefinition = &definition{"Mercator_1SP", []parameter{
+	{"central_meridian", 0}, {"scale_factor", 1}, {"false_easting", 0}, {"false_northing", 0},
+}}
+
+func (webMercator) describe() *definition {
+	return webMercatorDefinition
+}
+
+func (webMercator) forward(lon, lat float64) (float64, float64) {
+	const r = webMercatorRadius
+	return r * lon, r * math.Log(math.Tan(math.Pi/4+lat/2))
+}
+
+func (webMercator) inverse(x, y float64) (float64, float64) {
+	const r = webMercatorRadius
+	return x / r, 2*math.Atan(math.Exp(y/r)) - math.Pi/2
+}
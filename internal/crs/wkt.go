@@ -0,0 +1,125 @@
+package crs
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// WKT returns the definition of the system in the well-known text format
+// of OGC 01-009, as used by Shapefile .prj files and GeoPackages
+func (c *CRS) WKT() string {
+	var b strings.Builder
+	if c.proj == nil {
+		c.writeGeographic(&b, c.Code, c.Name)
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "PROJCS[%q,", c.Name)
+	c.writeGeographic(&b, c.geog.code, c.geog.name)
+	def := c.proj.describe()
+	fmt.Fprintf(&b, ",PROJECTION[%q]", def.method)
+	for _, p := range def.params {
+		fmt.Fprintf(&b, ",PARAMETER[%q,%s]", p.name, number(p.value))
+	}
+	fmt.Fprintf(&b, `,UNIT["metre",1],AUTHORITY["EPSG","%d"]]`, c.Code)
+	return b.String()
+}
+
+func (c *CRS) writeGeographic(b *strings.Builder, code int, name string) {
+	fmt.Fprintf(b, "GEOGCS[%q,DATUM[%q,SPHEROID[%q,%s,%s]", name, c.geog.datum, c.el.name, number(c.el.a), number(c.el.rf))
+	switch {
+	case c.shift != nil:
+		h := c.shift
+		fmt.Fprintf(b, ",TOWGS84[%s,%s,%s,%s,%s,%s,%s]",
+			number(h.tx), number(h.ty), number(h.tz), number(h.rx), number(h.ry), number(h.rz), number(h.s))
+	case c.geog != geogWGS84:
+		b.WriteString(",TOWGS84[0,0,0,0,0,0,0]")
+	}
+	fmt.Fprintf(b, `],PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433],AUTHORITY["EPSG","%d"]]`, code)
+}
+
+func number(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// wktAuthority matches the EPSG authority closing a WKT definition, which
+// belongs to the outermost system
+var wktAuthority = regexp.MustCompile(`(?i)AUTHORITY\[\s*"EPSG"\s*,\s*"?(\d+)"?\s*\]\s*\]\s*$`)
+
+// wktName matches the kind and name of the outermost system
+var wktName = regexp.MustCompile(`(?i)^\s*(PROJCS|GEOGCS|PROJCRS|GEOGCRS|GEODCRS)\s*\[\s*"([^"]*)"`)
+
+// esriUTM matches the UTM names written by ESRI software
+var esriUTM = regexp.MustCompile(`^(wgs1984|wgs84|etrs1989|etrs89)utmzone(\d+)([ns])$`)
+
+// esriNames maps the names ESRI software gives supported systems in .prj
+// files, which carry no authority, with separators removed
+var esriNames = map[string]int{
+	"gcswgs1984":                        4326,
+	"gcsetrs1989":                       4258,
+	"wgs1984webmercatorauxiliarysphere": 3857,
+	"belgelambert1972":                  31370,
+	"belgianlambert72":                  31370,
+	"belgelambert2008":                  3812,
+	"rdnew":                             28992,
+	"amersfoortrdnew":                   28992,
+	"rgf1993lambert93":                  2154,
+	"etrs1989laea":                      3035,
+	"etrs1989laeaeurope":                3035,
+	"britishnationalgrid":               27700,
+	"dhdn3degreegausszone2":             31466,
+	"dhdn3degreegausszone3":             31467,
+	"dhdn3degreegausszone4":             31468,
+	"dhdn3degreegausszone5":             31469,
+}
+
+// FromWKT identifies the system of a WKT definition, such as a Shapefile
+// .prj file, by its EPSG authority or else by its name
+func FromWKT(wkt string) (*CRS, error) {
+	if m := wktAuthority.FindStringSubmatch(wkt); m != nil {
+		code, _ := strconv.Atoi(m[1])
+		return Lookup(code)
+	}
+
+	m := wktName.FindStringSubmatch(wkt)
+	if m == nil {
+		return nil, fmt.Errorf("%w: unreadable WKT definition", ErrUnsupported)
+	}
+	name := squashName(m[2])
+	for _, c := range registry {
+		if squashName(c.Name) == name {
+			return c, nil
+		}
+	}
+	if code, ok := esriNames[name]; ok {
+		return Lookup(code)
+	}
+	if u := esriUTM.FindStringSubmatch(name); u != nil {
+		zone, _ := strconv.Atoi(u[2])
+		switch {
+		case strings.HasPrefix(u[1], "etrs") && u[3] == "n":
+			return Lookup(25800 + zone)
+		case u[3] == "n":
+			return Lookup(32600 + zone)
+		case !strings.HasPrefix(u[1], "etrs"):
+			return Lookup(32700 + zone)
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnsupported, m[2])
+}
+
+// squashName lowercases a system name and drops everything but letters
+// and digits
+func squashName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return -1
+	}, name)
+}
@@ -0,0 +1,238 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// postgresMigrations extend the tables owned by the processing service with
+// the columns and tables the web application needs. Every statement must be
+// idempotent because they run on each start-up.
+var postgresMigrations = []string{
+	// Content hash of the stored blob, used for deduplication
+	`ALTER TABLE files ADD COLUMN IF NOT EXISTS content_hash TEXT`,
+	`CREATE INDEX IF NOT EXISTS idx_files_content_hash ON files(content_hash)`,
+
+	// Resumable uploads that have not reached EOF yet
+	`CREATE TABLE IF NOT EXISTS uploads (
+		id UUID PRIMARY KEY,
+		project_id UUID NOT NULL,
+		owner_id UUID NOT NULL,
+		name TEXT NOT NULL,
+		roles TEXT NOT NULL,
+		storage_location TEXT NOT NULL,
+		size BIGINT NOT NULL,
+		sha256 TEXT NOT NULL,
+		chunk_size INTEGER NOT NULL,
+		next_chunk INTEGER NOT NULL DEFAULT 0,
+		temp_path TEXT NOT NULL,
+		created TIMESTAMP NOT NULL DEFAULT NOW(),
+		updated TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_uploads_project_id ON uploads(project_id)`,
+
+	// Text chunks produced by the document pipeline
+	`CREATE TABLE IF NOT EXISTS document_chunks (
+		id UUID PRIMARY KEY,
+		file_id UUID NOT NULL,
+		project_id UUID NOT NULL,
+		chunk_index INTEGER NOT NULL,
+		content TEXT NOT NULL,
+		page INTEGER NOT NULL DEFAULT 0,
+		page_end INTEGER NOT NULL DEFAULT 0,
+		heading_path TEXT[] NOT NULL DEFAULT '{}',
+		start_offset INTEGER NOT NULL,
+		end_offset INTEGER NOT NULL,
+		token_count INTEGER NOT NULL,
+		method TEXT NOT NULL,
+		created TIMESTAMP NOT NULL DEFAULT NOW(),
+		UNIQUE (file_id, chunk_index)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_document_chunks_project_id ON document_chunks(project_id)`,
+
+	// Extracted text of a document, kept between the extract and chunk jobs
+	`CREATE TABLE IF NOT EXISTS document_texts (
+		file_id UUID PRIMARY KEY,
+		project_id UUID NOT NULL,
+		sections JSONB NOT NULL,
+		created TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+
+	// Background processing jobs, claimed by workers with SKIP LOCKED
+	`CREATE TABLE IF NOT EXISTS jobs (
+		id UUID PRIMARY KEY,
+		file_id UUID NOT NULL,
+		project_id UUID NOT NULL,
+		step TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'queued',
+		options JSONB NOT NULL DEFAULT '{}',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		max_attempts INTEGER NOT NULL DEFAULT 3,
+		last_error TEXT NOT NULL DEFAULT '',
+		run_after TIMESTAMP NOT NULL DEFAULT NOW(),
+		locked_at TIMESTAMP,
+		finished_at TIMESTAMP,
+		created TIMESTAMP NOT NULL DEFAULT NOW(),
+		updated TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_jobs_status_run_after ON jobs(status, run_after)`,
+	`CREATE INDEX IF NOT EXISTS idx_jobs_file_id ON jobs(file_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_jobs_project_id ON jobs(project_id)`,
+
+	// Full-text index of chunks, analysed as English, Dutch and unstemmed
+	// words so exact identifiers such as permit numbers still match
+	`ALTER TABLE document_chunks ADD COLUMN IF NOT EXISTS search_vector tsvector
+		GENERATED ALWAYS AS (
+			to_tsvector('english', content) || to_tsvector('dutch', content) || to_tsvector('simple', content)
+		) STORED`,
+	`CREATE INDEX IF NOT EXISTS idx_document_chunks_search ON document_chunks USING GIN(search_vector)`,
+
+	// File versions: the files row holds the current content, earlier
+	// contents are kept in file_versions
+	`ALTER TABLE files ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1`,
+	`CREATE TABLE IF NOT EXISTS file_versions (
+		file_id UUID NOT NULL,
+		version INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		file_path TEXT NOT NULL,
+		storage_location TEXT NOT NULL,
+		mime_type TEXT NOT NULL,
+		size BIGINT NOT NULL,
+		content_hash TEXT NOT NULL DEFAULT '',
+		created TIMESTAMP NOT NULL,
+		PRIMARY KEY (file_id, version)
+	)`,
+
+	// Audit trail of document lifecycle events. Rows outlive their file.
+	`CREATE TABLE IF NOT EXISTS file_events (
+		id BIGSERIAL PRIMARY KEY,
+		file_id UUID NOT NULL,
+		project_id UUID NOT NULL,
+		user_id UUID,
+		file_name TEXT NOT NULL,
+		version INTEGER NOT NULL DEFAULT 1,
+		event TEXT NOT NULL,
+		detail TEXT NOT NULL DEFAULT '',
+		created TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_file_events_project_id ON file_events(project_id, created)`,
+
+	// Named map layers of a project. Source is one of file, table, query or
+	// chat; data holds the features of a saved chat result.
+	`CREATE TABLE IF NOT EXISTS map_layers (
+		id UUID PRIMARY KEY,
+		project_id UUID NOT NULL,
+		name TEXT NOT NULL,
+		source TEXT NOT NULL,
+		file_id UUID,
+		table_name TEXT NOT NULL DEFAULT '',
+		geometry_column TEXT NOT NULL DEFAULT '',
+		query TEXT NOT NULL DEFAULT '',
+		data JSONB,
+		created_by UUID NOT NULL,
+		created TIMESTAMP NOT NULL DEFAULT NOW(),
+		UNIQUE (project_id, name)
+	)`,
+
+	// Document sources cited by an answer
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS citations JSONB NOT NULL DEFAULT '[]'`,
+
+	// Properties written to a layer's vector tiles, and its size when
+	// last read, which decides whether the map requests tiles
+	`ALTER TABLE map_layers ADD COLUMN IF NOT EXISTS tile_attributes TEXT[] NOT NULL DEFAULT '{}'`,
+	`ALTER TABLE map_layers ADD COLUMN IF NOT EXISTS feature_count INTEGER NOT NULL DEFAULT 0`,
+	`CREATE INDEX IF NOT EXISTS idx_map_layers_file_id ON map_layers(file_id)`,
+
+	// EPSG code of the reference system assumed for project GeoJSON
+	// without a crs member
+	`ALTER TABLE projects ADD COLUMN IF NOT EXISTS default_crs INTEGER`,
+
+	// Cleaned geo objects of an answer by name, kept for export
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS geo_objects JSONB`,
+
+	// Layer within a multi-layer spatial file, and the extent and
+	// attribute columns of a layer when last read
+	`ALTER TABLE map_layers ADD COLUMN IF NOT EXISTS sublayer TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE map_layers ADD COLUMN IF NOT EXISTS bbox DOUBLE PRECISION[]`,
+	`ALTER TABLE map_layers ADD COLUMN IF NOT EXISTS attribute_schema JSONB NOT NULL DEFAULT '[]'`,
+
+	// Drawing rules of a layer: symbols, categories or classes and labels
+	`ALTER TABLE map_layers ADD COLUMN IF NOT EXISTS style JSONB`,
+
+	// Saved map states, attached to a project or a chat so that others
+	// with access can open them by link
+	`CREATE TABLE IF NOT EXISTS map_views (
+		id UUID PRIMARY KEY,
+		name TEXT NOT NULL,
+		project_id UUID,
+		chat_id UUID,
+		center_lon DOUBLE PRECISION NOT NULL,
+		center_lat DOUBLE PRECISION NOT NULL,
+		zoom DOUBLE PRECISION NOT NULL,
+		basemap TEXT NOT NULL DEFAULT 'osm',
+		layer_ids UUID[] NOT NULL DEFAULT '{}',
+		styles JSONB NOT NULL DEFAULT '{}',
+		sketches JSONB NOT NULL,
+		created_by UUID NOT NULL,
+		created TIMESTAMP NOT NULL DEFAULT NOW(),
+		updated TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_map_views_project_id ON map_views(project_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_map_views_chat_id ON map_views(chat_id)`,
+	// Personal access tokens for the REST API; only a hash of each token is kept
+	`CREATE TABLE IF NOT EXISTS api_tokens (
+		id UUID PRIMARY KEY,
+		user_id UUID NOT NULL,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		scopes TEXT[] NOT NULL DEFAULT '{}',
+		expires TIMESTAMP NOT NULL,
+		last_used TIMESTAMP,
+		created TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id)`,
+	// Outgoing webhooks; a NULL project_id subscribes to all projects of the creator
+	`CREATE TABLE IF NOT EXISTS webhooks (
+		id UUID PRIMARY KEY,
+		project_id UUID,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT[] NOT NULL DEFAULT '{}',
+		created_by UUID NOT NULL,
+		created TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_webhooks_project_id ON webhooks(project_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_webhooks_created_by ON webhooks(created_by)`,
+	// Delivery log and retry queue of the webhooks
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id UUID PRIMARY KEY,
+		webhook_id UUID NOT NULL,
+		project_id UUID NOT NULL,
+		event_id UUID NOT NULL,
+		event TEXT NOT NULL,
+		payload JSONB NOT NULL,
+		status TEXT NOT NULL DEFAULT 'queued',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		max_attempts INTEGER NOT NULL DEFAULT 6,
+		response_code INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		run_after TIMESTAMP NOT NULL DEFAULT NOW(),
+		locked_at TIMESTAMP,
+		delivered_at TIMESTAMP,
+		created TIMESTAMP NOT NULL DEFAULT NOW(),
+		updated TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, run_after)`,
+}
+
+// MigratePostgres applies the web application's schema changes
+func MigratePostgres(db *sql.DB) error {
+	for i, stmt := range postgresMigrations {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("migration %d failed: %w", i+1, err)
+		}
+	}
+	return nil
+}
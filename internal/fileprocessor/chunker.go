@@ -3,6 +3,7 @@ package fileprocessor
 import (
 	"errors"
 	"io"
+	"unicode/utf8"
 )
 
 type Chunker struct {
@@ -15,7 +16,7 @@ func NewChunkerWithSize(chunkSize int) *Chunker {
 }
 
 func NewChunkerWithCount(chunkCount int) *Chunker {
-	return &Chunker{ChunkSize: chunkCount}
+	return &Chunker{ChunkCount: chunkCount}
 }
 
 func (c *Chunker) ChunkData(r io.Reader) ([][]byte, error) {
@@ -27,26 +28,40 @@ func (c *Chunker) ChunkData(r io.Reader) ([][]byte, error) {
 			return nil, err
 		}
 		totalSize := len(data)
-		if c.ChunkCount > totalSize {
+		if totalSize == 0 {
+			return nil, nil
+		}
+		chunkCount := c.ChunkCount
+		if chunkCount > totalSize {
 			// If more chunks are requested than there are bytes, adjust to one byte per chunk.
-			c.ChunkCount = totalSize
+			chunkCount = totalSize
 		}
 
-		baseChunkSize := totalSize / c.ChunkCount
-		remainder := totalSize % c.ChunkCount
+		baseChunkSize := totalSize / chunkCount
+		remainder := totalSize % chunkCount
 
 		var chunks [][]byte
 		offset := 0
-		for i := 0; i < c.ChunkCount; i++ {
+		for i := 0; i < chunkCount; i++ {
 			// Distribute the remainder across the first few chunks.
 			extra := 0
 			if i < remainder {
 				extra = 1
 			}
 			currentChunkSize := baseChunkSize + extra
-			chunk := data[offset : offset+currentChunkSize]
-			chunks = append(chunks, chunk)
-			offset += currentChunkSize
+			end := offset + currentChunkSize
+			// Move the boundary forward so no rune is split between chunks.
+			for end < totalSize && !utf8.RuneStart(data[end]) {
+				end++
+			}
+			if end > totalSize || i == chunkCount-1 {
+				end = totalSize
+			}
+			if end <= offset {
+				continue
+			}
+			chunks = append(chunks, data[offset:end])
+			offset = end
 		}
 		return chunks, nil
 	}
@@ -54,17 +69,44 @@ func (c *Chunker) ChunkData(r io.Reader) ([][]byte, error) {
 	// Option 2: Split into chunks of a fixed byte size.
 	if c.ChunkSize > 0 {
 		var chunks [][]byte
-		buf := make([]byte, c.ChunkSize)
+		buf := make([]byte, c.ChunkSize+utf8.UTFMax)
+		pending := 0
 		for {
-			n, err := r.Read(buf)
+			n, err := io.ReadFull(r, buf[pending:c.ChunkSize])
+			n += pending
+			pending = 0
 			if n > 0 {
+				// Carry the bytes of an incomplete rune over to the next chunk.
+				cut := n
+				if err == nil {
+					for i := n - 1; i >= 0 && i >= n-utf8.UTFMax; i-- {
+						if utf8.RuneStart(buf[i]) {
+							if !utf8.FullRune(buf[i:n]) {
+								if i > 0 {
+									cut = i
+									break
+								}
+								// The chunk is smaller than the rune, so
+								// read on to the rune's last byte.
+								for err == nil && n < len(buf) && !utf8.FullRune(buf[:n]) {
+									var m int
+									m, err = io.ReadFull(r, buf[n:n+1])
+									n += m
+								}
+								cut = n
+							}
+							break
+						}
+					}
+				}
 				// Copy the bytes read into a new slice.
-				chunk := make([]byte, n)
-				copy(chunk, buf[:n])
+				chunk := make([]byte, cut)
+				copy(chunk, buf[:cut])
 				chunks = append(chunks, chunk)
+				pending = copy(buf, buf[cut:n])
 			}
 			if err != nil {
-				if err == io.EOF {
+				if err == io.EOF || err == io.ErrUnexpectedEOF {
 					break
 				}
 				return nil, err
@@ -0,0 +1,120 @@
+package fileprocessor
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// Sections longer than this are split before the next heading is reached
+const maxSectionBytes = 1 << 20
+
+// MarkdownHeaderSplitter splits Markdown at ATX headings up to MaxLevel and
+// tags every split with the headings it is nested under. Headings inside
+// fenced code blocks are ignored. Sections longer than the inner splitter's
+// chunk size are split further by it.
+type MarkdownHeaderSplitter struct {
+	MaxLevel int
+	Inner    *RecursiveSplitter
+}
+
+func NewMarkdownHeaderSplitter(maxLevel int, inner *RecursiveSplitter) *MarkdownHeaderSplitter {
+	if maxLevel <= 0 || maxLevel > 6 {
+		maxLevel = 6
+	}
+	return &MarkdownHeaderSplitter{MaxLevel: maxLevel, Inner: inner}
+}
+
+func (s *MarkdownHeaderSplitter) Split(r io.Reader, emit func(Split) error) error {
+	br := bufio.NewReader(r)
+
+	headings := []string{}
+	var section strings.Builder
+	var sectionStart, offset int64
+	fence := ""
+
+	flush := func() error {
+		text := section.String()
+		section.Reset()
+		start := sectionStart
+		sectionStart = offset
+		if isBlank(text) {
+			return nil
+		}
+
+		path := append([]string(nil), headings...)
+		if s.Inner == nil {
+			begin, end := trimSpace(text, 0, len(text))
+			return emit(Split{Text: text[begin:end], Offset: start + int64(begin), Headings: path})
+		}
+
+		// Offsets of the inner splitter are relative to the section
+		return s.Inner.split(strings.NewReader(text), path, func(split Split) error {
+			split.Offset += start
+			return emit(split)
+		})
+	}
+
+	for {
+		// Lines end in '\n', so reading them never splits a rune
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line == "" && err == io.EOF {
+			break
+		}
+
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case fence != "":
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			fence = trimmed[:3]
+		default:
+			if level, title := headingOf(trimmed); level > 0 && level <= s.MaxLevel {
+				if err := flush(); err != nil {
+					return err
+				}
+				if level > len(headings)+1 {
+					level = len(headings) + 1
+				}
+				headings = append(headings[:level-1], title)
+			}
+		}
+
+		section.WriteString(line)
+		offset += int64(len(line))
+
+		if section.Len() > maxSectionBytes {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	return flush()
+}
+
+// headingOf parses an ATX heading line, returning level 0 for other lines
+func headingOf(line string) (int, string) {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 || (level < len(line) && line[level] != ' ' && line[level] != '\t') {
+		return 0, ""
+	}
+
+	title := strings.TrimSpace(strings.TrimRight(strings.TrimSpace(line[level:]), "#"))
+	if title == "" {
+		return 0, ""
+	}
+	return level, title
+}
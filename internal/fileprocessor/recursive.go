@@ -0,0 +1,184 @@
+package fileprocessor
+
+import (
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultSeparators split on paragraphs, then lines, sentences and words
+var DefaultSeparators = []string{"\n\n", "\n", ". ", " ", ""}
+
+// RecursiveSplitter splits text on the first separator that keeps pieces
+// below ChunkSize and merges neighbouring pieces back up to ChunkSize. Pieces
+// that are still too large are split again with the next separator; the
+// empty separator splits between runes.
+type RecursiveSplitter struct {
+	ChunkSize    int
+	ChunkOverlap int
+	Separators   []string
+	// Length measures pieces, RuneLength when nil
+	Length LengthFunc
+}
+
+func NewRecursiveSplitter(chunkSize, chunkOverlap int) (*RecursiveSplitter, error) {
+	if chunkSize <= 0 || chunkOverlap < 0 || chunkOverlap >= chunkSize {
+		return nil, errInvalidSize
+	}
+	return &RecursiveSplitter{
+		ChunkSize:    chunkSize,
+		ChunkOverlap: chunkOverlap,
+		Separators:   DefaultSeparators,
+		Length:       RuneLength,
+	}, nil
+}
+
+func (s *RecursiveSplitter) Split(r io.Reader, emit func(Split) error) error {
+	return s.split(r, nil, emit)
+}
+
+// split reads the input in blocks. Once the buffer is large enough it is
+// split and every chunk except the last is emitted; the last one is kept in
+// the buffer so it can grow with the text that follows.
+func (s *RecursiveSplitter) split(r io.Reader, headings []string, emit func(Split) error) error {
+	rr := newRuneReader(r)
+	window := readBlockSize + 16*s.ChunkSize
+
+	buf := ""
+	var base int64
+	for {
+		block, err := rr.next()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		eof := err == io.EOF
+		buf += block
+
+		if !eof && len(buf) < window {
+			continue
+		}
+
+		spans := s.splitText(buf, 0, len(buf), s.separators())
+		if eof {
+			return emitSpans(buf, base, spans, headings, emit)
+		}
+		if len(spans) < 2 {
+			continue
+		}
+
+		last := spans[len(spans)-1]
+		if err := emitSpans(buf, base, spans[:len(spans)-1], headings, emit); err != nil {
+			return err
+		}
+		buf = buf[last.start:]
+		base += int64(last.start)
+	}
+}
+
+func (s *RecursiveSplitter) separators() []string {
+	if len(s.Separators) == 0 {
+		return DefaultSeparators
+	}
+	return s.Separators
+}
+
+func (s *RecursiveSplitter) length(text string) int {
+	if s.Length == nil {
+		return RuneLength(text)
+	}
+	return s.Length(text)
+}
+
+// splitText returns the chunk spans of text[start:end]
+func (s *RecursiveSplitter) splitText(text string, start, end int, separators []string) []span {
+	// Use the first separator that occurs in the text
+	sep, rest := "", []string(nil)
+	for i, candidate := range separators {
+		if candidate == "" || strings.Contains(text[start:end], candidate) {
+			sep, rest = candidate, separators[i+1:]
+			break
+		}
+	}
+
+	pieces := splitKeep(text, start, end, sep)
+
+	chunks := []span{}
+	current := []span{}
+	currentLength := 0
+	// added is set once current holds more than the overlap of the last chunk
+	added := false
+
+	flush := func() {
+		if !added {
+			return
+		}
+		added = false
+		chunks = append(chunks, span{current[0].start, current[len(current)-1].end})
+
+		// Keep trailing pieces as overlap for the next chunk
+		kept := 0
+		i := len(current)
+		for i > 0 && kept+s.length(text[current[i-1].start:current[i-1].end]) <= s.ChunkOverlap {
+			i--
+			kept += s.length(text[current[i].start:current[i].end])
+		}
+		current = append([]span(nil), current[i:]...)
+		currentLength = kept
+	}
+
+	for _, piece := range pieces {
+		length := s.length(text[piece.start:piece.end])
+
+		if length > s.ChunkSize {
+			flush()
+			current, currentLength = nil, 0
+			if len(rest) > 0 {
+				chunks = append(chunks, s.splitText(text, piece.start, piece.end, rest)...)
+			} else {
+				chunks = append(chunks, piece)
+			}
+			continue
+		}
+
+		if currentLength+length > s.ChunkSize {
+			flush()
+			// The overlap may not leave room for the piece
+			for len(current) > 0 && currentLength+length > s.ChunkSize {
+				currentLength -= s.length(text[current[0].start:current[0].end])
+				current = current[1:]
+			}
+		}
+		current = append(current, piece)
+		currentLength += length
+		added = true
+	}
+	flush()
+
+	return chunks
+}
+
+// splitKeep splits text[start:end] after every separator, keeping the
+// separator at the end of its piece. The empty separator splits runes.
+func splitKeep(text string, start, end int, sep string) []span {
+	pieces := []span{}
+
+	if sep == "" {
+		for i := start; i < end; {
+			_, n := utf8.DecodeRuneInString(text[i:end])
+			pieces = append(pieces, span{i, i + n})
+			i += n
+		}
+		return pieces
+	}
+
+	for i := start; i < end; {
+		j := strings.Index(text[i:end], sep)
+		if j < 0 {
+			pieces = append(pieces, span{i, end})
+			break
+		}
+		pieces = append(pieces, span{i, i + j + len(sep)})
+		i += j + len(sep)
+	}
+	return pieces
+}
@@ -0,0 +1,128 @@
+package fileprocessor
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Split is one piece of text produced by a Splitter
+type Split struct {
+	Text string
+	// Offset is the byte offset of Text in the input
+	Offset int64
+	// Headings lists the Markdown headings the text is nested under
+	Headings []string
+}
+
+// Splitter splits text read from a stream into chunks. Splitters never cut
+// through a UTF-8 encoded rune and keep only a bounded window of the input in
+// memory, so large documents can be split as they are read.
+type Splitter interface {
+	Split(r io.Reader, emit func(Split) error) error
+}
+
+// LengthFunc measures a piece of text, in runes or in tokens
+type LengthFunc func(text string) int
+
+// RuneLength counts the runes of a text
+func RuneLength(text string) int {
+	return utf8.RuneCountInString(text)
+}
+
+var errInvalidSize = errors.New("fileprocessor: chunk size must be positive and larger than the overlap")
+
+// Number of bytes read from the input at a time
+const readBlockSize = 32 * 1024
+
+// runeReader reads blocks of text without splitting a rune across blocks
+type runeReader struct {
+	r       io.Reader
+	pending []byte
+	eof     bool
+}
+
+func newRuneReader(r io.Reader) *runeReader {
+	return &runeReader{r: r}
+}
+
+// next returns the next block of text. It returns io.EOF together with the
+// last block once the input is exhausted.
+func (rr *runeReader) next() (string, error) {
+	if rr.eof {
+		return "", io.EOF
+	}
+
+	buf := make([]byte, len(rr.pending)+readBlockSize)
+	copy(buf, rr.pending)
+	n, err := io.ReadAtLeast(rr.r, buf[len(rr.pending):], 1)
+	buf = buf[:len(rr.pending)+n]
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		rr.eof = true
+		rr.pending = nil
+		return string(buf), io.EOF
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Hold back the bytes of a rune that is not complete yet
+	cut := len(buf)
+	for i := len(buf) - 1; i >= 0 && i >= len(buf)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(buf[i]) {
+			if !utf8.FullRune(buf[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	rr.pending = append([]byte(nil), buf[cut:]...)
+
+	return string(buf[:cut]), nil
+}
+
+// span is a byte range of a buffer
+type span struct {
+	start, end int
+}
+
+// emitSpans sends the non-blank spans of a buffer, trimming white space
+func emitSpans(buf string, base int64, spans []span, headings []string, emit func(Split) error) error {
+	for _, s := range spans {
+		start, end := trimSpace(buf, s.start, s.end)
+		if start >= end {
+			continue
+		}
+		if err := emit(Split{Text: buf[start:end], Offset: base + int64(start), Headings: headings}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trimSpace shrinks a byte range to exclude surrounding white space
+func trimSpace(text string, start, end int) (int, int) {
+	for start < end {
+		r, n := utf8.DecodeRuneInString(text[start:end])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		start += n
+	}
+	for end > start {
+		r, n := utf8.DecodeLastRuneInString(text[start:end])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		end -= n
+	}
+	return start, end
+}
+
+// isBlank reports whether a text holds only white space
+func isBlank(text string) bool {
+	return strings.TrimSpace(text) == ""
+}
@@ -0,0 +1,290 @@
+package fileprocessor
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"unicode/utf8"
+)
+
+// Seeds shared by the fuzz targets
+var splitterSeeds = []string{
+	"",
+	" ",
+	"Hello, world.",
+	"# Title\n\nSome text.\n\n## Section\n\nMore text, and more.\n",
+	"één twee drie, vier vijf zes. Zeven!\n\nacht",
+	"日本語のテキストには空白がありません。",
+	"🙂🙃🙂🙃 emoji 🙂",
+	"\xff\xfe invalid \xc3",
+	strings.Repeat("a", 100),
+	strings.Repeat("word ", 40),
+}
+
+// newTestEncoding writes a cl100k_base encoding with every byte and a few
+// merges, some of which end inside a rune, and loads it. The real encoding
+// is not downloaded in tests.
+var newTestEncoding = sync.OnceValue(func() error {
+	dir, err := os.MkdirTemp("", "tiktoken")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	var file strings.Builder
+	rank := 0
+	add := func(token string) {
+		fmt.Fprintf(&file, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), rank)
+		rank++
+	}
+	for b := 0; b < 256; b++ {
+		add(string([]byte{byte(b)}))
+	}
+	for _, token := range []string{"th", "he", "the", "é", " \xc3", "\xa9\xc3", "or", "wor", "word"} {
+		add(token)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "cl100k_base.tiktoken"), []byte(file.String()), 0o600); err != nil {
+		return err
+	}
+	UseEncodingDir(dir)
+	return PreloadEncoding(DefaultEncoding)
+})
+
+func newTestTokenSplitter(tb testing.TB, size, overlap int) *TokenSplitter {
+	tb.Helper()
+	if err := newTestEncoding(); err != nil {
+		tb.Fatal(err)
+	}
+	s, err := NewTokenSplitter(DefaultEncoding, size, overlap)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return s
+}
+
+// checkSplits splits a text read whole and one byte at a time, and checks
+// that every split is the text found at its offset, and valid UTF-8 when
+// the input is
+func checkSplits(t *testing.T, s Splitter, text string) {
+	t.Helper()
+	for _, r := range []io.Reader{strings.NewReader(text), iotest.OneByteReader(strings.NewReader(text))} {
+		err := s.Split(r, func(split Split) error {
+			end := split.Offset + int64(len(split.Text))
+			if split.Offset < 0 || end > int64(len(text)) {
+				return fmt.Errorf("split %q at %d is outside the input of %d bytes", split.Text, split.Offset, len(text))
+			}
+			if text[split.Offset:end] != split.Text {
+				return fmt.Errorf("split %q at %d, the input there is %q", split.Text, split.Offset, text[split.Offset:end])
+			}
+			if utf8.ValidString(text) && !utf8.ValidString(split.Text) {
+				return fmt.Errorf("split %q is not valid UTF-8", split.Text)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// splitSizes turns fuzzed bytes into a chunk size and a smaller overlap
+func splitSizes(size, overlap uint8) (int, int) {
+	n := int(size)%64 + 1
+	return n, int(overlap) % n
+}
+
+func addSplitterSeeds(f *testing.F) {
+	for _, seed := range splitterSeeds {
+		f.Add(seed, uint8(10), uint8(2))
+		f.Add(seed, uint8(0), uint8(0))
+		f.Add(seed, uint8(3), uint8(2))
+	}
+}
+
+func FuzzRecursiveSplitter(f *testing.F) {
+	addSplitterSeeds(f)
+	f.Fuzz(func(t *testing.T, text string, size, overlap uint8) {
+		s, err := NewRecursiveSplitter(splitSizes(size, overlap))
+		if err != nil {
+			t.Skip()
+		}
+		checkSplits(t, s, text)
+	})
+}
+
+func FuzzSlidingWindowSplitter(f *testing.F) {
+	addSplitterSeeds(f)
+	f.Fuzz(func(t *testing.T, text string, size, overlap uint8) {
+		s, err := NewSlidingWindowSplitter(splitSizes(size, overlap))
+		if err != nil {
+			t.Skip()
+		}
+		checkSplits(t, s, text)
+	})
+}
+
+func FuzzMarkdownHeaderSplitter(f *testing.F) {
+	addSplitterSeeds(f)
+	f.Fuzz(func(t *testing.T, text string, size, overlap uint8) {
+		inner, err := NewRecursiveSplitter(splitSizes(size, overlap))
+		if err != nil {
+			t.Skip()
+		}
+		checkSplits(t, NewMarkdownHeaderSplitter(6, inner), text)
+	})
+}
+
+func FuzzTokenSplitter(f *testing.F) {
+	addSplitterSeeds(f)
+	f.Fuzz(func(t *testing.T, text string, size, overlap uint8) {
+		n, o := splitSizes(size, overlap)
+		if o >= n {
+			t.Skip()
+		}
+		s := newTestTokenSplitter(t, n, o)
+		if !utf8.ValidString(text) {
+			if err := s.Split(strings.NewReader(text), func(Split) error { return nil }); err == nil {
+				t.Error("invalid UTF-8 was split")
+			}
+			return
+		}
+		checkSplits(t, s, text)
+	})
+}
+
+// A text without white space is encoded in blocks rather than held whole
+func TestTokenSplitterWithoutSpaces(t *testing.T) {
+	s := newTestTokenSplitter(t, 1000, 0)
+	text := strings.Repeat("é", 3*readBlockSize)
+
+	total := 0
+	err := s.Split(strings.NewReader(text), func(split Split) error {
+		total += len(split.Text)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != len(text) {
+		t.Errorf("splits hold %d bytes, want %d", total, len(text))
+	}
+}
+
+func FuzzChunker(f *testing.F) {
+	for _, seed := range splitterSeeds {
+		f.Add([]byte(seed), uint8(1), false)
+		f.Add([]byte(seed), uint8(3), true)
+		f.Add([]byte(seed), uint8(7), false)
+	}
+	f.Fuzz(func(t *testing.T, data []byte, n uint8, byCount bool) {
+		if n == 0 {
+			t.Skip()
+		}
+		c := NewChunkerWithSize(int(n))
+		if byCount {
+			c = NewChunkerWithCount(int(n))
+		}
+
+		chunks, err := c.ChunkData(iotest.HalfReader(strings.NewReader(string(data))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if byCount && len(chunks) > int(n) {
+			t.Errorf("%d chunks, want at most %d", len(chunks), n)
+		}
+		if c.ChunkCount != 0 && c.ChunkCount != int(n) {
+			t.Errorf("ChunkCount changed to %d", c.ChunkCount)
+		}
+
+		var joined []byte
+		for _, chunk := range chunks {
+			if len(chunk) == 0 {
+				t.Error("empty chunk")
+			}
+			if !byCount && len(chunk) > max(int(n), utf8.UTFMax) {
+				t.Errorf("chunk of %d bytes, want at most %d", len(chunk), n)
+			}
+			if utf8.Valid(data) && !utf8.Valid(chunk) {
+				t.Errorf("chunk %q is not valid UTF-8", chunk)
+			}
+			joined = append(joined, chunk...)
+		}
+		if string(joined) != string(data) {
+			t.Errorf("chunks join to %q, want %q", joined, data)
+		}
+	})
+}
+
+// benchmarkText is about a megabyte of Markdown with multi-byte runes
+var benchmarkText = func() string {
+	var b strings.Builder
+	for i := 0; b.Len() < 1<<20; i++ {
+		fmt.Fprintf(&b, "## Section %d\n\n", i)
+		b.WriteString(strings.Repeat("The quick brown fox jumps over the lazy dog. Één café, naïve façade. ", 8))
+		b.WriteString("\n\n")
+	}
+	return b.String()
+}()
+
+func benchmarkSplitter(b *testing.B, s Splitter) {
+	b.SetBytes(int64(len(benchmarkText)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := s.Split(strings.NewReader(benchmarkText), func(Split) error { return nil }); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRecursiveSplitter(b *testing.B) {
+	s, err := NewRecursiveSplitter(1000, 100)
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkSplitter(b, s)
+}
+
+func BenchmarkSlidingWindowSplitter(b *testing.B) {
+	s, err := NewSlidingWindowSplitter(1000, 100)
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkSplitter(b, s)
+}
+
+func BenchmarkMarkdownHeaderSplitter(b *testing.B) {
+	inner, err := NewRecursiveSplitter(1000, 100)
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkSplitter(b, NewMarkdownHeaderSplitter(6, inner))
+}
+
+func BenchmarkTokenSplitter(b *testing.B) {
+	benchmarkSplitter(b, newTestTokenSplitter(b, 500, 50))
+}
+
+func BenchmarkChunkerSize(b *testing.B) {
+	benchmarkChunker(b, NewChunkerWithSize(4096))
+}
+
+func BenchmarkChunkerCount(b *testing.B) {
+	benchmarkChunker(b, NewChunkerWithCount(64))
+}
+
+func benchmarkChunker(b *testing.B, c *Chunker) {
+	b.SetBytes(int64(len(benchmarkText)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.ChunkData(strings.NewReader(benchmarkText)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
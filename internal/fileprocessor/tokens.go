@@ -0,0 +1,245 @@
+package fileprocessor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+var errInvalidUTF8 = errors.New("fileprocessor: token splitting needs valid UTF-8 text")
+
+// DefaultEncoding is the tiktoken encoding used when none is configured
+const DefaultEncoding = "cl100k_base"
+
+// encodingLoader reads tiktoken encoding files from a local directory, so
+// encodings are never downloaded while processing documents
+type encodingLoader struct {
+	dir string
+}
+
+// UseEncodingDir makes tiktoken read encodings from files in dir, named as
+// published by OpenAI, e.g. cl100k_base.tiktoken, instead of downloading
+// them. It must be called before any TokenSplitter is created.
+func UseEncodingDir(dir string) {
+	tiktoken.SetBpeLoader(encodingLoader{dir: dir})
+}
+
+// PreloadEncoding loads an encoding ahead of its first use, so creating a
+// TokenSplitter later does not have to wait for it
+func PreloadEncoding(encoding string) error {
+	_, err := tiktoken.GetEncoding(encoding)
+	return err
+}
+
+// LoadTiktokenBpe parses an encoding file of base64 encoded tokens and their
+// ranks, one pair per line. file is the encoding's download URL.
+func (l encodingLoader) LoadTiktokenBpe(file string) (map[string]int, error) {
+	name := filepath.Join(l.dir, path.Base(file))
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("fileprocessor: tiktoken encoding not available offline: %w", err)
+	}
+	defer f.Close()
+
+	ranks := make(map[string]int)
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		line := lines.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		encoded, rank, ok := bytes.Cut(line, []byte(" "))
+		if !ok {
+			return nil, fmt.Errorf("fileprocessor: %s: malformed line %q", name, line)
+		}
+		token, err := base64.StdEncoding.DecodeString(string(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("fileprocessor: %s: %w", name, err)
+		}
+		n, err := strconv.Atoi(string(rank))
+		if err != nil {
+			return nil, fmt.Errorf("fileprocessor: %s: %w", name, err)
+		}
+		ranks[string(token)] = n
+	}
+	if err := lines.Err(); err != nil {
+		return nil, fmt.Errorf("fileprocessor: %s: %w", name, err)
+	}
+	return ranks, nil
+}
+
+// TokenSplitter emits chunks of ChunkSize tokens of a tiktoken encoding,
+// consecutive chunks share ChunkOverlap tokens. Chunk boundaries are moved so
+// no chunk starts or ends inside a rune. The input must be valid UTF-8, as
+// tiktoken replaces invalid bytes and offsets would no longer match.
+type TokenSplitter struct {
+	ChunkSize    int
+	ChunkOverlap int
+	encoding     *tiktoken.Tiktoken
+}
+
+// NewTokenSplitter loads the named encoding. Unless UseEncodingDir was
+// called, tiktoken downloads encodings on first use and caches them in
+// TIKTOKEN_CACHE_DIR.
+func NewTokenSplitter(encoding string, chunkSize, chunkOverlap int) (*TokenSplitter, error) {
+	if chunkSize <= 0 || chunkOverlap < 0 || chunkOverlap >= chunkSize {
+		return nil, errInvalidSize
+	}
+	if encoding == "" {
+		encoding = DefaultEncoding
+	}
+
+	enc, err := tiktoken.GetEncoding(encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenSplitter{ChunkSize: chunkSize, ChunkOverlap: chunkOverlap, encoding: enc}, nil
+}
+
+// Count returns the number of tokens in a text
+func (s *TokenSplitter) Count(text string) int {
+	return len(s.encoding.EncodeOrdinary(text))
+}
+
+func (s *TokenSplitter) Split(r io.Reader, emit func(Split) error) error {
+	rr := newRuneReader(r)
+
+	// Text that has not been encoded yet, cut at white space so words are
+	// encoded the same way as in the whole text. Text without white space is
+	// encoded once a block of it is pending.
+	pending := ""
+	tokens := []int{}
+	var base int64
+
+	for {
+		block, err := rr.next()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		eof := err == io.EOF
+		if !utf8.ValidString(block) {
+			return errInvalidUTF8
+		}
+		pending += block
+
+		text := pending
+		if !eof {
+			cut := lastSpace(pending)
+			if cut <= 0 && len(pending) < readBlockSize {
+				continue
+			}
+			if cut > 0 {
+				text = pending[:cut]
+			}
+		}
+		pending = pending[len(text):]
+		tokens = append(tokens, s.encode(text)...)
+
+		for len(tokens) > s.ChunkSize || (eof && len(tokens) > 0) {
+			end := min(s.ChunkSize, len(tokens))
+			chunk, used := s.decode(tokens[:end])
+			if used == 0 {
+				// A single rune spans more tokens than a chunk holds, so
+				// the chunk takes the tokens up to the rune's end
+				for used = end; used < len(tokens) && !utf8.ValidString(s.encoding.Decode(tokens[:used])); used++ {
+				}
+				chunk = s.encoding.Decode(tokens[:used])
+			}
+
+			begin, finish := trimSpace(chunk, 0, len(chunk))
+			if begin < finish {
+				if err := emit(Split{Text: chunk[begin:finish], Offset: base + int64(begin)}); err != nil {
+					return err
+				}
+			}
+			if used == len(tokens) {
+				tokens = tokens[:0]
+				base += int64(len(chunk))
+				break
+			}
+
+			// Step forward, keeping the overlap but never stepping back
+			// onto a token that starts inside a rune
+			step := used - s.ChunkOverlap
+			if step <= 0 {
+				step = used
+			}
+			for step < used && !s.startsRune(tokens[step]) {
+				step++
+			}
+			base += int64(len(s.encoding.Decode(tokens[:step])))
+			tokens = tokens[step:]
+		}
+
+		if eof {
+			return nil
+		}
+	}
+}
+
+// Longest text encoded at once. Byte pair encoding takes quadratic time in
+// the length of a word, so longer texts are encoded in pieces.
+const maxEncodeBytes = 1024
+
+// encode encodes a text in pieces cut at white space where possible, or
+// else between runes
+func (s *TokenSplitter) encode(text string) []int {
+	var tokens []int
+	for len(text) > maxEncodeBytes {
+		cut := lastSpace(text[:maxEncodeBytes])
+		if cut <= 0 {
+			cut = maxEncodeBytes
+			for cut > 0 && !utf8.RuneStart(text[cut]) {
+				cut--
+			}
+		}
+		if cut == 0 {
+			cut = maxEncodeBytes
+		}
+		tokens = append(tokens, s.encoding.EncodeOrdinary(text[:cut])...)
+		text = text[cut:]
+	}
+	return append(tokens, s.encoding.EncodeOrdinary(text)...)
+}
+
+// decode returns the text of the longest prefix of tokens that ends on a
+// rune boundary, and the number of tokens it uses
+func (s *TokenSplitter) decode(tokens []int) (string, int) {
+	for n := len(tokens); n > 0 && n > len(tokens)-utf8.UTFMax; n-- {
+		text := s.encoding.Decode(tokens[:n])
+		if utf8.ValidString(text) {
+			return text, n
+		}
+	}
+	return "", 0
+}
+
+// startsRune reports whether a token's bytes begin with a rune start
+func (s *TokenSplitter) startsRune(token int) bool {
+	b := s.encoding.Decode([]int{token})
+	return len(b) == 0 || utf8.RuneStart(b[0])
+}
+
+// lastSpace returns the byte offset of the last white space rune
+func lastSpace(text string) int {
+	for i := len(text); i > 0; {
+		r, n := utf8.DecodeLastRuneInString(text[:i])
+		i -= n
+		if unicode.IsSpace(r) {
+			return i
+		}
+	}
+	return -1
+}
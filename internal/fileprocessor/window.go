@@ -0,0 +1,78 @@
+package fileprocessor
+
+import (
+	"io"
+)
+
+// SlidingWindowSplitter emits windows of Size runes that start every
+// Size-Overlap runes, so consecutive windows share Overlap runes
+type SlidingWindowSplitter struct {
+	Size    int
+	Overlap int
+}
+
+func NewSlidingWindowSplitter(size, overlap int) (*SlidingWindowSplitter, error) {
+	if size <= 0 || overlap < 0 || overlap >= size {
+		return nil, errInvalidSize
+	}
+	return &SlidingWindowSplitter{Size: size, Overlap: overlap}, nil
+}
+
+func (s *SlidingWindowSplitter) Split(r io.Reader, emit func(Split) error) error {
+	rr := newRuneReader(r)
+
+	// Byte offsets of the runes in buf, plus the end of the last one
+	buf := ""
+	offsets := []int{}
+	var base int64
+	step := s.Size - s.Overlap
+	emitted := false
+
+	for {
+		block, err := rr.next()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		eof := err == io.EOF
+
+		for i := range block {
+			offsets = append(offsets, len(buf)+i)
+		}
+		buf += block
+
+		for len(offsets) >= s.Size+1 || (len(offsets) >= s.Size && eof) {
+			end := len(buf)
+			if s.Size < len(offsets) {
+				end = offsets[s.Size]
+			}
+			if !isBlank(buf[:end]) {
+				if err := emit(Split{Text: buf[:end], Offset: base}); err != nil {
+					return err
+				}
+			}
+			emitted = true
+
+			if len(offsets) == s.Size {
+				// The window ended exactly at the end of the input
+				return nil
+			}
+
+			cut := offsets[step]
+			buf = buf[cut:]
+			base += int64(cut)
+			for i := range offsets[step:] {
+				offsets[i] = offsets[step+i] - cut
+			}
+			offsets = offsets[:len(offsets)-step]
+		}
+
+		if eof {
+			// The rest is shorter than a window; skip it when it is only
+			// the overlap of the window before
+			if len(offsets) > 0 && (!emitted || len(offsets) > s.Overlap) && !isBlank(buf) {
+				return emit(Split{Text: buf, Offset: base})
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,306 @@
+// Package geocode finds places in an offline gazetteer kept in SQLite:
+// municipalities and other named places, streets and addresses imported
+// from CSV files such as OpenAddresses extracts. Names are matched fuzzily
+// on trigrams, so small spelling mistakes and missing accents still find
+// the place.
+package geocode
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Kinds of places
+const (
+	KindPlace   = "place"
+	KindStreet  = "street"
+	KindAddress = "address"
+)
+
+// minScore is the similarity below which names do not match
+const minScore = 0.3
+
+// Place is an entry of the gazetteer
+type Place struct {
+	ID          int64   `json:"id"`
+	Name        string  `json:"name"`
+	Kind        string  `json:"kind"`
+	Street      string  `json:"street,omitempty"`
+	HouseNumber string  `json:"housenumber,omitempty"`
+	Postcode    string  `json:"postcode,omitempty"`
+	City        string  `json:"city,omitempty"`
+	Region      string  `json:"region,omitempty"`
+	Country     string  `json:"country,omitempty"`
+	Lon         float64 `json:"lon"`
+	Lat         float64 `json:"lat"`
+
+	// Score is the similarity to the searched name and Distance the
+	// distance in metres to the searched position
+	Score    float64 `json:"score,omitempty"`
+	Distance float64 `json:"distance_m,omitempty"`
+}
+
+// Label describes a place with the municipality it lies in
+func (p *Place) Label() string {
+	parts := []string{p.Name}
+	if p.City != "" && !strings.EqualFold(p.City, p.Name) {
+		if p.Postcode != "" {
+			parts = append(parts, p.Postcode+" "+p.City)
+		} else {
+			parts = append(parts, p.City)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Gazetteer is a SQLite database of places
+type Gazetteer struct {
+	DB *sql.DB
+}
+
+// Open opens or creates a gazetteer database
+func Open(path string) (*Gazetteer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create gazetteer tables: %w", err)
+	}
+	return &Gazetteer{DB: db}, nil
+}
+
+// Close closes the database
+func (g *Gazetteer) Close() error {
+	return g.DB.Close()
+}
+
+// Addresses are not indexed by trigram: they are found through their
+// street, which keeps the index small for national address files
+const schema = `
+CREATE TABLE IF NOT EXISTS places (
+	id INTEGER PRIMARY KEY,
+	source TEXT NOT NULL,
+	name TEXT NOT NULL,
+	norm TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	street TEXT NOT NULL DEFAULT '',
+	housenumber TEXT NOT NULL DEFAULT '',
+	postcode TEXT NOT NULL DEFAULT '',
+	city TEXT NOT NULL DEFAULT '',
+	region TEXT NOT NULL DEFAULT '',
+	country TEXT NOT NULL DEFAULT '',
+	lon REAL NOT NULL,
+	lat REAL NOT NULL
+);
+CREATE INDEX IF NOT EXISTS places_norm_idx ON places(norm);
+CREATE INDEX IF NOT EXISTS places_source_idx ON places(source);
+CREATE INDEX IF NOT EXISTS places_lat_idx ON places(lat, lon);
+CREATE INDEX IF NOT EXISTS places_street_idx ON places(street, city) WHERE kind = 'address';
+CREATE TABLE IF NOT EXISTS place_grams (
+	gram TEXT NOT NULL,
+	place_id INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS place_grams_gram_idx ON place_grams(gram, place_id);
+`
+
+const placeColumns = `id, name, kind, street, housenumber, postcode, city, region, country, lon, lat`
+
+func scanPlace(row interface{ Scan(...interface{}) error }) (*Place, error) {
+	p := &Place{}
+	err := row.Scan(&p.ID, &p.Name, &p.Kind, &p.Street, &p.HouseNumber, &p.Postcode, &p.City, &p.Region, &p.Country, &p.Lon, &p.Lat)
+	return p, err
+}
+
+func (g *Gazetteer) queryPlaces(ctx context.Context, stmt string, args ...interface{}) ([]*Place, error) {
+	rows, err := g.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var places []*Place
+	for rows.Next() {
+		p, err := scanPlace(rows)
+		if err != nil {
+			return nil, err
+		}
+		places = append(places, p)
+	}
+	return places, rows.Err()
+}
+
+// Search returns the places best matching a name, best first. A house
+// number in the query finds the address on a matching street.
+func (g *Gazetteer) Search(ctx context.Context, query string, limit int) ([]*Place, error) {
+	var words, numbers []string
+	for _, w := range strings.Fields(Normalize(query)) {
+		if strings.ContainsAny(w, "0123456789") {
+			numbers = append(numbers, w)
+		} else {
+			words = append(words, w)
+		}
+	}
+	text := strings.Join(words, " ")
+	if text == "" {
+		return nil, nil
+	}
+
+	candidates, err := g.candidates(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []*Place
+	for _, p := range candidates {
+		p.Score = nameScore(text, p)
+		for _, n := range numbers {
+			if n == p.Postcode {
+				p.Score = math.Min(1, p.Score+0.1)
+			}
+		}
+		if p.Score >= minScore {
+			found = append(found, p)
+		}
+	}
+	sortPlaces(found)
+
+	// A house number turns the best streets into their addresses
+	if len(numbers) > 0 {
+		var addresses []*Place
+		for _, p := range found[:min(len(found), 3)] {
+			if p.Kind != KindStreet {
+				continue
+			}
+			for _, n := range numbers {
+				matches, err := g.queryPlaces(ctx, `SELECT `+placeColumns+` FROM places
+					WHERE kind = 'address' AND street = ? AND city = ? AND lower(housenumber) = ? LIMIT 1`,
+					p.Street, p.City, n)
+				if err != nil {
+					return nil, err
+				}
+				for _, a := range matches {
+					a.Score = p.Score
+					addresses = append(addresses, a)
+				}
+			}
+		}
+		found = append(addresses, found...)
+	}
+
+	if len(found) > limit {
+		found = found[:limit]
+	}
+	return found, nil
+}
+
+// candidates returns the indexed places sharing the most trigrams with a
+// normalized name
+func (g *Gazetteer) candidates(ctx context.Context, text string) ([]*Place, error) {
+	grams := trigrams(text)
+	if len(grams) == 0 {
+		return nil, nil
+	}
+	args := make([]interface{}, len(grams))
+	for i, gram := range grams {
+		args[i] = gram
+	}
+	marks := strings.TrimSuffix(strings.Repeat("?,", len(grams)), ",")
+
+	return g.queryPlaces(ctx, `SELECT `+placeColumns+` FROM places WHERE id IN (
+		SELECT place_id FROM place_grams WHERE gram IN (`+marks+`)
+		GROUP BY place_id ORDER BY COUNT(*) DESC LIMIT 200)`, args...)
+}
+
+// nameScore is the similarity of a query to the name of a place, alone
+// or followed by its municipality as in "Meir Antwerpen"
+func nameScore(text string, p *Place) float64 {
+	name := Normalize(p.Name)
+	score := similarity(text, name)
+	if p.City != "" {
+		score = math.Max(score, similarity(text, name+" "+Normalize(p.City)))
+	}
+	return score
+}
+
+// sortPlaces orders places by score, preferring places over streets on
+// ties
+func sortPlaces(places []*Place) {
+	rank := map[string]int{KindPlace: 0, KindStreet: 1, KindAddress: 2}
+	sort.SliceStable(places, func(i, j int) bool {
+		if places[i].Score != places[j].Score {
+			return places[i].Score > places[j].Score
+		}
+		return rank[places[i].Kind] < rank[places[j].Kind]
+	})
+}
+
+// Reverse returns the places nearest to a position, nearest first, within
+// about 200 kilometres. kind limits the search to one kind of place when
+// not empty.
+func (g *Gazetteer) Reverse(ctx context.Context, lon, lat float64, kind string, limit int) ([]*Place, error) {
+	// Search growing boxes until one holds enough places
+	for _, d := range []float64{0.002, 0.02, 0.2, 2} {
+		stmt := `SELECT ` + placeColumns + ` FROM places
+			WHERE lat BETWEEN ? AND ? AND lon BETWEEN ? AND ?`
+		args := []interface{}{lat - d, lat + d, lon - d, lon + d}
+		if kind != "" {
+			stmt += ` AND kind = ?`
+			args = append(args, kind)
+		}
+		// Nearest first by squared distance in degrees, longitudes scaled
+		// to the latitude, so dense boxes are cut after the nearest places
+		stmt += ` ORDER BY (lat - ?) * (lat - ?) + (lon - ?) * (lon - ?) * ? LIMIT 5000`
+		scale := math.Cos(lat * math.Pi / 180)
+		args = append(args, lat, lat, lon, lon, scale*scale)
+		places, err := g.queryPlaces(ctx, stmt, args...)
+		if err != nil {
+			return nil, err
+		}
+		if len(places) < limit && d < 2 {
+			continue
+		}
+
+		// Only places within the circle inside the box are surely nearest
+		radius := d * math.Pi / 180 * earthRadius * math.Cos(lat*math.Pi/180)
+		var near []*Place
+		for _, p := range places {
+			p.Distance = haversine(lon, lat, p.Lon, p.Lat)
+			if p.Distance <= radius || d == 2 {
+				near = append(near, p)
+			}
+		}
+		if len(near) < limit && d < 2 {
+			continue
+		}
+		sort.SliceStable(near, func(i, j int) bool { return near[i].Distance < near[j].Distance })
+		if len(near) > limit {
+			near = near[:limit]
+		}
+		return near, nil
+	}
+	return nil, nil
+}
+
+const earthRadius = 6371008.8
+
+// haversine is the great circle distance between two positions in metres
+func haversine(lon1, lat1, lon2, lat2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
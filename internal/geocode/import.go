@@ -0,0 +1,237 @@
+package geocode
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrImport is returned for gazetteer files that cannot be read
+var ErrImport = errors.New("geocode: unreadable gazetteer file")
+
+// streetsSource marks the streets derived from imported addresses
+const streetsSource = "streets"
+
+// Header names accepted for each column, as used by OpenAddresses and by
+// common municipality lists
+var columnNames = map[string][]string{
+	"lon":         {"lon", "lng", "long", "longitude", "x"},
+	"lat":         {"lat", "latitude", "y"},
+	"name":        {"name", "naam", "label", "place"},
+	"kind":        {"kind", "type", "class"},
+	"street":      {"street", "straat", "straatnaam", "street_name"},
+	"housenumber": {"number", "housenumber", "house_number", "huisnummer"},
+	"postcode":    {"postcode", "zip", "postal_code", "postalcode"},
+	"city":        {"city", "municipality", "gemeente", "town"},
+	"region":      {"region", "province", "provincie", "state", "district"},
+	"country":     {"country", "land"},
+}
+
+// Import loads a CSV file of places, replacing what was imported before
+// under the same source name. A row with a name is a place of its kind
+// column, or "place"; otherwise a row with a street and number is an
+// address, with only a street a street, and with only a city a place named
+// after it. Streets are then derived from the addresses. It returns the
+// number of rows added.
+func (g *Gazetteer) Import(ctx context.Context, source string, r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(4096)
+	first, _, _ := bytes.Cut(head, []byte("\n"))
+
+	reader := csv.NewReader(br)
+	reader.Comma = ','
+	for _, d := range []rune{';', '\t', '|'} {
+		if bytes.Count(first, []byte(string(d))) > bytes.Count(first, []byte(string(reader.Comma))) {
+			reader.Comma = d
+		}
+	}
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrImport, err)
+	}
+	columns := map[string]int{}
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		for key, names := range columnNames {
+			for _, name := range names {
+				if _, ok := columns[key]; !ok && h == name {
+					columns[key] = i
+				}
+			}
+		}
+	}
+	if _, ok := columns["lon"]; !ok {
+		return 0, fmt.Errorf("%w: no longitude column", ErrImport)
+	}
+	if _, ok := columns["lat"]; !ok {
+		return 0, fmt.Errorf("%w: no latitude column", ErrImport)
+	}
+
+	tx, err := g.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := deleteSource(ctx, tx, source); err != nil {
+		return 0, err
+	}
+
+	insert, err := tx.PrepareContext(ctx, `INSERT INTO places
+		(source, name, norm, kind, street, housenumber, postcode, city, region, country, lon, lat)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer insert.Close()
+	gram, err := tx.PrepareContext(ctx, `INSERT INTO place_grams (gram, place_id) VALUES (?, ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer gram.Close()
+
+	count := 0
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("%w: line %d: %v", ErrImport, line, err)
+		}
+		field := func(key string) string {
+			if i, ok := columns[key]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		lon, lonErr := parseCoordinate(field("lon"))
+		lat, latErr := parseCoordinate(field("lat"))
+		if lonErr != nil || latErr != nil || math.Abs(lon) > 180 || math.Abs(lat) > 90 {
+			continue
+		}
+
+		p := &Place{
+			Name:        field("name"),
+			Kind:        strings.ToLower(field("kind")),
+			Street:      field("street"),
+			HouseNumber: field("housenumber"),
+			Postcode:    field("postcode"),
+			City:        field("city"),
+			Region:      field("region"),
+			Country:     field("country"),
+			Lon:         lon,
+			Lat:         lat,
+		}
+		switch {
+		case p.Name != "":
+			if p.Kind == "" {
+				p.Kind = KindPlace
+			}
+		case p.Street != "" && p.HouseNumber != "":
+			p.Name, p.Kind = p.Street+" "+p.HouseNumber, KindAddress
+		case p.Street != "":
+			p.Name, p.Kind = p.Street, KindStreet
+		case p.City != "":
+			p.Name, p.Kind = p.City, KindPlace
+		default:
+			continue
+		}
+
+		if err := insertPlace(ctx, insert, gram, source, p); err != nil {
+			return 0, err
+		}
+		count++
+	}
+
+	if err := deriveStreets(ctx, tx, insert, gram); err != nil {
+		return 0, err
+	}
+	return count, tx.Commit()
+}
+
+func insertPlace(ctx context.Context, insert, gram *sql.Stmt, source string, p *Place) error {
+	key := Normalize(p.Name)
+	res, err := insert.ExecContext(ctx, source, p.Name, key, p.Kind, p.Street, p.HouseNumber,
+		p.Postcode, p.City, p.Region, p.Country, p.Lon, p.Lat)
+	if err != nil {
+		return err
+	}
+	if p.Kind == KindAddress {
+		return nil
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for _, t := range trigrams(key) {
+		if _, err := gram.ExecContext(ctx, t, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deriveStreets replaces the streets derived from addresses: one per
+// street and municipality, at the centre of its addresses, unless the
+// street was imported itself
+func deriveStreets(ctx context.Context, tx *sql.Tx, insert, gram *sql.Stmt) error {
+	if err := deleteSource(ctx, tx, streetsSource); err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT street, city, MIN(postcode), MIN(region), MIN(country), AVG(lon), AVG(lat)
+		FROM places a WHERE kind = 'address' AND street <> '' AND NOT EXISTS (
+			SELECT 1 FROM places s WHERE s.kind = 'street' AND s.street = a.street AND s.city = a.city)
+		GROUP BY street, city`)
+	if err != nil {
+		return err
+	}
+	var streets []*Place
+	for rows.Next() {
+		p := &Place{Kind: KindStreet}
+		if err := rows.Scan(&p.Street, &p.City, &p.Postcode, &p.Region, &p.Country, &p.Lon, &p.Lat); err != nil {
+			rows.Close()
+			return err
+		}
+		p.Name = p.Street
+		streets = append(streets, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range streets {
+		if err := insertPlace(ctx, insert, gram, streetsSource, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteSource removes the places imported from a source
+func deleteSource(ctx context.Context, tx *sql.Tx, source string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM place_grams WHERE place_id IN (SELECT id FROM places WHERE source = ?)`, source); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `DELETE FROM places WHERE source = ?`, source)
+	return err
+}
+
+// parseCoordinate reads a decimal degree, also with a decimal comma
+func parseCoordinate(s string) (float64, error) {
+	return strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+}
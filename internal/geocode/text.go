@@ -0,0 +1,124 @@
+package geocode
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalize folds a name for matching: lower case without accents, with
+// punctuation as single spaces
+func Normalize(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(t, s)
+	if err != nil {
+		folded = s
+	}
+	folded = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, folded)
+	return strings.Join(strings.Fields(folded), " ")
+}
+
+// trigrams returns the distinct three letter sequences of a normalized
+// name, padded so that word starts and ends count
+func trigrams(s string) []string {
+	seen := map[string]bool{}
+	var grams []string
+	for _, w := range strings.Fields(s) {
+		r := []rune("  " + w + " ")
+		for i := 0; i+3 <= len(r); i++ {
+			g := string(r[i : i+3])
+			if !seen[g] {
+				seen[g] = true
+				grams = append(grams, g)
+			}
+		}
+	}
+	return grams
+}
+
+// similarity is the Dice coefficient of the trigrams of two normalized
+// names, 1 for equal names
+func similarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	ga, gb := trigrams(a), trigrams(b)
+	if len(ga) == 0 || len(gb) == 0 {
+		return 0
+	}
+	in := map[string]bool{}
+	for _, g := range ga {
+		in[g] = true
+	}
+	common := 0
+	for _, g := range gb {
+		if in[g] {
+			common++
+		}
+	}
+	return 2 * float64(common) / float64(len(ga)+len(gb))
+}
+
+// Mention is a place named in a text
+type Mention struct {
+	Text  string `json:"text"`
+	Place *Place `json:"place"`
+}
+
+// maxMentions bounds the places found in one text
+const maxMentions = 20
+
+// maxMentionWords is the longest name, in words, looked for in texts
+const maxMentionWords = 4
+
+var word = regexp.MustCompile(`\p{L}[\p{L}\p{M}\p{N}'’-]*`)
+
+// Mentions finds the places and streets named in a text. Names must start
+// with a capital letter and match a gazetteer name exactly, apart from
+// case and accents, which keeps ordinary words from turning into places.
+// Longer names win over the names inside them.
+func (g *Gazetteer) Mentions(ctx context.Context, text string) ([]Mention, error) {
+	words := word.FindAllStringIndex(text, -1)
+	seen := map[string]bool{}
+	var mentions []Mention
+
+	for i := 0; i < len(words) && len(mentions) < maxMentions; i++ {
+		first := []rune(text[words[i][0]:words[i][1]])
+		if !unicode.IsUpper(first[0]) {
+			continue
+		}
+		for n := min(maxMentionWords, len(words)-i); n > 0; n-- {
+			phrase := text[words[i][0]:words[i+n-1][1]]
+			key := Normalize(phrase)
+			if len([]rune(key)) < 3 {
+				continue
+			}
+			places, err := g.queryPlaces(ctx, `SELECT `+placeColumns+` FROM places
+				WHERE norm = ? AND kind <> 'address'
+				ORDER BY CASE kind WHEN 'street' THEN 1 ELSE 0 END LIMIT 1`, key)
+			if err != nil {
+				return nil, err
+			}
+			if len(places) == 0 {
+				continue
+			}
+			if !seen[key] {
+				seen[key] = true
+				mentions = append(mentions, Mention{Text: phrase, Place: places[0]})
+			}
+			i += n - 1
+			break
+		}
+	}
+	return mentions, nil
+}
@@ -0,0 +1,176 @@
+// Package geoexport writes GeoJSON features to the formats desktop GIS
+// reads: GeoJSON, KML, zipped Shapefile, GeoPackage and CSV with WKT
+// geometries. Attribute columns are inferred from the feature properties
+// and coordinates are transformed to the requested reference system.
+package geoexport
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"kdg/be/lab/internal/crs"
+	"kdg/be/lab/internal/geojson"
+)
+
+// ErrFormat is returned for unknown formats and for reference systems a
+// format cannot hold
+var ErrFormat = errors.New("geoexport: unsupported export")
+
+// Layer is a named set of features. It becomes a table of a GeoPackage, a
+// folder of a KML document or a set of files in a Shapefile archive.
+type Layer struct {
+	Name     string
+	Features *geojson.FeatureCollection
+}
+
+// Format is an export format
+type Format struct {
+	Name        string
+	Title       string
+	Extension   string
+	ContentType string
+
+	// wgs84Only is set for formats whose coordinates are always WGS84
+	wgs84Only bool
+	write     func(w io.Writer, e *exporter) error
+}
+
+// Formats lists the export formats by name
+var Formats = []*Format{
+	{Name: "geojson", Title: "GeoJSON", Extension: ".geojson", ContentType: "application/geo+json", write: writeGeoJSON},
+	{Name: "kml", Title: "KML", Extension: ".kml", ContentType: "application/vnd.google-earth.kml+xml", wgs84Only: true, write: writeKML},
+	{Name: "shp", Title: "Shapefile (zip)", Extension: ".zip", ContentType: "application/zip", write: writeShapefile},
+	{Name: "gpkg", Title: "GeoPackage", Extension: ".gpkg", ContentType: "application/geopackage+sqlite3", write: writeGeoPackage},
+	{Name: "csv", Title: "CSV with WKT", Extension: ".csv", ContentType: "text/csv; charset=utf-8", write: writeCSV},
+}
+
+// LookupFormat returns the format with a name
+func LookupFormat(name string) (*Format, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, f := range Formats {
+		if f.Name == name {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: format %q", ErrFormat, name)
+}
+
+// Write exports the layers with coordinates in the target system, which
+// must be one the format can hold. Layer features are not changed.
+func (f *Format) Write(w io.Writer, layers []Layer, target *crs.CRS) error {
+	if f.wgs84Only && target != crs.WGS84 {
+		return fmt.Errorf("%w: %s coordinates are always WGS84", ErrFormat, strings.ToUpper(f.Name))
+	}
+	return f.write(w, &exporter{layers: layers, target: target})
+}
+
+// exporter holds what every format writer needs
+type exporter struct {
+	layers []Layer
+	target *crs.CRS
+}
+
+// geometry returns a copy of g with its coordinates in the target system.
+// Altitudes are dropped, as not every format can hold them.
+func (e *exporter) geometry(g *geojson.Geometry) *geojson.Geometry {
+	if g == nil {
+		return nil
+	}
+	out := &geojson.Geometry{Type: g.Type}
+	switch g.Type {
+	case geojson.Point:
+		out.Point = e.position(g.Point)
+	case geojson.MultiPoint:
+		out.MultiPoint = e.positions(g.MultiPoint)
+	case geojson.LineString:
+		out.LineString = e.positions(g.LineString)
+	case geojson.MultiLineString:
+		out.MultiLineString = e.positions2(g.MultiLineString)
+	case geojson.Polygon:
+		out.Polygon = e.positions2(g.Polygon)
+	case geojson.MultiPolygon:
+		out.MultiPolygon = make([][][]geojson.Position, len(g.MultiPolygon))
+		for i, polygon := range g.MultiPolygon {
+			out.MultiPolygon[i] = e.positions2(polygon)
+		}
+	case geojson.GeometryCollection:
+		out.Geometries = make([]*geojson.Geometry, 0, len(g.Geometries))
+		for _, member := range g.Geometries {
+			if member != nil {
+				out.Geometries = append(out.Geometries, e.geometry(member))
+			}
+		}
+	}
+	return out
+}
+
+func (e *exporter) position(p geojson.Position) geojson.Position {
+	if len(p) < 2 {
+		return nil
+	}
+	if e.target == crs.WGS84 {
+		return geojson.Position{p[0], p[1]}
+	}
+	x, y := e.target.FromWGS84(p[0], p[1])
+	return geojson.Position{x, y}
+}
+
+func (e *exporter) positions(ps []geojson.Position) []geojson.Position {
+	out := make([]geojson.Position, 0, len(ps))
+	for _, p := range ps {
+		if q := e.position(p); q != nil {
+			out = append(out, q)
+		}
+	}
+	return out
+}
+
+func (e *exporter) positions2(lines [][]geojson.Position) [][]geojson.Position {
+	out := make([][]geojson.Position, len(lines))
+	for i, line := range lines {
+		out[i] = e.positions(line)
+	}
+	return out
+}
+
+// features returns every feature of every layer
+func (e *exporter) features() []*geojson.Feature {
+	var all []*geojson.Feature
+	for _, l := range e.layers {
+		all = append(all, l.Features.Features...)
+	}
+	return all
+}
+
+// uniqueNames maps names to ones that are unique and valid for a format,
+// as made by clean. Taken names get a numeric suffix within max bytes.
+func uniqueNames(names []string, max int, clean func(string) string) []string {
+	seen := map[string]bool{}
+	out := make([]string, len(names))
+	for i, name := range names {
+		base := truncate(clean(name), max)
+		candidate := base
+		for n := 1; seen[strings.ToLower(candidate)]; n++ {
+			suffix := fmt.Sprintf("_%d", n)
+			candidate = truncate(base, max-len(suffix)) + suffix
+		}
+		seen[strings.ToLower(candidate)] = true
+		out[i] = candidate
+	}
+	return out
+}
+
+// truncate cuts s to at most max bytes without splitting a character
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	s = s[:max]
+	for !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,177 @@
+package geoexport
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+
+	"kdg/be/lab/internal/geojson"
+)
+
+// WKT writes a geometry as well-known text; nil becomes the empty string
+func WKT(g *geojson.Geometry) string {
+	if g == nil {
+		return ""
+	}
+	var b strings.Builder
+	writeWKT(&b, g)
+	return b.String()
+}
+
+func writeWKT(b *strings.Builder, g *geojson.Geometry) {
+	b.WriteString(strings.ToUpper(g.Type))
+	switch g.Type {
+	case geojson.Point:
+		if len(g.Point) < 2 {
+			b.WriteString(" EMPTY")
+			return
+		}
+		b.WriteString(" (")
+		wktPosition(b, g.Point)
+		b.WriteByte(')')
+	case geojson.MultiPoint:
+		wktList(b, len(g.MultiPoint), func(i int) {
+			b.WriteByte('(')
+			wktPosition(b, g.MultiPoint[i])
+			b.WriteByte(')')
+		})
+	case geojson.LineString:
+		wktLine(b, g.LineString)
+	case geojson.MultiLineString:
+		wktList(b, len(g.MultiLineString), func(i int) { wktLine(b, g.MultiLineString[i]) })
+	case geojson.Polygon:
+		wktList(b, len(g.Polygon), func(i int) { wktLine(b, g.Polygon[i]) })
+	case geojson.MultiPolygon:
+		wktList(b, len(g.MultiPolygon), func(i int) {
+			polygon := g.MultiPolygon[i]
+			wktList(b, len(polygon), func(j int) { wktLine(b, polygon[j]) })
+		})
+	case geojson.GeometryCollection:
+		wktList(b, len(g.Geometries), func(i int) { writeWKT(b, g.Geometries[i]) })
+	}
+}
+
+// wktList writes n items in parentheses, or EMPTY
+func wktList(b *strings.Builder, n int, item func(i int)) {
+	if n == 0 {
+		b.WriteString(" EMPTY")
+		return
+	}
+	b.WriteString(" (")
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		item(i)
+	}
+	b.WriteByte(')')
+}
+
+// wktLine writes positions in parentheses without a type name
+func wktLine(b *strings.Builder, line []geojson.Position) {
+	var inner strings.Builder
+	wktList(&inner, len(line), func(i int) { wktPosition(&inner, line[i]) })
+	b.WriteString(strings.TrimPrefix(inner.String(), " "))
+}
+
+func wktPosition(b *strings.Builder, p geojson.Position) {
+	b.WriteString(strconv.FormatFloat(p[0], 'f', -1, 64))
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatFloat(p[1], 'f', -1, 64))
+}
+
+// WKB geometry type codes
+var wkbTypes = map[string]uint32{
+	geojson.Point:              1,
+	geojson.LineString:         2,
+	geojson.Polygon:            3,
+	geojson.MultiPoint:         4,
+	geojson.MultiLineString:    5,
+	geojson.MultiPolygon:       6,
+	geojson.GeometryCollection: 7,
+}
+
+// appendWKB writes a geometry as little-endian well-known binary. An empty
+// point is written with NaN coordinates, as GeoPackage requires.
+func appendWKB(b []byte, g *geojson.Geometry) []byte {
+	b = append(b, 1)
+	b = binary.LittleEndian.AppendUint32(b, wkbTypes[g.Type])
+
+	line := func(b []byte, ps []geojson.Position) []byte {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(ps)))
+		for _, p := range ps {
+			b = appendPoint(b, p)
+		}
+		return b
+	}
+	polygon := func(b []byte, rings [][]geojson.Position) []byte {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(rings)))
+		for _, ring := range rings {
+			b = line(b, ring)
+		}
+		return b
+	}
+
+	switch g.Type {
+	case geojson.Point:
+		if len(g.Point) < 2 {
+			return appendPoint(b, geojson.Position{math.NaN(), math.NaN()})
+		}
+		return appendPoint(b, g.Point)
+	case geojson.LineString:
+		return line(b, g.LineString)
+	case geojson.Polygon:
+		return polygon(b, g.Polygon)
+	case geojson.MultiPoint:
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(g.MultiPoint)))
+		for _, p := range g.MultiPoint {
+			b = appendWKB(b, &geojson.Geometry{Type: geojson.Point, Point: p})
+		}
+	case geojson.MultiLineString:
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(g.MultiLineString)))
+		for _, l := range g.MultiLineString {
+			b = appendWKB(b, &geojson.Geometry{Type: geojson.LineString, LineString: l})
+		}
+	case geojson.MultiPolygon:
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(g.MultiPolygon)))
+		for _, p := range g.MultiPolygon {
+			b = appendWKB(b, &geojson.Geometry{Type: geojson.Polygon, Polygon: p})
+		}
+	case geojson.GeometryCollection:
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(g.Geometries)))
+		for _, member := range g.Geometries {
+			b = appendWKB(b, member)
+		}
+	}
+	return b
+}
+
+func appendPoint(b []byte, p geojson.Position) []byte {
+	b = binary.LittleEndian.AppendUint64(b, math.Float64bits(p[0]))
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(p[1]))
+}
+
+// isEmpty reports whether a geometry has no positions
+func isEmpty(g *geojson.Geometry) bool {
+	_, ok := g.Bounds()
+	return !ok
+}
+
+// ringArea is twice the signed area of a ring, positive when it runs
+// counterclockwise
+func ringArea(ring []geojson.Position) float64 {
+	var sum float64
+	for i := 0; i+1 < len(ring); i++ {
+		sum += ring[i][0]*ring[i+1][1] - ring[i+1][0]*ring[i][1]
+	}
+	return sum
+}
+
+func reversed(ring []geojson.Position) []geojson.Position {
+	out := make([]geojson.Position, len(ring))
+	for i, p := range ring {
+		out[len(ring)-1-i] = p
+	}
+	return out
+}
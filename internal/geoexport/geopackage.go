@@ -0,0 +1,303 @@
+package geoexport
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"kdg/be/lab/internal/crs"
+	"kdg/be/lab/internal/geojson"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// GeoPackage identification, version 1.3
+const (
+	gpkgApplicationID = 0x47504B47 // "GPKG"
+	gpkgUserVersion   = 10300
+)
+
+// gpkgTables creates the metadata tables every GeoPackage needs, as given
+// in the specification
+var gpkgTables = []string{
+	`CREATE TABLE gpkg_spatial_ref_sys (
+		srs_name TEXT NOT NULL,
+		srs_id INTEGER PRIMARY KEY,
+		organization TEXT NOT NULL,
+		organization_coordsys_id INTEGER NOT NULL,
+		definition TEXT NOT NULL,
+		description TEXT
+	)`,
+	`CREATE TABLE gpkg_contents (
+		table_name TEXT NOT NULL PRIMARY KEY,
+		data_type TEXT NOT NULL,
+		identifier TEXT UNIQUE,
+		description TEXT DEFAULT '',
+		last_change DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+		min_x DOUBLE,
+		min_y DOUBLE,
+		max_x DOUBLE,
+		max_y DOUBLE,
+		srs_id INTEGER,
+		CONSTRAINT fk_gc_r_srs_id FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys(srs_id)
+	)`,
+	`CREATE TABLE gpkg_geometry_columns (
+		table_name TEXT NOT NULL,
+		column_name TEXT NOT NULL,
+		geometry_type_name TEXT NOT NULL,
+		srs_id INTEGER NOT NULL,
+		z TINYINT NOT NULL,
+		m TINYINT NOT NULL,
+		CONSTRAINT pk_geom_cols PRIMARY KEY (table_name, column_name),
+		CONSTRAINT fk_gc_tn FOREIGN KEY (table_name) REFERENCES gpkg_contents(table_name),
+		CONSTRAINT fk_gc_srs FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys(srs_id)
+	)`,
+}
+
+// gpkgColumnTypes maps field types to GeoPackage column types
+var gpkgColumnTypes = map[FieldType]string{
+	String:  "TEXT",
+	Integer: "INTEGER",
+	Real:    "REAL",
+	Boolean: "BOOLEAN",
+}
+
+// writeGeoPackage writes a table per layer. SQLite needs a file, so the
+// package is built in a temporary one and then copied.
+func writeGeoPackage(w io.Writer, e *exporter) error {
+	tmp, err := os.CreateTemp("", "export-*.gpkg")
+	if err != nil {
+		return err
+	}
+	path := tmp.Name()
+	tmp.Close()
+	defer os.Remove(path)
+
+	if err := e.buildGeoPackage(path); err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (e *exporter) buildGeoPackage(path string) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmts := append([]string{
+		fmt.Sprintf("PRAGMA application_id = %d", gpkgApplicationID),
+		fmt.Sprintf("PRAGMA user_version = %d", gpkgUserVersion),
+	}, gpkgTables...)
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	// The specification requires WGS84 and the two undefined systems
+	srs := `INSERT INTO gpkg_spatial_ref_sys
+		(srs_name, srs_id, organization, organization_coordsys_id, definition, description)
+		VALUES (?, ?, ?, ?, ?, ?)`
+	rows := [][]interface{}{
+		{"Undefined cartesian SRS", -1, "NONE", -1, "undefined", "undefined cartesian coordinate reference system"},
+		{"Undefined geographic SRS", 0, "NONE", 0, "undefined", "undefined geographic coordinate reference system"},
+		{crs.WGS84.Name, crs.WGS84.Code, "EPSG", crs.WGS84.Code, crs.WGS84.WKT(), nil},
+	}
+	if e.target != crs.WGS84 {
+		rows = append(rows, []interface{}{e.target.Name, e.target.Code, "EPSG", e.target.Code, e.target.WKT(), nil})
+	}
+	for _, row := range rows {
+		if _, err := tx.Exec(srs, row...); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, len(e.layers))
+	for i, l := range e.layers {
+		names[i] = l.Name
+	}
+	names = uniqueNames(names, 128, tableName)
+
+	for i, l := range e.layers {
+		if err := e.writeTable(tx, names[i], l); err != nil {
+			return fmt.Errorf("layer %q: %w", l.Name, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// writeTable creates and fills the feature table of a layer
+func (e *exporter) writeTable(tx *sql.Tx, table string, l Layer) error {
+	schema := InferSchema(l.Features.Features)
+
+	// Property columns may not take the names of the key and geometry
+	names := append([]string{"fid", "geom"}, make([]string, len(schema))...)
+	for i, f := range schema {
+		names[i+2] = f.Name
+	}
+	names = uniqueNames(names, 128, func(s string) string { return s })[2:]
+
+	columns := []string{"fid INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL", "geom " + geometryTypeName(l.Features.Features)}
+	for i, f := range schema {
+		columns = append(columns, quoteIdent(names[i])+" "+gpkgColumnTypes[f.Type])
+	}
+	if _, err := tx.Exec(fmt.Sprintf("CREATE TABLE %s (%s)", quoteIdent(table), strings.Join(columns, ", "))); err != nil {
+		return err
+	}
+
+	box := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	insertColumns := []string{"geom"}
+	params := []string{"?"}
+	for i := range schema {
+		insertColumns = append(insertColumns, quoteIdent(names[i]))
+		params = append(params, "?")
+	}
+	insert, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		quoteIdent(table), strings.Join(insertColumns, ", "), strings.Join(params, ", ")))
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	for _, f := range l.Features.Features {
+		var blob []byte
+		if f.Geometry != nil {
+			g := e.geometry(f.Geometry)
+			var gb geojson.BBox
+			blob, gb = e.gpkgGeometry(g)
+			if !isEmpty(g) {
+				box = [4]float64{math.Min(box[0], gb[0]), math.Min(box[1], gb[1]), math.Max(box[2], gb[2]), math.Max(box[3], gb[3])}
+			}
+		}
+
+		_, values := properties(f)
+		args := []interface{}{blob}
+		for _, field := range schema {
+			args = append(args, columnValue(field, values[field.Name]))
+		}
+		if _, err := insert.Exec(args...); err != nil {
+			return err
+		}
+	}
+
+	var bounds []interface{}
+	if math.IsInf(box[0], 0) {
+		bounds = []interface{}{nil, nil, nil, nil}
+	} else {
+		bounds = []interface{}{box[0], box[1], box[2], box[3]}
+	}
+	contents := `INSERT INTO gpkg_contents (table_name, data_type, identifier, min_x, min_y, max_x, max_y, srs_id)
+		VALUES (?, 'features', ?, ?, ?, ?, ?, ?)`
+	if _, err := tx.Exec(contents, append(append([]interface{}{table, table}, bounds...), e.target.Code)...); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO gpkg_geometry_columns (table_name, column_name, geometry_type_name, srs_id, z, m)
+		VALUES (?, 'geom', ?, ?, 0, 0)`, table, geometryTypeName(l.Features.Features), e.target.Code)
+	return err
+}
+
+// gpkgGeometry writes a geometry in the GeoPackage binary format: a header
+// with the system and envelope, then little-endian WKB
+func (e *exporter) gpkgGeometry(g *geojson.Geometry) ([]byte, geojson.BBox) {
+	box, ok := g.Bounds()
+
+	// Flags: little-endian, with an xy envelope or marked empty
+	flags := byte(0x01)
+	if ok {
+		flags |= 0x02
+	} else {
+		flags |= 0x10
+	}
+	b := []byte{'G', 'P', 0, flags}
+	b = binary.LittleEndian.AppendUint32(b, uint32(int32(e.target.Code)))
+	if ok {
+		for _, v := range []float64{box[0], box[2], box[1], box[3]} {
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+		}
+	}
+	return appendWKB(b, g), box
+}
+
+// geometryTypeName is the type shared by all geometries, or GEOMETRY
+func geometryTypeName(features []*geojson.Feature) string {
+	name := ""
+	for _, f := range features {
+		if f.Geometry == nil {
+			continue
+		}
+		t := strings.ToUpper(f.Geometry.Type)
+		if name != "" && name != t {
+			return "GEOMETRY"
+		}
+		name = t
+	}
+	if name == "" {
+		return "GEOMETRY"
+	}
+	return name
+}
+
+// columnValue converts a property for a column of the field's type.
+// Values that do not fit the type are stored as text, which SQLite allows.
+func columnValue(f *Field, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch f.Type {
+	case Boolean:
+		if b, ok := v.(bool); ok {
+			return b
+		}
+	case Integer, Real:
+		if n, ok := v.(json.Number); ok {
+			if f.Type == Integer {
+				if i, err := n.Int64(); err == nil {
+					return i
+				}
+			}
+			if x, err := n.Float64(); err == nil {
+				return x
+			}
+		}
+	}
+	return formatValue(v)
+}
+
+// tableName keeps a layer name readable while leaving out characters that
+// tools handle badly in table names
+func tableName(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "gpkg_") || strings.HasPrefix(strings.ToLower(name), "sqlite_") {
+		name = "layer_" + name
+	}
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || r == '"' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
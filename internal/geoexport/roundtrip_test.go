@@ -0,0 +1,272 @@
+package geoexport_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"kdg/be/lab/internal/crs"
+	"kdg/be/lab/internal/geoexport"
+	"kdg/be/lab/internal/geoimport"
+	"kdg/be/lab/internal/geojson"
+	"kdg/be/lab/internal/sniff"
+)
+
+// roundTripLayers hold every geometry type, holes, multi-part geometries
+// and attributes of every type, including text that looks like a number
+var roundTripLayers = []struct {
+	name string
+	doc  string
+}{
+	{"parcels", `{"type":"FeatureCollection","features":[
+		{"type":"Feature","geometry":{"type":"Polygon","coordinates":[[[4,51],[5,51],[5,52],[4,52],[4,51]],[[4.2,51.2],[4.2,51.8],[4.8,51.8],[4.2,51.2]]]},
+			"properties":{"name":"Één","area":12.5,"count":3,"active":true,"code":"0123"}},
+		{"type":"Feature","geometry":{"type":"MultiPolygon","coordinates":[[[[4,50],[4.5,50],[4.5,50.5],[4,50]]],[[[5,50],[5.5,50],[5.5,50.5],[5,50]]]]},
+			"properties":{"name":"b","area":1,"count":null,"active":false,"code":"x"}}
+	]}`},
+	{"roads", `{"type":"FeatureCollection","features":[
+		{"type":"Feature","geometry":{"type":"LineString","coordinates":[[4,51],[4.5,51.5]]},"properties":{"name":"r1","lanes":2}},
+		{"type":"Feature","geometry":{"type":"MultiLineString","coordinates":[[[4,50],[4.1,50.1]],[[4.2,50.2],[4.3,50.3],[4.4,50.2]]]},"properties":{"name":"r2","lanes":4}}
+	]}`},
+	{"wells", `{"type":"FeatureCollection","features":[
+		{"type":"Feature","geometry":{"type":"Point","coordinates":[4.25,51.125]},"properties":{"name":"w1","depth":-12.75}},
+		{"type":"Feature","geometry":{"type":"MultiPoint","coordinates":[[4,51],[4.5,51.5],[5,52]]},"properties":{"name":"w2","depth":3}}
+	]}`},
+}
+
+func exportLayers(t *testing.T) []geoexport.Layer {
+	t.Helper()
+	var layers []geoexport.Layer
+	for _, l := range roundTripLayers {
+		fc, err := geojson.Decode([]byte(l.doc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		layers = append(layers, geoexport.Layer{Name: l.name, Features: fc})
+	}
+	return layers
+}
+
+// propertyMap reads properties without their null values, which not every
+// format keeps
+func propertyMap(t *testing.T, raw json.RawMessage) map[string]interface{} {
+	t.Helper()
+	var m map[string]interface{}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range m {
+		if v == nil {
+			delete(m, k)
+		}
+	}
+	return m
+}
+
+// sameGeometry compares geometries coordinate by coordinate within a
+// tolerance in degrees
+func sameGeometry(t *testing.T, got, want *geojson.Geometry, tolerance float64) bool {
+	t.Helper()
+	if got == nil || want == nil {
+		return got == want
+	}
+	if got.Type != want.Type {
+		return false
+	}
+	var g, w []float64
+	flatten := func(out *[]float64) func(geojson.Position) {
+		return func(p geojson.Position) { *out = append(*out, p...) }
+	}
+	eachPosition(got, flatten(&g))
+	eachPosition(want, flatten(&w))
+	if len(g) != len(w) {
+		return false
+	}
+	for i := range g {
+		if math.Abs(g[i]-w[i]) > tolerance {
+			return false
+		}
+	}
+
+	// The nesting must match too
+	shape := func(g *geojson.Geometry) []int {
+		var s []int
+		for _, rings := range g.MultiPolygon {
+			s = append(s, len(rings))
+			for _, r := range rings {
+				s = append(s, len(r))
+			}
+		}
+		for _, r := range append(g.Polygon, g.MultiLineString...) {
+			s = append(s, len(r))
+		}
+		return append(s, len(g.LineString), len(g.MultiPoint))
+	}
+	gs, ws := shape(got), shape(want)
+	if len(gs) != len(ws) {
+		return false
+	}
+	for i := range gs {
+		if gs[i] != ws[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func eachPosition(g *geojson.Geometry, fn func(geojson.Position)) {
+	switch g.Type {
+	case geojson.Point:
+		fn(g.Point)
+	case geojson.MultiPoint, geojson.LineString:
+		for _, p := range append(g.MultiPoint, g.LineString...) {
+			fn(p)
+		}
+	case geojson.MultiLineString, geojson.Polygon:
+		for _, line := range append(g.MultiLineString, g.Polygon...) {
+			for _, p := range line {
+				fn(p)
+			}
+		}
+	case geojson.MultiPolygon:
+		for _, rings := range g.MultiPolygon {
+			for _, r := range rings {
+				for _, p := range r {
+					fn(p)
+				}
+			}
+		}
+	}
+}
+
+func compareFeatures(t *testing.T, layer string, got, want []*geojson.Feature, tolerance float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("layer %s: %d features, want %d", layer, len(got), len(want))
+	}
+	for i := range want {
+		if !sameGeometry(t, got[i].Geometry, want[i].Geometry, tolerance) {
+			g, _ := json.Marshal(got[i].Geometry)
+			w, _ := json.Marshal(want[i].Geometry)
+			t.Errorf("layer %s feature %d: geometry %s, want %s", layer, i, g, w)
+		}
+		gp, wp := propertyMap(t, got[i].Properties), propertyMap(t, want[i].Properties)
+		delete(gp, "layer")
+		g, _ := json.Marshal(gp)
+		w, _ := json.Marshal(wp)
+		if string(g) != string(w) {
+			t.Errorf("layer %s feature %d: properties %s, want %s", layer, i, g, w)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	rd, err := crs.Lookup(28992)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format    string
+		docType   string
+		target    *crs.CRS
+		tolerance float64
+		// oneLayer formats write every layer to a single table
+		oneLayer bool
+	}{
+		{"shp", sniff.Shapefile, crs.WGS84, 0, false},
+		{"shp", sniff.Shapefile, rd, 1e-7, false},
+		{"gpkg", sniff.GPKG, crs.WGS84, 0, false},
+		{"gpkg", sniff.GPKG, rd, 1e-7, false},
+		{"kml", sniff.KML, crs.WGS84, 0, false},
+		{"csv", sniff.CSV, crs.WGS84, 0, true},
+		{"geojson", sniff.GeoJSON, crs.WGS84, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format+" "+tt.target.String(), func(t *testing.T) {
+			layers := exportLayers(t)
+			format, err := geoexport.LookupFormat(tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var b bytes.Buffer
+			if err := format.Write(&b, layers, tt.target); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := sniff.Detect(bytes.NewReader(b.Bytes()), int64(b.Len())); err != nil || got != tt.docType {
+				t.Errorf("export sniffed as %s, %v, want %s", got, err, tt.docType)
+			}
+
+			imported, err := geoimport.Read(b.Bytes(), tt.docType, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.oneLayer {
+				var want []*geojson.Feature
+				for _, l := range layers {
+					want = append(want, l.Features.Features...)
+				}
+				if len(imported) != 1 {
+					t.Fatalf("%d layers, want 1", len(imported))
+				}
+				compareFeatures(t, "", imported[0].Features.Features, want, tt.tolerance)
+				return
+			}
+
+			if len(imported) != len(layers) {
+				t.Fatalf("%d layers, want %d", len(imported), len(layers))
+			}
+			for _, l := range layers {
+				got, err := geoimport.Find(imported, l.Name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.target != crs.WGS84 && (got.Source == nil || got.Source.Code != tt.target.Code) {
+					t.Errorf("layer %s read in %v, want %v", l.Name, got.Source, tt.target)
+				}
+				compareFeatures(t, l.Name, got.Features.Features, l.Features.Features, tt.tolerance)
+			}
+		})
+	}
+}
+
+// A Shapefile holds one shape type, so a layer of mixed geometries is
+// split over a file per type
+func TestRoundTripShapefileMixed(t *testing.T) {
+	var features []*geojson.Feature
+	for _, l := range exportLayers(t) {
+		features = append(features, l.Features.Features...)
+	}
+	fc := &geojson.FeatureCollection{Features: features}
+
+	format, err := geoexport.LookupFormat("shp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := format.Write(&b, []geoexport.Layer{{Name: "mixed", Features: fc}}, crs.WGS84); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := geoimport.Read(b.Bytes(), sniff.Shapefile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []struct {
+		name     string
+		features []*geojson.Feature
+	}{
+		{"mixed_points", features[4:6]},
+		{"mixed_lines", features[2:4]},
+		{"mixed_polygons", features[0:2]},
+	} {
+		got, err := geoimport.Find(imported, want.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compareFeatures(t, want.name, got.Features.Features, want.features, 0)
+	}
+}
@@ -0,0 +1,149 @@
+package geoexport
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	"kdg/be/lab/internal/geojson"
+)
+
+// FieldType is the type of an attribute column
+type FieldType int
+
+const (
+	String FieldType = iota
+	Integer
+	Real
+	Boolean
+)
+
+func (t FieldType) String() string {
+	switch t {
+	case Integer:
+		return "integer"
+	case Real:
+		return "real"
+	case Boolean:
+		return "boolean"
+	}
+	return "string"
+}
+
+// Field is an attribute column. Width is the longest value in bytes as
+// written.
+type Field struct {
+	Name  string
+	Type  FieldType
+	Width int
+
+	typed bool // false until a non-null value was seen
+}
+
+// Schema lists the attribute columns of a set of features in the order
+// their properties first appear
+type Schema []*Field
+
+// InferSchema derives columns from feature properties. Strings, booleans,
+// integers and other numbers get their own type; a property whose values
+// mix integers and other numbers is real and one mixing other types is a
+// string. Objects and arrays are written as JSON strings.
+func InferSchema(features []*geojson.Feature) Schema {
+	var schema Schema
+	index := map[string]*Field{}
+
+	for _, f := range features {
+		keys, values := properties(f)
+		for _, key := range keys {
+			field, ok := index[key]
+			if !ok {
+				field = &Field{Name: key}
+				index[key] = field
+				schema = append(schema, field)
+			}
+			v := values[key]
+			if v == nil {
+				continue
+			}
+			field.observe(v)
+		}
+	}
+	return schema
+}
+
+func (f *Field) observe(v interface{}) {
+	t := valueType(v)
+	switch {
+	case !f.typed:
+		f.Type, f.typed = t, true
+	case f.Type == t:
+	case (f.Type == Integer && t == Real) || (f.Type == Real && t == Integer):
+		f.Type = Real
+	default:
+		f.Type = String
+	}
+	if n := len(formatValue(v)); n > f.Width {
+		f.Width = n
+	}
+}
+
+func valueType(v interface{}) FieldType {
+	switch v := v.(type) {
+	case bool:
+		return Boolean
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return Integer
+		}
+		return Real
+	}
+	return String
+}
+
+// formatValue writes a property value as text; null is the empty string
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// properties decodes the properties of a feature, keeping their order
+func properties(f *geojson.Feature) ([]string, map[string]interface{}) {
+	if len(f.Properties) == 0 || string(f.Properties) == "null" {
+		return nil, nil
+	}
+
+	d := json.NewDecoder(bytes.NewReader(f.Properties))
+	d.UseNumber()
+	if t, err := d.Token(); err != nil || t != json.Delim('{') {
+		return nil, nil
+	}
+
+	var keys []string
+	values := map[string]interface{}{}
+	for d.More() {
+		t, err := d.Token()
+		if err != nil {
+			return keys, values
+		}
+		key, _ := t.(string)
+		var v interface{}
+		if err := d.Decode(&v); err != nil {
+			return keys, values
+		}
+		if _, seen := values[key]; !seen {
+			keys = append(keys, key)
+		}
+		values[key] = v
+	}
+	return keys, values
+}
@@ -0,0 +1,446 @@
+package geoexport
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"kdg/be/lab/internal/geojson"
+)
+
+// Shape types
+const (
+	shapeNull       = 0
+	shapePoint      = 1
+	shapePolyLine   = 3
+	shapePolygon    = 5
+	shapeMultiPoint = 8
+)
+
+// dBase limits
+const (
+	dbfNameLength  = 10
+	dbfStringWidth = 254
+	dbfNumberWidth = 24
+	dbfDecimals    = 15
+)
+
+// shapeGroup collects the features of a layer that share a shape type, as
+// a Shapefile holds a single one
+type shapeGroup struct {
+	suffix   string
+	features []*geojson.Feature
+	shapes   []*shape
+}
+
+// shape is a feature geometry in Shapefile terms: parts of points
+type shape struct {
+	parts [][]geojson.Position
+}
+
+// writeShapefile writes a zip archive with a Shapefile per layer and shape
+// type: points, lines and polygons. Geometry collections are split over
+// the files of their members' types.
+func writeShapefile(w io.Writer, e *exporter) error {
+	zw := zip.NewWriter(w)
+	prj := e.target.WKT()
+
+	names := make([]string, len(e.layers))
+	for i, l := range e.layers {
+		names[i] = l.Name
+	}
+	names = uniqueNames(names, 64, fileName)
+
+	for i, l := range e.layers {
+		schema := InferSchema(l.Features.Features)
+		groups := e.shapeGroups(l.Features.Features)
+		for _, typ := range []int{shapePoint, shapeMultiPoint, shapePolyLine, shapePolygon, shapeNull} {
+			group := groups[typ]
+			if group == nil {
+				continue
+			}
+			base := names[i]
+			if len(groups) > 1 {
+				base += "_" + group.suffix
+			}
+			shp, shx := encodeShapes(typ, group.shapes)
+			files := []struct {
+				ext  string
+				data []byte
+			}{
+				{".shp", shp},
+				{".shx", shx},
+				{".dbf", encodeDBF(schema, group.features)},
+				{".prj", []byte(prj)},
+				{".cpg", []byte("UTF-8")},
+			}
+			for _, f := range files {
+				fw, err := zw.CreateHeader(&zip.FileHeader{Name: base + f.ext, Method: zip.Deflate, Modified: time.Now()})
+				if err != nil {
+					return err
+				}
+				if _, err := fw.Write(f.data); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return zw.Close()
+}
+
+// shapeGroups sorts features by shape type. Features without a geometry
+// join the first group, or form a null shape file of their own.
+func (e *exporter) shapeGroups(features []*geojson.Feature) map[int]*shapeGroup {
+	groups := map[int]*shapeGroup{}
+	var nulls []*geojson.Feature
+
+	add := func(typ int, suffix string, f *geojson.Feature, s *shape) {
+		g := groups[typ]
+		if g == nil {
+			g = &shapeGroup{suffix: suffix}
+			groups[typ] = g
+		}
+		g.features = append(g.features, f)
+		g.shapes = append(g.shapes, s)
+	}
+
+	for _, f := range features {
+		if f.Geometry == nil || isEmpty(f.Geometry) {
+			nulls = append(nulls, f)
+			continue
+		}
+		points, lines, polygons := &shape{}, &shape{}, &shape{}
+		collectShapes(e.geometry(f.Geometry), points, lines, polygons)
+		if len(points.parts) > 0 {
+			add(shapeMultiPoint, "points", f, points)
+		}
+		if len(lines.parts) > 0 {
+			add(shapePolyLine, "lines", f, lines)
+		}
+		if len(polygons.parts) > 0 {
+			add(shapePolygon, "polygons", f, polygons)
+		}
+	}
+
+	// A layer of single points is written as a point file
+	if g := groups[shapeMultiPoint]; g != nil {
+		single := true
+		for _, s := range g.shapes {
+			single = single && len(s.parts[0]) == 1
+		}
+		if single {
+			delete(groups, shapeMultiPoint)
+			groups[shapePoint] = g
+		}
+	}
+
+	if len(nulls) > 0 {
+		var first *shapeGroup
+		for _, typ := range []int{shapePoint, shapeMultiPoint, shapePolyLine, shapePolygon} {
+			if first = groups[typ]; first != nil {
+				break
+			}
+		}
+		if first == nil {
+			first = &shapeGroup{suffix: "features"}
+			groups[shapeNull] = first
+		}
+		for _, f := range nulls {
+			first.features = append(first.features, f)
+			first.shapes = append(first.shapes, nil)
+		}
+	}
+	return groups
+}
+
+// collectShapes adds the parts of a geometry to the shape of its type.
+// Points of a multipoint are one part; polygon rings are oriented the way
+// Shapefiles require: exterior rings clockwise, holes counterclockwise.
+func collectShapes(g *geojson.Geometry, points, lines, polygons *shape) {
+	addPolygon := func(rings [][]geojson.Position) {
+		for i, ring := range rings {
+			if len(ring) < 4 {
+				continue
+			}
+			if (i == 0) == (ringArea(ring) > 0) {
+				ring = reversed(ring)
+			}
+			polygons.parts = append(polygons.parts, ring)
+		}
+	}
+
+	switch g.Type {
+	case geojson.Point:
+		if len(g.Point) < 2 {
+			return
+		}
+		if len(points.parts) == 0 {
+			points.parts = [][]geojson.Position{nil}
+		}
+		points.parts[0] = append(points.parts[0], g.Point)
+	case geojson.MultiPoint:
+		if len(g.MultiPoint) == 0 {
+			return
+		}
+		if len(points.parts) == 0 {
+			points.parts = [][]geojson.Position{nil}
+		}
+		points.parts[0] = append(points.parts[0], g.MultiPoint...)
+	case geojson.LineString:
+		if len(g.LineString) > 1 {
+			lines.parts = append(lines.parts, g.LineString)
+		}
+	case geojson.MultiLineString:
+		for _, l := range g.MultiLineString {
+			if len(l) > 1 {
+				lines.parts = append(lines.parts, l)
+			}
+		}
+	case geojson.Polygon:
+		addPolygon(g.Polygon)
+	case geojson.MultiPolygon:
+		for _, p := range g.MultiPolygon {
+			addPolygon(p)
+		}
+	case geojson.GeometryCollection:
+		for _, member := range g.Geometries {
+			collectShapes(member, points, lines, polygons)
+		}
+	}
+}
+
+// encodeShapes writes the main file and index of a Shapefile
+func encodeShapes(typ int, shapes []*shape) (shp, shx []byte) {
+	var records [][]byte
+	box := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+
+	for _, s := range shapes {
+		var rec []byte
+		if s == nil {
+			rec = binary.LittleEndian.AppendUint32(nil, shapeNull)
+			records = append(records, rec)
+			continue
+		}
+
+		sb := shapeBounds(s)
+		box = [4]float64{math.Min(box[0], sb[0]), math.Min(box[1], sb[1]), math.Max(box[2], sb[2]), math.Max(box[3], sb[3])}
+
+		rec = binary.LittleEndian.AppendUint32(nil, uint32(typ))
+		switch typ {
+		case shapePoint:
+			rec = appendPoint(rec, s.parts[0][0])
+		case shapeMultiPoint:
+			rec = appendBox(rec, sb)
+			rec = binary.LittleEndian.AppendUint32(rec, uint32(len(s.parts[0])))
+			for _, p := range s.parts[0] {
+				rec = appendPoint(rec, p)
+			}
+		default:
+			rec = appendBox(rec, sb)
+			total := 0
+			for _, part := range s.parts {
+				total += len(part)
+			}
+			rec = binary.LittleEndian.AppendUint32(rec, uint32(len(s.parts)))
+			rec = binary.LittleEndian.AppendUint32(rec, uint32(total))
+			start := 0
+			for _, part := range s.parts {
+				rec = binary.LittleEndian.AppendUint32(rec, uint32(start))
+				start += len(part)
+			}
+			for _, part := range s.parts {
+				for _, p := range part {
+					rec = appendPoint(rec, p)
+				}
+			}
+		}
+		records = append(records, rec)
+	}
+	if math.IsInf(box[0], 0) {
+		box = [4]float64{}
+	}
+
+	// Lengths and offsets are counted in 16-bit words
+	shpLen := 100
+	for _, rec := range records {
+		shpLen += 8 + len(rec)
+	}
+	shp = shapeHeader(typ, shpLen, box)
+	shx = shapeHeader(typ, 100+8*len(records), box)
+
+	offset := 100
+	for i, rec := range records {
+		shp = binary.BigEndian.AppendUint32(shp, uint32(i+1))
+		shp = binary.BigEndian.AppendUint32(shp, uint32(len(rec)/2))
+		shp = append(shp, rec...)
+
+		shx = binary.BigEndian.AppendUint32(shx, uint32(offset/2))
+		shx = binary.BigEndian.AppendUint32(shx, uint32(len(rec)/2))
+		offset += 8 + len(rec)
+	}
+	return shp, shx
+}
+
+func shapeHeader(typ, length int, box [4]float64) []byte {
+	b := binary.BigEndian.AppendUint32(nil, 9994)
+	b = append(b, make([]byte, 20)...)
+	b = binary.BigEndian.AppendUint32(b, uint32(length/2))
+	b = binary.LittleEndian.AppendUint32(b, 1000)
+	b = binary.LittleEndian.AppendUint32(b, uint32(typ))
+	b = appendBox(b, box)
+	// Z and M ranges
+	return append(b, make([]byte, 32)...)
+}
+
+func shapeBounds(s *shape) [4]float64 {
+	box := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	for _, part := range s.parts {
+		for _, p := range part {
+			box = [4]float64{math.Min(box[0], p[0]), math.Min(box[1], p[1]), math.Max(box[2], p[0]), math.Max(box[3], p[1])}
+		}
+	}
+	return box
+}
+
+func appendBox(b []byte, box [4]float64) []byte {
+	for _, v := range box {
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+	}
+	return b
+}
+
+// dbfField is a column of a dBase table
+type dbfField struct {
+	*Field
+	name     string
+	kind     byte
+	width    int
+	decimals int
+}
+
+// encodeDBF writes the attribute table of a Shapefile. Column names are
+// cut to ten characters and strings to 254 bytes, the limits of the
+// format.
+func encodeDBF(schema Schema, features []*geojson.Feature) []byte {
+	names := make([]string, len(schema))
+	for i, f := range schema {
+		names[i] = f.Name
+	}
+	names = uniqueNames(names, dbfNameLength, fieldName)
+
+	fields := make([]dbfField, len(schema))
+	recordLength := 1
+	for i, f := range schema {
+		field := dbfField{Field: f, name: names[i]}
+		switch f.Type {
+		case Integer:
+			field.kind, field.width = 'N', 18
+		case Real:
+			field.kind, field.width, field.decimals = 'N', dbfNumberWidth, dbfDecimals
+		case Boolean:
+			field.kind, field.width = 'L', 1
+		default:
+			field.kind, field.width = 'C', max(1, min(f.Width, dbfStringWidth))
+		}
+		fields[i] = field
+		recordLength += field.width
+	}
+
+	var b bytes.Buffer
+	now := time.Now()
+	b.Write([]byte{3, byte(now.Year() - 1900), byte(now.Month()), byte(now.Day())})
+	binary.Write(&b, binary.LittleEndian, uint32(len(features)))
+	binary.Write(&b, binary.LittleEndian, uint16(32+32*len(fields)+1))
+	binary.Write(&b, binary.LittleEndian, uint16(recordLength))
+	b.Write(make([]byte, 20))
+
+	for _, f := range fields {
+		name := make([]byte, 11)
+		copy(name, f.name)
+		b.Write(name)
+		b.WriteByte(f.kind)
+		b.Write(make([]byte, 4))
+		b.Write([]byte{byte(f.width), byte(f.decimals)})
+		b.Write(make([]byte, 14))
+	}
+	b.WriteByte(0x0D)
+
+	for _, feature := range features {
+		_, values := properties(feature)
+		b.WriteByte(' ')
+		for _, f := range fields {
+			b.WriteString(f.value(values[f.Field.Name]))
+		}
+	}
+	b.WriteByte(0x1A)
+	return b.Bytes()
+}
+
+// value writes a property as a fixed width dBase value
+func (f dbfField) value(v interface{}) string {
+	switch f.kind {
+	case 'L':
+		switch v {
+		case true:
+			return "T"
+		case false:
+			return "F"
+		}
+		return "?"
+	case 'N':
+		s := ""
+		if v != nil {
+			n, _ := strconv.ParseFloat(formatValue(v), 64)
+			if f.decimals == 0 {
+				s = formatValue(v)
+			} else {
+				s = strconv.FormatFloat(n, 'f', f.decimals, 64)
+			}
+			if len(s) > f.width {
+				s = strconv.FormatFloat(n, 'e', f.width-8, 64)
+			}
+		}
+		return strings.Repeat(" ", f.width-len(s)) + s
+	}
+
+	s := truncate(formatValue(v), f.width)
+	return s + strings.Repeat(" ", f.width-len(s))
+}
+
+// fieldName keeps letters, digits and underscores, as dBase column names
+// allow nothing else, and starts with a letter
+func fieldName(name string) string {
+	if name == "" {
+		return "field"
+	}
+	name = strings.ReplaceAll(fileName(name), "-", "_")
+	if name[0] < 'A' || name[0] > 'z' || (name[0] > 'Z' && name[0] < 'a') {
+		name = "f" + name
+	}
+	return name
+}
+
+// fileName replaces what is not an ASCII letter, digit, dash or
+// underscore with underscores
+func fileName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			b.WriteRune(r)
+		case r == '-' && b.Len() > 0:
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "layer"
+	}
+	return b.String()
+}
@@ -0,0 +1,202 @@
+package geoexport
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"kdg/be/lab/internal/crs"
+	"kdg/be/lab/internal/geojson"
+)
+
+// writeGeoJSON merges the layers into one feature collection. Coordinates
+// in another system than WGS84 are named by a crs member, which RFC 7946
+// dropped but GIS software still reads.
+func writeGeoJSON(w io.Writer, e *exporter) error {
+	var doc struct {
+		Type     string             `json:"type"`
+		CRS      interface{}        `json:"crs,omitempty"`
+		Features []*geojson.Feature `json:"features"`
+	}
+	doc.Type = "FeatureCollection"
+	doc.Features = []*geojson.Feature{}
+	if e.target != crs.WGS84 {
+		doc.CRS = map[string]interface{}{
+			"type":       "name",
+			"properties": map[string]string{"name": fmt.Sprintf("urn:ogc:def:crs:EPSG::%d", e.target.Code)},
+		}
+	}
+	for _, f := range e.features() {
+		g := f.Geometry
+		if e.target != crs.WGS84 {
+			g = e.geometry(g)
+		}
+		doc.Features = append(doc.Features, &geojson.Feature{ID: f.ID, Geometry: g, Properties: f.Properties})
+	}
+	return json.NewEncoder(w).Encode(doc)
+}
+
+// writeCSV writes one row per feature with a column per property and the
+// geometry as WKT in the last column. A layer column is added when there
+// is more than one layer.
+func writeCSV(w io.Writer, e *exporter) error {
+	schema := InferSchema(e.features())
+	withLayer := len(e.layers) > 1
+
+	header := make([]string, 0, len(schema)+2)
+	if withLayer {
+		header = append(header, "layer")
+	}
+	for _, field := range schema {
+		header = append(header, field.Name)
+	}
+	header = append(header, "wkt")
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, l := range e.layers {
+		for _, f := range l.Features.Features {
+			_, values := properties(f)
+			row := make([]string, 0, len(header))
+			if withLayer {
+				row = append(row, l.Name)
+			}
+			for _, field := range schema {
+				row = append(row, formatValue(values[field.Name]))
+			}
+			row = append(row, WKT(e.geometry(f.Geometry)))
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeKML writes a folder of placemarks per layer. Properties become
+// extended data; a name or title property names the placemark.
+func writeKML(w io.Writer, e *exporter) error {
+	b := &strings.Builder{}
+	b.WriteString(xml.Header)
+	b.WriteString(`<kml xmlns="http://www.opengis.net/kml/2.2"><Document>`)
+	for _, l := range e.layers {
+		b.WriteString("<Folder><name>")
+		xmlText(b, l.Name)
+		b.WriteString("</name>")
+		for _, f := range l.Features.Features {
+			keys, values := properties(f)
+			b.WriteString("<Placemark>")
+			for _, key := range []string{"name", "Name", "NAME", "title"} {
+				if v, ok := values[key].(string); ok && v != "" {
+					b.WriteString("<name>")
+					xmlText(b, v)
+					b.WriteString("</name>")
+					break
+				}
+			}
+			if len(keys) > 0 {
+				b.WriteString("<ExtendedData>")
+				for _, key := range keys {
+					if values[key] == nil {
+						continue
+					}
+					b.WriteString(`<Data name="`)
+					xmlText(b, key)
+					b.WriteString(`"><value>`)
+					xmlText(b, formatValue(values[key]))
+					b.WriteString("</value></Data>")
+				}
+				b.WriteString("</ExtendedData>")
+			}
+			if f.Geometry != nil {
+				kmlGeometry(b, e.geometry(f.Geometry))
+			}
+			b.WriteString("</Placemark>")
+		}
+		b.WriteString("</Folder>")
+	}
+	b.WriteString("</Document></kml>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func kmlGeometry(b *strings.Builder, g *geojson.Geometry) {
+	multi := func(members []*geojson.Geometry) {
+		b.WriteString("<MultiGeometry>")
+		for _, m := range members {
+			kmlGeometry(b, m)
+		}
+		b.WriteString("</MultiGeometry>")
+	}
+
+	switch g.Type {
+	case geojson.Point:
+		if len(g.Point) < 2 {
+			return
+		}
+		b.WriteString("<Point><coordinates>")
+		kmlCoordinates(b, []geojson.Position{g.Point})
+		b.WriteString("</coordinates></Point>")
+	case geojson.LineString:
+		b.WriteString("<LineString><coordinates>")
+		kmlCoordinates(b, g.LineString)
+		b.WriteString("</coordinates></LineString>")
+	case geojson.Polygon:
+		if len(g.Polygon) == 0 {
+			return
+		}
+		b.WriteString("<Polygon>")
+		for i, ring := range g.Polygon {
+			boundary := "innerBoundaryIs"
+			if i == 0 {
+				boundary = "outerBoundaryIs"
+			}
+			b.WriteString("<" + boundary + "><LinearRing><coordinates>")
+			kmlCoordinates(b, ring)
+			b.WriteString("</coordinates></LinearRing></" + boundary + ">")
+		}
+		b.WriteString("</Polygon>")
+	case geojson.MultiPoint:
+		members := make([]*geojson.Geometry, len(g.MultiPoint))
+		for i, p := range g.MultiPoint {
+			members[i] = &geojson.Geometry{Type: geojson.Point, Point: p}
+		}
+		multi(members)
+	case geojson.MultiLineString:
+		members := make([]*geojson.Geometry, len(g.MultiLineString))
+		for i, l := range g.MultiLineString {
+			members[i] = &geojson.Geometry{Type: geojson.LineString, LineString: l}
+		}
+		multi(members)
+	case geojson.MultiPolygon:
+		members := make([]*geojson.Geometry, len(g.MultiPolygon))
+		for i, p := range g.MultiPolygon {
+			members[i] = &geojson.Geometry{Type: geojson.Polygon, Polygon: p}
+		}
+		multi(members)
+	case geojson.GeometryCollection:
+		multi(g.Geometries)
+	}
+}
+
+func kmlCoordinates(b *strings.Builder, ps []geojson.Position) {
+	for i, p := range ps {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(strconv.FormatFloat(p[0], 'f', -1, 64))
+		b.WriteByte(',')
+		b.WriteString(strconv.FormatFloat(p[1], 'f', -1, 64))
+	}
+}
+
+func xmlText(b *strings.Builder, s string) {
+	xml.EscapeText(b, []byte(s))
+}
@@ -0,0 +1,140 @@
+package geoimport
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"kdg/be/lab/internal/crs"
+	"kdg/be/lab/internal/geojson"
+)
+
+// Column names recognised for the geometry of a CSV row, compared in
+// lower case
+var (
+	wktColumns = []string{"wkt", "geometry", "geom", "the_geom", "wkt_geom", "shape"}
+	latColumns = []string{"lat", "latitude", "y", "breedtegraad"}
+	lonColumns = []string{"lon", "lng", "long", "longitude", "x", "lengtegraad"}
+)
+
+// readCSV reads a table with a header row and a WKT column, or latitude and
+// longitude columns. The delimiter is the one of comma, semicolon and tab
+// most used in the header; with another than a comma, coordinates may use
+// a decimal comma. The WKT column is left out of the properties.
+func readCSV(data []byte) ([]*Layer, error) {
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+	header := data
+	if i := bytes.IndexByte(header, '\n'); i >= 0 {
+		header = header[:i]
+	}
+	delimiter := ','
+	for _, d := range []rune{';', '\t'} {
+		if bytes.Count(header, []byte(string(d))) > bytes.Count(header, []byte(string(delimiter))) {
+			delimiter = d
+		}
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = delimiter
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	names, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid CSV: %v", ErrImport, err)
+	}
+	for i := range names {
+		names[i] = strings.TrimSpace(names[i])
+	}
+
+	wktCol := findColumn(names, wktColumns)
+	latCol, lonCol := findColumn(names, latColumns), findColumn(names, lonColumns)
+	if wktCol < 0 && (latCol < 0 || lonCol < 0) {
+		return nil, fmt.Errorf("%w: a CSV layer needs latitude and longitude columns or a WKT column", ErrImport)
+	}
+
+	l := &Layer{Features: geojson.NewFeatureCollection()}
+	srid := 0
+	for line := 2; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid CSV: %v", ErrImport, err)
+		}
+		if len(row) == 1 && strings.TrimSpace(row[0]) == "" {
+			continue
+		}
+		cell := func(i int) string {
+			if i < 0 || i >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[i])
+		}
+
+		var g *geojson.Geometry
+		if wktCol >= 0 {
+			var rowSRID int
+			g, rowSRID, err = ParseWKT(cell(wktCol))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			if rowSRID != 0 {
+				if srid != 0 && rowSRID != srid {
+					return nil, fmt.Errorf("%w: line %d: rows mix SRID %d and %d", ErrImport, line, srid, rowSRID)
+				}
+				srid = rowSRID
+			}
+		} else if cell(latCol) != "" || cell(lonCol) != "" {
+			lat, err1 := parseCoordinate(cell(latCol), delimiter)
+			lon, err2 := parseCoordinate(cell(lonCol), delimiter)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("%w: line %d: invalid coordinates %q, %q", ErrImport, line, cell(latCol), cell(lonCol))
+			}
+			g = &geojson.Geometry{Type: geojson.Point, Point: geojson.Position{lon, lat}}
+		}
+
+		var keys []string
+		values := map[string]interface{}{}
+		for i, name := range names {
+			if i == wktCol || name == "" {
+				continue
+			}
+			if _, seen := values[name]; !seen {
+				keys = append(keys, name)
+			}
+			values[name] = textValue(cell(i))
+		}
+		l.Features.Features = append(l.Features.Features, &geojson.Feature{Geometry: g, Properties: encodeProperties(keys, values)})
+	}
+
+	if srid != 0 {
+		if l.Source, err = crs.Lookup(srid); err != nil {
+			return nil, err
+		}
+	}
+	return []*Layer{l}, nil
+}
+
+// findColumn returns the index of the first name in candidates, or -1
+func findColumn(names, candidates []string) int {
+	for _, c := range candidates {
+		for i, name := range names {
+			if strings.EqualFold(name, c) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func parseCoordinate(s string, delimiter rune) (float64, error) {
+	if delimiter != ',' {
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	return strconv.ParseFloat(s, 64)
+}
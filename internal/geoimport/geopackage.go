@@ -0,0 +1,379 @@
+package geoimport
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"kdg/be/lab/internal/crs"
+	"kdg/be/lab/internal/geojson"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// readGeoPackage makes a layer of every feature table. SQLite needs a
+// file, so the package is copied to a temporary one.
+func readGeoPackage(data []byte) ([]*Layer, error) {
+	tmp, err := os.CreateTemp("", "import-*.gpkg")
+	if err != nil {
+		return nil, err
+	}
+	path := tmp.Name()
+	defer os.Remove(path)
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	type table struct {
+		name, identifier, column string
+		srsID                    int
+	}
+	rows, err := db.Query(`
+		SELECT c.table_name, COALESCE(NULLIF(c.identifier, ''), c.table_name), g.column_name, g.srs_id
+		FROM gpkg_contents c
+		JOIN gpkg_geometry_columns g ON g.table_name = c.table_name
+		WHERE c.data_type = 'features'
+		ORDER BY c.table_name`)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid GeoPackage: %v", ErrImport, err)
+	}
+	var tables []table
+	for rows.Next() {
+		var t table
+		if err := rows.Scan(&t.name, &t.identifier, &t.column, &t.srsID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tables = append(tables, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var layers []*Layer
+	for _, t := range tables {
+		source, err := gpkgSystem(db, t.srsID)
+		if err != nil {
+			return nil, fmt.Errorf("layer %q: %w", t.identifier, err)
+		}
+		fc, err := readFeatureTable(db, t.name, t.column)
+		if err != nil {
+			return nil, fmt.Errorf("layer %q: %w", t.identifier, err)
+		}
+		layers = append(layers, &Layer{Name: t.identifier, Features: fc, Source: source})
+	}
+	return layers, nil
+}
+
+// gpkgSystem returns the reference system of a GeoPackage srs_id, or nil
+// for the undefined systems
+func gpkgSystem(db *sql.DB, srsID int) (*crs.CRS, error) {
+	if srsID == 0 || srsID == -1 {
+		return nil, nil
+	}
+	var organization, definition string
+	var code int
+	err := db.QueryRow(`SELECT organization, organization_coordsys_id, definition FROM gpkg_spatial_ref_sys WHERE srs_id = ?`, srsID).
+		Scan(&organization, &code, &definition)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reference system %d: %v", ErrImport, srsID, err)
+	}
+	if strings.EqualFold(organization, "EPSG") {
+		return crs.Lookup(code)
+	}
+	return crs.FromWKT(definition)
+}
+
+// readFeatureTable reads the rows of a feature table. The primary key
+// becomes the feature ID and the other columns its properties.
+func readFeatureTable(db *sql.DB, table, geometryColumn string) (*geojson.FeatureCollection, error) {
+	pk := ""
+	info, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", quoteIdent(table)))
+	if err != nil {
+		return nil, err
+	}
+	for info.Next() {
+		var cid, notNull, primary int
+		var name, kind string
+		var dflt interface{}
+		if err := info.Scan(&cid, &name, &kind, &notNull, &dflt, &primary); err != nil {
+			info.Close()
+			return nil, err
+		}
+		if primary == 1 {
+			pk = name
+		}
+	}
+	info.Close()
+
+	rows, err := db.Query(fmt.Sprintf("SELECT * FROM %s", quoteIdent(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	fc := geojson.NewFeatureCollection()
+	raw := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range raw {
+		dest[i] = &raw[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		f := &geojson.Feature{}
+		var keys []string
+		values := map[string]interface{}{}
+		for i, column := range columns {
+			switch column {
+			case geometryColumn:
+				blob, _ := raw[i].([]byte)
+				if blob != nil {
+					if f.Geometry, err = gpkgGeometry(blob); err != nil {
+						return nil, fmt.Errorf("feature %d: %w", len(fc.Features)+1, err)
+					}
+				}
+			case pk:
+				f.ID, _ = json.Marshal(columnValue(raw[i]))
+			default:
+				keys = append(keys, column)
+				values[column] = columnValue(raw[i])
+			}
+		}
+		f.Properties = encodeProperties(keys, values)
+		fc.Features = append(fc.Features, f)
+	}
+	return fc, rows.Err()
+}
+
+// columnValue converts an SQLite value to a property value. Other blobs
+// than geometries have no JSON form and are left out.
+func columnValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int64:
+		return json.Number(strconv.FormatInt(v, 10))
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return nil
+		}
+		return json.Number(strconv.FormatFloat(v, 'g', -1, 64))
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case []byte:
+		return nil
+	}
+	return v
+}
+
+// gpkgGeometry reads a geometry in the GeoPackage binary format: a header
+// with the system and an optional envelope, then WKB
+func gpkgGeometry(b []byte) (*geojson.Geometry, error) {
+	if len(b) < 8 || b[0] != 'G' || b[1] != 'P' {
+		return nil, fmt.Errorf("%w: invalid GeoPackage geometry", ErrImport)
+	}
+	flags := b[3]
+	if flags&0x20 != 0 {
+		return nil, fmt.Errorf("%w: extended GeoPackage geometries are not supported", ErrImport)
+	}
+	if flags&0x10 != 0 {
+		return nil, nil // empty
+	}
+
+	envelope := [...]int{0, 32, 48, 48, 64}
+	kind := int(flags>>1) & 0x07
+	if kind >= len(envelope) {
+		return nil, fmt.Errorf("%w: invalid GeoPackage envelope", ErrImport)
+	}
+	offset := 8 + envelope[kind]
+	if offset > len(b) {
+		return nil, fmt.Errorf("%w: truncated GeoPackage geometry", ErrImport)
+	}
+	return readWKB(b[offset:])
+}
+
+// wkbReader reads WKB in either byte order, as ISO WKB or PostGIS EWKB
+type wkbReader struct {
+	b     []byte
+	off   int
+	order binary.ByteOrder
+	err   error
+}
+
+// readWKB reads a geometry in well-known binary. Measures are dropped and
+// empty points become nil.
+func readWKB(b []byte) (*geojson.Geometry, error) {
+	r := &wkbReader{b: b}
+	g := r.geometry(0)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return g, nil
+}
+
+func (r *wkbReader) take(n int) []byte {
+	if r.err != nil || n < 0 || r.off+n > len(r.b) {
+		r.err = fmt.Errorf("%w: truncated WKB geometry", ErrImport)
+		return nil
+	}
+	b := r.b[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *wkbReader) uint32() uint32 {
+	b := r.take(4)
+	if b == nil {
+		return 0
+	}
+	return r.order.Uint32(b)
+}
+
+func (r *wkbReader) count() int {
+	n := int(r.uint32())
+	if n > len(r.b)-r.off {
+		r.err = fmt.Errorf("%w: invalid WKB count", ErrImport)
+		return 0
+	}
+	return n
+}
+
+func (r *wkbReader) position(dims int, hasZ bool) geojson.Position {
+	b := r.take(8 * dims)
+	if b == nil {
+		return nil
+	}
+	p := geojson.Position{
+		math.Float64frombits(r.order.Uint64(b)),
+		math.Float64frombits(r.order.Uint64(b[8:])),
+	}
+	if hasZ {
+		p = append(p, math.Float64frombits(r.order.Uint64(b[16:])))
+	}
+	return p
+}
+
+func (r *wkbReader) positions(dims int, hasZ bool) []geojson.Position {
+	ps := make([]geojson.Position, r.count())
+	for i := range ps {
+		ps[i] = r.position(dims, hasZ)
+	}
+	return ps
+}
+
+func (r *wkbReader) geometry(depth int) *geojson.Geometry {
+	if depth > 8 {
+		r.err = fmt.Errorf("%w: WKB geometries nested too deeply", ErrImport)
+		return nil
+	}
+	order := r.take(1)
+	if order == nil {
+		return nil
+	}
+	r.order = binary.ByteOrder(binary.BigEndian)
+	if order[0] == 1 {
+		r.order = binary.LittleEndian
+	}
+
+	code := r.uint32()
+	hasZ, hasM := code&0x80000000 != 0, code&0x40000000 != 0
+	if code&0x20000000 != 0 {
+		r.take(4) // EWKB SRID
+	}
+	code &= 0x0FFFFFFF
+	switch code / 1000 {
+	case 1:
+		hasZ = true
+	case 2:
+		hasM = true
+	case 3:
+		hasZ, hasM = true, true
+	}
+	dims := 2
+	if hasZ {
+		dims++
+	}
+	if hasM {
+		dims++
+	}
+
+	switch code % 1000 {
+	case 1:
+		p := r.position(dims, hasZ)
+		if p == nil || math.IsNaN(p[0]) || math.IsNaN(p[1]) {
+			return nil
+		}
+		return &geojson.Geometry{Type: geojson.Point, Point: p}
+	case 2:
+		return &geojson.Geometry{Type: geojson.LineString, LineString: r.positions(dims, hasZ)}
+	case 3:
+		rings := make([][]geojson.Position, r.count())
+		for i := range rings {
+			rings[i] = r.positions(dims, hasZ)
+		}
+		return &geojson.Geometry{Type: geojson.Polygon, Polygon: rings}
+	case 4, 5, 6, 7:
+		n := r.count()
+		members := make([]*geojson.Geometry, 0, n)
+		for i := 0; i < n && r.err == nil; i++ {
+			if m := r.geometry(depth + 1); m != nil {
+				members = append(members, m)
+			}
+		}
+		return multi(code%1000, members)
+	}
+	r.err = fmt.Errorf("%w: unsupported WKB geometry type %d", ErrImport, code)
+	return nil
+}
+
+// multi builds a multi geometry or collection of WKB type code from its
+// members
+func multi(code uint32, members []*geojson.Geometry) *geojson.Geometry {
+	var g *geojson.Geometry
+	switch code {
+	case 4:
+		g = &geojson.Geometry{Type: geojson.MultiPoint, MultiPoint: []geojson.Position{}}
+		for _, m := range members {
+			g.MultiPoint = append(g.MultiPoint, m.Point)
+		}
+	case 5:
+		g = &geojson.Geometry{Type: geojson.MultiLineString, MultiLineString: [][]geojson.Position{}}
+		for _, m := range members {
+			g.MultiLineString = append(g.MultiLineString, m.LineString)
+		}
+	case 6:
+		g = &geojson.Geometry{Type: geojson.MultiPolygon, MultiPolygon: [][][]geojson.Position{}}
+		for _, m := range members {
+			g.MultiPolygon = append(g.MultiPolygon, m.Polygon)
+		}
+	default:
+		g = &geojson.Geometry{Type: geojson.GeometryCollection, Geometries: members}
+	}
+	return g
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
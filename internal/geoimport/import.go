@@ -0,0 +1,195 @@
+// Package geoimport reads the spatial files users upload: GeoJSON, KML,
+// zipped Shapefile, GeoPackage and CSV with coordinate or WKT columns. Every
+// file becomes one or more layers of validated GeoJSON features in WGS84.
+package geoimport
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"kdg/be/lab/internal/crs"
+	"kdg/be/lab/internal/geojson"
+	"kdg/be/lab/internal/sniff"
+)
+
+// ErrImport is returned for files that cannot be read as spatial data
+var ErrImport = errors.New("geoimport: unreadable file")
+
+// Layer is a named set of features read from a file: a GeoPackage table, a
+// KML folder, a Shapefile in an archive or the whole file. Single layer
+// formats leave the name empty.
+type Layer struct {
+	Name     string
+	Features *geojson.FeatureCollection
+
+	// Source is the system the coordinates were read in, and Fixes
+	// describes the reprojection and repairs, as made by Normalize
+	Source *crs.CRS
+	Fixes  []string
+}
+
+// Supported reports whether Read can parse a document type
+func Supported(docType string) bool {
+	switch docType {
+	case sniff.GeoJSON, sniff.KML, sniff.Shapefile, sniff.GPKG, sniff.CSV:
+		return true
+	}
+	return false
+}
+
+// Read parses a spatial file of a sniffed document type into layers with
+// WGS84 coordinates. fallback is the system assumed for coordinates that
+// are not degrees in files that do not name their system.
+func Read(data []byte, docType string, fallback *crs.CRS) ([]*Layer, error) {
+	var layers []*Layer
+	var err error
+	switch docType {
+	case sniff.GeoJSON:
+		var fc *geojson.FeatureCollection
+		fc, err = geojson.Decode(data)
+		layers = []*Layer{{Features: fc}}
+	case sniff.KML:
+		layers, err = readKML(data)
+	case sniff.Shapefile:
+		layers, err = readShapefile(data)
+	case sniff.GPKG:
+		layers, err = readGeoPackage(data)
+	case sniff.CSV:
+		layers, err = readCSV(data)
+	default:
+		return nil, fmt.Errorf("%w: %s is not a spatial format", ErrImport, docType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("%w: the file holds no features", ErrImport)
+	}
+
+	for _, l := range layers {
+		l.Fixes, err = Normalize(l.Features, l.Source, fallback)
+		if err != nil {
+			if l.Name != "" {
+				return nil, fmt.Errorf("layer %q: %w", l.Name, err)
+			}
+			return nil, err
+		}
+	}
+	return layers, nil
+}
+
+// Normalize reprojects features to WGS84 from source, or when that is nil
+// from the system named by their crs member, fallback or a guessed
+// national grid, before repairing and validating them. The reprojection is
+// reported as the first fix.
+func Normalize(fc *geojson.FeatureCollection, source, fallback *crs.CRS) ([]string, error) {
+	if source == nil {
+		extent, _ := fc.Bounds()
+		var err error
+		source, err = crs.Detect(fc.CRS, fallback, extent)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var fixes []string
+	if !source.IsWGS84() {
+		fc.Transform(crs.Transform(source, crs.WGS84))
+		fixes = append(fixes, fmt.Sprintf("$: reprojected from %s (%s)", source, source.Name))
+	}
+
+	fixes = append(fixes, fc.Repair()...)
+	if err := fc.Validate(); err != nil {
+		return fixes, err
+	}
+	return fixes, nil
+}
+
+// Find returns the layer with a name; the empty name stands for the first
+// layer
+func Find(layers []*Layer, name string) (*Layer, error) {
+	for _, l := range layers {
+		if l.Name == name || name == "" {
+			return l, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no layer %q in the file", ErrImport, name)
+}
+
+// encodeProperties writes properties as a JSON object in the given order
+func encodeProperties(keys []string, values map[string]interface{}) json.RawMessage {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(values[key])
+		if err != nil {
+			v = []byte("null")
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes()
+}
+
+// textValue turns a text attribute into a property: null when blank, a
+// number or boolean when it reads as one and else the trimmed text
+func textValue(s string) interface{} {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "":
+		return nil
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if n, ok := numberValue(s); ok {
+		return n
+	}
+	return s
+}
+
+// numberValue reads a decimal number. Leading zeros and plus signs, as in
+// postal codes and phone numbers, keep text as text.
+func numberValue(s string) (json.Number, bool) {
+	digits := strings.TrimPrefix(s, "-")
+	if strings.HasPrefix(s, "+") || (len(digits) > 1 && digits[0] == '0' && digits[1] != '.') {
+		return "", false
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return json.Number(strconv.FormatInt(i, 10)), true
+	}
+	if !strings.ContainsAny(s, "0123456789") || strings.ContainsAny(s, "xXpP_") {
+		return "", false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return "", false
+	}
+	return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), true
+}
+
+// decodeText reads text in an unknown encoding: UTF-8 when it is valid and
+// Latin-1 otherwise, which older GIS files use
+func decodeText(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
@@ -0,0 +1,271 @@
+package geoimport
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"kdg/be/lab/internal/crs"
+	"kdg/be/lab/internal/geojson"
+)
+
+// kmlNode is an element of a KML document. Namespaces are ignored, so
+// elements are matched by their local name.
+type kmlNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Text     string     `xml:",chardata"`
+	Children []*kmlNode `xml:",any"`
+}
+
+func (n *kmlNode) child(name string) *kmlNode {
+	for _, c := range n.Children {
+		if c.XMLName.Local == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (n *kmlNode) childText(name string) string {
+	if c := n.child(name); c != nil {
+		return strings.TrimSpace(c.Text)
+	}
+	return ""
+}
+
+func (n *kmlNode) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// readKML makes a layer of the placemarks of every document and folder.
+// KML coordinates are always WGS84.
+func readKML(data []byte) ([]*Layer, error) {
+	var root kmlNode
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	if err := d.Decode(&root); err != nil {
+		return nil, fmt.Errorf("%w: invalid KML: %v", ErrImport, err)
+	}
+
+	var layers []*Layer
+	var walk func(n *kmlNode, name string) error
+	walk = func(n *kmlNode, name string) error {
+		if title := n.childText("name"); title != "" {
+			name = title
+		}
+		var features []*geojson.Feature
+		for _, c := range n.Children {
+			switch c.XMLName.Local {
+			case "Placemark":
+				f, err := kmlPlacemark(c)
+				if err != nil {
+					return err
+				}
+				features = append(features, f)
+			case "Document", "Folder":
+				if err := walk(c, name); err != nil {
+					return err
+				}
+			}
+		}
+		if len(features) > 0 {
+			layers = append(layers, &Layer{Name: name, Features: &geojson.FeatureCollection{Features: features}, Source: crs.WGS84})
+		}
+		return nil
+	}
+	if err := walk(&root, ""); err != nil {
+		return nil, err
+	}
+
+	// Folders of the same name are merged into one layer
+	merged := layers[:0]
+	index := map[string]*Layer{}
+	for _, l := range layers {
+		if first, ok := index[l.Name]; ok {
+			first.Features.Features = append(first.Features.Features, l.Features.Features...)
+			continue
+		}
+		index[l.Name] = l
+		merged = append(merged, l)
+	}
+	return merged, nil
+}
+
+// kmlPlacemark reads the name, description and extended data of a
+// placemark as properties
+func kmlPlacemark(n *kmlNode) (*geojson.Feature, error) {
+	var keys []string
+	values := map[string]interface{}{}
+	set := func(key string, v interface{}) {
+		if _, seen := values[key]; !seen {
+			keys = append(keys, key)
+		}
+		values[key] = v
+	}
+
+	if n.child("name") != nil {
+		set("name", n.childText("name"))
+	}
+	if n.child("description") != nil {
+		set("description", n.childText("description"))
+	}
+	if ext := n.child("ExtendedData"); ext != nil {
+		for _, c := range ext.Children {
+			switch c.XMLName.Local {
+			case "Data":
+				set(c.attr("name"), textValue(c.childText("value")))
+			case "SchemaData":
+				for _, sd := range c.Children {
+					if sd.XMLName.Local == "SimpleData" {
+						set(sd.attr("name"), textValue(sd.Text))
+					}
+				}
+			}
+		}
+	}
+
+	f := &geojson.Feature{Properties: encodeProperties(keys, values)}
+	if id := n.attr("id"); id != "" {
+		f.ID, _ = json.Marshal(id)
+	}
+	for _, c := range n.Children {
+		g, err := kmlGeometry(c)
+		if err != nil {
+			return nil, err
+		}
+		if g != nil {
+			f.Geometry = g
+			break
+		}
+	}
+	return f, nil
+}
+
+// kmlGeometry reads a geometry element, or returns nil for other elements
+func kmlGeometry(n *kmlNode) (*geojson.Geometry, error) {
+	switch n.XMLName.Local {
+	case "Point":
+		ps, err := kmlCoordinates(n.childText("coordinates"))
+		if err != nil || len(ps) == 0 {
+			return nil, err
+		}
+		return &geojson.Geometry{Type: geojson.Point, Point: ps[0]}, nil
+
+	case "LineString", "LinearRing":
+		ps, err := kmlCoordinates(n.childText("coordinates"))
+		if err != nil {
+			return nil, err
+		}
+		return &geojson.Geometry{Type: geojson.LineString, LineString: ps}, nil
+
+	case "Polygon":
+		var rings [][]geojson.Position
+		for _, boundary := range []string{"outerBoundaryIs", "innerBoundaryIs"} {
+			for _, c := range n.Children {
+				if c.XMLName.Local != boundary {
+					continue
+				}
+				ring := c.child("LinearRing")
+				if ring == nil {
+					continue
+				}
+				ps, err := kmlCoordinates(ring.childText("coordinates"))
+				if err != nil {
+					return nil, err
+				}
+				rings = append(rings, ps)
+			}
+		}
+		return &geojson.Geometry{Type: geojson.Polygon, Polygon: rings}, nil
+
+	case "MultiGeometry":
+		var members []*geojson.Geometry
+		for _, c := range n.Children {
+			g, err := kmlGeometry(c)
+			if err != nil {
+				return nil, err
+			}
+			if g != nil {
+				members = append(members, g)
+			}
+		}
+		return collect(members), nil
+	}
+	return nil, nil
+}
+
+// kmlCoordinates reads longitude,latitude[,altitude] tuples separated by
+// white space
+func kmlCoordinates(s string) ([]geojson.Position, error) {
+	var ps []geojson.Position
+	for _, tuple := range strings.Fields(s) {
+		parts := strings.Split(tuple, ",")
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("%w: invalid KML coordinates %q", ErrImport, tuple)
+		}
+		p := make(geojson.Position, 0, 3)
+		for i, part := range parts {
+			if i == 3 {
+				break
+			}
+			v, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid KML coordinates %q", ErrImport, tuple)
+			}
+			p = append(p, v)
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// collect joins geometries into the multi geometry of their type, or a
+// geometry collection when their types differ
+func collect(members []*geojson.Geometry) *geojson.Geometry {
+	if len(members) == 0 {
+		return nil
+	}
+	if len(members) == 1 {
+		return members[0]
+	}
+
+	t := members[0].Type
+	for _, m := range members {
+		if m.Type != t {
+			return &geojson.Geometry{Type: geojson.GeometryCollection, Geometries: members}
+		}
+	}
+	switch t {
+	case geojson.Point:
+		g := &geojson.Geometry{Type: geojson.MultiPoint}
+		for _, m := range members {
+			g.MultiPoint = append(g.MultiPoint, m.Point)
+		}
+		return g
+	case geojson.LineString:
+		g := &geojson.Geometry{Type: geojson.MultiLineString}
+		for _, m := range members {
+			g.MultiLineString = append(g.MultiLineString, m.LineString)
+		}
+		return g
+	case geojson.Polygon:
+		g := &geojson.Geometry{Type: geojson.MultiPolygon}
+		for _, m := range members {
+			g.MultiPolygon = append(g.MultiPolygon, m.Polygon)
+		}
+		return g
+	}
+	return &geojson.Geometry{Type: geojson.GeometryCollection, Geometries: members}
+}
@@ -0,0 +1,445 @@
+package geoimport
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"sort"
+	"strings"
+
+	"kdg/be/lab/internal/crs"
+	"kdg/be/lab/internal/geojson"
+)
+
+// Shape types, with their Z and M variants read as the plain type
+const (
+	shpNull       = 0
+	shpPoint      = 1
+	shpPolyLine   = 3
+	shpPolygon    = 5
+	shpMultiPoint = 8
+)
+
+// maxShapefilePart bounds the uncompressed size of a file in the archive
+const maxShapefilePart = 512 << 20
+
+// readShapefile makes a layer of every .shp file in a zip archive, with the
+// attributes of its .dbf file and the system of its .prj file
+func readShapefile(data []byte) ([]*Layer, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid zip archive: %v", ErrImport, err)
+	}
+
+	// Members by lowercased path without extension, then extension
+	parts := map[string]map[string]*zip.File{}
+	var bases []string
+	for _, f := range archive.File {
+		ext := strings.ToLower(path.Ext(f.Name))
+		base := strings.ToLower(strings.TrimSuffix(f.Name, path.Ext(f.Name)))
+		if strings.HasPrefix(path.Base(f.Name), "._") {
+			continue // macOS resource forks
+		}
+		if parts[base] == nil {
+			parts[base] = map[string]*zip.File{}
+		}
+		parts[base][ext] = f
+		if ext == ".shp" {
+			bases = append(bases, base)
+		}
+	}
+	sort.Strings(bases)
+
+	var layers []*Layer
+	for _, base := range bases {
+		members := parts[base]
+		shp := members[".shp"]
+		name := strings.TrimSuffix(path.Base(shp.Name), path.Ext(shp.Name))
+		l, err := readShapefileLayer(members)
+		if err != nil {
+			return nil, fmt.Errorf("layer %q: %w", name, err)
+		}
+		l.Name = name
+		layers = append(layers, l)
+	}
+	return layers, nil
+}
+
+func readShapefileLayer(members map[string]*zip.File) (*Layer, error) {
+	if members[".dbf"] == nil {
+		return nil, fmt.Errorf("%w: the .dbf file is missing", ErrImport)
+	}
+
+	shp, err := readZipFile(members[".shp"])
+	if err != nil {
+		return nil, err
+	}
+	dbf, err := readZipFile(members[".dbf"])
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Layer{}
+	if prj := members[".prj"]; prj != nil {
+		wkt, err := readZipFile(prj)
+		if err != nil {
+			return nil, err
+		}
+		if l.Source, err = crs.FromWKT(string(wkt)); err != nil {
+			return nil, err
+		}
+	}
+
+	geometries, err := readShapes(shp)
+	if err != nil {
+		return nil, err
+	}
+	records, err := readDBF(dbf)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) != len(geometries) {
+		return nil, fmt.Errorf("%w: %d shapes but %d attribute records", ErrImport, len(geometries), len(records))
+	}
+
+	l.Features = geojson.NewFeatureCollection()
+	for i, g := range geometries {
+		if records[i] == nil {
+			continue // deleted record
+		}
+		l.Features.Features = append(l.Features.Features, &geojson.Feature{Geometry: g, Properties: records[i]})
+	}
+	return l, nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrImport, f.Name, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxShapefilePart+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrImport, f.Name, err)
+	}
+	if len(data) > maxShapefilePart {
+		return nil, fmt.Errorf("%w: %s is too large", ErrImport, f.Name)
+	}
+	return data, nil
+}
+
+// shpReader reads little-endian values, failing once past the end
+type shpReader struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (r *shpReader) take(n int) []byte {
+	if r.err != nil || n < 0 || r.off+n > len(r.b) {
+		r.err = fmt.Errorf("%w: truncated shape record", ErrImport)
+		return nil
+	}
+	b := r.b[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *shpReader) int32() int {
+	b := r.take(4)
+	if b == nil {
+		return 0
+	}
+	return int(int32(binary.LittleEndian.Uint32(b)))
+}
+
+func (r *shpReader) float64() float64 {
+	b := r.take(8)
+	if b == nil {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
+
+// remaining is the number of unread bytes
+func (r *shpReader) remaining() int {
+	return len(r.b) - r.off
+}
+
+func (r *shpReader) points(n int) []geojson.Position {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > r.remaining()/16 {
+		r.err = fmt.Errorf("%w: invalid point count", ErrImport)
+		return nil
+	}
+	ps := make([]geojson.Position, n)
+	for i := range ps {
+		ps[i] = geojson.Position{r.float64(), r.float64()}
+	}
+	return ps
+}
+
+// readShapes reads the geometry of every record of a .shp file. Heights and
+// measures are dropped.
+func readShapes(data []byte) ([]*geojson.Geometry, error) {
+	if len(data) < 100 || binary.BigEndian.Uint32(data) != 9994 {
+		return nil, fmt.Errorf("%w: not a .shp file", ErrImport)
+	}
+
+	var geometries []*geojson.Geometry
+	for off := 100; off+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[off+4:])) * 2
+		off += 8
+		if length < 4 || off+length > len(data) {
+			return nil, fmt.Errorf("%w: truncated shape record %d", ErrImport, len(geometries)+1)
+		}
+		g, err := readShape(data[off : off+length])
+		if err != nil {
+			return nil, fmt.Errorf("shape record %d: %w", len(geometries)+1, err)
+		}
+		geometries = append(geometries, g)
+		off += length
+	}
+	return geometries, nil
+}
+
+func readShape(b []byte) (*geojson.Geometry, error) {
+	r := &shpReader{b: b}
+	shapeType := r.int32()
+	if shapeType == shpNull {
+		return nil, nil
+	}
+
+	var g *geojson.Geometry
+	switch shapeType % 10 {
+	case shpPoint:
+		p := geojson.Position{r.float64(), r.float64()}
+		if math.IsNaN(p[0]) || math.IsNaN(p[1]) {
+			return nil, r.err
+		}
+		g = &geojson.Geometry{Type: geojson.Point, Point: p}
+
+	case shpMultiPoint:
+		r.take(32) // bounding box
+		ps := r.points(r.int32())
+		g = &geojson.Geometry{Type: geojson.MultiPoint, MultiPoint: ps}
+		if len(ps) == 1 {
+			g = &geojson.Geometry{Type: geojson.Point, Point: ps[0]}
+		}
+
+	case shpPolyLine, shpPolygon:
+		r.take(32)
+		numParts, numPoints := r.int32(), r.int32()
+		if r.err != nil {
+			return nil, r.err
+		}
+		if numParts < 0 || numParts > r.remaining()/4 {
+			return nil, fmt.Errorf("%w: invalid part count", ErrImport)
+		}
+		starts := make([]int, numParts)
+		for i := range starts {
+			starts[i] = r.int32()
+		}
+		ps := r.points(numPoints)
+		if r.err != nil {
+			return nil, r.err
+		}
+
+		lines := make([][]geojson.Position, 0, numParts)
+		for i, start := range starts {
+			end := len(ps)
+			if i+1 < len(starts) {
+				end = starts[i+1]
+			}
+			if start < 0 || start > end || end > len(ps) {
+				return nil, fmt.Errorf("%w: invalid part offsets", ErrImport)
+			}
+			lines = append(lines, ps[start:end])
+		}
+
+		switch {
+		case shapeType%10 == shpPolygon:
+			g = shapePolygon(lines)
+		case len(lines) == 1:
+			g = &geojson.Geometry{Type: geojson.LineString, LineString: lines[0]}
+		default:
+			g = &geojson.Geometry{Type: geojson.MultiLineString, MultiLineString: lines}
+		}
+
+	default:
+		return nil, fmt.Errorf("%w: unsupported shape type %d", ErrImport, shapeType)
+	}
+	return g, r.err
+}
+
+// shapePolygon groups rings into polygons. Shapefile exterior rings run
+// clockwise and holes counter-clockwise; a hole belongs to the exterior
+// that holds it. Rings are reversed to the GeoJSON winding order.
+func shapePolygon(rings [][]geojson.Position) *geojson.Geometry {
+	var polygons [][][]geojson.Position
+	var holes [][]geojson.Position
+	for _, ring := range rings {
+		if len(ring) == 0 {
+			continue
+		}
+		reverse(ring)
+		if ringArea(ring) >= 0 {
+			polygons = append(polygons, [][]geojson.Position{ring})
+		} else {
+			holes = append(holes, ring)
+		}
+	}
+
+	// Without any exterior the winding was wrong and every ring is one
+	if len(polygons) == 0 {
+		for _, hole := range holes {
+			polygons = append(polygons, [][]geojson.Position{hole})
+		}
+		holes = nil
+	}
+
+	for _, hole := range holes {
+		owner := len(polygons) - 1
+		for i, p := range polygons {
+			if containsPoint(p[0], hole[0]) {
+				owner = i
+				break
+			}
+		}
+		polygons[owner] = append(polygons[owner], hole)
+	}
+
+	if len(polygons) == 1 {
+		return &geojson.Geometry{Type: geojson.Polygon, Polygon: polygons[0]}
+	}
+	return &geojson.Geometry{Type: geojson.MultiPolygon, MultiPolygon: polygons}
+}
+
+func reverse(ring []geojson.Position) {
+	for i, j := 0, len(ring)-1; i < j; i, j = i+1, j-1 {
+		ring[i], ring[j] = ring[j], ring[i]
+	}
+}
+
+// ringArea is the signed area of a ring, positive when counter-clockwise
+func ringArea(ring []geojson.Position) float64 {
+	var sum float64
+	for i := 0; i+1 < len(ring); i++ {
+		sum += ring[i][0]*ring[i+1][1] - ring[i+1][0]*ring[i][1]
+	}
+	return sum / 2
+}
+
+// containsPoint tests a point against a ring by ray casting
+func containsPoint(ring []geojson.Position, p geojson.Position) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > p[1]) != (b[1] > p[1]) && p[0] < (b[0]-a[0])*(p[1]-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// dbfField is a column of a dBase table
+type dbfField struct {
+	name     string
+	kind     byte
+	length   int
+	decimals int
+}
+
+// readDBF reads the records of a dBase table as feature properties. Deleted
+// records are nil.
+func readDBF(data []byte) ([][]byte, error) {
+	if len(data) < 32 {
+		return nil, fmt.Errorf("%w: not a .dbf file", ErrImport)
+	}
+	count := int(binary.LittleEndian.Uint32(data[4:]))
+	headerLen := int(binary.LittleEndian.Uint16(data[8:]))
+	recordLen := int(binary.LittleEndian.Uint16(data[10:]))
+	if headerLen < 32 || headerLen > len(data) || recordLen < 1 {
+		return nil, fmt.Errorf("%w: invalid .dbf header", ErrImport)
+	}
+	// The record count comes from the file; check it before allocating
+	if count > (len(data)-headerLen)/recordLen {
+		return nil, fmt.Errorf("%w: the .dbf header claims %d records but the file is too short", ErrImport, count)
+	}
+
+	var fields []dbfField
+	width := 1 // deletion flag
+	for off := 32; off+32 <= headerLen && data[off] != 0x0D; off += 32 {
+		d := data[off : off+32]
+		name := d[:11]
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+		f := dbfField{name: strings.TrimSpace(decodeText(name)), kind: d[11], length: int(d[16]), decimals: int(d[17])}
+		fields = append(fields, f)
+		width += f.length
+	}
+	if width > recordLen {
+		return nil, fmt.Errorf("%w: .dbf fields do not fit the records", ErrImport)
+	}
+
+	records := make([][]byte, 0, count)
+	keys := make([]string, len(fields))
+	for i, f := range fields {
+		keys[i] = f.name
+	}
+	for i := 0; i < count; i++ {
+		off := headerLen + i*recordLen
+		if off+recordLen > len(data) {
+			return nil, fmt.Errorf("%w: truncated .dbf file", ErrImport)
+		}
+		rec := data[off : off+recordLen]
+		if rec[0] == '*' {
+			records = append(records, nil)
+			continue
+		}
+
+		values := map[string]interface{}{}
+		pos := 1
+		for _, f := range fields {
+			values[f.name] = dbfValue(f, rec[pos:pos+f.length])
+			pos += f.length
+		}
+		records = append(records, encodeProperties(keys, values))
+	}
+	return records, nil
+}
+
+// dbfValue converts a field of a record to a property value
+func dbfValue(f dbfField, raw []byte) interface{} {
+	s := strings.TrimSpace(decodeText(bytes.TrimRight(raw, "\x00")))
+	switch f.kind {
+	case 'N', 'F':
+		if n, ok := numberValue(s); ok {
+			return n
+		}
+		return nil
+	case 'L':
+		switch strings.ToUpper(s) {
+		case "T", "Y":
+			return true
+		case "F", "N":
+			return false
+		}
+		return nil
+	case 'D':
+		if len(s) == 8 {
+			return s[:4] + "-" + s[4:6] + "-" + s[6:]
+		}
+	}
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,218 @@
+package geoimport
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"kdg/be/lab/internal/geojson"
+)
+
+// ParseWKT reads a geometry in well-known text, optionally with the SRID
+// prefix of PostGIS EWKT, which is returned or 0. Measures are dropped;
+// blank text and empty geometries return nil.
+func ParseWKT(s string) (*geojson.Geometry, int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, 0, nil
+	}
+
+	srid := 0
+	if strings.HasPrefix(strings.ToUpper(s), "SRID=") {
+		end := strings.IndexByte(s, ';')
+		if end < 0 {
+			return nil, 0, fmt.Errorf("%w: invalid WKT SRID", ErrImport)
+		}
+		var err error
+		if srid, err = strconv.Atoi(strings.TrimSpace(s[5:end])); err != nil {
+			return nil, 0, fmt.Errorf("%w: invalid WKT SRID", ErrImport)
+		}
+		s = s[end+1:]
+	}
+
+	p := &wktParser{s: s}
+	g := p.geometry(0)
+	if p.err == nil && p.next() != "" {
+		p.fail("unexpected text after the geometry")
+	}
+	if p.err != nil {
+		return nil, 0, p.err
+	}
+	return g, srid, nil
+}
+
+// wktParser reads WKT tokens: words, numbers and punctuation
+type wktParser struct {
+	s   string
+	off int
+	err error
+}
+
+func (p *wktParser) fail(format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = fmt.Errorf("%w: invalid WKT: %s", ErrImport, fmt.Sprintf(format, args...))
+	}
+}
+
+// peek returns the next token without consuming it
+func (p *wktParser) peek() string {
+	off := p.off
+	t := p.next()
+	p.off = off
+	return t
+}
+
+func (p *wktParser) next() string {
+	for p.off < len(p.s) && strings.ContainsRune(" \t\r\n", rune(p.s[p.off])) {
+		p.off++
+	}
+	if p.off >= len(p.s) {
+		return ""
+	}
+	start := p.off
+	if strings.ContainsRune("(),", rune(p.s[p.off])) {
+		p.off++
+		return p.s[start:p.off]
+	}
+	for p.off < len(p.s) && !strings.ContainsRune(" \t\r\n(),", rune(p.s[p.off])) {
+		p.off++
+	}
+	return p.s[start:p.off]
+}
+
+func (p *wktParser) expect(t string) {
+	if got := p.next(); got != t && p.err == nil {
+		p.fail("expected %q, found %q", t, got)
+	}
+}
+
+func (p *wktParser) geometry(depth int) *geojson.Geometry {
+	if depth > 8 {
+		p.fail("geometries nested too deeply")
+		return nil
+	}
+	kind := strings.ToUpper(p.next())
+
+	// Dimensions come as a separate word or a suffix: POINT Z, POINTZ
+	dims := ""
+	for _, suffix := range []string{"ZM", "Z", "M"} {
+		if base := strings.TrimSuffix(kind, suffix); base != kind && wktTypes[base] {
+			kind, dims = base, suffix
+			break
+		}
+	}
+	if dims == "" {
+		switch d := strings.ToUpper(p.peek()); d {
+		case "Z", "M", "ZM":
+			p.next()
+			dims = d
+		}
+	}
+	if !wktTypes[kind] {
+		p.fail("unknown geometry type %q", kind)
+		return nil
+	}
+
+	if strings.ToUpper(p.peek()) == "EMPTY" {
+		p.next()
+		return nil
+	}
+
+	switch kind {
+	case "POINT":
+		p.expect("(")
+		pos := p.position(dims)
+		p.expect(")")
+		return &geojson.Geometry{Type: geojson.Point, Point: pos}
+	case "LINESTRING":
+		return &geojson.Geometry{Type: geojson.LineString, LineString: p.positions(dims)}
+	case "POLYGON":
+		return &geojson.Geometry{Type: geojson.Polygon, Polygon: p.rings(dims)}
+	case "MULTIPOINT":
+		g := &geojson.Geometry{Type: geojson.MultiPoint}
+		p.list(func() {
+			// Points may or may not be in parentheses
+			if p.peek() == "(" {
+				p.next()
+				g.MultiPoint = append(g.MultiPoint, p.position(dims))
+				p.expect(")")
+			} else {
+				g.MultiPoint = append(g.MultiPoint, p.position(dims))
+			}
+		})
+		return g
+	case "MULTILINESTRING":
+		return &geojson.Geometry{Type: geojson.MultiLineString, MultiLineString: p.rings(dims)}
+	case "MULTIPOLYGON":
+		g := &geojson.Geometry{Type: geojson.MultiPolygon}
+		p.list(func() { g.MultiPolygon = append(g.MultiPolygon, p.rings(dims)) })
+		return g
+	default: // GEOMETRYCOLLECTION
+		g := &geojson.Geometry{Type: geojson.GeometryCollection, Geometries: []*geojson.Geometry{}}
+		p.list(func() {
+			if m := p.geometry(depth + 1); m != nil {
+				g.Geometries = append(g.Geometries, m)
+			}
+		})
+		return g
+	}
+}
+
+var wktTypes = map[string]bool{
+	"POINT": true, "LINESTRING": true, "POLYGON": true, "MULTIPOINT": true,
+	"MULTILINESTRING": true, "MULTIPOLYGON": true, "GEOMETRYCOLLECTION": true,
+}
+
+// list reads a parenthesised, comma separated list
+func (p *wktParser) list(item func()) {
+	p.expect("(")
+	for p.err == nil {
+		item()
+		if t := p.next(); t == ")" {
+			return
+		} else if t != "," {
+			p.fail("expected \",\" or \")\", found %q", t)
+		}
+	}
+}
+
+func (p *wktParser) positions(dims string) []geojson.Position {
+	var ps []geojson.Position
+	p.list(func() { ps = append(ps, p.position(dims)) })
+	return ps
+}
+
+func (p *wktParser) rings(dims string) [][]geojson.Position {
+	var rings [][]geojson.Position
+	p.list(func() { rings = append(rings, p.positions(dims)) })
+	return rings
+}
+
+// position reads the numbers of a position, keeping the height but not the
+// measure
+func (p *wktParser) position(dims string) geojson.Position {
+	var values []float64
+	for p.err == nil {
+		t := p.peek()
+		if t == "" || t == "," || t == ")" || t == "(" {
+			break
+		}
+		p.next()
+		v, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			p.fail("invalid number %q", t)
+			return nil
+		}
+		values = append(values, v)
+	}
+	if len(values) < 2 || len(values) > 4 {
+		p.fail("positions need 2 to 4 numbers")
+		return nil
+	}
+	if dims == "M" {
+		values = values[:2]
+	} else if len(values) > 3 {
+		values = values[:3]
+	}
+	return geojson.Position(values)
+}
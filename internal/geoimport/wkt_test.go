@@ -0,0 +1,87 @@
+package geoimport
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"kdg/be/lab/internal/geoexport"
+	"kdg/be/lab/internal/geojson"
+)
+
+// Geometries written by the exporter read back unchanged
+func TestWKTRoundTrip(t *testing.T) {
+	tests := []string{
+		`{"type":"Point","coordinates":[4.25,-51.125]}`,
+		`{"type":"MultiPoint","coordinates":[[4,51],[4.5,51.5]]}`,
+		`{"type":"LineString","coordinates":[[0,0],[1e-7,123456.789]]}`,
+		`{"type":"MultiLineString","coordinates":[[[0,0],[1,1]],[[2,2],[3,3],[4,2]]]}`,
+		`{"type":"Polygon","coordinates":[[[0,0],[4,0],[4,4],[0,0]],[[1,1],[2,2],[2,1],[1,1]]]}`,
+		`{"type":"MultiPolygon","coordinates":[[[[0,0],[1,0],[1,1],[0,0]]],[[[2,0],[3,0],[3,1],[2,0]],[[2.1,0.1],[2.2,0.2],[2.2,0.1],[2.1,0.1]]]]}`,
+		`{"type":"GeometryCollection","geometries":[{"type":"Point","coordinates":[1,2]},{"type":"LineString","coordinates":[[0,0],[1,1]]}]}`,
+	}
+
+	for _, want := range tests {
+		g := &geojson.Geometry{}
+		if err := json.Unmarshal([]byte(want), g); err != nil {
+			t.Fatal(err)
+		}
+		text := geoexport.WKT(g)
+		parsed, srid, err := ParseWKT(text)
+		if err != nil {
+			t.Errorf("ParseWKT(%s): %v", text, err)
+			continue
+		}
+		got, _ := json.Marshal(parsed)
+		if string(got) != want || srid != 0 {
+			t.Errorf("ParseWKT(%s) = %s, %d, want %s", text, got, srid, want)
+		}
+	}
+}
+
+func TestParseWKT(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    string
+		srid    int
+		wantErr bool
+	}{
+		{"POINT (1 2)", `{"type":"Point","coordinates":[1,2]}`, 0, false},
+		{"point(1 2)", `{"type":"Point","coordinates":[1,2]}`, 0, false},
+		{"POINT Z (1 2 3)", `{"type":"Point","coordinates":[1,2,3]}`, 0, false},
+		{"POINTZ (1 2 3)", `{"type":"Point","coordinates":[1,2,3]}`, 0, false},
+		{"POINT M (1 2 3)", `{"type":"Point","coordinates":[1,2]}`, 0, false},
+		{"POINT ZM (1 2 3 4)", `{"type":"Point","coordinates":[1,2,3]}`, 0, false},
+		{"SRID=31370;POINT (150000 170000)", `{"type":"Point","coordinates":[150000,170000]}`, 31370, false},
+		{"MULTIPOINT (1 2, 3 4)", `{"type":"MultiPoint","coordinates":[[1,2],[3,4]]}`, 0, false},
+		{"GEOMETRYCOLLECTION (POINT EMPTY, POINT (1 2))", `{"type":"GeometryCollection","geometries":[{"type":"Point","coordinates":[1,2]}]}`, 0, false},
+		{"POLYGON EMPTY", `null`, 0, false},
+		{"  ", `null`, 0, false},
+		{"CIRCLE (1 2)", "", 0, true},
+		{"POINT (1)", "", 0, true},
+		{"POINT (1 x)", "", 0, true},
+		{"LINESTRING (1 2, 3 4", "", 0, true},
+		{"POINT (1 2) POINT (3 4)", "", 0, true},
+		{"SRID=x;POINT (1 2)", "", 0, true},
+		{"SRID=4326 POINT (1 2)", "", 0, true},
+		{"GEOMETRYCOLLECTION (GEOMETRYCOLLECTION (GEOMETRYCOLLECTION (GEOMETRYCOLLECTION (GEOMETRYCOLLECTION (GEOMETRYCOLLECTION (GEOMETRYCOLLECTION (GEOMETRYCOLLECTION (GEOMETRYCOLLECTION (GEOMETRYCOLLECTION EMPTY)))))))))", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		g, srid, err := ParseWKT(tt.text)
+		if tt.wantErr {
+			if !errors.Is(err, ErrImport) {
+				t.Errorf("ParseWKT(%q) error = %v, want ErrImport", tt.text, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseWKT(%q): %v", tt.text, err)
+			continue
+		}
+		got, _ := json.Marshal(g)
+		if string(got) != tt.want || srid != tt.srid {
+			t.Errorf("ParseWKT(%q) = %s, %d, want %s, %d", tt.text, got, srid, tt.want, tt.srid)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package geojson
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ErrInvalid is wrapped by every decoding and validation error
+var ErrInvalid = errors.New("geojson: invalid document")
+
+// maxCollectionDepth limits how deep geometry collections may be nested
+const maxCollectionDepth = 8
+
+// Error is a problem at a path in a document, written like
+// $.features[3].geometry.coordinates[0][5]
+type Error struct {
+	Path    string
+	Message string
+}
+
+func (e *Error) Error() string { return e.Path + ": " + e.Message }
+func (e *Error) Unwrap() error { return ErrInvalid }
+
+// Errors lists every problem found in a document
+type Errors []*Error
+
+func (e Errors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d problems: %s", len(e), strings.Join(msgs, "; "))
+}
+
+func (e Errors) Unwrap() error { return ErrInvalid }
+
+// Decode reads a FeatureCollection, a single Feature or a bare geometry and
+// returns it as a feature collection. It fails on structural problems such
+// as unknown types or badly nested coordinates; use Validate for the rules
+// of RFC 7946 and Repair to fix what can be fixed.
+func Decode(data []byte) (*FeatureCollection, error) {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, Errors{{Path: "$", Message: err.Error()}}
+	}
+
+	d := &decoder{}
+	fc := d.document(v, "$")
+	if len(d.errs) > 0 {
+		return nil, d.errs
+	}
+	return fc, nil
+}
+
+// decoder converts generic JSON values into typed structs, collecting
+// errors with their paths
+type decoder struct {
+	errs Errors
+}
+
+func (d *decoder) fail(path, format string, args ...interface{}) {
+	d.errs = append(d.errs, &Error{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// object checks that v is an object with a string type member
+func (d *decoder) object(v interface{}, path string) (map[string]interface{}, string, bool) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		d.fail(path, "expected an object")
+		return nil, "", false
+	}
+	t, ok := m["type"].(string)
+	if !ok {
+		d.fail(path, "missing or non-string type member")
+		return nil, "", false
+	}
+	return m, t, true
+}
+
+func (d *decoder) document(v interface{}, path string) *FeatureCollection {
+	m, t, ok := d.object(v, path)
+	if !ok {
+		return nil
+	}
+
+	fc := NewFeatureCollection()
+	if member, present := m["crs"]; present && member != nil {
+		fc.CRS, _ = json.Marshal(member)
+	}
+
+	switch t {
+	case "FeatureCollection":
+		fc.BBox = d.bbox(m, path)
+		features, ok := m["features"].([]interface{})
+		if !ok {
+			d.fail(path, "features must be an array")
+			return nil
+		}
+		for i, f := range features {
+			if feature := d.feature(f, fmt.Sprintf("%s.features[%d]", path, i)); feature != nil {
+				fc.Features = append(fc.Features, feature)
+			}
+		}
+	case "Feature":
+		if feature := d.feature(v, path); feature != nil {
+			fc.Features = append(fc.Features, feature)
+		}
+	default:
+		if g := d.geometry(v, path, 0); g != nil {
+			fc.Features = append(fc.Features, &Feature{Geometry: g, Properties: json.RawMessage("{}")})
+		}
+	}
+	return fc
+}
+
+func (d *decoder) feature(v interface{}, path string) *Feature {
+	m, t, ok := d.object(v, path)
+	if !ok {
+		return nil
+	}
+	if t != "Feature" {
+		d.fail(path, "expected type Feature, got %q", t)
+		return nil
+	}
+
+	f := &Feature{BBox: d.bbox(m, path)}
+
+	if id, present := m["id"]; present {
+		switch id.(type) {
+		case string, float64:
+			f.ID, _ = json.Marshal(id)
+		default:
+			d.fail(path+".id", "id must be a string or a number")
+		}
+	}
+
+	geometry, present := m["geometry"]
+	switch {
+	case !present:
+		f.missingGeometry = true
+	case geometry != nil:
+		f.Geometry = d.geometry(geometry, path+".geometry", 0)
+	}
+
+	properties, present := m["properties"]
+	switch properties.(type) {
+	case map[string]interface{}:
+		f.Properties, _ = json.Marshal(properties)
+	case nil:
+		f.Properties = json.RawMessage("null")
+		f.missingProperties = !present
+	default:
+		d.fail(path+".properties", "properties must be an object or null")
+	}
+
+	return f
+}
+
+func (d *decoder) bbox(m map[string]interface{}, path string) []float64 {
+	v, present := m["bbox"]
+	if !present {
+		return nil
+	}
+	values, ok := v.([]interface{})
+	if !ok {
+		d.fail(path+".bbox", "bbox must be an array")
+		return nil
+	}
+	box := make([]float64, len(values))
+	for i, value := range values {
+		n, ok := value.(float64)
+		if !ok {
+			d.fail(fmt.Sprintf("%s.bbox[%d]", path, i), "expected a number")
+			return nil
+		}
+		box[i] = n
+	}
+	return box
+}
+
+func (d *decoder) geometry(v interface{}, path string, depth int) *Geometry {
+	m, t, ok := d.object(v, path)
+	if !ok {
+		return nil
+	}
+
+	g := &Geometry{Type: t, BBox: d.bbox(m, path)}
+
+	if t == GeometryCollection {
+		if depth >= maxCollectionDepth {
+			d.fail(path, "geometry collections nested more than %d deep", maxCollectionDepth)
+			return nil
+		}
+		members, ok := m["geometries"].([]interface{})
+		if !ok {
+			d.fail(path, "geometries must be an array")
+			return nil
+		}
+		g.Geometries = []*Geometry{}
+		for i, member := range members {
+			if mg := d.geometry(member, fmt.Sprintf("%s.geometries[%d]", path, i), depth+1); mg != nil {
+				g.Geometries = append(g.Geometries, mg)
+			}
+		}
+		return g
+	}
+
+	switch t {
+	case Point, MultiPoint, LineString, MultiLineString, Polygon, MultiPolygon:
+	default:
+		d.fail(path, "unknown geometry type %q", t)
+		return nil
+	}
+
+	coordinates, present := m["coordinates"]
+	if !present {
+		d.fail(path, "missing coordinates member")
+		return nil
+	}
+	path += ".coordinates"
+
+	switch t {
+	case Point:
+		g.Point = d.position(coordinates, path)
+	case MultiPoint:
+		g.MultiPoint = d.positions(coordinates, path)
+	case LineString:
+		g.LineString = d.positions(coordinates, path)
+	case MultiLineString:
+		g.MultiLineString = d.positions2(coordinates, path)
+	case Polygon:
+		g.Polygon = d.positions2(coordinates, path)
+	case MultiPolygon:
+		values, ok := d.array(coordinates, path)
+		if !ok {
+			return nil
+		}
+		g.MultiPolygon = make([][][]Position, len(values))
+		for i, value := range values {
+			g.MultiPolygon[i] = d.positions2(value, fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+	return g
+}
+
+func (d *decoder) array(v interface{}, path string) ([]interface{}, bool) {
+	values, ok := v.([]interface{})
+	if !ok {
+		d.fail(path, "expected an array")
+	}
+	return values, ok
+}
+
+// position reads an array of numbers. Nulls and the strings NaN, Infinity
+// and -Infinity, which some tools write, become non-finite values for
+// Validate to report and Repair to drop.
+func (d *decoder) position(v interface{}, path string) Position {
+	values, ok := d.array(v, path)
+	if !ok {
+		return nil
+	}
+	p := make(Position, len(values))
+	for i, value := range values {
+		switch n := value.(type) {
+		case float64:
+			p[i] = n
+		case nil:
+			p[i] = math.NaN()
+		case string:
+			switch n {
+			case "NaN":
+				p[i] = math.NaN()
+			case "Infinity":
+				p[i] = math.Inf(1)
+			case "-Infinity":
+				p[i] = math.Inf(-1)
+			default:
+				d.fail(fmt.Sprintf("%s[%d]", path, i), "expected a number")
+				return nil
+			}
+		default:
+			d.fail(fmt.Sprintf("%s[%d]", path, i), "expected a number")
+			return nil
+		}
+	}
+	return p
+}
+
+func (d *decoder) positions(v interface{}, path string) []Position {
+	values, ok := d.array(v, path)
+	if !ok {
+		return nil
+	}
+	ps := make([]Position, len(values))
+	for i, value := range values {
+		ps[i] = d.position(value, fmt.Sprintf("%s[%d]", path, i))
+	}
+	return ps
+}
+
+func (d *decoder) positions2(v interface{}, path string) [][]Position {
+	values, ok := d.array(v, path)
+	if !ok {
+		return nil
+	}
+	lines := make([][]Position, len(values))
+	for i, value := range values {
+		lines[i] = d.positions(value, fmt.Sprintf("%s[%d]", path, i))
+	}
+	return lines
+}
+
+// Parse decodes and validates a document. With repair set, fixable problems
+// are repaired before validation and the fixes are returned.
+func Parse(data []byte, repair bool) (*FeatureCollection, []string, error) {
+	fc, err := Decode(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var fixes []string
+	if repair {
+		fixes = fc.Repair()
+	}
+
+	if err := fc.Validate(); err != nil {
+		return nil, fixes, err
+	}
+	return fc, fixes, nil
+}
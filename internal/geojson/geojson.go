@@ -0,0 +1,321 @@
+// Package geojson reads, validates and repairs GeoJSON documents as defined
+// by RFC 7946, and filters feature collections by bounding box.
+package geojson
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Geometry types
+const (
+	Point              = "Point"
+	MultiPoint         = "MultiPoint"
+	LineString         = "LineString"
+	MultiLineString    = "MultiLineString"
+	Polygon            = "Polygon"
+	MultiPolygon       = "MultiPolygon"
+	GeometryCollection = "GeometryCollection"
+)
+
+// Position is a longitude, latitude and optional altitude
+type Position []float64
+
+// Geometry is a GeoJSON geometry. Only the coordinates field matching Type
+// is set; a GeometryCollection uses Geometries.
+type Geometry struct {
+	Type            string
+	Point           Position
+	MultiPoint      []Position
+	LineString      []Position
+	MultiLineString [][]Position
+	Polygon         [][]Position
+	MultiPolygon    [][][]Position
+	Geometries      []*Geometry
+	BBox            []float64
+}
+
+// Feature is a GeoJSON feature. ID and properties are kept as raw JSON so
+// they pass through unchanged.
+type Feature struct {
+	ID         json.RawMessage
+	Geometry   *Geometry
+	Properties json.RawMessage
+	BBox       []float64
+
+	// Set by Decode when the member was absent rather than null
+	missingGeometry   bool
+	missingProperties bool
+}
+
+// FeatureCollection is a GeoJSON feature collection
+type FeatureCollection struct {
+	Features []*Feature
+	BBox     []float64
+
+	// CRS is the crs member of the 2008 specification, read by Decode but
+	// never written: RFC 7946 positions are always WGS84
+	CRS json.RawMessage
+}
+
+// NewFeatureCollection returns an empty feature collection
+func NewFeatureCollection() *FeatureCollection {
+	return &FeatureCollection{Features: []*Feature{}}
+}
+
+// coordinates returns the coordinates field matching the geometry type
+func (g *Geometry) coordinates() interface{} {
+	switch g.Type {
+	case Point:
+		return g.Point
+	case MultiPoint:
+		return g.MultiPoint
+	case LineString:
+		return g.LineString
+	case MultiLineString:
+		return g.MultiLineString
+	case Polygon:
+		return g.Polygon
+	case MultiPolygon:
+		return g.MultiPolygon
+	}
+	return nil
+}
+
+func (g *Geometry) MarshalJSON() ([]byte, error) {
+	if g.Type == GeometryCollection {
+		geometries := g.Geometries
+		if geometries == nil {
+			geometries = []*Geometry{}
+		}
+		return json.Marshal(struct {
+			Type       string      `json:"type"`
+			BBox       []float64   `json:"bbox,omitempty"`
+			Geometries []*Geometry `json:"geometries"`
+		}{g.Type, g.BBox, geometries})
+	}
+
+	coordinates := g.coordinates()
+	if v, ok := coordinates.(Position); ok && v == nil {
+		coordinates = []float64{}
+	}
+	return json.Marshal(struct {
+		Type        string      `json:"type"`
+		BBox        []float64   `json:"bbox,omitempty"`
+		Coordinates interface{} `json:"coordinates"`
+	}{g.Type, g.BBox, coordinates})
+}
+
+func (g *Geometry) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return &Error{Path: "$", Message: err.Error()}
+	}
+	d := &decoder{}
+	parsed := d.geometry(v, "$", 0)
+	if len(d.errs) > 0 {
+		return d.errs
+	}
+	*g = *parsed
+	return nil
+}
+
+func (f *Feature) MarshalJSON() ([]byte, error) {
+	properties := f.Properties
+	if len(properties) == 0 {
+		properties = json.RawMessage("null")
+	}
+	return json.Marshal(struct {
+		Type       string          `json:"type"`
+		ID         json.RawMessage `json:"id,omitempty"`
+		BBox       []float64       `json:"bbox,omitempty"`
+		Geometry   *Geometry       `json:"geometry"`
+		Properties json.RawMessage `json:"properties"`
+	}{"Feature", f.ID, f.BBox, f.Geometry, properties})
+}
+
+func (fc *FeatureCollection) MarshalJSON() ([]byte, error) {
+	features := fc.Features
+	if features == nil {
+		features = []*Feature{}
+	}
+	return json.Marshal(struct {
+		Type     string     `json:"type"`
+		BBox     []float64  `json:"bbox,omitempty"`
+		Features []*Feature `json:"features"`
+	}{"FeatureCollection", fc.BBox, features})
+}
+
+func (fc *FeatureCollection) UnmarshalJSON(data []byte) error {
+	parsed, err := Decode(data)
+	if err != nil {
+		return err
+	}
+	*fc = *parsed
+	return nil
+}
+
+// BBox is a bounding box as west, south, east, north in degrees
+type BBox [4]float64
+
+// ParseBBox reads a bounding box written as "west,south,east,north"
+func ParseBBox(s string) (BBox, error) {
+	var b BBox
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return b, fmt.Errorf("bbox needs 4 values, got %d", len(parts))
+	}
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return b, fmt.Errorf("invalid bbox value %q", p)
+		}
+		b[i] = v
+	}
+	if b[0] > b[2] || b[1] > b[3] {
+		return b, fmt.Errorf("bbox minimum exceeds maximum")
+	}
+	return b, nil
+}
+
+// Intersects reports whether two boxes overlap, touching edges included
+func (b BBox) Intersects(o BBox) bool {
+	return b[0] <= o[2] && o[0] <= b[2] && b[1] <= o[3] && o[1] <= b[3]
+}
+
+// Bounds returns the bounding box of a geometry. ok is false for empty
+// geometries.
+func (g *Geometry) Bounds() (box BBox, ok bool) {
+	box = BBox{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	g.eachPosition(func(p Position) {
+		if len(p) < 2 || !finite(p[0]) || !finite(p[1]) {
+			return
+		}
+		box[0] = math.Min(box[0], p[0])
+		box[1] = math.Min(box[1], p[1])
+		box[2] = math.Max(box[2], p[0])
+		box[3] = math.Max(box[3], p[1])
+		ok = true
+	})
+	return box, ok
+}
+
+// eachPosition calls fn for every position of the geometry
+func (g *Geometry) eachPosition(fn func(Position)) {
+	switch g.Type {
+	case Point:
+		fn(g.Point)
+	case MultiPoint:
+		for _, p := range g.MultiPoint {
+			fn(p)
+		}
+	case LineString:
+		for _, p := range g.LineString {
+			fn(p)
+		}
+	case MultiLineString, Polygon:
+		lines := g.MultiLineString
+		if g.Type == Polygon {
+			lines = g.Polygon
+		}
+		for _, line := range lines {
+			for _, p := range line {
+				fn(p)
+			}
+		}
+	case MultiPolygon:
+		for _, polygon := range g.MultiPolygon {
+			for _, ring := range polygon {
+				for _, p := range ring {
+					fn(p)
+				}
+			}
+		}
+	case GeometryCollection:
+		for _, member := range g.Geometries {
+			if member != nil {
+				member.eachPosition(fn)
+			}
+		}
+	}
+}
+
+// Bounds returns the bounding box of all geometries. ok is false when the
+// collection has no positions.
+func (fc *FeatureCollection) Bounds() (box BBox, ok bool) {
+	box = BBox{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	for _, f := range fc.Features {
+		if f.Geometry == nil {
+			continue
+		}
+		if fb, found := f.Geometry.Bounds(); found {
+			box[0] = math.Min(box[0], fb[0])
+			box[1] = math.Min(box[1], fb[1])
+			box[2] = math.Max(box[2], fb[2])
+			box[3] = math.Max(box[3], fb[3])
+			ok = true
+		}
+	}
+	return box, ok
+}
+
+// Transform replaces the first two values of every position with the
+// result of fn, leaving non-finite values for Repair. Bounding boxes are
+// dropped as they no longer match, and so is the crs member.
+func (fc *FeatureCollection) Transform(fn func(x, y float64) (float64, float64)) {
+	transform := func(p Position) {
+		if len(p) >= 2 && finite(p[0]) && finite(p[1]) {
+			p[0], p[1] = fn(p[0], p[1])
+		}
+	}
+	fc.BBox, fc.CRS = nil, nil
+	for _, f := range fc.Features {
+		f.BBox = nil
+		if f.Geometry != nil {
+			f.Geometry.eachPosition(transform)
+			f.Geometry.dropBBox()
+		}
+	}
+}
+
+func (g *Geometry) dropBBox() {
+	g.BBox = nil
+	for _, member := range g.Geometries {
+		if member != nil {
+			member.dropBBox()
+		}
+	}
+}
+
+// Filter returns the features whose geometry intersects the box. Features
+// without a geometry are left out.
+func (fc *FeatureCollection) Filter(box BBox) *FeatureCollection {
+	filtered := NewFeatureCollection()
+	for _, f := range fc.Features {
+		if f.Geometry == nil {
+			continue
+		}
+		fb, ok := f.Geometry.Bounds()
+		if ok && box.Intersects(fb) {
+			filtered.Features = append(filtered.Features, f)
+		}
+	}
+	return filtered
+}
+
+// Limit cuts the collection to at most n features and reports whether any
+// were dropped
+func (fc *FeatureCollection) Limit(n int) bool {
+	if n <= 0 || len(fc.Features) <= n {
+		return false
+	}
+	fc.Features = fc.Features[:n]
+	return true
+}
+
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
@@ -0,0 +1,124 @@
+package geojson
+
+import "fmt"
+
+// Repair fixes what can be fixed without guessing: it drops positions with
+// missing or non-finite values and values beyond the altitude, closes open
+// rings, reverses rings with the wrong winding order and flattens nested
+// geometry collections. It returns a description of every fix.
+func (fc *FeatureCollection) Repair() []string {
+	r := &repairer{}
+	for i, f := range fc.Features {
+		if f.Geometry != nil {
+			r.geometry(f.Geometry, fmt.Sprintf("$.features[%d].geometry", i))
+		}
+		if f.missingProperties {
+			f.missingProperties = false
+			r.fixed(fmt.Sprintf("$.features[%d]", i), "added null properties")
+		}
+		if f.missingGeometry {
+			f.missingGeometry = false
+			r.fixed(fmt.Sprintf("$.features[%d]", i), "added null geometry")
+		}
+	}
+	return r.fixes
+}
+
+type repairer struct {
+	fixes []string
+}
+
+func (r *repairer) fixed(path, format string, args ...interface{}) {
+	r.fixes = append(r.fixes, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (r *repairer) geometry(g *Geometry, path string) {
+	coords := path + ".coordinates"
+
+	switch g.Type {
+	case Point:
+		g.Point = r.position(g.Point, coords)
+	case MultiPoint:
+		g.MultiPoint = r.positions(g.MultiPoint, coords)
+	case LineString:
+		g.LineString = r.positions(g.LineString, coords)
+	case MultiLineString:
+		for i := range g.MultiLineString {
+			g.MultiLineString[i] = r.positions(g.MultiLineString[i], fmt.Sprintf("%s[%d]", coords, i))
+		}
+	case Polygon:
+		r.polygon(g.Polygon, coords)
+	case MultiPolygon:
+		for i := range g.MultiPolygon {
+			r.polygon(g.MultiPolygon[i], fmt.Sprintf("%s[%d]", coords, i))
+		}
+	case GeometryCollection:
+		flat := make([]*Geometry, 0, len(g.Geometries))
+		for i, member := range g.Geometries {
+			memberPath := fmt.Sprintf("%s.geometries[%d]", path, i)
+			if member.Type == GeometryCollection {
+				r.geometry(member, memberPath)
+				flat = append(flat, member.Geometries...)
+				r.fixed(memberPath, "flattened nested geometry collection")
+				continue
+			}
+			r.geometry(member, memberPath)
+			flat = append(flat, member)
+		}
+		g.Geometries = flat
+	}
+}
+
+// position returns p without values beyond the altitude, or nil when it
+// has too few or non-finite values
+func (r *repairer) position(p Position, path string) Position {
+	if len(p) > 3 {
+		r.fixed(path, "dropped %d values beyond the altitude", len(p)-3)
+		p = p[:3]
+	}
+	if len(p) < 2 {
+		return p
+	}
+	for _, value := range p {
+		if !finite(value) {
+			r.fixed(path, "dropped position with a non-finite value")
+			return nil
+		}
+	}
+	return p
+}
+
+// positions drops the unusable positions of a list
+func (r *repairer) positions(ps []Position, path string) []Position {
+	kept := ps[:0]
+	for i, p := range ps {
+		if p = r.position(p, fmt.Sprintf("%s[%d]", path, i)); p != nil {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+func (r *repairer) polygon(rings [][]Position, path string) {
+	for i := range rings {
+		ringPath := fmt.Sprintf("%s[%d]", path, i)
+		ring := r.positions(rings[i], ringPath)
+
+		if len(ring) >= 3 && !samePosition(ring[0], ring[len(ring)-1]) {
+			ring = append(ring, append(Position(nil), ring[0]...))
+			r.fixed(ringPath, "closed ring")
+		}
+
+		if len(ring) >= 4 {
+			area := signedArea(ring)
+			if (i == 0 && area < 0) || (i > 0 && area > 0) {
+				for a, b := 0, len(ring)-1; a < b; a, b = a+1, b-1 {
+					ring[a], ring[b] = ring[b], ring[a]
+				}
+				r.fixed(ringPath, "reversed winding order")
+			}
+		}
+
+		rings[i] = ring
+	}
+}
@@ -0,0 +1,169 @@
+package geojson
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRepair(t *testing.T) {
+	tests := []struct {
+		name     string
+		doc      string
+		want     string // geometry of the first feature after repair
+		fixes    []string
+		validate []string // errors left after repair
+	}{
+		{
+			"open ring",
+			`{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1]]]}`,
+			`{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}`,
+			[]string{"$.features[0].geometry.coordinates[0]: closed ring"},
+			nil,
+		},
+		{
+			"wrong winding",
+			`{"type":"Polygon","coordinates":[[[0,0],[0,4],[4,4],[4,0],[0,0]],[[1,1],[2,1],[2,2],[1,1]]]}`,
+			`{"type":"Polygon","coordinates":[[[0,0],[4,0],[4,4],[0,4],[0,0]],[[1,1],[2,2],[2,1],[1,1]]]}`,
+			[]string{
+				"$.features[0].geometry.coordinates[0]: reversed winding order",
+				"$.features[0].geometry.coordinates[1]: reversed winding order",
+			},
+			nil,
+		},
+		{
+			"open ring with wrong winding",
+			`{"type":"MultiPolygon","coordinates":[[[[0,0],[0,1],[1,1],[1,0]]]]}`,
+			`{"type":"MultiPolygon","coordinates":[[[[0,0],[1,0],[1,1],[0,1],[0,0]]]]}`,
+			[]string{
+				"$.features[0].geometry.coordinates[0][0]: closed ring",
+				"$.features[0].geometry.coordinates[0][0]: reversed winding order",
+			},
+			nil,
+		},
+		{
+			"non-finite positions",
+			`{"type":"LineString","coordinates":[[0,0],[null,1],[1,1,2,3],[2,"NaN"],[3,"-Infinity"],[2,2]]}`,
+			`{"type":"LineString","coordinates":[[0,0],[1,1,2],[2,2]]}`,
+			[]string{
+				"$.features[0].geometry.coordinates[1]: dropped position with a non-finite value",
+				"$.features[0].geometry.coordinates[2]: dropped 1 values beyond the altitude",
+				"$.features[0].geometry.coordinates[3]: dropped position with a non-finite value",
+				"$.features[0].geometry.coordinates[4]: dropped position with a non-finite value",
+			},
+			nil,
+		},
+		{
+			"non-finite point",
+			`{"type":"Point","coordinates":["Infinity",0]}`,
+			`{"type":"Point","coordinates":[]}`,
+			[]string{"$.features[0].geometry.coordinates: dropped position with a non-finite value"},
+			[]string{"$.features[0].geometry.coordinates: position needs at least 2 values, has 0"},
+		},
+		{
+			"nested geometry collections",
+			`{"type":"GeometryCollection","geometries":[
+				{"type":"Point","coordinates":[0,0]},
+				{"type":"GeometryCollection","geometries":[
+					{"type":"Point","coordinates":[1,1]},
+					{"type":"GeometryCollection","geometries":[{"type":"Polygon","coordinates":[[[0,0],[0,1],[1,1]]]}]}
+				]}
+			]}`,
+			`{"type":"GeometryCollection","geometries":[{"type":"Point","coordinates":[0,0]},{"type":"Point","coordinates":[1,1]},{"type":"Polygon","coordinates":[[[0,0],[1,1],[0,1],[0,0]]]}]}`,
+			[]string{
+				"$.features[0].geometry.geometries[1].geometries[1].geometries[0].coordinates[0]: closed ring",
+				"$.features[0].geometry.geometries[1].geometries[1].geometries[0].coordinates[0]: reversed winding order",
+				"$.features[0].geometry.geometries[1].geometries[1]: flattened nested geometry collection",
+				"$.features[0].geometry.geometries[1]: flattened nested geometry collection",
+			},
+			nil,
+		},
+		{
+			"degenerate rings",
+			`{"type":"Polygon","coordinates":[[[0,0],[1,0]],[[0,0],[1,1],[0,0]],[[0,0],[1,1],[2,2]]]}`,
+			`{"type":"Polygon","coordinates":[[[0,0],[1,0]],[[0,0],[1,1],[0,0]],[[0,0],[1,1],[2,2],[0,0]]]}`,
+			[]string{"$.features[0].geometry.coordinates[2]: closed ring"},
+			[]string{
+				"$.features[0].geometry.coordinates[0]: ring needs at least 4 positions, has 2",
+				"$.features[0].geometry.coordinates[1]: ring needs at least 4 positions, has 3",
+				"$.features[0].geometry.coordinates[2]: ring has no area",
+			},
+		},
+		{
+			"ring too short after dropping positions",
+			`{"type":"Polygon","coordinates":[[[0,0],[1,0],[null,null],[0,0]]]}`,
+			`{"type":"Polygon","coordinates":[[[0,0],[1,0],[0,0]]]}`,
+			[]string{"$.features[0].geometry.coordinates[0][2]: dropped position with a non-finite value"},
+			[]string{"$.features[0].geometry.coordinates[0]: ring needs at least 4 positions, has 3"},
+		},
+		{
+			"valid geometry",
+			`{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}`,
+			`{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}`,
+			nil,
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc, err := Decode([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkStrings(t, "fixes", fc.Repair(), tt.fixes)
+
+			got, err := json.Marshal(fc.Features[0].Geometry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("repaired geometry = %s, want %s", got, tt.want)
+			}
+
+			var left []string
+			if err := fc.Validate(); err != nil {
+				for _, e := range err.(Errors) {
+					left = append(left, e.Error())
+				}
+			}
+			checkStrings(t, "errors after repair", left, tt.validate)
+		})
+	}
+}
+
+func TestRepairMembers(t *testing.T) {
+	fc, err := Decode([]byte(`{"type":"FeatureCollection","features":[{"type":"Feature"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStrings(t, "fixes", fc.Repair(), []string{
+		"$.features[0]: added null properties",
+		"$.features[0]: added null geometry",
+	})
+	if err := fc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := json.Marshal(fc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"type":"FeatureCollection","features":[{"type":"Feature","geometry":null,"properties":null}]}`; string(got) != want {
+		t.Errorf("repaired collection = %s, want %s", got, want)
+	}
+}
+
+func TestParse(t *testing.T) {
+	doc := []byte(`{"type":"Polygon","coordinates":[[[0,0],[0,1],[1,1],[1,0]]]}`)
+
+	if _, _, err := Parse(doc, false); err == nil {
+		t.Error("an open ring parsed without repair")
+	}
+
+	fc, fixes, err := Parse(doc, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixes) != 2 || len(fc.Features) != 1 {
+		t.Errorf("fixes = %q, features = %d", fixes, len(fc.Features))
+	}
+}
@@ -0,0 +1,167 @@
+package geojson
+
+import "fmt"
+
+// Validate checks a decoded collection against RFC 7946: positions have two
+// or three finite values within WGS84 range, lines have two positions,
+// polygon rings are closed with four positions, exterior rings run
+// counterclockwise and holes clockwise, geometry collections are not nested,
+// and features have geometry and properties members. It returns nil or
+// Errors.
+func (fc *FeatureCollection) Validate() error {
+	v := &validator{}
+	v.bbox(fc.BBox, "$")
+	for i, f := range fc.Features {
+		path := fmt.Sprintf("$.features[%d]", i)
+		if f.missingGeometry {
+			v.fail(path, "missing geometry member")
+		}
+		if f.missingProperties {
+			v.fail(path, "missing properties member")
+		}
+		v.bbox(f.BBox, path)
+		if f.Geometry != nil {
+			v.geometry(f.Geometry, path+".geometry", false)
+		}
+	}
+
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+type validator struct {
+	errs Errors
+}
+
+func (v *validator) fail(path, format string, args ...interface{}) {
+	v.errs = append(v.errs, &Error{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) bbox(box []float64, path string) {
+	if box == nil {
+		return
+	}
+	if len(box) != 4 && len(box) != 6 {
+		v.fail(path+".bbox", "bbox must have 4 or 6 values, has %d", len(box))
+		return
+	}
+	// The south edge may not exceed the north edge; west may exceed east
+	// when the box crosses the antimeridian
+	half := len(box) / 2
+	if box[1] > box[half+1] {
+		v.fail(path+".bbox", "south edge exceeds north edge")
+	}
+}
+
+func (v *validator) geometry(g *Geometry, path string, inCollection bool) {
+	v.bbox(g.BBox, path)
+	coords := path + ".coordinates"
+
+	switch g.Type {
+	case Point:
+		v.position(g.Point, coords)
+	case MultiPoint:
+		for i, p := range g.MultiPoint {
+			v.position(p, fmt.Sprintf("%s[%d]", coords, i))
+		}
+	case LineString:
+		v.line(g.LineString, coords)
+	case MultiLineString:
+		for i, line := range g.MultiLineString {
+			v.line(line, fmt.Sprintf("%s[%d]", coords, i))
+		}
+	case Polygon:
+		v.polygon(g.Polygon, coords)
+	case MultiPolygon:
+		for i, polygon := range g.MultiPolygon {
+			v.polygon(polygon, fmt.Sprintf("%s[%d]", coords, i))
+		}
+	case GeometryCollection:
+		if inCollection {
+			v.fail(path, "nested geometry collection")
+		}
+		for i, member := range g.Geometries {
+			v.geometry(member, fmt.Sprintf("%s.geometries[%d]", path, i), true)
+		}
+	}
+}
+
+func (v *validator) position(p Position, path string) {
+	if len(p) < 2 {
+		v.fail(path, "position needs at least 2 values, has %d", len(p))
+		return
+	}
+	if len(p) > 3 {
+		v.fail(path, "position has %d values, at most 3 are allowed", len(p))
+	}
+	for i, value := range p {
+		if !finite(value) {
+			v.fail(fmt.Sprintf("%s[%d]", path, i), "value is not a finite number")
+			return
+		}
+	}
+	if p[0] < -180 || p[0] > 180 {
+		v.fail(path, "longitude %g out of range", p[0])
+	}
+	if p[1] < -90 || p[1] > 90 {
+		v.fail(path, "latitude %g out of range", p[1])
+	}
+}
+
+func (v *validator) line(line []Position, path string) {
+	if len(line) < 2 {
+		v.fail(path, "line needs at least 2 positions, has %d", len(line))
+	}
+	for i, p := range line {
+		v.position(p, fmt.Sprintf("%s[%d]", path, i))
+	}
+}
+
+func (v *validator) polygon(rings [][]Position, path string) {
+	for i, ring := range rings {
+		ringPath := fmt.Sprintf("%s[%d]", path, i)
+
+		before := len(v.errs)
+		for j, p := range ring {
+			v.position(p, fmt.Sprintf("%s[%d]", ringPath, j))
+		}
+		if len(v.errs) > before {
+			continue
+		}
+
+		if len(ring) < 4 {
+			v.fail(ringPath, "ring needs at least 4 positions, has %d", len(ring))
+			continue
+		}
+		if !samePosition(ring[0], ring[len(ring)-1]) {
+			v.fail(ringPath, "ring is not closed")
+			continue
+		}
+
+		area := signedArea(ring)
+		switch {
+		case area == 0:
+			v.fail(ringPath, "ring has no area")
+		case i == 0 && area < 0:
+			v.fail(ringPath, "exterior ring must be counterclockwise")
+		case i > 0 && area > 0:
+			v.fail(ringPath, "hole must be clockwise")
+		}
+	}
+}
+
+// samePosition compares the longitude and latitude of two positions
+func samePosition(a, b Position) bool {
+	return len(a) >= 2 && len(b) >= 2 && a[0] == b[0] && a[1] == b[1]
+}
+
+// signedArea is positive for counterclockwise rings
+func signedArea(ring []Position) float64 {
+	var sum float64
+	for i := 0; i+1 < len(ring); i++ {
+		sum += ring[i][0]*ring[i+1][1] - ring[i+1][0]*ring[i][1]
+	}
+	return sum / 2
+}
@@ -0,0 +1,198 @@
+package geojson
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// errorStrings decodes and validates a document and returns its errors as
+// "path: message"
+func errorStrings(t *testing.T, doc string) []string {
+	t.Helper()
+	fc, err := Decode([]byte(doc))
+	if err == nil {
+		err = fc.Validate()
+	}
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, ErrInvalid) {
+		t.Errorf("error %v does not wrap ErrInvalid", err)
+	}
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("error %v is not Errors", err)
+	}
+	got := make([]string, len(errs))
+	for i, e := range errs {
+		got[i] = e.Error()
+	}
+	return got
+}
+
+func checkStrings(t *testing.T, name string, got, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s = %q, want %q", name, got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want []string
+	}{
+		{
+			"valid collection",
+			`{"type":"FeatureCollection","features":[
+				{"type":"Feature","geometry":{"type":"Point","coordinates":[4.4,51.2]},"properties":{"name":"a"}},
+				{"type":"Feature","geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]],[[0.2,0.2],[0.2,0.8],[0.8,0.8],[0.2,0.2]]]},"properties":null},
+				{"type":"Feature","geometry":null,"properties":{}}
+			]}`,
+			nil,
+		},
+		{
+			"bare geometry",
+			`{"type":"LineString","coordinates":[[0,0],[1,1,5]]}`,
+			nil,
+		},
+		{
+			"open ring",
+			`{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1]]]}`,
+			[]string{"$.features[0].geometry.coordinates[0]: ring is not closed"},
+		},
+		{
+			"clockwise exterior",
+			`{"type":"Polygon","coordinates":[[[0,0],[0,1],[1,1],[1,0],[0,0]]]}`,
+			[]string{"$.features[0].geometry.coordinates[0]: exterior ring must be counterclockwise"},
+		},
+		{
+			"counterclockwise hole",
+			`{"type":"MultiPolygon","coordinates":[[[[0,0],[1,0],[1,1],[0,1],[0,0]]],[[[0,0],[4,0],[4,4],[0,4],[0,0]],[[1,1],[2,1],[2,2],[1,1]]]]}`,
+			[]string{"$.features[0].geometry.coordinates[1][1]: hole must be clockwise"},
+		},
+		{
+			"degenerate rings",
+			`{"type":"Polygon","coordinates":[[[0,0],[1,0],[0,0]],[[0,0],[1,1],[2,2],[0,0]]]}`,
+			[]string{
+				"$.features[0].geometry.coordinates[0]: ring needs at least 4 positions, has 3",
+				"$.features[0].geometry.coordinates[1]: ring has no area",
+			},
+		},
+		{
+			"non-finite positions",
+			`{"type":"FeatureCollection","features":[{"type":"Feature","properties":{},
+				"geometry":{"type":"MultiLineString","coordinates":[[[0,0],[1,1]],[[0,0],[null,1],[2,"Infinity"]]]}}]}`,
+			[]string{
+				"$.features[0].geometry.coordinates[1][1][0]: value is not a finite number",
+				"$.features[0].geometry.coordinates[1][2][1]: value is not a finite number",
+			},
+		},
+		{
+			"position ranges",
+			`{"type":"MultiPoint","coordinates":[[181,0],[0,-91],[1],[1,2,3,4]]}`,
+			[]string{
+				"$.features[0].geometry.coordinates[0]: longitude 181 out of range",
+				"$.features[0].geometry.coordinates[1]: latitude -91 out of range",
+				"$.features[0].geometry.coordinates[2]: position needs at least 2 values, has 1",
+				"$.features[0].geometry.coordinates[3]: position has 4 values, at most 3 are allowed",
+			},
+		},
+		{
+			"short line",
+			`{"type":"LineString","coordinates":[[0,0]]}`,
+			[]string{"$.features[0].geometry.coordinates: line needs at least 2 positions, has 1"},
+		},
+		{
+			"nested geometry collection",
+			`{"type":"GeometryCollection","geometries":[
+				{"type":"Point","coordinates":[0,0]},
+				{"type":"GeometryCollection","geometries":[{"type":"Point","coordinates":[0,200]}]}
+			]}`,
+			[]string{
+				"$.features[0].geometry.geometries[1]: nested geometry collection",
+				"$.features[0].geometry.geometries[1].geometries[0].coordinates: latitude 200 out of range",
+			},
+		},
+		{
+			"missing members",
+			`{"type":"FeatureCollection","features":[{"type":"Feature"}]}`,
+			[]string{
+				"$.features[0]: missing geometry member",
+				"$.features[0]: missing properties member",
+			},
+		},
+		{
+			"bboxes",
+			`{"type":"FeatureCollection","bbox":[0,10,1,5],"features":[
+				{"type":"Feature","bbox":[170,0,-170,1],"geometry":null,"properties":null},
+				{"type":"Feature","geometry":{"type":"Point","bbox":[0,0,1],"coordinates":[0,0]},"properties":null}
+			]}`,
+			[]string{
+				"$.bbox: south edge exceeds north edge",
+				"$.features[1].geometry.bbox: bbox must have 4 or 6 values, has 3",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkStrings(t, "errors", errorStrings(t, tt.doc), tt.want)
+		})
+	}
+}
+
+// Structural problems are reported by Decode with their paths
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want []string
+	}{
+		{
+			"unknown geometry type",
+			`{"type":"FeatureCollection","features":[{"type":"Feature","properties":{},"geometry":{"type":"Circle","coordinates":[0,0]}}]}`,
+			[]string{`$.features[0].geometry: unknown geometry type "Circle"`},
+		},
+		{
+			"bad coordinates",
+			`{"type":"Polygon","coordinates":[[[0,0],[1,"x"]],5]}`,
+			[]string{
+				"$.coordinates[0][1][1]: expected a number",
+				"$.coordinates[1]: expected an array",
+			},
+		},
+		{
+			"bad members",
+			`{"type":"Feature","id":true,"geometry":{"type":"Point"},"properties":[]}`,
+			[]string{
+				"$.id: id must be a string or a number",
+				"$.geometry: missing coordinates member",
+				"$.properties: properties must be an object or null",
+			},
+		},
+		{
+			"collection too deep",
+			`{"type":"GeometryCollection","geometries":[{"type":"GeometryCollection","geometries":[{"type":"GeometryCollection","geometries":[{"type":"GeometryCollection","geometries":[{"type":"GeometryCollection","geometries":[{"type":"GeometryCollection","geometries":[{"type":"GeometryCollection","geometries":[{"type":"GeometryCollection","geometries":[{"type":"GeometryCollection","geometries":[]}]}]}]}]}]}]}]}]}`,
+			[]string{"$" + strings.Repeat(".geometries[0]", 8) + ": geometry collections nested more than 8 deep"},
+		},
+		{
+			"not json",
+			`{"type":`,
+			[]string{"$: unexpected end of JSON input"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkStrings(t, "errors", errorStrings(t, tt.doc), tt.want)
+		})
+	}
+}
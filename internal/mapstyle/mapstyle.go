@@ -0,0 +1,363 @@
+// Package mapstyle defines how features are drawn on the map: a base
+// symbol of fill and stroke, optionally categorized by the values of a
+// property or graduated by numeric ranges of one, and a property to label
+// features with. Symbols use the names of Leaflet's path options so that
+// the browser can apply them as they are.
+package mapstyle
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalid is returned for unusable style definitions
+var ErrInvalid = errors.New("mapstyle: invalid style")
+
+// Kinds of styles
+const (
+	KindSingle      = "single"
+	KindCategorized = "categorized"
+	KindGraduated   = "graduated"
+)
+
+// Style limits
+const (
+	MaxCategories = 50
+	MaxClasses    = 20
+)
+
+var hexColor = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// Symbol is the fill and stroke of a feature. Unset fields fall back to
+// the base symbol of the style, then to the map's defaults.
+type Symbol struct {
+	Color       string   `json:"color,omitempty"`  // Stroke colour
+	Weight      *float64 `json:"weight,omitempty"` // Stroke width in pixels
+	Opacity     *float64 `json:"opacity,omitempty"`
+	FillColor   string   `json:"fillColor,omitempty"`
+	FillOpacity *float64 `json:"fillOpacity,omitempty"`
+	Radius      *float64 `json:"radius,omitempty"` // Point radius in pixels
+}
+
+// Check returns an error when a colour is not a hex colour or a size is
+// out of range
+func (s Symbol) Check() error {
+	for _, c := range []string{s.Color, s.FillColor} {
+		if c != "" && !hexColor.MatchString(c) {
+			return fmt.Errorf("%w: colours are hex colours such as #3388ff", ErrInvalid)
+		}
+	}
+	limits := []struct {
+		v   *float64
+		max float64
+	}{{s.Weight, 20}, {s.Opacity, 1}, {s.FillOpacity, 1}, {s.Radius, 50}}
+	for _, l := range limits {
+		if l.v != nil && (math.IsNaN(*l.v) || *l.v < 0 || *l.v > l.max) {
+			return fmt.Errorf("%w: weights, opacities and radii are out of range", ErrInvalid)
+		}
+	}
+	return nil
+}
+
+// over returns s with the fields set in o replaced
+func (s Symbol) over(o Symbol) Symbol {
+	if o.Color != "" {
+		s.Color = o.Color
+	}
+	if o.Weight != nil {
+		s.Weight = o.Weight
+	}
+	if o.Opacity != nil {
+		s.Opacity = o.Opacity
+	}
+	if o.FillColor != "" {
+		s.FillColor = o.FillColor
+	}
+	if o.FillOpacity != nil {
+		s.FillOpacity = o.FillOpacity
+	}
+	if o.Radius != nil {
+		s.Radius = o.Radius
+	}
+	return s
+}
+
+// Category styles the features whose property has a value
+type Category struct {
+	Value  string `json:"value"`
+	Label  string `json:"label,omitempty"`
+	Symbol Symbol `json:"symbol"`
+}
+
+// Class styles the features whose property lies in [Min, Max), or up to
+// and including Max for the last class
+type Class struct {
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Label  string  `json:"label,omitempty"`
+	Symbol Symbol  `json:"symbol"`
+}
+
+// Style is the drawing of a layer or of a set of chat features
+type Style struct {
+	Kind       string     `json:"kind"`
+	Symbol     Symbol     `json:"symbol"`             // Base symbol, also for features no rule matches
+	Property   string     `json:"property,omitempty"` // Categorized or graduated by
+	Categories []Category `json:"categories,omitempty"`
+	Classes    []Class    `json:"classes,omitempty"`
+	LabelField string     `json:"label_field,omitempty"` // Property shown as a label
+}
+
+// Validate checks a style. Categorized and graduated styles without
+// categories or classes are valid: Complete derives them from features.
+func (s *Style) Validate() error {
+	if s.Kind == "" {
+		s.Kind = KindSingle
+	}
+	if err := s.Symbol.Check(); err != nil {
+		return err
+	}
+
+	switch s.Kind {
+	case KindSingle:
+		if len(s.Categories) > 0 || len(s.Classes) > 0 {
+			return fmt.Errorf("%w: a single style has no categories or classes", ErrInvalid)
+		}
+	case KindCategorized:
+		if s.Property == "" || len(s.Classes) > 0 {
+			return fmt.Errorf("%w: a categorized style needs a property and no classes", ErrInvalid)
+		}
+		if len(s.Categories) > MaxCategories {
+			return fmt.Errorf("%w: at most %d categories", ErrInvalid, MaxCategories)
+		}
+		seen := map[string]bool{}
+		for _, c := range s.Categories {
+			if seen[c.Value] {
+				return fmt.Errorf("%w: category %q appears twice", ErrInvalid, c.Value)
+			}
+			seen[c.Value] = true
+			if err := c.Symbol.Check(); err != nil {
+				return err
+			}
+		}
+	case KindGraduated:
+		if s.Property == "" || len(s.Categories) > 0 {
+			return fmt.Errorf("%w: a graduated style needs a property and no categories", ErrInvalid)
+		}
+		if len(s.Classes) > MaxClasses {
+			return fmt.Errorf("%w: at most %d classes", ErrInvalid, MaxClasses)
+		}
+		for i, c := range s.Classes {
+			if math.IsNaN(c.Min) || math.IsNaN(c.Max) || c.Min > c.Max || (i > 0 && c.Min < s.Classes[i-1].Max) {
+				return fmt.Errorf("%w: classes must be ascending ranges that do not overlap", ErrInvalid)
+			}
+			if err := c.Symbol.Check(); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalid, s.Kind)
+	}
+	return nil
+}
+
+// Value returns a property value as categories compare it, and whether
+// the value can be categorized at all
+func Value(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case json.Number:
+		return v.String(), true
+	}
+	return "", false
+}
+
+// Complete derives missing categories or classes from the properties of
+// features: the most frequent values become categories and graduated
+// classes split the values into quantiles.
+func (s *Style) Complete(properties []map[string]interface{}) {
+	switch {
+	case s.Kind == KindCategorized && len(s.Categories) == 0:
+		counts := map[string]int{}
+		for _, p := range properties {
+			if v, ok := Value(p[s.Property]); ok {
+				counts[v]++
+			}
+		}
+		values := make([]string, 0, len(counts))
+		for v := range counts {
+			values = append(values, v)
+		}
+		sort.Slice(values, func(i, j int) bool {
+			if counts[values[i]] != counts[values[j]] {
+				return counts[values[i]] > counts[values[j]]
+			}
+			return values[i] < values[j]
+		})
+		if len(values) > MaxCategories {
+			values = values[:MaxCategories]
+		}
+		for i, v := range values {
+			c := Palette[i%len(Palette)]
+			s.Categories = append(s.Categories, Category{Value: v, Symbol: Symbol{Color: c, FillColor: c}})
+		}
+
+	case s.Kind == KindGraduated && len(s.Classes) == 0:
+		var values []float64
+		for _, p := range properties {
+			if v, ok := p[s.Property].(float64); ok && !math.IsNaN(v) && !math.IsInf(v, 0) {
+				values = append(values, v)
+			}
+		}
+		s.Classes = quantiles(values, len(Ramp))
+	}
+}
+
+// quantiles splits sorted values into at most n classes of about equal
+// size, coloured along the ramp
+func quantiles(values []float64, n int) []Class {
+	if len(values) == 0 {
+		return nil
+	}
+	sort.Float64s(values)
+	var breaks []float64
+	for i := 0; i <= n; i++ {
+		b := values[min(i*len(values)/n, len(values)-1)]
+		if i == n {
+			b = values[len(values)-1]
+		}
+		if len(breaks) == 0 || b > breaks[len(breaks)-1] {
+			breaks = append(breaks, b)
+		}
+	}
+	if len(breaks) == 1 {
+		breaks = append(breaks, breaks[0])
+	}
+
+	classes := make([]Class, len(breaks)-1)
+	for i := range classes {
+		c := Ramp[i*(len(Ramp)-1)/max(len(classes)-1, 1)]
+		classes[i] = Class{Min: breaks[i], Max: breaks[i+1], Symbol: Symbol{Color: c, FillColor: c}}
+	}
+	return classes
+}
+
+// Palette colours categories and chat feature sets
+var Palette = []string{
+	"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd",
+	"#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf",
+}
+
+// Ramp colours graduated classes from low to high
+var Ramp = []string{"#ffffb2", "#fecc5c", "#fd8d3c", "#f03b20", "#bd0026"}
+
+// ForKey returns the default style of a set of chat features, coloured
+// after the geometry named in its key, such as "Polygon", or else by its
+// position among the sets
+func ForKey(key string, index int) *Style {
+	c := Palette[index%len(Palette)]
+	name := strings.ToLower(key)
+	switch {
+	case strings.Contains(name, "polygon"):
+		c = "#33cc33"
+	case strings.Contains(name, "line"):
+		c = "#3366ff"
+	case strings.Contains(name, "point"):
+		c = "#ff5733"
+	}
+	weight, opacity, fillOpacity, radius := 2.0, 1.0, 0.5, 8.0
+	return &Style{
+		Kind: KindSingle,
+		Symbol: Symbol{
+			Color:       c,
+			Weight:      &weight,
+			Opacity:     &opacity,
+			FillColor:   c,
+			FillOpacity: &fillOpacity,
+			Radius:      &radius,
+		},
+	}
+}
+
+// Entry is a line of a legend
+type Entry struct {
+	Label  string `json:"label"`
+	Symbol Symbol `json:"symbol"`
+}
+
+// Legend explains the symbols of a style
+type Legend struct {
+	Title   string  `json:"title"`
+	Entries []Entry `json:"entries"`
+}
+
+// Legend returns the legend of a style, with symbols resolved against the
+// base symbol
+func (s *Style) Legend(title string) Legend {
+	l := Legend{Title: title}
+	switch s.Kind {
+	case KindCategorized:
+		if s.Property != "" {
+			l.Title += " by " + s.Property
+		}
+		for _, c := range s.Categories {
+			label := c.Label
+			if label == "" {
+				label = c.Value
+			}
+			l.Entries = append(l.Entries, Entry{label, s.Symbol.over(c.Symbol)})
+		}
+		l.Entries = append(l.Entries, Entry{"Other", s.Symbol})
+	case KindGraduated:
+		if s.Property != "" {
+			l.Title += " by " + s.Property
+		}
+		for _, c := range s.Classes {
+			label := c.Label
+			if label == "" {
+				label = formatNumber(c.Min) + " – " + formatNumber(c.Max)
+			}
+			l.Entries = append(l.Entries, Entry{label, s.Symbol.over(c.Symbol)})
+		}
+	default:
+		l.Entries = []Entry{{title, s.Symbol}}
+	}
+	return l
+}
+
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}
+
+// LoadFile reads styles by chat feature set key from a JSON file
+func LoadFile(path string) (map[string]*Style, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var styles map[string]*Style
+	if err := json.Unmarshal(data, &styles); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	for key, s := range styles {
+		if s == nil {
+			return nil, fmt.Errorf("%w: %q has no style", ErrInvalid, key)
+		}
+		if err := s.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return styles, nil
+}
@@ -21,6 +21,19 @@ type ChatRequest struct {
 	UserID     string `json:"user_id,omitempty"`
 	ChatID     string `json:"chat_id,omitempty"`
 	ProjectID  string `json:"project_id,omitempty"` // Added ProjectID field
+	// Documents are the project's chunks found for the question
+	Documents []ChatDocument `json:"documents,omitempty"`
+}
+
+// ChatDocument is a document chunk sent with a question as context
+type ChatDocument struct {
+	ChunkID     string   `json:"chunk_id"`
+	FileID      string   `json:"file_id"`
+	FileName    string   `json:"file_name"`
+	Page        int      `json:"page,omitempty"`
+	HeadingPath []string `json:"heading_path,omitempty"`
+	Content     string   `json:"content"`
+	Score       float32  `json:"score"`
 }
 
 // ForwardMessageWithStream sends a message to the connected websocket server
@@ -34,6 +47,7 @@ func (c *ChatPort) ForwardMessageWithStream(
 	userID string,
 	chatID string,
 	projectID string, // Added projectID parameter
+	documents []ChatDocument,
 ) (<-chan string, error) {
 	otherServer := "ws://localhost" + c.Port + "/ws/chat"
 	conn, _, err := websocket.DefaultDialer.Dial(otherServer, nil)
@@ -51,6 +65,7 @@ func (c *ChatPort) ForwardMessageWithStream(
 		UserID:     userID,
 		ChatID:     chatID,
 		ProjectID:  projectID, // Include projectID in the request
+		Documents:  documents,
 	}
 
 	jsonMsg, err := json.Marshal(req)
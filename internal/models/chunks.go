@@ -0,0 +1,273 @@
+// models/chunks.go
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// DocumentChunk is a piece of extracted document text ready to be embedded
+type DocumentChunk struct {
+	ID          uuid.UUID
+	FileID      uuid.UUID
+	ProjectID   uuid.UUID
+	Index       int
+	Content     string
+	Page        int
+	PageEnd     int
+	HeadingPath []string
+	StartOffset int
+	EndOffset   int
+	TokenCount  int
+	Method      string
+	Created     time.Time
+}
+
+type ChunkModel struct {
+	DB *sql.DB
+}
+
+func NewChunkModel(db *sql.DB) *ChunkModel {
+	return &ChunkModel{DB: db}
+}
+
+// ReplaceForFile stores the chunks of a file, removing those of an earlier run
+func (m *ChunkModel) ReplaceForFile(fileID uuid.UUID, chunks []*DocumentChunk) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM document_chunks WHERE file_id = $1`, fileID); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO document_chunks (
+			id, file_id, project_id, chunk_index, content, page, page_end,
+			heading_path, start_offset, end_offset, token_count, method, created
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NOW())
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, c := range chunks {
+		if c.ID == uuid.Nil {
+			c.ID = uuid.New()
+		}
+		c.FileID = fileID
+
+		_, err := stmt.Exec(
+			c.ID,
+			c.FileID,
+			c.ProjectID,
+			c.Index,
+			c.Content,
+			c.Page,
+			c.PageEnd,
+			pq.Array(c.HeadingPath),
+			c.StartOffset,
+			c.EndOffset,
+			c.TokenCount,
+			c.Method,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m *ChunkModel) GetByFile(fileID uuid.UUID) ([]*DocumentChunk, error) {
+	stmt := `
+		SELECT id, file_id, project_id, chunk_index, content, page, page_end,
+			   heading_path, start_offset, end_offset, token_count, method, created
+		FROM document_chunks
+		WHERE file_id = $1
+		ORDER BY chunk_index
+	`
+
+	rows, err := m.DB.Query(stmt, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chunks := []*DocumentChunk{}
+	for rows.Next() {
+		c := &DocumentChunk{}
+		err := rows.Scan(
+			&c.ID,
+			&c.FileID,
+			&c.ProjectID,
+			&c.Index,
+			&c.Content,
+			&c.Page,
+			&c.PageEnd,
+			pq.Array(&c.HeadingPath),
+			&c.StartOffset,
+			&c.EndOffset,
+			&c.TokenCount,
+			&c.Method,
+			&c.Created,
+		)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return chunks, nil
+}
+
+// CountByFile returns how many chunks are stored for a file
+func (m *ChunkModel) CountByFile(fileID uuid.UUID) (int, error) {
+	var count int
+	err := m.DB.QueryRow(`SELECT COUNT(*) FROM document_chunks WHERE file_id = $1`, fileID).Scan(&count)
+	return count, err
+}
+
+// GetByIDs returns the chunks with the given IDs, in no particular order
+func (m *ChunkModel) GetByIDs(ids []uuid.UUID) ([]*DocumentChunk, error) {
+	stmt := `
+		SELECT id, file_id, project_id, chunk_index, content, page, page_end,
+			   heading_path, start_offset, end_offset, token_count, method, created
+		FROM document_chunks
+		WHERE id = ANY($1::uuid[])
+	`
+
+	strIDs := make([]string, len(ids))
+	for i, id := range ids {
+		strIDs[i] = id.String()
+	}
+
+	rows, err := m.DB.Query(stmt, pq.Array(strIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chunks := []*DocumentChunk{}
+	for rows.Next() {
+		c := &DocumentChunk{}
+		err := rows.Scan(
+			&c.ID,
+			&c.FileID,
+			&c.ProjectID,
+			&c.Index,
+			&c.Content,
+			&c.Page,
+			&c.PageEnd,
+			pq.Array(&c.HeadingPath),
+			&c.StartOffset,
+			&c.EndOffset,
+			&c.TokenCount,
+			&c.Method,
+			&c.Created,
+		)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return chunks, nil
+}
+
+// ChunkMatch is a chunk found by a keyword search
+type ChunkMatch struct {
+	ChunkID uuid.UUID
+	FileID  uuid.UUID
+	Score   float64
+}
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// KeywordSearch ranks the chunks of a project against the query with BM25,
+// using the same analysers as the search_vector column. A non-empty fileIDs
+// limits the results to those files; document frequencies still cover the
+// whole project.
+func (m *ChunkModel) KeywordSearch(projectID uuid.UUID, query string, k int, fileIDs []uuid.UUID) ([]*ChunkMatch, error) {
+	stmt := `
+		WITH terms AS (
+			SELECT DISTINCT lexeme FROM unnest(
+				to_tsvector('english', $2) || to_tsvector('dutch', $2) || to_tsvector('simple', $2)
+			)
+		),
+		corpus AS (
+			SELECT COUNT(*) AS n, GREATEST(AVG(length(search_vector)), 1) AS avgdl
+			FROM document_chunks
+			WHERE project_id = $1
+		),
+		matches AS (
+			SELECT c.id, c.file_id, length(c.search_vector) AS dl,
+				   t.lexeme, COALESCE(array_length(t.positions, 1), 1) AS tf
+			FROM document_chunks c, unnest(c.search_vector) t
+			WHERE c.project_id = $1
+			  AND c.search_vector @@ (
+				SELECT to_tsquery('simple', string_agg(quote_literal(lexeme), ' | ')) FROM terms
+			  )
+			  AND t.lexeme IN (SELECT lexeme FROM terms)
+		),
+		df AS (
+			SELECT lexeme, COUNT(*) AS df FROM matches GROUP BY lexeme
+		)
+		SELECT m.id, m.file_id,
+			   SUM(
+				   ln(1 + (corpus.n - df.df + 0.5) / (df.df + 0.5)) *
+				   m.tf * ($4::float8 + 1) / (m.tf + $4::float8 * (1 - $5::float8 + $5::float8 * m.dl / corpus.avgdl))
+			   ) AS score
+		FROM matches m
+		JOIN df ON df.lexeme = m.lexeme
+		CROSS JOIN corpus
+		WHERE cardinality($3::uuid[]) = 0 OR m.file_id = ANY($3::uuid[])
+		GROUP BY m.id, m.file_id
+		ORDER BY score DESC
+		LIMIT $6
+	`
+
+	strIDs := make([]string, len(fileIDs))
+	for i, id := range fileIDs {
+		strIDs[i] = id.String()
+	}
+
+	rows, err := m.DB.Query(stmt, projectID, query, pq.Array(strIDs), bm25K1, bm25B, k)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []*ChunkMatch{}
+	for rows.Next() {
+		match := &ChunkMatch{}
+		if err := rows.Scan(&match.ChunkID, &match.FileID, &match.Score); err != nil {
+			return nil, err
+		}
+		matches = append(matches, match)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return matches, nil
+}
@@ -10,4 +10,6 @@ var (
 	ErrInvalidCredentials = errors.New("models: invalid credentials")
 
 	ErrDuplicateEmail = errors.New("models: duplicate email")
+
+	ErrDuplicateName = errors.New("models: duplicate name")
 )
\ No newline at end of file
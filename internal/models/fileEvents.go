@@ -0,0 +1,106 @@
+// models/fileEvents.go
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Document lifecycle events
+const (
+	FileUploaded    = "uploaded"
+	FileQuarantined = "quarantined"
+	FileReplaced    = "replaced"
+	FileReprocessed = "reprocessed"
+	FileProcessed   = "processed"
+	FileImported    = "imported"
+	FileFailed      = "failed"
+	FileDeleted     = "deleted"
+)
+
+// FileEvent is an entry in the audit trail of a project's documents. UserID
+// is nil for events of the processing workers.
+type FileEvent struct {
+	ID        int64
+	FileID    uuid.UUID
+	ProjectID uuid.UUID
+	UserID    uuid.NullUUID
+	FileName  string
+	Version   int
+	Event     string
+	Detail    string
+	Created   time.Time
+}
+
+type FileEventModel struct {
+	DB *sql.DB
+}
+
+func NewFileEventModel(db *sql.DB) *FileEventModel {
+	return &FileEventModel{DB: db}
+}
+
+func (m *FileEventModel) Insert(event *FileEvent) error {
+	stmt := `
+		INSERT INTO file_events (
+			file_id, project_id, user_id, file_name, version, event, detail, created
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+		RETURNING id, created
+	`
+
+	return m.DB.QueryRow(
+		stmt,
+		event.FileID,
+		event.ProjectID,
+		event.UserID,
+		event.FileName,
+		event.Version,
+		event.Event,
+		event.Detail,
+	).Scan(&event.ID, &event.Created)
+}
+
+// GetByProject returns the latest events of a project, newest first
+func (m *FileEventModel) GetByProject(projectID uuid.UUID, limit int) ([]*FileEvent, error) {
+	stmt := `
+		SELECT id, file_id, project_id, user_id, file_name, version, event, detail, created
+		FROM file_events
+		WHERE project_id = $1
+		ORDER BY created DESC, id DESC
+		LIMIT $2
+	`
+
+	rows, err := m.DB.Query(stmt, projectID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*FileEvent{}
+	for rows.Next() {
+		e := &FileEvent{}
+		err := rows.Scan(
+			&e.ID,
+			&e.FileID,
+			&e.ProjectID,
+			&e.UserID,
+			&e.FileName,
+			&e.Version,
+			&e.Event,
+			&e.Detail,
+			&e.Created,
+		)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
@@ -24,6 +24,21 @@ type File struct {
 	UploadedAt      time.Time
 	ProcessedAt     sql.NullTime
 	Status          string
+	ContentHash     string
+	Version         int
+}
+
+// FileVersion is an earlier content of a file
+type FileVersion struct {
+	FileID          uuid.UUID
+	Version         int
+	Name            string
+	FilePath        string
+	StorageLocation string
+	MimeType        string
+	Size            int64
+	ContentHash     string
+	Created         time.Time
 }
 
 type FileModel struct {
@@ -40,12 +55,14 @@ func (m *FileModel) Insert(file *File) error {
 		file.ID = uuid.New()
 	}
 
+	file.Version = 1
+
 	// Insert into files table
 	stmt := `
 		INSERT INTO files (
 			id, name, description, file_path, mime_type, size, 
-			role, storage_location, uploaded_at, status, owner_id
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW(), $9, $10)
+			role, storage_location, uploaded_at, status, owner_id, content_hash, version
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW(), $9, $10, $11, 1)
 		RETURNING id
 	`
 
@@ -62,6 +79,7 @@ func (m *FileModel) Insert(file *File) error {
 		file.StorageLocation,
 		file.Status,
 		file.UserID,
+		file.ContentHash,
 	).Scan(&id)
 
 	if err != nil {
@@ -88,13 +106,14 @@ func (m *FileModel) Insert(file *File) error {
 func (m *FileModel) GetByID(id uuid.UUID) (*File, error) {
 	stmt := `
 		SELECT f.id, f.name, f.description, f.file_path, f.mime_type, f.size,
-			   f.role, f.storage_location, f.uploaded_at, f.processed_at, f.status, f.owner_id
+			   f.role, f.storage_location, f.uploaded_at, f.processed_at, f.status, f.owner_id,
+			   f.content_hash, f.version
 		FROM files f
 		WHERE f.id = $1
 	`
 
 	var file File
-	var description, filePath sql.NullString
+	var description, filePath, contentHash sql.NullString
 	
 	err := m.DB.QueryRow(stmt, id).Scan(
 		&file.ID,
@@ -109,6 +128,8 @@ func (m *FileModel) GetByID(id uuid.UUID) (*File, error) {
 		&file.ProcessedAt,
 		&file.Status,
 		&file.UserID,
+		&contentHash,
+		&file.Version,
 	)
 
 	if err != nil {
@@ -126,6 +147,10 @@ func (m *FileModel) GetByID(id uuid.UUID) (*File, error) {
 		file.FilePath = filePath.String
 	}
 
+	if contentHash.Valid {
+		file.ContentHash = contentHash.String
+	}
+
 	// Get ProjectID from the many-to-many relationship
 	projectStmt := `
 		SELECT project_id FROM files_projects WHERE file_id = $1 LIMIT 1
@@ -140,10 +165,13 @@ func (m *FileModel) GetByID(id uuid.UUID) (*File, error) {
 
 func (m *FileModel) GetByProject(projectID uuid.UUID) ([]*File, error) {
 	stmt := `
-		SELECT f.id, f.name, f.file_path, f.storage_location, f.owner_id
+		SELECT f.id, f.name, f.description, f.file_path, f.mime_type, f.size, f.role,
+			   f.storage_location, f.uploaded_at, f.processed_at, f.status, f.owner_id,
+			   f.content_hash, f.version
 		FROM files f
 		JOIN files_projects fp ON f.id = fp.file_id
 		WHERE fp.project_id = $1
+		ORDER BY f.uploaded_at DESC
 	`
 
 	rows, err := m.DB.Query(stmt, projectID)
@@ -155,19 +183,32 @@ func (m *FileModel) GetByProject(projectID uuid.UUID) ([]*File, error) {
 	files := []*File{}
 	for rows.Next() {
 		var file File
-		var description, filePath sql.NullString
+		var description, filePath, contentHash sql.NullString
 		
 		err := rows.Scan(
 			&file.ID,
 			&file.Name,
+			&description,
 			&filePath,
+			&file.MimeType,
+			&file.Size,
+			&file.Role,
 			&file.StorageLocation,
+			&file.UploadedAt,
+			&file.ProcessedAt,
+			&file.Status,
 			&file.UserID,
+			&contentHash,
+			&file.Version,
 		)
 		if err != nil {
 			return nil, err
 		}
 
+		if contentHash.Valid {
+			file.ContentHash = contentHash.String
+		}
+
 		if description.Valid {
 			file.Description = description.String
 		}
@@ -209,4 +250,230 @@ func (m *FileModel) UpdateStatus(id uuid.UUID, status string) error {
 
 	_, err := m.DB.Exec(stmt, args...)
 	return err
+}
+
+// GetByHash returns the file in a project with the given content hash
+func (m *FileModel) GetByHash(projectID uuid.UUID, hash string) (*File, error) {
+	stmt := `
+		SELECT f.id
+		FROM files f
+		JOIN files_projects fp ON f.id = fp.file_id
+		WHERE fp.project_id = $1 AND f.content_hash = $2
+		LIMIT 1
+	`
+
+	var id uuid.UUID
+	err := m.DB.QueryRow(stmt, projectID, hash).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return m.GetByID(id)
+}
+
+// CountByBlob returns how many files and file versions reference the blob
+// stored under a key
+func (m *FileModel) CountByBlob(storageLocation, filePath string) (int, error) {
+	stmt := `
+		SELECT
+			(SELECT COUNT(*) FROM files WHERE storage_location = $1 AND file_path = $2) +
+			(SELECT COUNT(*) FROM file_versions WHERE storage_location = $1 AND file_path = $2)
+	`
+
+	var count int
+	err := m.DB.QueryRow(stmt, storageLocation, filePath).Scan(&count)
+	return count, err
+}
+
+// UpdateContent points an existing file at newly stored content. The
+// previous content is kept as a version and file.Version is incremented.
+func (m *FileModel) UpdateContent(file *File) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	archive := `
+		INSERT INTO file_versions (
+			file_id, version, name, file_path, storage_location, mime_type,
+			size, content_hash, created
+		)
+		SELECT id, version, name, file_path, storage_location, mime_type,
+			   size, COALESCE(content_hash, ''), uploaded_at
+		FROM files
+		WHERE id = $1
+	`
+	if _, err := tx.Exec(archive, file.ID); err != nil {
+		return err
+	}
+
+	stmt := `
+		UPDATE files
+		SET name = $1, file_path = $2, mime_type = $3, size = $4,
+			storage_location = $5, content_hash = $6, status = $7,
+			uploaded_at = NOW(), processed_at = NULL, version = version + 1
+		WHERE id = $8
+		RETURNING version
+	`
+
+	err = tx.QueryRow(
+		stmt,
+		file.Name,
+		file.FilePath,
+		file.MimeType,
+		file.Size,
+		file.StorageLocation,
+		file.ContentHash,
+		file.Status,
+		file.ID,
+	).Scan(&file.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Versions returns the earlier contents of a file, newest first
+func (m *FileModel) Versions(id uuid.UUID) ([]*FileVersion, error) {
+	stmt := `
+		SELECT file_id, version, name, file_path, storage_location, mime_type,
+			   size, content_hash, created
+		FROM file_versions
+		WHERE file_id = $1
+		ORDER BY version DESC
+	`
+
+	rows, err := m.DB.Query(stmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []*FileVersion{}
+	for rows.Next() {
+		v := &FileVersion{}
+		err := rows.Scan(
+			&v.FileID,
+			&v.Version,
+			&v.Name,
+			&v.FilePath,
+			&v.StorageLocation,
+			&v.MimeType,
+			&v.Size,
+			&v.ContentHash,
+			&v.Created,
+		)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// clearProcessing removes the extracted text and chunks of a file and
+// cancels its unfinished jobs
+func clearProcessing(tx *sql.Tx, id uuid.UUID) error {
+	if _, err := tx.Exec(`DELETE FROM document_chunks WHERE file_id = $1`, id); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM document_texts WHERE file_id = $1`, id); err != nil {
+		return err
+	}
+
+	cancel := `
+		UPDATE jobs
+		SET status = 'cancelled', locked_at = NULL, finished_at = NOW(), updated = NOW()
+		WHERE file_id = $1 AND status IN ('queued', 'running')
+	`
+	_, err := tx.Exec(cancel, id)
+	return err
+}
+
+// ClearProcessing discards the processing results of a file so it can be
+// processed again
+func (m *FileModel) ClearProcessing(id uuid.UUID) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := clearProcessing(tx, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a file, its versions and project links, its extracted text
+// and chunks, and cancels its unfinished jobs
+func (m *FileModel) Delete(id uuid.UUID) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := clearProcessing(tx, id); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM file_versions WHERE file_id = $1`, id); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM files_projects WHERE file_id = $1`, id); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`DELETE FROM files WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return tx.Commit()
+}
+
+// ProjectUsage returns the total size of the files stored in a project,
+// their earlier versions included
+func (m *FileModel) ProjectUsage(projectID uuid.UUID) (int64, error) {
+	stmt := `
+		SELECT
+			(SELECT COALESCE(SUM(f.size), 0)
+			 FROM files f
+			 JOIN files_projects fp ON f.id = fp.file_id
+			 WHERE fp.project_id = $1)
+			+
+			(SELECT COALESCE(SUM(v.size), 0)
+			 FROM file_versions v
+			 JOIN files_projects fp ON v.file_id = fp.file_id
+			 WHERE fp.project_id = $1)
+	`
+
+	var total int64
+	err := m.DB.QueryRow(stmt, projectID).Scan(&total)
+	return total, err
 }
\ No newline at end of file
@@ -0,0 +1,268 @@
+// models/jobs.go
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Job steps, run in this order for every processed document
+const (
+	JobExtract = "extract"
+	JobChunk   = "chunk"
+	JobEmbed   = "embed"
+	JobIndex   = "index"
+)
+
+// Job states
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job is one processing step of a document
+type Job struct {
+	ID          uuid.UUID
+	FileID      uuid.UUID
+	ProjectID   uuid.UUID
+	Step        string
+	Status      string
+	Options     []byte
+	Attempts    int
+	MaxAttempts int
+	LastError   string
+	RunAfter    time.Time
+	LockedAt    sql.NullTime
+	FinishedAt  sql.NullTime
+	Created     time.Time
+	Updated     time.Time
+}
+
+type JobModel struct {
+	DB *sql.DB
+}
+
+func NewJobModel(db *sql.DB) *JobModel {
+	return &JobModel{DB: db}
+}
+
+const jobColumns = `
+	id, file_id, project_id, step, status, options, attempts, max_attempts,
+	last_error, run_after, locked_at, finished_at, created, updated
+`
+
+func scanJob(row interface{ Scan(...interface{}) error }) (*Job, error) {
+	j := &Job{}
+	err := row.Scan(
+		&j.ID,
+		&j.FileID,
+		&j.ProjectID,
+		&j.Step,
+		&j.Status,
+		&j.Options,
+		&j.Attempts,
+		&j.MaxAttempts,
+		&j.LastError,
+		&j.RunAfter,
+		&j.LockedAt,
+		&j.FinishedAt,
+		&j.Created,
+		&j.Updated,
+	)
+	return j, err
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertJob is shared by Enqueue and Complete so a next step can be queued
+// in the same transaction that finishes the previous one
+func insertJob(db execer, job *Job) error {
+	if job.ID == uuid.Nil {
+		job.ID = uuid.New()
+	}
+	if len(job.Options) == 0 {
+		job.Options = []byte("{}")
+	}
+
+	stmt := `
+		INSERT INTO jobs (
+			id, file_id, project_id, step, status, options, attempts,
+			max_attempts, run_after, created, updated
+		) VALUES ($1, $2, $3, $4, 'queued', $5, 0, $6, NOW(), NOW(), NOW())
+	`
+
+	_, err := db.Exec(stmt, job.ID, job.FileID, job.ProjectID, job.Step, job.Options, job.MaxAttempts)
+	if err != nil {
+		return err
+	}
+	job.Status = JobQueued
+	return nil
+}
+
+// Enqueue adds a job that can run immediately
+func (m *JobModel) Enqueue(job *Job) error {
+	return insertJob(m.DB, job)
+}
+
+// Claim locks the next job that is due and marks it running. Jobs left
+// running longer than lockTimeout by a worker that died are claimed again.
+// It returns ErrNoRecord when no job is due.
+func (m *JobModel) Claim(lockTimeout time.Duration) (*Job, error) {
+	stmt := `
+		UPDATE jobs
+		SET status = 'running', attempts = attempts + 1, locked_at = NOW(), updated = NOW()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE (status = 'queued' AND run_after <= NOW())
+			   OR (status = 'running' AND locked_at < NOW() - $1 * INTERVAL '1 second')
+			ORDER BY run_after, created
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING` + jobColumns
+
+	job, err := scanJob(m.DB.QueryRow(stmt, int(lockTimeout.Seconds())))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+// Complete marks a running job done and queues the next step, if any. It
+// returns ErrNoRecord when the job was cancelled while it ran.
+func (m *JobModel) Complete(id uuid.UUID, next *Job) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+		UPDATE jobs
+		SET status = 'done', last_error = '', locked_at = NULL,
+			finished_at = NOW(), updated = NOW()
+		WHERE id = $1 AND status = 'running'
+	`
+	result, err := tx.Exec(stmt, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	if next != nil {
+		if err := insertJob(tx, next); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Retry records a failed attempt and queues the job again at runAfter
+func (m *JobModel) Retry(id uuid.UUID, message string, runAfter time.Time) error {
+	stmt := `
+		UPDATE jobs
+		SET status = 'queued', last_error = $1, run_after = $2,
+			locked_at = NULL, updated = NOW()
+		WHERE id = $3 AND status = 'running'
+	`
+
+	_, err := m.DB.Exec(stmt, message, runAfter, id)
+	return err
+}
+
+// Fail marks a job as failed for good
+func (m *JobModel) Fail(id uuid.UUID, message string) error {
+	stmt := `
+		UPDATE jobs
+		SET status = 'failed', last_error = $1, locked_at = NULL,
+			finished_at = NOW(), updated = NOW()
+		WHERE id = $2 AND status = 'running'
+	`
+
+	_, err := m.DB.Exec(stmt, message, id)
+	return err
+}
+
+// Cancel marks a job as cancelled, for example when its file is gone
+func (m *JobModel) Cancel(id uuid.UUID) error {
+	stmt := `
+		UPDATE jobs
+		SET status = 'cancelled', locked_at = NULL, finished_at = NOW(), updated = NOW()
+		WHERE id = $1
+	`
+
+	_, err := m.DB.Exec(stmt, id)
+	return err
+}
+
+// LatestOptions returns the options of the most recent job of a file, or
+// ErrNoRecord when the file was never processed
+func (m *JobModel) LatestOptions(fileID uuid.UUID) ([]byte, error) {
+	stmt := `
+		SELECT options FROM jobs
+		WHERE file_id = $1
+		ORDER BY created DESC
+		LIMIT 1
+	`
+
+	var options []byte
+	err := m.DB.QueryRow(stmt, fileID).Scan(&options)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return options, nil
+}
+
+// LatestByProject returns the most recent job of every file in a project
+func (m *JobModel) LatestByProject(projectID uuid.UUID) ([]*Job, error) {
+	stmt := `
+		SELECT DISTINCT ON (file_id)` + jobColumns + `
+		FROM jobs
+		WHERE project_id = $1
+		ORDER BY file_id, created DESC
+	`
+
+	rows, err := m.DB.Query(stmt, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
@@ -0,0 +1,262 @@
+// models/layers.go
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"kdg/be/lab/internal/mapstyle"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Map layer sources
+const (
+	LayerFile  = "file"
+	LayerTable = "table"
+	LayerQuery = "query"
+	LayerChat  = "chat"
+)
+
+// Layer is a named set of features shown on a project's map
+type Layer struct {
+	ID             uuid.UUID       `json:"id"`
+	ProjectID      uuid.UUID       `json:"project_id"`
+	Name           string          `json:"name"`
+	Source         string          `json:"source"`
+	FileID         uuid.NullUUID   `json:"file_id"`
+	Sublayer       string          `json:"sublayer,omitempty"` // Table, folder or Shapefile within the file
+	TableName      string          `json:"table_name,omitempty"`
+	GeometryColumn string          `json:"geometry_column,omitempty"`
+	Query          string          `json:"query,omitempty"`
+	Data           []byte          `json:"-"`
+	Attributes     []string        `json:"attributes"`    // Properties written to vector tiles
+	FeatureCount   int             `json:"feature_count"` // When last read; capped for database layers
+	BBox           []float64       `json:"bbox"`          // West, south, east, north when last read; nil when empty
+	Schema         []LayerField    `json:"schema"`        // Attribute columns when last read
+	Style          *mapstyle.Style `json:"style"`         // Drawing rules; nil for the map's default
+	CreatedBy      uuid.UUID       `json:"created_by"`
+	Created        time.Time       `json:"created"`
+}
+
+// LayerField is an attribute column of a layer
+type LayerField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type LayerModel struct {
+	DB *sql.DB
+}
+
+func NewLayerModel(db *sql.DB) *LayerModel {
+	return &LayerModel{DB: db}
+}
+
+const layerColumns = `
+	id, project_id, name, source, file_id, sublayer, table_name, geometry_column,
+	query, data, tile_attributes, feature_count, bbox, attribute_schema,
+	style, created_by, created
+`
+
+func scanLayer(row interface{ Scan(...interface{}) error }) (*Layer, error) {
+	l := &Layer{}
+	var schema, style []byte
+	err := row.Scan(
+		&l.ID,
+		&l.ProjectID,
+		&l.Name,
+		&l.Source,
+		&l.FileID,
+		&l.Sublayer,
+		&l.TableName,
+		&l.GeometryColumn,
+		&l.Query,
+		&l.Data,
+		pq.Array(&l.Attributes),
+		&l.FeatureCount,
+		(*pq.Float64Array)(&l.BBox),
+		&schema,
+		&style,
+		&l.CreatedBy,
+		&l.Created,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoRecord
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(schema, &l.Schema); err != nil {
+		return nil, err
+	}
+	if len(style) > 0 {
+		if err := json.Unmarshal(style, &l.Style); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (m *LayerModel) Insert(layer *Layer) error {
+	if layer.ID == uuid.Nil {
+		layer.ID = uuid.New()
+	}
+
+	stmt := `
+		INSERT INTO map_layers (
+			id, project_id, name, source, file_id, sublayer, table_name, geometry_column,
+			query, data, tile_attributes, feature_count, bbox, attribute_schema,
+			created_by, created
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, NOW())
+		RETURNING created
+	`
+
+	var data interface{}
+	if len(layer.Data) > 0 {
+		data = layer.Data
+	}
+	if layer.Attributes == nil {
+		layer.Attributes = []string{}
+	}
+	if layer.Schema == nil {
+		layer.Schema = []LayerField{}
+	}
+	schema, err := json.Marshal(layer.Schema)
+	if err != nil {
+		return err
+	}
+
+	err = m.DB.QueryRow(
+		stmt,
+		layer.ID,
+		layer.ProjectID,
+		layer.Name,
+		layer.Source,
+		layer.FileID,
+		layer.Sublayer,
+		layer.TableName,
+		layer.GeometryColumn,
+		layer.Query,
+		data,
+		pq.Array(layer.Attributes),
+		layer.FeatureCount,
+		pq.Float64Array(layer.BBox),
+		schema,
+		layer.CreatedBy,
+	).Scan(&layer.Created)
+	if err != nil {
+		var pgErr *pq.Error
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrDuplicateName
+		}
+		return err
+	}
+	return nil
+}
+
+// Get returns a layer by ID
+func (m *LayerModel) Get(id uuid.UUID) (*Layer, error) {
+	stmt := `SELECT` + layerColumns + `FROM map_layers WHERE id = $1`
+	return scanLayer(m.DB.QueryRow(stmt, id))
+}
+
+// GetByName returns the layer of a project with the given name
+func (m *LayerModel) GetByName(projectID uuid.UUID, name string) (*Layer, error) {
+	stmt := `SELECT` + layerColumns + `FROM map_layers WHERE project_id = $1 AND name = $2`
+	return scanLayer(m.DB.QueryRow(stmt, projectID, name))
+}
+
+// GetByProject returns the layers of a project ordered by name
+func (m *LayerModel) GetByProject(projectID uuid.UUID) ([]*Layer, error) {
+	stmt := `SELECT` + layerColumns + `FROM map_layers WHERE project_id = $1 ORDER BY name`
+	return m.query(stmt, projectID)
+}
+
+// GetByFile returns the layers showing a file
+func (m *LayerModel) GetByFile(fileID uuid.UUID) ([]*Layer, error) {
+	stmt := `SELECT` + layerColumns + `FROM map_layers WHERE file_id = $1`
+	return m.query(stmt, fileID)
+}
+
+func (m *LayerModel) query(stmt string, args ...interface{}) ([]*Layer, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	layers := []*Layer{}
+	for rows.Next() {
+		layer, err := scanLayer(rows)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, layer)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return layers, nil
+}
+
+// SetAttributes replaces the properties written to a layer's vector tiles
+func (m *LayerModel) SetAttributes(id uuid.UUID, attributes []string) error {
+	if attributes == nil {
+		attributes = []string{}
+	}
+	_, err := m.DB.Exec(`UPDATE map_layers SET tile_attributes = $2 WHERE id = $1`, id, pq.Array(attributes))
+	return err
+}
+
+// SetStyle replaces the drawing rules of a layer; nil restores the default
+func (m *LayerModel) SetStyle(id uuid.UUID, style *mapstyle.Style) error {
+	var value interface{}
+	if style != nil {
+		data, err := json.Marshal(style)
+		if err != nil {
+			return err
+		}
+		value = data
+	}
+	_, err := m.DB.Exec(`UPDATE map_layers SET style = $2 WHERE id = $1`, id, value)
+	return err
+}
+
+// SetSummary records the size, extent and attribute columns of a layer as
+// last read
+func (m *LayerModel) SetSummary(layer *Layer) error {
+	if layer.Schema == nil {
+		layer.Schema = []LayerField{}
+	}
+	schema, err := json.Marshal(layer.Schema)
+	if err != nil {
+		return err
+	}
+	_, err = m.DB.Exec(
+		`UPDATE map_layers SET feature_count = $2, bbox = $3, attribute_schema = $4 WHERE id = $1`,
+		layer.ID, layer.FeatureCount, pq.Float64Array(layer.BBox), schema,
+	)
+	return err
+}
+
+func (m *LayerModel) Delete(id uuid.UUID) error {
+	result, err := m.DB.Exec(`DELETE FROM map_layers WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+// models/mapViews.go
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// MapView is a saved state of the map: its extent, basemap, visible layers
+// and their styles, and features sketched by the user. A view attached to
+// a project can be opened by the project's members, one attached to a chat
+// by the chat's owner and any view by its creator.
+type MapView struct {
+	ID        uuid.UUID       `json:"id"`
+	Name      string          `json:"name"`
+	ProjectID uuid.NullUUID   `json:"project_id"`
+	ChatID    uuid.NullUUID   `json:"chat_id"`
+	CenterLon float64         `json:"center_lon"`
+	CenterLat float64         `json:"center_lat"`
+	Zoom      float64         `json:"zoom"`
+	Basemap   string          `json:"basemap"`
+	LayerIDs  []uuid.UUID     `json:"layer_ids"`
+	Styles    json.RawMessage `json:"styles"`   // Style options by layer ID
+	Sketches  json.RawMessage `json:"sketches"` // FeatureCollection drawn on the map
+	CreatedBy uuid.UUID       `json:"created_by"`
+	Created   time.Time       `json:"created"`
+	Updated   time.Time       `json:"updated"`
+}
+
+type MapViewModel struct {
+	DB *sql.DB
+}
+
+func NewMapViewModel(db *sql.DB) *MapViewModel {
+	return &MapViewModel{DB: db}
+}
+
+const mapViewColumns = `
+	id, name, project_id, chat_id, center_lon, center_lat, zoom, basemap,
+	layer_ids, styles, sketches, created_by, created, updated
+`
+
+func scanMapView(row interface{ Scan(...interface{}) error }) (*MapView, error) {
+	v := &MapView{}
+	var layerIDs []string
+	err := row.Scan(
+		&v.ID,
+		&v.Name,
+		&v.ProjectID,
+		&v.ChatID,
+		&v.CenterLon,
+		&v.CenterLat,
+		&v.Zoom,
+		&v.Basemap,
+		pq.Array(&layerIDs),
+		&v.Styles,
+		&v.Sketches,
+		&v.CreatedBy,
+		&v.Created,
+		&v.Updated,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	v.LayerIDs = make([]uuid.UUID, 0, len(layerIDs))
+	for _, id := range layerIDs {
+		if layerID, err := uuid.Parse(id); err == nil {
+			v.LayerIDs = append(v.LayerIDs, layerID)
+		}
+	}
+	return v, nil
+}
+
+// mapViewArgs returns the stored columns after id, in mapViewColumns order
+func mapViewArgs(v *MapView) []interface{} {
+	layerIDs := make([]string, len(v.LayerIDs))
+	for i, id := range v.LayerIDs {
+		layerIDs[i] = id.String()
+	}
+	if len(v.Styles) == 0 {
+		v.Styles = json.RawMessage("{}")
+	}
+	if len(v.Sketches) == 0 {
+		v.Sketches = json.RawMessage(`{"type":"FeatureCollection","features":[]}`)
+	}
+	return []interface{}{
+		v.Name, v.ProjectID, v.ChatID, v.CenterLon, v.CenterLat, v.Zoom, v.Basemap,
+		pq.Array(layerIDs), []byte(v.Styles), []byte(v.Sketches),
+	}
+}
+
+// Insert stores a new view
+func (m *MapViewModel) Insert(v *MapView) error {
+	if v.ID == uuid.Nil {
+		v.ID = uuid.New()
+	}
+
+	stmt := `
+		INSERT INTO map_views (
+			id, name, project_id, chat_id, center_lon, center_lat, zoom, basemap,
+			layer_ids, styles, sketches, created_by, created, updated
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NOW(), NOW())
+		RETURNING created, updated
+	`
+
+	args := append([]interface{}{v.ID}, mapViewArgs(v)...)
+	args = append(args, v.CreatedBy)
+	return m.DB.QueryRow(stmt, args...).Scan(&v.Created, &v.Updated)
+}
+
+// Update replaces everything but the creator of a view
+func (m *MapViewModel) Update(v *MapView) error {
+	stmt := `
+		UPDATE map_views SET
+			name = $2, project_id = $3, chat_id = $4, center_lon = $5, center_lat = $6,
+			zoom = $7, basemap = $8, layer_ids = $9, styles = $10, sketches = $11,
+			updated = NOW()
+		WHERE id = $1
+		RETURNING updated
+	`
+
+	args := append([]interface{}{v.ID}, mapViewArgs(v)...)
+	err := m.DB.QueryRow(stmt, args...).Scan(&v.Updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNoRecord
+	}
+	return err
+}
+
+// Get returns a view by ID
+func (m *MapViewModel) Get(id uuid.UUID) (*MapView, error) {
+	stmt := `SELECT` + mapViewColumns + `FROM map_views WHERE id = $1`
+	return scanMapView(m.DB.QueryRow(stmt, id))
+}
+
+// GetByProject returns the views attached to a project, newest first
+func (m *MapViewModel) GetByProject(projectID uuid.UUID) ([]*MapView, error) {
+	stmt := `SELECT` + mapViewColumns + `FROM map_views WHERE project_id = $1 ORDER BY updated DESC`
+	return m.query(stmt, projectID)
+}
+
+// GetByChat returns the views attached to a chat, newest first
+func (m *MapViewModel) GetByChat(chatID uuid.UUID) ([]*MapView, error) {
+	stmt := `SELECT` + mapViewColumns + `FROM map_views WHERE chat_id = $1 ORDER BY updated DESC`
+	return m.query(stmt, chatID)
+}
+
+func (m *MapViewModel) query(stmt string, args ...interface{}) ([]*MapView, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	views := []*MapView{}
+	for rows.Next() {
+		v, err := scanMapView(rows)
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, v)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return views, nil
+}
+
+func (m *MapViewModel) Delete(id uuid.UUID) error {
+	result, err := m.DB.Exec(`DELETE FROM map_views WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
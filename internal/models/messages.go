@@ -3,6 +3,8 @@ package models
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,6 +16,21 @@ type Message struct {
 	SenderType string
 	Content    string
 	Timestamp  time.Time
+	Citations  []Citation
+	// GeoObjects holds the answer's feature collections by name, or nil
+	GeoObjects json.RawMessage
+}
+
+// Citation points from an answer to the document text it is based on
+type Citation struct {
+	FileID  uuid.UUID `json:"file_id"`
+	ChunkID uuid.UUID `json:"chunk_id"`
+	Page    int       `json:"page,omitempty"`
+	Snippet string    `json:"snippet,omitempty"`
+	// FileName and Available are filled in from the files table when the
+	// citation is shown; a deleted file is no longer available
+	FileName  string `json:"file_name,omitempty"`
+	Available bool   `json:"available"`
 }
 
 type MessageModel struct {
@@ -24,22 +41,72 @@ func NewMessageModel(db *sql.DB) *MessageModel {
 	return &MessageModel{DB: db}
 }
 
-func (m *MessageModel) Insert(chatID uuid.UUID, senderType, content string) error {
+// Insert stores a message and returns its ID. geoObjects may be nil.
+func (m *MessageModel) Insert(chatID uuid.UUID, senderType, content string, citations []Citation, geoObjects json.RawMessage) (int, error) {
+	if citations == nil {
+		citations = []Citation{}
+	}
+	citationsJSON, err := json.Marshal(citations)
+	if err != nil {
+		return 0, err
+	}
+
+	var geo interface{}
+	if len(geoObjects) > 0 {
+		geo = []byte(geoObjects)
+	}
+
 	stmt := `
-		INSERT INTO messages (chat_id, sender_type, content, timestamp)
-		VALUES ($1, $2, $3, NOW())
+		INSERT INTO messages (chat_id, sender_type, content, citations, geo_objects, timestamp)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		RETURNING id
 	`
-	_, err := m.DB.Exec(stmt, chatID, senderType, content)
+	var id int
+	err = m.DB.QueryRow(stmt, chatID, senderType, content, citationsJSON, geo).Scan(&id)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	return id, nil
+}
+
+// Get returns a message by ID
+func (m *MessageModel) Get(id int) (*Message, error) {
+	stmt := `
+		SELECT id, chat_id, sender_type, content, citations, geo_objects, timestamp
+		FROM messages
+		WHERE id = $1
+	`
+
+	msg := &Message{}
+	var citations, geoObjects []byte
+	err := m.DB.QueryRow(stmt, id).Scan(
+		&msg.ID,
+		&msg.ChatID,
+		&msg.SenderType,
+		&msg.Content,
+		&citations,
+		&geoObjects,
+		&msg.Timestamp,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(citations, &msg.Citations); err != nil {
+		return nil, err
+	}
+	if len(geoObjects) > 0 {
+		msg.GeoObjects = geoObjects
+	}
+	return msg, nil
 }
 
 func (m *MessageModel) GetByChatID(chatID uuid.UUID) ([]*Message, error) {
 	stmt := `
-		SELECT id, chat_id, sender_type, content, timestamp
+		SELECT id, chat_id, sender_type, content, citations, geo_objects, timestamp
 		FROM messages
 		WHERE chat_id = $1
 		ORDER BY timestamp ASC
@@ -54,16 +121,25 @@ func (m *MessageModel) GetByChatID(chatID uuid.UUID) ([]*Message, error) {
 	messages := []*Message{}
 	for rows.Next() {
 		msg := &Message{}
+		var citations, geoObjects []byte
 		err = rows.Scan(
 			&msg.ID,
 			&msg.ChatID,
 			&msg.SenderType,
 			&msg.Content,
+			&citations,
+			&geoObjects,
 			&msg.Timestamp,
 		)
 		if err != nil {
 			return nil, err
 		}
+		if err := json.Unmarshal(citations, &msg.Citations); err != nil {
+			return nil, err
+		}
+		if len(geoObjects) > 0 {
+			msg.GeoObjects = geoObjects
+		}
 		messages = append(messages, msg)
 	}
 
@@ -72,4 +148,4 @@ func (m *MessageModel) GetByChatID(chatID uuid.UUID) ([]*Message, error) {
 	}
 
 	return messages, nil
-}
\ No newline at end of file
+}
@@ -18,6 +18,7 @@ type Project struct {
 	Created        time.Time
 	Updated        time.Time
 	DocumentCount  int
+	DefaultCRS     int // EPSG code, 0 when not set
 }
 
 type ProjectModel struct {
@@ -61,7 +62,8 @@ func (m *ProjectModel) Insert(name, description string, userID uuid.UUID) (uuid.
 func (m *ProjectModel) Get(id uuid.UUID) (*Project, error) {
 	stmt := `
         SELECT p.id, p.name,
-               (SELECT COUNT(*) FROM files_projects fp WHERE fp.project_id = p.id) AS document_count
+               (SELECT COUNT(*) FROM files_projects fp WHERE fp.project_id = p.id) AS document_count,
+               COALESCE(p.default_crs, 0)
         FROM projects p
         WHERE p.id = $1
     `
@@ -72,6 +74,7 @@ func (m *ProjectModel) Get(id uuid.UUID) (*Project, error) {
 		&project.ID,
 		&project.Name,
 		&project.DocumentCount,
+		&project.DefaultCRS,
 	)
 	
 	if err != nil {
@@ -158,4 +161,41 @@ func (m *ProjectModel) GetAll() ([]*Project, error) {
 	}
 	
 	return projects, nil
-}
\ No newline at end of file
+}
+// HasAccess reports whether the user is linked to the project
+func (m *ProjectModel) HasAccess(projectID, userID uuid.UUID) (bool, error) {
+	var exists bool
+	stmt := `
+        SELECT EXISTS(
+            SELECT 1 FROM users_projects WHERE project_id = $1 AND user_id = $2
+        )
+    `
+
+	err := m.DB.QueryRow(stmt, projectID, userID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// SetDefaultCRS stores the EPSG code assumed for GeoJSON without a crs
+// member; 0 clears it
+func (m *ProjectModel) SetDefaultCRS(id uuid.UUID, code int) error {
+	stmt := `UPDATE projects SET default_crs = NULLIF($2, 0), updated = NOW() WHERE id = $1`
+
+	result, err := m.DB.Exec(stmt, id, code)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
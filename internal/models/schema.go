@@ -104,4 +104,24 @@ func (m *SchemaModel) ListSchemasByDatabaseID(databaseID uuid.UUID) ([]Schema, e
 	}
 
 	return schemas, nil
-}
\ No newline at end of file
+}
+// GetProjectID returns the project whose database a schema belongs to
+func (m *SchemaModel) GetProjectID(schemaID uuid.UUID) (uuid.UUID, error) {
+	stmt := `
+		SELECT d.project_id
+		FROM schemas s
+		JOIN databases d ON d.id = s.database_id
+		WHERE s.id = $1
+	`
+
+	var projectID uuid.UUID
+	err := m.DB.QueryRow(stmt, schemaID).Scan(&projectID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, ErrNoRecord
+		}
+		return uuid.Nil, err
+	}
+
+	return projectID, nil
+}
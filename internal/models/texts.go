@@ -0,0 +1,44 @@
+// models/texts.go
+package models
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// TextModel stores the sections extracted from a document as JSON, so the
+// chunk step can run without extracting the document again
+type TextModel struct {
+	DB *sql.DB
+}
+
+func NewTextModel(db *sql.DB) *TextModel {
+	return &TextModel{DB: db}
+}
+
+// Save stores the extracted sections of a file, replacing earlier ones
+func (m *TextModel) Save(fileID, projectID uuid.UUID, sections []byte) error {
+	stmt := `
+		INSERT INTO document_texts (file_id, project_id, sections, created)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (file_id) DO UPDATE
+		SET project_id = EXCLUDED.project_id, sections = EXCLUDED.sections, created = NOW()
+	`
+
+	_, err := m.DB.Exec(stmt, fileID, projectID, sections)
+	return err
+}
+
+func (m *TextModel) Get(fileID uuid.UUID) ([]byte, error) {
+	var sections []byte
+	err := m.DB.QueryRow(`SELECT sections FROM document_texts WHERE file_id = $1`, fileID).Scan(&sections)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return sections, nil
+}
@@ -0,0 +1,157 @@
+// models/tokens.go
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Token scopes
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// TokenPrefix starts every personal access token so that leaked tokens are
+// easy to recognise
+const TokenPrefix = "kdg_"
+
+// Token is a personal access token for the REST API. The token itself is
+// shown once when created; only its SHA-256 hash is stored.
+type Token struct {
+	ID       uuid.UUID
+	UserID   uuid.UUID
+	Name     string
+	Prefix   string // First characters of the token, to tell tokens apart
+	Scopes   []string
+	Expires  time.Time
+	LastUsed sql.NullTime
+	Created  time.Time
+}
+
+// HasScope reports whether the token grants a scope. Write implies read.
+func (t *Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || (s == ScopeWrite && scope == ScopeRead) {
+			return true
+		}
+	}
+	return false
+}
+
+type TokenModel struct {
+	DB *sql.DB
+}
+
+func NewTokenModel(db *sql.DB) *TokenModel {
+	return &TokenModel{DB: db}
+}
+
+func hashToken(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+// Insert creates a token for a user and returns it with its plaintext
+func (m *TokenModel) Insert(userID uuid.UUID, name string, scopes []string, expires time.Time) (*Token, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	plaintext := TokenPrefix + hex.EncodeToString(secret)
+
+	t := &Token{
+		ID:      uuid.New(),
+		UserID:  userID,
+		Name:    name,
+		Prefix:  plaintext[:len(TokenPrefix)+8],
+		Scopes:  scopes,
+		Expires: expires.UTC(),
+	}
+
+	stmt := `
+		INSERT INTO api_tokens (id, user_id, name, prefix, token_hash, scopes, expires, created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+		RETURNING created
+	`
+	err := m.DB.QueryRow(stmt, t.ID, t.UserID, t.Name, t.Prefix, hashToken(plaintext), pq.Array(t.Scopes), t.Expires).Scan(&t.Created)
+	if err != nil {
+		return nil, "", err
+	}
+	return t, plaintext, nil
+}
+
+// Authenticate returns the unexpired token with the given plaintext and
+// records its use. Unknown and expired tokens give ErrInvalidCredentials.
+func (m *TokenModel) Authenticate(plaintext string) (*Token, error) {
+	stmt := `
+		UPDATE api_tokens SET last_used = NOW()
+		WHERE token_hash = $1 AND expires > NOW()
+		RETURNING id, user_id, name, prefix, scopes, expires, last_used, created
+	`
+	t := &Token{}
+	err := m.DB.QueryRow(stmt, hashToken(plaintext)).Scan(
+		&t.ID, &t.UserID, &t.Name, &t.Prefix, pq.Array(&t.Scopes), &t.Expires, &t.LastUsed, &t.Created,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+// GetByUser returns the tokens of a user, newest first
+func (m *TokenModel) GetByUser(userID uuid.UUID) ([]*Token, error) {
+	stmt := `
+		SELECT id, user_id, name, prefix, scopes, expires, last_used, created
+		FROM api_tokens WHERE user_id = $1
+		ORDER BY created DESC
+	`
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*Token{}
+	for rows.Next() {
+		t := &Token{}
+		err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Prefix, pq.Array(&t.Scopes), &t.Expires, &t.LastUsed, &t.Created)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Delete revokes one of a user's tokens
+func (m *TokenModel) Delete(id, userID uuid.UUID) error {
+	result, err := m.DB.Exec(`DELETE FROM api_tokens WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+// models/uploads.go
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Upload is an in-progress, resumable document upload
+type Upload struct {
+	ID              uuid.UUID
+	ProjectID       uuid.UUID
+	OwnerID         uuid.UUID
+	Name            string
+	Roles           string
+	StorageLocation string
+	Size            int64
+	SHA256          string
+	ChunkSize       int
+	NextChunk       int
+	TempPath        string
+	Created         time.Time
+	Updated         time.Time
+}
+
+type UploadModel struct {
+	DB *sql.DB
+}
+
+func NewUploadModel(db *sql.DB) *UploadModel {
+	return &UploadModel{DB: db}
+}
+
+func (m *UploadModel) Insert(upload *Upload) error {
+	if upload.ID == uuid.Nil {
+		upload.ID = uuid.New()
+	}
+
+	stmt := `
+		INSERT INTO uploads (
+			id, project_id, owner_id, name, roles, storage_location,
+			size, sha256, chunk_size, next_chunk, temp_path, created, updated
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, 0, $10, NOW(), NOW())
+	`
+
+	_, err := m.DB.Exec(
+		stmt,
+		upload.ID,
+		upload.ProjectID,
+		upload.OwnerID,
+		upload.Name,
+		upload.Roles,
+		upload.StorageLocation,
+		upload.Size,
+		upload.SHA256,
+		upload.ChunkSize,
+		upload.TempPath,
+	)
+	return err
+}
+
+func (m *UploadModel) Get(id uuid.UUID) (*Upload, error) {
+	stmt := `
+		SELECT id, project_id, owner_id, name, roles, storage_location,
+			   size, sha256, chunk_size, next_chunk, temp_path, created, updated
+		FROM uploads
+		WHERE id = $1
+	`
+
+	var u Upload
+	err := m.DB.QueryRow(stmt, id).Scan(
+		&u.ID,
+		&u.ProjectID,
+		&u.OwnerID,
+		&u.Name,
+		&u.Roles,
+		&u.StorageLocation,
+		&u.Size,
+		&u.SHA256,
+		&u.ChunkSize,
+		&u.NextChunk,
+		&u.TempPath,
+		&u.Created,
+		&u.Updated,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+// AcknowledgeChunk records that every chunk before next has been written
+func (m *UploadModel) AcknowledgeChunk(id uuid.UUID, next int) error {
+	stmt := `
+		UPDATE uploads
+		SET next_chunk = $1, updated = NOW()
+		WHERE id = $2
+	`
+
+	_, err := m.DB.Exec(stmt, next, id)
+	return err
+}
+
+// PendingBytes returns the declared size of unfinished uploads in a project
+func (m *UploadModel) PendingBytes(projectID uuid.UUID) (int64, error) {
+	stmt := `SELECT COALESCE(SUM(size), 0) FROM uploads WHERE project_id = $1`
+
+	var total int64
+	err := m.DB.QueryRow(stmt, projectID).Scan(&total)
+	return total, err
+}
+
+func (m *UploadModel) Delete(id uuid.UUID) error {
+	_, err := m.DB.Exec(`DELETE FROM uploads WHERE id = $1`, id)
+	return err
+}
+
+// Stale returns uploads that have not received a chunk since the cutoff
+func (m *UploadModel) Stale(cutoff time.Time) ([]*Upload, error) {
+	stmt := `
+		SELECT id, temp_path
+		FROM uploads
+		WHERE updated < $1
+	`
+
+	rows, err := m.DB.Query(stmt, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []*Upload{}
+	for rows.Next() {
+		u := &Upload{}
+		if err := rows.Scan(&u.ID, &u.TempPath); err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return uploads, nil
+}
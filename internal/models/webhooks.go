@@ -0,0 +1,417 @@
+// models/webhooks.go
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Webhook events
+const (
+	EventFileProcessed    = "file.processed"
+	EventSchemaRegistered = "schema.registered"
+	EventChatAnswer       = "chat.answer"
+	EventProjectCreated   = "project.created"
+)
+
+// WebhookEvents lists the events a webhook can subscribe to
+var WebhookEvents = []string{EventFileProcessed, EventSchemaRegistered, EventChatAnswer, EventProjectCreated}
+
+// WebhookSecretPrefix starts every signing secret
+const WebhookSecretPrefix = "whsec_"
+
+// Delivery states
+const (
+	DeliveryQueued    = "queued"
+	DeliveryRunning   = "running"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook posts the events of a project to a URL. A webhook without a
+// project receives the events of every project of its creator, including
+// projects created later.
+type Webhook struct {
+	ID          uuid.UUID
+	ProjectID   uuid.NullUUID
+	ProjectName string // Empty for webhooks of all projects
+	URL         string
+	Secret      string
+	Events      []string
+	CreatedBy   uuid.UUID
+	Created     time.Time
+}
+
+// HasEvent reports whether the webhook subscribes to an event
+func (w *Webhook) HasEvent(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is one attempt, retried until it succeeds, to post an
+// event to a webhook
+type WebhookDelivery struct {
+	ID           uuid.UUID
+	WebhookID    uuid.UUID
+	ProjectID    uuid.UUID
+	EventID      uuid.UUID // Shared by the deliveries of an event and their replays
+	Event        string
+	Payload      []byte
+	Status       string
+	Attempts     int
+	MaxAttempts  int
+	ResponseCode int
+	LastError    string
+	RunAfter     time.Time
+	LockedAt     sql.NullTime
+	DeliveredAt  sql.NullTime
+	Created      time.Time
+	Updated      time.Time
+	URL          string // Of the webhook, set by Recent
+}
+
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+func NewWebhookModel(db *sql.DB) *WebhookModel {
+	return &WebhookModel{DB: db}
+}
+
+// webhookVisible matches the webhooks a user ($1) created or that belong to
+// one of the user's projects
+const webhookVisible = `
+	(w.created_by = $1 OR w.project_id IN (SELECT project_id FROM users_projects WHERE user_id = $1))
+`
+
+const webhookColumns = `
+	w.id, w.project_id, COALESCE(p.name, ''), w.url, w.secret, w.events, w.created_by, w.created
+`
+
+func scanWebhook(row interface{ Scan(...interface{}) error }) (*Webhook, error) {
+	h := &Webhook{}
+	err := row.Scan(&h.ID, &h.ProjectID, &h.ProjectName, &h.URL, &h.Secret, pq.Array(&h.Events), &h.CreatedBy, &h.Created)
+	return h, err
+}
+
+func (m *WebhookModel) query(stmt string, args ...interface{}) ([]*Webhook, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := []*Webhook{}
+	for rows.Next() {
+		h, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return hooks, nil
+}
+
+// Insert creates a webhook with a new signing secret
+func (m *WebhookModel) Insert(projectID uuid.NullUUID, url string, events []string, createdBy uuid.UUID) (*Webhook, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	h := &Webhook{
+		ID:        uuid.New(),
+		ProjectID: projectID,
+		URL:       url,
+		Secret:    WebhookSecretPrefix + hex.EncodeToString(secret),
+		Events:    events,
+		CreatedBy: createdBy,
+	}
+
+	stmt := `
+		INSERT INTO webhooks (id, project_id, url, secret, events, created_by, created)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		RETURNING created
+	`
+	err := m.DB.QueryRow(stmt, h.ID, h.ProjectID, h.URL, h.Secret, pq.Array(h.Events), h.CreatedBy).Scan(&h.Created)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Get returns a webhook by ID
+func (m *WebhookModel) Get(id uuid.UUID) (*Webhook, error) {
+	stmt := `SELECT` + webhookColumns + `
+		FROM webhooks w LEFT JOIN projects p ON p.id = w.project_id
+		WHERE w.id = $1
+	`
+	h, err := scanWebhook(m.DB.QueryRow(stmt, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return h, nil
+}
+
+// GetByUser returns the webhooks a user can manage, newest first
+func (m *WebhookModel) GetByUser(userID uuid.UUID) ([]*Webhook, error) {
+	stmt := `SELECT` + webhookColumns + `
+		FROM webhooks w LEFT JOIN projects p ON p.id = w.project_id
+		WHERE` + webhookVisible + `
+		ORDER BY w.created DESC
+	`
+	return m.query(stmt, userID)
+}
+
+// ForEvent returns the webhooks that subscribe to an event of a project
+// and whose creator is still a member of it. When createdBy is valid only
+// that user's webhooks are returned.
+func (m *WebhookModel) ForEvent(projectID uuid.UUID, event string, createdBy uuid.NullUUID) ([]*Webhook, error) {
+	stmt := `SELECT` + webhookColumns + `
+		FROM webhooks w LEFT JOIN projects p ON p.id = w.project_id
+		WHERE $2 = ANY(w.events)
+		AND (w.project_id = $1 OR w.project_id IS NULL)
+		AND ($3::uuid IS NULL OR w.created_by = $3)
+		AND EXISTS (
+			SELECT 1 FROM users_projects up WHERE up.project_id = $1 AND up.user_id = w.created_by
+		)
+	`
+	return m.query(stmt, projectID, event, createdBy)
+}
+
+// Delete removes a webhook the user can manage, with its deliveries
+func (m *WebhookModel) Delete(id, userID uuid.UUID) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM webhooks w WHERE w.id = $2 AND`+webhookVisible, userID, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	if _, err := tx.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = $1`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type WebhookDeliveryModel struct {
+	DB *sql.DB
+}
+
+func NewWebhookDeliveryModel(db *sql.DB) *WebhookDeliveryModel {
+	return &WebhookDeliveryModel{DB: db}
+}
+
+const deliveryColumns = `
+	id, webhook_id, project_id, event_id, event, payload, status, attempts,
+	max_attempts, response_code, last_error, run_after, locked_at,
+	delivered_at, created, updated
+`
+
+func scanDelivery(row interface{ Scan(...interface{}) error }, extra ...interface{}) (*WebhookDelivery, error) {
+	d := &WebhookDelivery{}
+	dest := []interface{}{
+		&d.ID,
+		&d.WebhookID,
+		&d.ProjectID,
+		&d.EventID,
+		&d.Event,
+		&d.Payload,
+		&d.Status,
+		&d.Attempts,
+		&d.MaxAttempts,
+		&d.ResponseCode,
+		&d.LastError,
+		&d.RunAfter,
+		&d.LockedAt,
+		&d.DeliveredAt,
+		&d.Created,
+		&d.Updated,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	return d, err
+}
+
+// Enqueue adds a delivery that can run immediately
+func (m *WebhookDeliveryModel) Enqueue(d *WebhookDelivery) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+
+	stmt := `
+		INSERT INTO webhook_deliveries (
+			id, webhook_id, project_id, event_id, event, payload, status,
+			attempts, max_attempts, run_after, created, updated
+		) VALUES ($1, $2, $3, $4, $5, $6, 'queued', 0, $7, NOW(), NOW(), NOW())
+	`
+
+	_, err := m.DB.Exec(stmt, d.ID, d.WebhookID, d.ProjectID, d.EventID, d.Event, d.Payload, d.MaxAttempts)
+	if err != nil {
+		return err
+	}
+	d.Status = DeliveryQueued
+	return nil
+}
+
+// Get returns a delivery by ID
+func (m *WebhookDeliveryModel) Get(id uuid.UUID) (*WebhookDelivery, error) {
+	stmt := `SELECT` + deliveryColumns + `FROM webhook_deliveries WHERE id = $1`
+
+	d, err := scanDelivery(m.DB.QueryRow(stmt, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return d, nil
+}
+
+// Claim locks the next delivery that is due and marks it running, like
+// JobModel.Claim. It returns ErrNoRecord when no delivery is due.
+func (m *WebhookDeliveryModel) Claim(lockTimeout time.Duration) (*WebhookDelivery, error) {
+	stmt := `
+		UPDATE webhook_deliveries
+		SET status = 'running', attempts = attempts + 1, locked_at = NOW(), updated = NOW()
+		WHERE id = (
+			SELECT id FROM webhook_deliveries
+			WHERE (status = 'queued' AND run_after <= NOW())
+			   OR (status = 'running' AND locked_at < NOW() - $1 * INTERVAL '1 second')
+			ORDER BY run_after, created
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING` + deliveryColumns
+
+	d, err := scanDelivery(m.DB.QueryRow(stmt, int(lockTimeout.Seconds())))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return d, nil
+}
+
+// Succeed marks a running delivery delivered
+func (m *WebhookDeliveryModel) Succeed(id uuid.UUID, responseCode int) error {
+	stmt := `
+		UPDATE webhook_deliveries
+		SET status = 'delivered', response_code = $1, last_error = '',
+			locked_at = NULL, delivered_at = NOW(), updated = NOW()
+		WHERE id = $2 AND status = 'running'
+	`
+
+	_, err := m.DB.Exec(stmt, responseCode, id)
+	return err
+}
+
+// Retry records a failed attempt and queues the delivery again at runAfter
+func (m *WebhookDeliveryModel) Retry(id uuid.UUID, responseCode int, message string, runAfter time.Time) error {
+	stmt := `
+		UPDATE webhook_deliveries
+		SET status = 'queued', response_code = $1, last_error = $2, run_after = $3,
+			locked_at = NULL, updated = NOW()
+		WHERE id = $4 AND status = 'running'
+	`
+
+	_, err := m.DB.Exec(stmt, responseCode, message, runAfter, id)
+	return err
+}
+
+// Fail marks a delivery as failed for good
+func (m *WebhookDeliveryModel) Fail(id uuid.UUID, responseCode int, message string) error {
+	stmt := `
+		UPDATE webhook_deliveries
+		SET status = 'failed', response_code = $1, last_error = $2,
+			locked_at = NULL, updated = NOW()
+		WHERE id = $3 AND status = 'running'
+	`
+
+	_, err := m.DB.Exec(stmt, responseCode, message, id)
+	return err
+}
+
+// Replay queues a new delivery of the same event and payload
+func (m *WebhookDeliveryModel) Replay(d *WebhookDelivery) (*WebhookDelivery, error) {
+	replay := &WebhookDelivery{
+		WebhookID:   d.WebhookID,
+		ProjectID:   d.ProjectID,
+		EventID:     d.EventID,
+		Event:       d.Event,
+		Payload:     d.Payload,
+		MaxAttempts: d.MaxAttempts,
+	}
+	if err := m.Enqueue(replay); err != nil {
+		return nil, err
+	}
+	return replay, nil
+}
+
+// Recent returns the latest deliveries of the webhooks a user can manage
+func (m *WebhookDeliveryModel) Recent(userID uuid.UUID, limit int) ([]*WebhookDelivery, error) {
+	stmt := `
+		SELECT d.id, d.webhook_id, d.project_id, d.event_id, d.event, d.payload,
+			d.status, d.attempts, d.max_attempts, d.response_code, d.last_error,
+			d.run_after, d.locked_at, d.delivered_at, d.created, d.updated, w.url
+		FROM webhook_deliveries d
+		JOIN webhooks w ON w.id = d.webhook_id
+		WHERE` + webhookVisible + `
+		ORDER BY d.created DESC
+		LIMIT $2
+	`
+
+	rows, err := m.DB.Query(stmt, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		var url string
+		d, err := scanDelivery(rows, &url)
+		if err != nil {
+			return nil, err
+		}
+		d.URL = url
+		deliveries = append(deliveries, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
@@ -0,0 +1,400 @@
+package mvt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+
+	"kdg/be/lab/internal/geojson"
+)
+
+// Geometry types of the specification
+const (
+	typePoint      = 1
+	typeLineString = 2
+	typePolygon    = 3
+)
+
+// Geometry commands
+const (
+	cmdMoveTo    = 1
+	cmdLineTo    = 2
+	cmdClosePath = 7
+)
+
+// Layer collects the features of one named layer of a tile
+type Layer struct {
+	name       string
+	tile       Tile
+	attributes map[string]bool
+
+	keys     []string
+	keyIndex map[string]uint32
+	values   [][]byte
+	valIndex map[string]uint32
+	features [][]byte
+}
+
+// NewLayer starts a layer for a tile. Only properties named in attributes
+// are written.
+func NewLayer(name string, tile Tile, attributes []string) *Layer {
+	l := &Layer{
+		name:       name,
+		tile:       tile,
+		attributes: make(map[string]bool, len(attributes)),
+		keyIndex:   map[string]uint32{},
+		valIndex:   map[string]uint32{},
+	}
+	for _, a := range attributes {
+		l.attributes[a] = true
+	}
+	return l
+}
+
+// Len returns the number of encoded features
+func (l *Layer) Len() int {
+	return len(l.features)
+}
+
+// Add encodes a feature. Geometry collections become one feature per
+// member. Features entirely outside the tile are skipped.
+func (l *Layer) Add(f *geojson.Feature) {
+	if f.Geometry == nil {
+		return
+	}
+	tags := l.tags(f.Properties)
+	id, hasID := featureID(f.ID)
+	l.addGeometry(f.Geometry, tags, id, hasID)
+}
+
+func (l *Layer) addGeometry(g *geojson.Geometry, tags []uint32, id uint64, hasID bool) {
+	var geomType int
+	var commands []uint32
+
+	switch g.Type {
+	case geojson.Point:
+		geomType, commands = typePoint, l.points([]geojson.Position{g.Point})
+	case geojson.MultiPoint:
+		geomType, commands = typePoint, l.points(g.MultiPoint)
+	case geojson.LineString:
+		geomType, commands = typeLineString, l.lines([][]geojson.Position{g.LineString})
+	case geojson.MultiLineString:
+		geomType, commands = typeLineString, l.lines(g.MultiLineString)
+	case geojson.Polygon:
+		geomType, commands = typePolygon, l.polygons([][][]geojson.Position{g.Polygon})
+	case geojson.MultiPolygon:
+		geomType, commands = typePolygon, l.polygons(g.MultiPolygon)
+	case geojson.GeometryCollection:
+		for _, member := range g.Geometries {
+			if member != nil {
+				l.addGeometry(member, tags, id, hasID)
+			}
+		}
+		return
+	}
+	if len(commands) == 0 {
+		return
+	}
+
+	var b buffer
+	if hasID {
+		b.uintField(1, id)
+	}
+	if len(tags) > 0 {
+		b.packedField(2, tags)
+	}
+	b.uintField(3, uint64(geomType))
+	b.packedField(4, commands)
+	l.features = append(l.features, b)
+}
+
+// cursor writes geometry commands with coordinates relative to the
+// previous point, as the specification requires
+type cursor struct {
+	x, y     int32
+	commands []uint32
+}
+
+func command(id, count int) uint32 {
+	return uint32(id&0x7) | uint32(count)<<3
+}
+
+func zigzag(v int32) uint32 {
+	return uint32((v << 1) ^ (v >> 31))
+}
+
+func (c *cursor) moveTo(p [2]int32) {
+	c.commands = append(c.commands, command(cmdMoveTo, 1))
+	c.point(p)
+}
+
+func (c *cursor) lineTo(ps [][2]int32) {
+	c.commands = append(c.commands, command(cmdLineTo, len(ps)))
+	for _, p := range ps {
+		c.point(p)
+	}
+}
+
+func (c *cursor) point(p [2]int32) {
+	c.commands = append(c.commands, zigzag(p[0]-c.x), zigzag(p[1]-c.y))
+	c.x, c.y = p[0], p[1]
+}
+
+// quantize rounds points to integer coordinates and drops repeats
+func quantize(ps []point) [][2]int32 {
+	out := make([][2]int32, 0, len(ps))
+	for _, p := range ps {
+		q := [2]int32{int32(math.Round(p.x)), int32(math.Round(p.y))}
+		if len(out) > 0 && out[len(out)-1] == q {
+			continue
+		}
+		out = append(out, q)
+	}
+	return out
+}
+
+func (l *Layer) points(ps []geojson.Position) []uint32 {
+	var kept [][2]int32
+	for _, p := range l.tile.projectAll(ps) {
+		if inside(p) {
+			kept = append(kept, quantize([]point{p})[0])
+		}
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+
+	c := &cursor{}
+	c.commands = append(c.commands, command(cmdMoveTo, len(kept)))
+	for _, p := range kept {
+		c.point(p)
+	}
+	return c.commands
+}
+
+func (l *Layer) lines(lines [][]geojson.Position) []uint32 {
+	c := &cursor{}
+	for _, line := range lines {
+		projected := simplify(l.tile.projectAll(line), simplifyTolerance)
+		for _, part := range clipLine(projected) {
+			q := quantize(part)
+			if len(q) < 2 {
+				continue
+			}
+			c.moveTo(q[0])
+			c.lineTo(q[1:])
+		}
+	}
+	return c.commands
+}
+
+func (l *Layer) polygons(polygons [][][]geojson.Position) []uint32 {
+	c := &cursor{}
+	for _, polygon := range polygons {
+		for i, ring := range polygon {
+			projected := simplify(l.tile.projectAll(ring), simplifyTolerance)
+			q := quantize(clipRing(projected))
+			if len(q) > 1 && q[0] == q[len(q)-1] {
+				q = q[:len(q)-1]
+			}
+
+			area := ringArea(q)
+			if len(q) < 3 || area == 0 {
+				if i == 0 {
+					// Without its exterior ring the holes mean nothing
+					break
+				}
+				continue
+			}
+
+			// Exterior rings have a positive area in tile coordinates,
+			// where y points down, and holes a negative one
+			if (i == 0) != (area > 0) {
+				for a, b := 0, len(q)-1; a < b; a, b = a+1, b-1 {
+					q[a], q[b] = q[b], q[a]
+				}
+			}
+
+			c.moveTo(q[0])
+			c.lineTo(q[1:])
+			c.commands = append(c.commands, command(cmdClosePath, 1))
+		}
+	}
+	return c.commands
+}
+
+// ringArea applies the surveyor's formula to an open ring
+func ringArea(ring [][2]int32) int64 {
+	var sum int64
+	for i := range ring {
+		j := (i + 1) % len(ring)
+		sum += int64(ring[i][0])*int64(ring[j][1]) - int64(ring[j][0])*int64(ring[i][1])
+	}
+	return sum
+}
+
+// featureID returns the feature's id when it is a non-negative integer,
+// the only kind the specification allows
+func featureID(raw json.RawMessage) (uint64, bool) {
+	if len(raw) == 0 {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(string(raw), 10, 64)
+	return id, err == nil
+}
+
+// tags encodes the allowed properties as key and value indexes
+func (l *Layer) tags(raw json.RawMessage) []uint32 {
+	if len(l.attributes) == 0 || len(raw) == 0 {
+		return nil
+	}
+
+	var properties map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	if err := d.Decode(&properties); err != nil {
+		return nil
+	}
+
+	// Sorted keys keep the tile bytes, and so its ETag, stable
+	keys := make([]string, 0, len(properties))
+	for key, value := range properties {
+		if l.attributes[key] && value != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	tags := make([]uint32, 0, 2*len(keys))
+	for _, key := range keys {
+		tags = append(tags, l.key(key), l.value(properties[key]))
+	}
+	return tags
+}
+
+func (l *Layer) key(k string) uint32 {
+	if i, ok := l.keyIndex[k]; ok {
+		return i
+	}
+	i := uint32(len(l.keys))
+	l.keys = append(l.keys, k)
+	l.keyIndex[k] = i
+	return i
+}
+
+// value encodes a property value; arrays and objects are written as JSON
+// strings
+func (l *Layer) value(v interface{}) uint32 {
+	var b buffer
+	var id string
+
+	switch v := v.(type) {
+	case string:
+		b.bytesField(1, []byte(v))
+		id = "s" + v
+	case bool:
+		b.uintField(7, boolInt(v))
+		id = "b" + strconv.FormatBool(v)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			if n < 0 {
+				b.uintField(6, uint64((n<<1)^(n>>63)))
+			} else {
+				b.uintField(5, uint64(n))
+			}
+			id = "i" + strconv.FormatInt(n, 10)
+		} else {
+			f, _ := v.Float64()
+			b.fixed64Field(3, math.Float64bits(f))
+			id = "d" + v.String()
+		}
+	default:
+		data, _ := json.Marshal(v)
+		b.bytesField(1, data)
+		id = "s" + string(data)
+	}
+
+	if i, ok := l.valIndex[id]; ok {
+		return i
+	}
+	i := uint32(len(l.values))
+	l.values = append(l.values, b)
+	l.valIndex[id] = i
+	return i
+}
+
+func boolInt(v bool) uint64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// Encode writes a tile holding the non-empty layers
+func Encode(layers ...*Layer) []byte {
+	var tile buffer
+	for _, l := range layers {
+		if l.Len() == 0 {
+			continue
+		}
+		var b buffer
+		b.uintField(15, 2)
+		b.bytesField(1, []byte(l.name))
+		for _, f := range l.features {
+			b.bytesField(2, f)
+		}
+		for _, k := range l.keys {
+			b.bytesField(3, []byte(k))
+		}
+		for _, v := range l.values {
+			b.bytesField(4, v)
+		}
+		b.uintField(5, Extent)
+		tile.bytesField(3, b)
+	}
+	return tile
+}
+
+// buffer writes protocol buffer fields
+type buffer []byte
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+func (b *buffer) varint(v uint64) {
+	*b = binary.AppendUvarint(*b, v)
+}
+
+func (b *buffer) key(field, wire int) {
+	b.varint(uint64(field<<3 | wire))
+}
+
+func (b *buffer) uintField(field int, v uint64) {
+	b.key(field, wireVarint)
+	b.varint(v)
+}
+
+func (b *buffer) fixed64Field(field int, v uint64) {
+	b.key(field, wireFixed64)
+	*b = binary.LittleEndian.AppendUint64(*b, v)
+}
+
+func (b *buffer) bytesField(field int, data []byte) {
+	b.key(field, wireBytes)
+	b.varint(uint64(len(data)))
+	*b = append(*b, data...)
+}
+
+func (b *buffer) packedField(field int, vs []uint32) {
+	var packed buffer
+	for _, v := range vs {
+		packed.varint(uint64(v))
+	}
+	b.bytesField(field, packed)
+}
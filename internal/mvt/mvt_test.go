@@ -0,0 +1,189 @@
+package mvt
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"kdg/be/lab/internal/geojson"
+)
+
+func feature(t *testing.T, s string) *geojson.Feature {
+	t.Helper()
+	fc, err := geojson.Decode([]byte(s))
+	if err != nil {
+		t.Fatalf("%s: %v", s, err)
+	}
+	return fc.Features[0]
+}
+
+// unhex reads bytes written as hex pairs, ignoring spaces
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// The expected tiles are written out field by field: a tile holds a layer
+// (field 3) with version 2 (78 02), a name (0a), features (12), keys (1a),
+// values (22) and the extent 4096 (28 80 20). A feature has an id (08),
+// packed tags (12), a type (18) and packed geometry commands (22).
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name       string
+		tile       Tile
+		attributes []string
+		feature    string
+		want       string
+	}{
+		{
+			// Null island is the middle of tile 0/0/0, at 2048,2048:
+			// MoveTo(1) = 09 and zigzag(2048) = 4096 = 80 20
+			"point",
+			Tile{0, 0, 0},
+			[]string{"name"},
+			`{"type":"Feature","id":1,"geometry":{"type":"Point","coordinates":[0,0]},"properties":{"name":"a","skipped":2}}`,
+			"1a 29" +
+				"78 02" +
+				"0a 06 706f696e7473" +
+				"12 0f" +
+				/**/ "08 01" +
+				/**/ "12 02 00 00" +
+				/**/ "18 01" +
+				/**/ "22 05 09 8020 8020" +
+				"1a 04 6e616d65" +
+				"22 03 0a 01 61" +
+				"28 8020",
+		},
+		{
+			// In tile 1/1/0 the line runs through 1024,4096, 2048,2048 and
+			// 3072,4096: MoveTo(1), +1024 +4096, LineTo(2) = 12, then
+			// +1024 -2048 and +1024 +2048, where zigzag(-2048) = 4095 = ff 1f
+			"line",
+			Tile{1, 1, 0},
+			nil,
+			`{"type":"Feature","id":"text","geometry":{"type":"LineString","coordinates":[[45,0],[90,66.51326044311186],[135,0]]},"properties":{"name":"a"}}`,
+			"1a 20" +
+				"78 02" +
+				"0a 05 6c696e6573" +
+				"12 12" +
+				/**/ "18 02" +
+				/**/ "22 0e 09 8010 8040 12 8010 ff1f 8010 8020" +
+				"28 8020",
+		},
+		{
+			// The counterclockwise ring becomes 2048,1024, 3072,1024,
+			// 3072,2048 and 2048,2048, clockwise on screen where y points
+			// down: MoveTo(1), LineTo(3) = 1a with zigzag(-1024) = 2047 =
+			// ff 0f, and ClosePath(1) = 0f
+			"polygon",
+			Tile{0, 0, 0},
+			nil,
+			`{"type":"Feature","geometry":{"type":"Polygon","coordinates":[[[0,0],[90,0],[90,66.51326044311186],[0,66.51326044311186],[0,0]]]},"properties":null}`,
+			"1a 25" +
+				"78 02" +
+				"0a 08 706f6c79676f6e73" +
+				"12 14" +
+				/**/ "18 03" +
+				/**/ "22 10 09 8020 8010 1a 8010 00 00 8010 ff0f 00 0f" +
+				"28 8020",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLayer(tt.name+"s", tt.tile, tt.attributes)
+			l.Add(feature(t, tt.feature))
+			if l.Len() != 1 {
+				t.Fatalf("layer has %d features, want 1", l.Len())
+			}
+			got, want := Encode(l), unhex(t, tt.want)
+			if string(got) != string(want) {
+				t.Errorf("tile = % x\nwant   % x", got, want)
+			}
+		})
+	}
+}
+
+func TestEncodeSkips(t *testing.T) {
+	l := NewLayer("skipped", Tile{2, 0, 0}, nil)
+	for _, f := range []string{
+		`{"type":"Feature","geometry":null,"properties":null}`,
+		`{"type":"Feature","geometry":{"type":"Point","coordinates":[100,-40]},"properties":null}`,
+		`{"type":"Feature","geometry":{"type":"LineString","coordinates":[[100,-40],[120,-40]]},"properties":null}`,
+	} {
+		l.Add(feature(t, f))
+	}
+	if l.Len() != 0 {
+		t.Errorf("layer has %d features outside the tile", l.Len())
+	}
+	if got := Encode(l); len(got) != 0 {
+		t.Errorf("empty layer encoded as % x", got)
+	}
+}
+
+func TestValues(t *testing.T) {
+	l := NewLayer("values", Tile{}, []string{"s", "i", "n", "d", "b", "o"})
+	properties := `{"s":"x","i":300,"n":-2,"d":1.5,"b":true,"o":[1]}`
+	l.Add(&geojson.Feature{
+		Geometry:   &geojson.Geometry{Type: geojson.Point, Point: geojson.Position{0, 0}},
+		Properties: json.RawMessage(properties),
+	})
+	// Repeated values share an index
+	l.Add(&geojson.Feature{
+		Geometry:   &geojson.Geometry{Type: geojson.Point, Point: geojson.Position{1, 1}},
+		Properties: json.RawMessage(`{"s":"x"}`),
+	})
+
+	// Keys are sorted: b, d, i, n, o, s
+	wantKeys := []string{"b", "d", "i", "n", "o", "s"}
+	if strings.Join(l.keys, ",") != strings.Join(wantKeys, ",") {
+		t.Errorf("keys = %v, want %v", l.keys, wantKeys)
+	}
+	wantValues := []string{
+		"38 01",               // bool
+		"19 000000000000f83f", // double 1.5
+		"28 ac02",             // uint 300
+		"30 03",               // sint -2
+		"0a 03 5b315d",        // [1] as JSON
+		"0a 01 78",            // string x
+	}
+	if len(l.values) != len(wantValues) {
+		t.Fatalf("%d values, want %d", len(l.values), len(wantValues))
+	}
+	for i, want := range wantValues {
+		if got := l.values[i]; string(got) != string(unhex(t, want)) {
+			t.Errorf("value %d = % x, want %s", i, got, want)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	zigzags := []struct {
+		v    int32
+		want uint32
+	}{
+		{0, 0}, {-1, 1}, {1, 2}, {-2, 3}, {2, 4}, {2147483647, 4294967294}, {-2147483648, 4294967295},
+	}
+	for _, tt := range zigzags {
+		if got := zigzag(tt.v); got != tt.want {
+			t.Errorf("zigzag(%d) = %d, want %d", tt.v, got, tt.want)
+		}
+	}
+
+	commands := []struct {
+		id, count int
+		want      uint32
+	}{
+		{cmdMoveTo, 1, 9}, {cmdLineTo, 3, 26}, {cmdClosePath, 1, 15}, {cmdMoveTo, 120, 961},
+	}
+	for _, tt := range commands {
+		if got := command(tt.id, tt.count); got != tt.want {
+			t.Errorf("command(%d, %d) = %d, want %d", tt.id, tt.count, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+// Package mvt encodes GeoJSON features as Mapbox Vector Tiles (version 2
+// of the specification), projecting them to Web Mercator tile coordinates,
+// clipping them to the tile and simplifying them for its zoom level.
+package mvt
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"kdg/be/lab/internal/geojson"
+)
+
+// Tile geometry
+const (
+	// Extent is the number of coordinate units along a tile edge
+	Extent = 4096
+	// Buffer is how far, in coordinate units, geometries extend beyond
+	// the tile so that lines and polygons join up without seams
+	Buffer = 64
+	// MaxZoom is the deepest zoom level served
+	MaxZoom = 22
+
+	// simplifyTolerance is the Douglas-Peucker tolerance in coordinate
+	// units: half a screen pixel on a 256 pixel tile. Being fixed in tile
+	// units, it halves in ground distance with every zoom level.
+	simplifyTolerance = 8
+
+	maxLatitude = 85.05112878
+)
+
+// ErrInvalidTile is returned for tile addresses outside the pyramid
+var ErrInvalidTile = errors.New("mvt: invalid tile address")
+
+// Tile addresses a tile in the XYZ scheme used by web maps
+type Tile struct {
+	Z, X, Y uint32
+}
+
+// ParseTile reads a tile address. The y value may carry a .mvt suffix.
+func ParseTile(z, x, y string) (Tile, error) {
+	y = strings.TrimSuffix(y, ".mvt")
+	zv, errZ := strconv.ParseUint(z, 10, 32)
+	xv, errX := strconv.ParseUint(x, 10, 32)
+	yv, errY := strconv.ParseUint(y, 10, 32)
+	if errZ != nil || errX != nil || errY != nil || zv > MaxZoom {
+		return Tile{}, ErrInvalidTile
+	}
+	n := uint64(1) << zv
+	if xv >= n || yv >= n {
+		return Tile{}, ErrInvalidTile
+	}
+	return Tile{Z: uint32(zv), X: uint32(xv), Y: uint32(yv)}, nil
+}
+
+// Bounds returns the area the tile and its buffer cover, in degrees
+func (t Tile) Bounds() geojson.BBox {
+	n := float64(uint64(1) << t.Z)
+	pad := float64(Buffer) / Extent
+	west := (float64(t.X)-pad)/n*360 - 180
+	east := (float64(t.X)+1+pad)/n*360 - 180
+	north := tileLatitude(float64(t.Y)-pad, n)
+	south := tileLatitude(float64(t.Y)+1+pad, n)
+	return geojson.BBox{math.Max(west, -180), south, math.Min(east, 180), north}
+}
+
+func tileLatitude(y, n float64) float64 {
+	return math.Atan(math.Sinh(math.Pi*(1-2*y/n))) * 180 / math.Pi
+}
+
+// project converts a longitude and latitude to coordinate units of the
+// tile, which may fall outside 0..Extent
+func (t Tile) project(p geojson.Position) point {
+	n := float64(uint64(1) << t.Z)
+	lat := math.Max(-maxLatitude, math.Min(maxLatitude, p[1])) * math.Pi / 180
+	x := (p[0] + 180) / 360 * n
+	y := (1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2 * n
+	return point{(x - float64(t.X)) * Extent, (y - float64(t.Y)) * Extent}
+}
+
+type point struct{ x, y float64 }
+
+// clipBox is the tile area including the buffer
+var clipBox = [4]float64{-Buffer, -Buffer, Extent + Buffer, Extent + Buffer}
+
+func inside(p point) bool {
+	return p.x >= clipBox[0] && p.y >= clipBox[1] && p.x <= clipBox[2] && p.y <= clipBox[3]
+}
+
+func (t Tile) projectAll(ps []geojson.Position) []point {
+	out := make([]point, 0, len(ps))
+	for _, p := range ps {
+		if len(p) >= 2 {
+			out = append(out, t.project(p))
+		}
+	}
+	return out
+}
+
+// simplify applies Douglas-Peucker to a line, keeping both end points
+func simplify(ps []point, tolerance float64) []point {
+	if len(ps) < 3 {
+		return ps
+	}
+	keep := make([]bool, len(ps))
+	keep[0], keep[len(ps)-1] = true, true
+
+	stack := [][2]int{{0, len(ps) - 1}}
+	for len(stack) > 0 {
+		first, last := stack[len(stack)-1][0], stack[len(stack)-1][1]
+		stack = stack[:len(stack)-1]
+
+		maxDist, index := 0.0, -1
+		for i := first + 1; i < last; i++ {
+			if d := segmentDistance(ps[i], ps[first], ps[last]); d > maxDist {
+				maxDist, index = d, i
+			}
+		}
+		if index >= 0 && maxDist > tolerance {
+			keep[index] = true
+			stack = append(stack, [2]int{first, index}, [2]int{index, last})
+		}
+	}
+
+	out := make([]point, 0, len(ps))
+	for i, p := range ps {
+		if keep[i] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// segmentDistance is the distance from p to the segment a-b
+func segmentDistance(p, a, b point) float64 {
+	dx, dy := b.x-a.x, b.y-a.y
+	if dx == 0 && dy == 0 {
+		return math.Hypot(p.x-a.x, p.y-a.y)
+	}
+	t := ((p.x-a.x)*dx + (p.y-a.y)*dy) / (dx*dx + dy*dy)
+	t = math.Max(0, math.Min(1, t))
+	return math.Hypot(p.x-(a.x+t*dx), p.y-(a.y+t*dy))
+}
+
+// clipLine cuts a line to the clip box, which may split it into parts
+func clipLine(ps []point) [][]point {
+	var parts [][]point
+	var current []point
+	for i := 0; i+1 < len(ps); i++ {
+		a, b, ok := clipSegment(ps[i], ps[i+1])
+		if !ok {
+			if len(current) > 1 {
+				parts = append(parts, current)
+			}
+			current = nil
+			continue
+		}
+		if len(current) == 0 {
+			current = append(current, a)
+		} else if current[len(current)-1] != a {
+			parts = append(parts, current)
+			current = []point{a}
+		}
+		current = append(current, b)
+		// A segment leaving the box ends the part
+		if b != ps[i+1] {
+			parts = append(parts, current)
+			current = nil
+		}
+	}
+	if len(current) > 1 {
+		parts = append(parts, current)
+	}
+	return parts
+}
+
+// clipSegment clips a segment to the clip box with the Liang-Barsky
+// algorithm
+func clipSegment(a, b point) (point, point, bool) {
+	dx, dy := b.x-a.x, b.y-a.y
+	t0, t1 := 0.0, 1.0
+	checks := [4][2]float64{
+		{-dx, a.x - clipBox[0]},
+		{dx, clipBox[2] - a.x},
+		{-dy, a.y - clipBox[1]},
+		{dy, clipBox[3] - a.y},
+	}
+	for _, c := range checks {
+		p, q := c[0], c[1]
+		if p == 0 {
+			if q < 0 {
+				return a, b, false
+			}
+			continue
+		}
+		r := q / p
+		if p < 0 {
+			if r > t1 {
+				return a, b, false
+			}
+			t0 = math.Max(t0, r)
+		} else {
+			if r < t0 {
+				return a, b, false
+			}
+			t1 = math.Min(t1, r)
+		}
+	}
+	clippedA, clippedB := a, b
+	if t0 > 0 {
+		clippedA = point{a.x + t0*dx, a.y + t0*dy}
+	}
+	if t1 < 1 {
+		clippedB = point{a.x + t1*dx, a.y + t1*dy}
+	}
+	return clippedA, clippedB, true
+}
+
+// clipRing cuts a closed ring to the clip box with the Sutherland-Hodgman
+// algorithm. The result is not closed.
+func clipRing(ring []point) []point {
+	if len(ring) > 1 && ring[0] == ring[len(ring)-1] {
+		ring = ring[:len(ring)-1]
+	}
+	for edge := 0; edge < 4; edge++ {
+		if len(ring) == 0 {
+			return nil
+		}
+		in := func(p point) bool {
+			switch edge {
+			case 0:
+				return p.x >= clipBox[0]
+			case 1:
+				return p.x <= clipBox[2]
+			case 2:
+				return p.y >= clipBox[1]
+			default:
+				return p.y <= clipBox[3]
+			}
+		}
+		intersect := func(a, b point) point {
+			var t float64
+			switch edge {
+			case 0:
+				t = (clipBox[0] - a.x) / (b.x - a.x)
+			case 1:
+				t = (clipBox[2] - a.x) / (b.x - a.x)
+			case 2:
+				t = (clipBox[1] - a.y) / (b.y - a.y)
+			default:
+				t = (clipBox[3] - a.y) / (b.y - a.y)
+			}
+			return point{a.x + t*(b.x-a.x), a.y + t*(b.y-a.y)}
+		}
+
+		out := make([]point, 0, len(ring)+4)
+		prev := ring[len(ring)-1]
+		for _, p := range ring {
+			switch {
+			case in(p) && in(prev):
+				out = append(out, p)
+			case in(p):
+				out = append(out, intersect(prev, p), p)
+			case in(prev):
+				out = append(out, intersect(prev, p))
+			}
+			prev = p
+		}
+		ring = out
+	}
+	return ring
+}
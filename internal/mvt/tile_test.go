@@ -0,0 +1,81 @@
+package mvt
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"kdg/be/lab/internal/geojson"
+)
+
+func TestParseTile(t *testing.T) {
+	tests := []struct {
+		z, x, y string
+		want    Tile
+		wantErr bool
+	}{
+		{"0", "0", "0", Tile{0, 0, 0}, false},
+		{"3", "7", "5.mvt", Tile{3, 7, 5}, false},
+		{"22", "4194303", "4194303", Tile{22, 4194303, 4194303}, false},
+		{"0", "1", "0", Tile{}, true},
+		{"0", "0", "1", Tile{}, true},
+		{"3", "8", "0", Tile{}, true},
+		{"3", "0", "8", Tile{}, true},
+		{"-1", "0", "0", Tile{}, true},
+		{"3", "-1", "0", Tile{}, true},
+		{"23", "0", "0", Tile{}, true},
+		{"64", "0", "0", Tile{}, true},
+		{"4294967296", "0", "0", Tile{}, true},
+		{"a", "0", "0", Tile{}, true},
+		{"3", "1.5", "0", Tile{}, true},
+		{"3", "0", "", Tile{}, true},
+		{"3", "0", "0.png", Tile{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTile(tt.z, tt.x, tt.y)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidTile) {
+				t.Errorf("ParseTile(%s, %s, %s) error = %v, want ErrInvalidTile", tt.z, tt.x, tt.y, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseTile(%s, %s, %s) = %v, %v, want %v", tt.z, tt.x, tt.y, got, err, tt.want)
+		}
+	}
+}
+
+func TestProject(t *testing.T) {
+	tests := []struct {
+		tile Tile
+		p    geojson.Position
+		want point
+	}{
+		{Tile{0, 0, 0}, geojson.Position{-180, maxLatitude}, point{0, 0}},
+		{Tile{0, 0, 0}, geojson.Position{180, -maxLatitude}, point{Extent, Extent}},
+		{Tile{0, 0, 0}, geojson.Position{0, 0}, point{2048, 2048}},
+		{Tile{0, 0, 0}, geojson.Position{0, 89}, point{2048, 0}},
+		{Tile{1, 1, 1}, geojson.Position{0, 0}, point{0, 0}},
+		{Tile{1, 0, 0}, geojson.Position{0, 0}, point{Extent, Extent}},
+		{Tile{2, 1, 1}, geojson.Position{-45, 0}, point{2048, Extent}},
+	}
+
+	for _, tt := range tests {
+		got := tt.tile.project(tt.p)
+		if math.Abs(got.x-tt.want.x) > 1e-6 || math.Abs(got.y-tt.want.y) > 1e-6 {
+			t.Errorf("%v.project(%v) = %v, want %v", tt.tile, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestBounds(t *testing.T) {
+	box := Tile{1, 1, 0}.Bounds()
+	pad := 360.0 / 2 * Buffer / Extent
+	if math.Abs(box[0]+pad) > 1e-9 || box[2] != 180 {
+		t.Errorf("west and east = %v, %v, want %v, 180", box[0], box[2], -pad)
+	}
+	if box[1] >= 0 || box[3] <= maxLatitude {
+		t.Errorf("south and north = %v, %v, want beyond 0 and %v", box[1], box[3], maxLatitude)
+	}
+}
@@ -0,0 +1,241 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Chunking methods offered on the admin panel
+const (
+	MethodParagraph = "paragraph"
+	MethodSentence  = "sentence"
+	MethodToken     = "token"
+)
+
+// Tokenizer counts the tokens of a text for the embedding model
+type Tokenizer interface {
+	Count(text string) int
+}
+
+// WordTokenizer counts white space separated words, a close enough estimate
+// when the model's tokenizer is not available
+type WordTokenizer struct{}
+
+func (WordTokenizer) Count(text string) int {
+	return len(strings.Fields(text))
+}
+
+// ChunkStage splits the sections of a document into chunks of Count
+// paragraphs, sentences or tokens. Consecutive chunks share Overlap units.
+// A chunk never spans two heading paths, so each chunk stays within one part
+// of the document.
+type ChunkStage struct {
+	Method    string
+	Count     int
+	Overlap   int
+	Tokenizer Tokenizer
+}
+
+// NewChunkStage validates the options chosen for chunking
+func NewChunkStage(method string, count, overlap int) (*ChunkStage, error) {
+	switch method {
+	case MethodParagraph, MethodSentence, MethodToken:
+	case "":
+		method = MethodParagraph
+	default:
+		return nil, fmt.Errorf("pipeline: unknown chunk method %q", method)
+	}
+	if count <= 0 {
+		return nil, fmt.Errorf("pipeline: chunk count must be positive, got %d", count)
+	}
+	if overlap < 0 || overlap >= count {
+		return nil, fmt.Errorf("pipeline: overlap must be between 0 and %d, got %d", count-1, overlap)
+	}
+
+	return &ChunkStage{Method: method, Count: count, Overlap: overlap, Tokenizer: WordTokenizer{}}, nil
+}
+
+func (s *ChunkStage) Name() string {
+	return "chunk"
+}
+
+// unit is the smallest piece of text a chunk is built from
+type unit struct {
+	start, end  int
+	page        int
+	headingPath []string
+}
+
+func (s *ChunkStage) Process(ctx context.Context, doc *Document) error {
+	tokenizer := s.Tokenizer
+	if tokenizer == nil {
+		tokenizer = WordTokenizer{}
+	}
+
+	units := s.units(doc)
+	doc.Chunks = doc.Chunks[:0]
+
+	for start := 0; start < len(units); {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Extend the chunk up to Count units within one heading path
+		end := start + 1
+		for end < len(units) && end-start < s.Count && samePath(units[end].headingPath, units[start].headingPath) {
+			end++
+		}
+
+		first, last := units[start], units[end-1]
+		text := doc.Text[first.start:last.end]
+		doc.Chunks = append(doc.Chunks, Chunk{
+			Index:       len(doc.Chunks),
+			Text:        text,
+			Page:        first.page,
+			PageEnd:     last.page,
+			HeadingPath: copyPath(first.headingPath),
+			Start:       first.start,
+			End:         last.end,
+			TokenCount:  tokenizer.Count(text),
+		})
+
+		if end == len(units) {
+			break
+		}
+
+		// Step back by the overlap, unless the next chunk starts a new heading
+		next := end - s.Overlap
+		if next <= start || !samePath(units[end].headingPath, units[start].headingPath) {
+			next = end
+		}
+		start = next
+	}
+
+	return nil
+}
+
+// units splits every section according to the chunking method
+func (s *ChunkStage) units(doc *Document) []unit {
+	units := []unit{}
+	for _, section := range doc.Sections {
+		switch s.Method {
+		case MethodSentence:
+			for _, span := range sentenceSpans(section.Text) {
+				units = append(units, unit{section.Start + span[0], section.Start + span[1], section.Page, section.HeadingPath})
+			}
+		case MethodToken:
+			for _, span := range wordSpans(section.Text) {
+				units = append(units, unit{section.Start + span[0], section.Start + span[1], section.Page, section.HeadingPath})
+			}
+		default:
+			units = append(units, unit{section.Start, section.End, section.Page, section.HeadingPath})
+		}
+	}
+	return units
+}
+
+// sentenceSpans returns the byte ranges of the sentences in text. A sentence
+// ends at '.', '!' or '?' followed by white space and an upper case letter,
+// digit or quote, or at the end of a line break pair.
+func sentenceSpans(text string) [][2]int {
+	spans := [][2]int{}
+	start := 0
+
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+
+		if r != '.' && r != '!' && r != '?' && r != '\n' {
+			continue
+		}
+
+		// Include closing quotes and brackets in the sentence
+		end := i
+		for end < len(text) {
+			c, n := utf8.DecodeRuneInString(text[end:])
+			if c != '"' && c != '\'' && c != ')' && c != '’' && c != '”' {
+				break
+			}
+			end += n
+		}
+
+		next := end
+		for next < len(text) {
+			c, n := utf8.DecodeRuneInString(text[next:])
+			if !unicode.IsSpace(c) {
+				break
+			}
+			next += n
+		}
+		if next == end && next < len(text) {
+			continue
+		}
+
+		if r != '\n' && next < len(text) {
+			c, _ := utf8.DecodeRuneInString(text[next:])
+			if !unicode.IsUpper(c) && !unicode.IsDigit(c) && c != '"' && c != '“' && c != '\'' {
+				continue
+			}
+		}
+		if r == '\n' && !strings.HasPrefix(text[end:], "\n") {
+			// Single line breaks stay inside the sentence
+			continue
+		}
+
+		if strings.TrimSpace(text[start:end]) != "" {
+			spans = append(spans, trimSpan(text, start, end))
+		}
+		start = next
+		i = next
+	}
+
+	if strings.TrimSpace(text[start:]) != "" {
+		spans = append(spans, trimSpan(text, start, len(text)))
+	}
+	return spans
+}
+
+// wordSpans returns the byte ranges of the white space separated words
+func wordSpans(text string) [][2]int {
+	spans := [][2]int{}
+	start := -1
+
+	for i, r := range text {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				spans = append(spans, [2]int{start, i})
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(text)})
+	}
+	return spans
+}
+
+// trimSpan shrinks a byte range to exclude surrounding white space
+func trimSpan(text string, start, end int) [2]int {
+	for start < end {
+		r, n := utf8.DecodeRuneInString(text[start:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		start += n
+	}
+	for end > start {
+		r, n := utf8.DecodeLastRuneInString(text[:end])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		end -= n
+	}
+	return [2]int{start, end}
+}
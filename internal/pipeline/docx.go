@@ -0,0 +1,194 @@
+package pipeline
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DOCXExtractor reads the paragraphs and tables of a Word document. Headings
+// are recognised by their outline level, page numbers follow the page breaks
+// stored in the document.
+type DOCXExtractor struct{}
+
+// Matches the built-in heading style IDs, "Heading1", "Kop1", ...
+var headingStyle = regexp.MustCompile(`(?i)^(heading|kop|titre|berschrift)\s*([1-9])$`)
+
+func (DOCXExtractor) Extract(r io.ReaderAt, size int64) ([]Section, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var document *zip.File
+	outlineLevels := map[string]int{}
+	for _, f := range archive.File {
+		switch f.Name {
+		case "word/document.xml":
+			document = f
+		case "word/styles.xml":
+			if levels, err := docxOutlineLevels(f); err == nil {
+				outlineLevels = levels
+			}
+		}
+	}
+	if document == nil {
+		return nil, errors.New("word/document.xml not found")
+	}
+
+	rc, err := document.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	sections := []Section{}
+	headings := []string{}
+	page := 1
+	hardBreak := false
+
+	var paragraph strings.Builder
+	level := 0
+	tableDepth := 0
+	row := []string{}
+
+	dec := xml.NewDecoder(rc)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "tbl":
+				tableDepth++
+			case "p":
+				paragraph.Reset()
+				level = 0
+			case "pStyle":
+				style := xmlAttr(t, "val")
+				if l, ok := outlineLevels[style]; ok {
+					level = l
+				} else if m := headingStyle.FindStringSubmatch(style); m != nil {
+					level, _ = strconv.Atoi(m[2])
+				} else if strings.EqualFold(style, "Title") {
+					level = 1
+				}
+			case "outlineLvl":
+				if l, err := strconv.Atoi(xmlAttr(t, "val")); err == nil && l < 9 {
+					level = l + 1
+				}
+			case "t":
+				var text string
+				if err := dec.DecodeElement(&text, &t); err != nil {
+					return nil, err
+				}
+				paragraph.WriteString(text)
+				hardBreak = false
+			case "tab":
+				paragraph.WriteString("\t")
+			case "br":
+				if xmlAttr(t, "type") == "page" {
+					page++
+					hardBreak = true
+				} else {
+					paragraph.WriteString("\n")
+				}
+			case "lastRenderedPageBreak":
+				// Word also records where an explicit break was rendered
+				if !hardBreak {
+					page++
+				}
+				hardBreak = false
+			}
+
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "p":
+				text := strings.TrimSpace(paragraph.String())
+				if text == "" {
+					continue
+				}
+				if tableDepth > 0 {
+					row = append(row, text)
+					continue
+				}
+				if level > 0 {
+					if level > len(headings)+1 {
+						level = len(headings) + 1
+					}
+					headings = append(headings[:level-1], text)
+				}
+				sections = append(sections, Section{Text: text, Page: page, HeadingPath: copyPath(headings)})
+			case "tr":
+				if len(row) > 0 {
+					sections = append(sections, Section{Text: strings.Join(row, " | "), Page: page, HeadingPath: copyPath(headings)})
+				}
+				row = row[:0]
+			case "tbl":
+				tableDepth--
+			}
+		}
+	}
+
+	return sections, nil
+}
+
+// docxOutlineLevels maps paragraph style IDs to the heading level their
+// outline level gives them
+func docxOutlineLevels(f *zip.File) (map[string]int, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	levels := map[string]int{}
+	style := ""
+
+	dec := xml.NewDecoder(rc)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return levels, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "style":
+				style = xmlAttr(t, "styleId")
+			case "outlineLvl":
+				if l, err := strconv.Atoi(xmlAttr(t, "val")); err == nil && style != "" && l < 9 {
+					levels[style] = l + 1
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "style" {
+				style = ""
+			}
+		}
+	}
+}
+
+// xmlAttr returns an attribute by local name, ignoring its namespace
+func xmlAttr(e xml.StartElement, name string) string {
+	for _, attr := range e.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
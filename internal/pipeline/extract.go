@@ -0,0 +1,81 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"kdg/be/lab/internal/sniff"
+	"path/filepath"
+	"strings"
+)
+
+// Extractor reads the text of one kind of document
+type Extractor interface {
+	Extract(r io.ReaderAt, size int64) ([]Section, error)
+}
+
+// ExtractStage fills Document.Sections using the extractor registered for the
+// document type
+type ExtractStage struct {
+	Extractors map[string]Extractor
+}
+
+// NewExtractStage returns an extraction stage for every supported type
+func NewExtractStage() *ExtractStage {
+	return &ExtractStage{
+		Extractors: map[string]Extractor{
+			sniff.PDF:  PDFExtractor{},
+			sniff.DOCX: DOCXExtractor{},
+			sniff.CSV:  CSVExtractor{},
+			sniff.Text: TextExtractor{},
+			"html":     HTMLExtractor{},
+			"markdown": MarkdownExtractor{},
+		},
+	}
+}
+
+func (s *ExtractStage) Name() string {
+	return "extract"
+}
+
+func (s *ExtractStage) Process(ctx context.Context, doc *Document) error {
+	docType := textType(doc.Type, doc.Name)
+
+	extractor, ok := s.Extractors[docType]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoExtractor, doc.Type)
+	}
+
+	sections, err := extractor.Extract(doc.Source, doc.Size)
+	if err != nil {
+		return fmt.Errorf("pipeline: extracting %s: %w", docType, err)
+	}
+
+	doc.SetSections(sections)
+	if len(doc.Sections) == 0 {
+		return ErrNoText
+	}
+
+	return nil
+}
+
+// textType refines the plain text type using the file extension, content
+// sniffing cannot tell Markdown or HTML apart from other text
+func textType(docType, name string) string {
+	if docType != sniff.Text {
+		return docType
+	}
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".md", ".markdown":
+		return "markdown"
+	case ".html", ".htm", ".xhtml":
+		return "html"
+	}
+	return docType
+}
+
+// readAll reads a whole document into memory
+func readAll(r io.ReaderAt, size int64) ([]byte, error) {
+	return io.ReadAll(io.NewSectionReader(r, 0, size))
+}
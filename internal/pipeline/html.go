@@ -0,0 +1,174 @@
+package pipeline
+
+import (
+	"html"
+	"io"
+	"strings"
+)
+
+// Elements whose content is never shown as text
+var htmlSkipped = map[string]bool{
+	"head": true, "script": true, "style": true, "noscript": true,
+	"template": true, "svg": true, "iframe": true,
+}
+
+// Elements that end the current paragraph
+var htmlBlocks = map[string]bool{
+	"p": true, "div": true, "section": true, "article": true, "main": true,
+	"header": true, "footer": true, "aside": true, "nav": true, "blockquote": true,
+	"pre": true, "ul": true, "ol": true, "li": true, "dl": true, "dt": true, "dd": true,
+	"table": true, "tr": true, "figure": true, "figcaption": true, "form": true,
+	"hr": true, "br": true, "address": true,
+}
+
+// HTMLExtractor collects the visible text of an HTML page per block element
+// and tracks the h1 to h6 headings above it
+type HTMLExtractor struct{}
+
+func (HTMLExtractor) Extract(r io.ReaderAt, size int64) ([]Section, error) {
+	data, err := readAll(r, size)
+	if err != nil {
+		return nil, err
+	}
+	doc := toUTF8(data)
+
+	sections := []Section{}
+	headings := []string{}
+	var text strings.Builder
+	heading := 0
+	skip := ""
+
+	flush := func() {
+		content := collapseSpace(html.UnescapeString(text.String()))
+		text.Reset()
+		if content == "" {
+			return
+		}
+
+		if heading > 0 {
+			level := heading
+			if level > len(headings)+1 {
+				level = len(headings) + 1
+			}
+			headings = append(headings[:level-1], content)
+		}
+		sections = append(sections, Section{Text: content, HeadingPath: copyPath(headings)})
+	}
+
+	for i := 0; i < len(doc); {
+		if doc[i] != '<' {
+			next := strings.IndexByte(doc[i:], '<')
+			if next < 0 {
+				next = len(doc) - i
+			}
+			if skip == "" {
+				text.WriteString(doc[i : i+next])
+			}
+			i += next
+			continue
+		}
+
+		// Comments, doctypes and processing instructions
+		if strings.HasPrefix(doc[i:], "<!--") {
+			end := strings.Index(doc[i+4:], "-->")
+			if end < 0 {
+				break
+			}
+			i += 4 + end + 3
+			continue
+		}
+		if strings.HasPrefix(doc[i:], "<!") || strings.HasPrefix(doc[i:], "<?") {
+			end := strings.IndexByte(doc[i:], '>')
+			if end < 0 {
+				break
+			}
+			i += end + 1
+			continue
+		}
+
+		end := tagEnd(doc, i)
+		if end < 0 {
+			break
+		}
+		name, closing := tagName(doc[i+1 : end])
+		i = end + 1
+
+		if skip != "" {
+			if closing && name == skip {
+				skip = ""
+			}
+			continue
+		}
+		if !closing && htmlSkipped[name] {
+			skip = name
+			continue
+		}
+
+		if level := headingLevel(name); level > 0 {
+			flush()
+			if closing {
+				heading = 0
+			} else {
+				heading = level
+			}
+			continue
+		}
+
+		if htmlBlocks[name] {
+			flush()
+			continue
+		}
+
+		// Table cells stay on one line but need a separator
+		if !closing && (name == "td" || name == "th") {
+			text.WriteString(" | ")
+		}
+	}
+	flush()
+
+	return sections, nil
+}
+
+// tagEnd finds the '>' that closes the tag starting at i, skipping quoted
+// attribute values
+func tagEnd(doc string, i int) int {
+	quote := byte(0)
+	for j := i + 1; j < len(doc); j++ {
+		switch c := doc[j]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return j
+		}
+	}
+	return -1
+}
+
+// tagName returns the lower case element name of a tag's content
+func tagName(tag string) (string, bool) {
+	closing := strings.HasPrefix(tag, "/")
+	tag = strings.TrimPrefix(tag, "/")
+
+	end := strings.IndexAny(tag, " \t\r\n/")
+	if end >= 0 {
+		tag = tag[:end]
+	}
+	return strings.ToLower(tag), closing
+}
+
+func headingLevel(name string) int {
+	if len(name) == 2 && name[0] == 'h' && name[1] >= '1' && name[1] <= '6' {
+		return int(name[1] - '0')
+	}
+	return 0
+}
+
+// collapseSpace replaces runs of white space with a single space
+func collapseSpace(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	return strings.Trim(s, " |")
+}
@@ -0,0 +1,645 @@
+package pipeline
+
+import (
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// PDFExtractor reads the text layer of a PDF page by page. Scanned documents
+// without text yield no sections.
+type PDFExtractor struct{}
+
+func (PDFExtractor) Extract(r io.ReaderAt, size int64) ([]Section, error) {
+	data, err := readAll(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := parsePDF(data)
+	if err != nil {
+		return nil, err
+	}
+
+	sections := []Section{}
+	for i, page := range f.pages() {
+		text := f.pageText(page)
+		for _, paragraph := range splitParagraphs(text) {
+			sections = append(sections, Section{Text: joinPDFLines(paragraph), Page: i + 1})
+		}
+	}
+
+	return sections, nil
+}
+
+// pdfPage is a page dictionary with the resources it inherits
+type pdfPage struct {
+	dict      pdfDict
+	resources pdfDict
+}
+
+// pages walks the page tree from the catalog. Documents with a broken tree
+// fall back to every page object in object number order.
+func (f *pdfFile) pages() []pdfPage {
+	var root pdfDict
+	if ref, ok := f.trailerValue("Root"); ok {
+		root = f.dict(ref)
+	}
+	if root == nil {
+		for _, obj := range f.objects {
+			if d, ok := obj.(pdfDict); ok && d["Type"] == pdfName("Catalog") {
+				root = d
+				break
+			}
+		}
+	}
+
+	pages := []pdfPage{}
+	if root != nil {
+		visited := map[int]bool{}
+		var walk func(node interface{}, resources pdfDict)
+		walk = func(node interface{}, resources pdfDict) {
+			if ref, ok := node.(pdfRef); ok {
+				if visited[ref.Num] {
+					return
+				}
+				visited[ref.Num] = true
+			}
+
+			dict := f.dict(node)
+			if dict == nil {
+				return
+			}
+			if res := f.dict(dict["Resources"]); res != nil {
+				resources = res
+			}
+
+			if kids, ok := f.resolve(dict["Kids"]).(pdfArray); ok {
+				for _, kid := range kids {
+					walk(kid, resources)
+				}
+				return
+			}
+			pages = append(pages, pdfPage{dict: dict, resources: resources})
+		}
+		walk(root["Pages"], nil)
+	}
+	if len(pages) > 0 {
+		return pages
+	}
+
+	nums := []int{}
+	for num, obj := range f.objects {
+		if d, ok := obj.(pdfDict); ok && d["Type"] == pdfName("Page") {
+			nums = append(nums, num)
+		}
+	}
+	sort.Ints(nums)
+	for _, num := range nums {
+		d := f.objects[num].(pdfDict)
+		pages = append(pages, pdfPage{dict: d, resources: f.dict(d["Resources"])})
+	}
+	return pages
+}
+
+// pageText runs the content streams of a page and returns its text, with
+// blank lines where the vertical gap suggests a new paragraph
+func (f *pdfFile) pageText(page pdfPage) string {
+	var content []byte
+	switch v := f.resolve(page.dict["Contents"]).(type) {
+	case *pdfStream:
+		content, _ = f.decodeStream(v)
+	case pdfArray:
+		for _, part := range v {
+			if stream, ok := f.resolve(part).(*pdfStream); ok {
+				if data, err := f.decodeStream(stream); err == nil {
+					content = append(content, data...)
+					content = append(content, '\n')
+				}
+			}
+		}
+	}
+
+	w := &pdfTextWriter{fontSize: 1, scale: 1}
+	f.runContent(w, content, page.resources, 0)
+	return w.b.String()
+}
+
+// pdfTextWriter collects the text shown by a content stream
+type pdfTextWriter struct {
+	b        strings.Builder
+	font     *pdfFont
+	fontSize float64
+	// scale is the vertical scale of the text matrix
+	scale float64
+	y     float64
+	haveY bool
+}
+
+func (w *pdfTextWriter) write(s string) {
+	w.b.WriteString(s)
+}
+
+// space adds a word break unless the text already ends with one
+func (w *pdfTextWriter) space() {
+	s := w.b.String()
+	if len(s) > 0 && !strings.HasSuffix(s, " ") && !strings.HasSuffix(s, "\n") {
+		w.b.WriteByte(' ')
+	}
+}
+
+// moveTo handles a move to a new line at vertical position y
+func (w *pdfTextWriter) moveTo(y float64) {
+	if !w.haveY {
+		w.y, w.haveY = y, true
+		return
+	}
+
+	gap := math.Abs(w.y - y)
+	w.y = y
+	lineHeight := math.Abs(w.fontSize * w.scale)
+	if lineHeight == 0 {
+		lineHeight = 1
+	}
+
+	switch {
+	case gap < lineHeight*0.3:
+		w.space()
+	case gap > lineHeight*1.8:
+		w.newline(true)
+	default:
+		w.newline(false)
+	}
+}
+
+func (w *pdfTextWriter) newline(paragraph bool) {
+	s := w.b.String()
+	if s == "" {
+		return
+	}
+	if paragraph {
+		if !strings.HasSuffix(s, "\n\n") {
+			if strings.HasSuffix(s, "\n") {
+				w.b.WriteByte('\n')
+			} else {
+				w.b.WriteString("\n\n")
+			}
+		}
+		return
+	}
+	if !strings.HasSuffix(s, "\n") {
+		w.b.WriteByte('\n')
+	}
+}
+
+// runContent interprets the text operators of a content stream. Form
+// XObjects are followed up to a fixed depth.
+func (f *pdfFile) runContent(w *pdfTextWriter, content []byte, resources pdfDict, depth int) {
+	if depth > 8 {
+		return
+	}
+
+	fonts := f.dict(resources["Font"])
+	xobjects := f.dict(resources["XObject"])
+
+	l := &pdfLexer{data: content}
+	operands := []interface{}{}
+
+	for {
+		tok, err := l.token()
+		if err != nil {
+			return
+		}
+		op, ok := tok.(pdfKeyword)
+		if !ok {
+			obj, err := l.objectFrom(tok)
+			if err != nil {
+				return
+			}
+			operands = append(operands, obj)
+			continue
+		}
+
+		switch op {
+		case "Tf":
+			if len(operands) >= 2 {
+				if name, ok := operands[0].(pdfName); ok {
+					w.font = f.font(fonts, name)
+				}
+				if size, ok := operands[1].(float64); ok {
+					w.fontSize = size
+				}
+			}
+		case "Tj":
+			if len(operands) >= 1 {
+				w.write(w.font.decode(operands[0]))
+			}
+		case "'":
+			w.newline(false)
+			if len(operands) >= 1 {
+				w.write(w.font.decode(operands[0]))
+			}
+		case "\"":
+			w.newline(false)
+			if len(operands) >= 3 {
+				w.write(w.font.decode(operands[2]))
+			}
+		case "TJ":
+			if len(operands) >= 1 {
+				if arr, ok := operands[0].(pdfArray); ok {
+					for _, item := range arr {
+						switch v := item.(type) {
+						case pdfString:
+							w.write(w.font.decode(v))
+						case float64:
+							// Large negative adjustments separate words
+							if v < -200 {
+								w.space()
+							}
+						}
+					}
+				}
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 {
+				tx, _ := operands[0].(float64)
+				ty, _ := operands[1].(float64)
+				if ty != 0 {
+					w.moveTo(w.y + ty*w.scale)
+				} else if tx != 0 {
+					w.space()
+				}
+			}
+		case "Tm":
+			if len(operands) >= 6 {
+				d, _ := operands[3].(float64)
+				y, _ := operands[5].(float64)
+				if d != 0 {
+					w.scale = d
+				}
+				w.moveTo(y)
+			}
+		case "T*":
+			w.newline(false)
+		case "ET":
+			w.space()
+		case "Do":
+			if len(operands) >= 1 {
+				if name, ok := operands[0].(pdfName); ok {
+					if form, ok := f.resolve(xobjects[name]).(*pdfStream); ok && form.Dict["Subtype"] == pdfName("Form") {
+						formResources := f.dict(form.Dict["Resources"])
+						if formResources == nil {
+							formResources = resources
+						}
+						if data, err := f.decodeStream(form); err == nil {
+							f.runContent(w, data, formResources, depth+1)
+						}
+					}
+				}
+			}
+		case "BI":
+			// Skip inline image data up to the EI operator
+			end := inlineImageEnd.FindIndex(l.data[l.pos:])
+			if end == nil {
+				return
+			}
+			l.pos += end[1]
+		}
+
+		operands = operands[:0]
+	}
+}
+
+var inlineImageEnd = regexp.MustCompile(`\sEI\s`)
+
+// joinPDFLines joins the lines of a paragraph, removing hyphenation
+func joinPDFLines(paragraph string) string {
+	lines := strings.Split(paragraph, "\n")
+
+	var b strings.Builder
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		b.WriteString(line)
+		if i == len(lines)-1 {
+			break
+		}
+		if strings.HasSuffix(line, "-") && i+1 < len(lines) && startsLower(lines[i+1]) {
+			// Drop the hyphen of a word broken across lines
+			s := b.String()
+			b.Reset()
+			b.WriteString(s[:len(s)-1])
+			continue
+		}
+		b.WriteByte(' ')
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func startsLower(s string) bool {
+	s = strings.TrimSpace(s)
+	return s != "" && s[0] >= 'a' && s[0] <= 'z'
+}
+
+// pdfFont decodes the strings shown with a font
+type pdfFont struct {
+	// toUnicode maps character codes to text when the font has a ToUnicode map
+	toUnicode map[string]string
+	// codeLengths are the code lengths in bytes used by toUnicode, longest first
+	codeLengths []int
+	// composite fonts use two byte codes
+	composite bool
+	// differences override single byte codes of simple fonts
+	differences map[byte]string
+}
+
+// font loads and caches the font resource with the given name
+func (f *pdfFile) font(fonts pdfDict, name pdfName) *pdfFont {
+	ref, isRef := fonts[name].(pdfRef)
+	if isRef {
+		if font, ok := f.fonts[ref]; ok {
+			return font
+		}
+	}
+
+	dict := f.dict(fonts[name])
+	if dict == nil {
+		return nil
+	}
+
+	font := &pdfFont{composite: dict["Subtype"] == pdfName("Type0")}
+	if isRef {
+		f.fonts[ref] = font
+	}
+
+	if stream, ok := f.resolve(dict["ToUnicode"]).(*pdfStream); ok {
+		if data, err := f.decodeStream(stream); err == nil {
+			font.toUnicode, font.codeLengths = parseToUnicode(data)
+		}
+	}
+
+	if enc := f.dict(dict["Encoding"]); enc != nil {
+		if diffs, ok := f.resolve(enc["Differences"]).(pdfArray); ok {
+			font.differences = map[byte]string{}
+			code := 0
+			for _, item := range diffs {
+				switch v := item.(type) {
+				case float64:
+					code = int(v)
+				case pdfName:
+					if s, ok := glyphText(string(v)); ok && code >= 0 && code < 256 {
+						font.differences[byte(code)] = s
+					}
+					code++
+				}
+			}
+		}
+	}
+
+	return font
+}
+
+// decode turns a shown string into text
+func (font *pdfFont) decode(obj interface{}) string {
+	s, ok := obj.(pdfString)
+	if !ok {
+		return ""
+	}
+	if font == nil {
+		return winAnsi(s)
+	}
+
+	if font.toUnicode != nil {
+		var b strings.Builder
+		for i := 0; i < len(s); {
+			matched := false
+			for _, n := range font.codeLengths {
+				if i+n <= len(s) {
+					if text, ok := font.toUnicode[string(s[i:i+n])]; ok {
+						b.WriteString(text)
+						i += n
+						matched = true
+						break
+					}
+				}
+			}
+			if !matched {
+				if font.composite {
+					i += 2
+				} else {
+					b.WriteString(winAnsi(s[i : i+1]))
+					i++
+				}
+			}
+		}
+		return b.String()
+	}
+
+	// Composite fonts without a ToUnicode map cannot be decoded
+	if font.composite {
+		return ""
+	}
+
+	if font.differences != nil {
+		var b strings.Builder
+		for _, c := range s {
+			if text, ok := font.differences[c]; ok {
+				b.WriteString(text)
+			} else {
+				b.WriteString(winAnsi([]byte{c}))
+			}
+		}
+		return b.String()
+	}
+
+	return winAnsi(s)
+}
+
+// parseToUnicode reads the bfchar and bfrange mappings of a ToUnicode CMap
+func parseToUnicode(data []byte) (map[string]string, []int) {
+	mapping := map[string]string{}
+	lengths := map[int]bool{}
+
+	l := &pdfLexer{data: data}
+	var operands []interface{}
+	for {
+		tok, err := l.token()
+		if err != nil {
+			break
+		}
+		obj, err := l.objectFrom(tok)
+		if err != nil {
+			break
+		}
+
+		op, ok := obj.(pdfKeyword)
+		if !ok {
+			operands = append(operands, obj)
+			continue
+		}
+
+		switch op {
+		case "endcodespacerange":
+			for i := 0; i+1 < len(operands); i += 2 {
+				if lo, ok := operands[i].(pdfString); ok {
+					lengths[len(lo)] = true
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok1 := operands[i].(pdfString)
+				dst, ok2 := operands[i+1].(pdfString)
+				if ok1 && ok2 {
+					mapping[string(src)] = utf16BE(dst)
+					lengths[len(src)] = true
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, ok1 := operands[i].(pdfString)
+				hi, ok2 := operands[i+1].(pdfString)
+				if !ok1 || !ok2 || len(lo) != len(hi) || len(lo) == 0 || len(lo) > 4 {
+					continue
+				}
+				lengths[len(lo)] = true
+
+				start, end := codeValue(lo), codeValue(hi)
+				if end < start || end-start > 0xFFFF {
+					continue
+				}
+				for code := start; code <= end; code++ {
+					key := string(codeBytes(code, len(lo)))
+					offset := code - start
+					switch dst := operands[i+2].(type) {
+					case pdfString:
+						mapping[key] = utf16BE(incrementLast(dst, offset))
+					case pdfArray:
+						if int(offset) < len(dst) {
+							if s, ok := dst[offset].(pdfString); ok {
+								mapping[key] = utf16BE(s)
+							}
+						}
+					}
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+
+	codeLengths := []int{}
+	for n := range lengths {
+		codeLengths = append(codeLengths, n)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(codeLengths)))
+	if len(codeLengths) == 0 {
+		codeLengths = []int{1}
+	}
+	return mapping, codeLengths
+}
+
+func codeValue(b []byte) uint32 {
+	var v uint32
+	for _, c := range b {
+		v = v<<8 | uint32(c)
+	}
+	return v
+}
+
+func codeBytes(v uint32, n int) []byte {
+	b := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+	return b
+}
+
+// incrementLast adds offset to the last UTF-16 code unit of dst
+func incrementLast(dst pdfString, offset uint32) pdfString {
+	out := append(pdfString(nil), dst...)
+	if len(out) < 2 {
+		if len(out) == 1 {
+			out[0] += byte(offset)
+		}
+		return out
+	}
+	n := len(out)
+	v := uint32(out[n-2])<<8 | uint32(out[n-1])
+	v += offset
+	out[n-2], out[n-1] = byte(v>>8), byte(v)
+	return out
+}
+
+// utf16BE decodes the UTF-16BE text of a CMap destination
+func utf16BE(b []byte) string {
+	if len(b)%2 == 1 {
+		return winAnsi(b)
+	}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+	}
+	return string(utf16.Decode(units))
+}
+
+// Characters of the Windows-1252 range 0x80 to 0x9F
+var winAnsiHigh = [32]rune{
+	'€', 0, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0, 'Ž', 0,
+	0, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0, 'ž', 'Ÿ',
+}
+
+// winAnsi decodes single byte text in the standard Latin encoding
+func winAnsi(b []byte) string {
+	runes := make([]rune, 0, len(b))
+	for _, c := range b {
+		switch {
+		case c >= 0x80 && c <= 0x9F:
+			if r := winAnsiHigh[c-0x80]; r != 0 {
+				runes = append(runes, r)
+			}
+		case c < 0x20 && c != '\t' && c != '\n':
+		default:
+			runes = append(runes, rune(c))
+		}
+	}
+	return string(runes)
+}
+
+// Glyph names that differ from the character they draw
+var glyphNames = map[string]string{
+	"space": " ", "exclam": "!", "quotedbl": "\"", "numbersign": "#", "dollar": "$",
+	"percent": "%", "ampersand": "&", "quotesingle": "'", "parenleft": "(", "parenright": ")",
+	"asterisk": "*", "plus": "+", "comma": ",", "hyphen": "-", "period": ".", "slash": "/",
+	"zero": "0", "one": "1", "two": "2", "three": "3", "four": "4", "five": "5", "six": "6",
+	"seven": "7", "eight": "8", "nine": "9", "colon": ":", "semicolon": ";", "less": "<",
+	"equal": "=", "greater": ">", "question": "?", "at": "@", "bracketleft": "[",
+	"backslash": "\\", "bracketright": "]", "underscore": "_", "quoteleft": "‘",
+	"quoteright": "’", "quotedblleft": "“", "quotedblright": "”", "endash": "–",
+	"emdash": "—", "bullet": "•", "ellipsis": "…", "fi": "fi", "fl": "fl", "ff": "ff",
+	"ffi": "ffi", "ffl": "ffl", "eacute": "é", "egrave": "è", "ecircumflex": "ê",
+	"edieresis": "ë", "agrave": "à", "acircumflex": "â", "ccedilla": "ç", "idieresis": "ï",
+	"icircumflex": "î", "ocircumflex": "ô", "odieresis": "ö", "udieresis": "ü",
+	"ugrave": "ù", "adieresis": "ä", "Eacute": "É", "germandbls": "ß",
+}
+
+// glyphText returns the text drawn by a glyph name
+func glyphText(name string) (string, bool) {
+	if len(name) == 1 {
+		return name, true
+	}
+	if s, ok := glyphNames[name]; ok {
+		return s, true
+	}
+	if strings.HasPrefix(name, "uni") && len(name) == 7 {
+		if v, err := strconv.ParseUint(name[3:], 16, 16); err == nil {
+			return string(rune(v)), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,552 @@
+package pipeline
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/ascii85"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// A minimal reader for the PDF object syntax, just enough to reach the page
+// content streams and fonts needed to extract text
+
+type (
+	pdfName    string
+	pdfKeyword string
+	pdfString  []byte
+	pdfDelim   string
+	pdfArray   []interface{}
+	pdfDict    map[pdfName]interface{}
+	pdfRef     struct{ Num, Gen int }
+	pdfStream  struct {
+		Dict pdfDict
+		Raw  []byte
+	}
+)
+
+var errPDFEOF = io.EOF
+
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func isPDFSpace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+func isPDFDelim(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+// skipSpace skips white space and comments
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if isPDFSpace(c) {
+			l.pos++
+			continue
+		}
+		if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		return
+	}
+}
+
+// token returns the next token: a number, name, string, keyword or delimiter
+func (l *pdfLexer) token() (interface{}, error) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, errPDFEOF
+	}
+
+	c := l.data[l.pos]
+	switch {
+	case c == '/':
+		return l.name(), nil
+	case c == '(':
+		return l.literalString(), nil
+	case c == '<':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '<' {
+			l.pos += 2
+			return pdfDelim("<<"), nil
+		}
+		return l.hexString(), nil
+	case c == '>':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '>' {
+			l.pos += 2
+			return pdfDelim(">>"), nil
+		}
+		l.pos++
+		return pdfDelim(">"), nil
+	case c == '[' || c == ']' || c == '{' || c == '}' || c == ')':
+		l.pos++
+		return pdfDelim(string(c)), nil
+	}
+
+	start := l.pos
+	for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelim(l.data[l.pos]) {
+		l.pos++
+	}
+	word := string(l.data[start:l.pos])
+
+	if n, err := strconv.ParseFloat(word, 64); err == nil && (word[0] == '-' || word[0] == '+' || word[0] == '.' || (word[0] >= '0' && word[0] <= '9')) {
+		return n, nil
+	}
+	return pdfKeyword(word), nil
+}
+
+func (l *pdfLexer) name() pdfName {
+	l.pos++
+	var b []byte
+	for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelim(l.data[l.pos]) {
+		c := l.data[l.pos]
+		if c == '#' && l.pos+2 < len(l.data) {
+			if v, err := strconv.ParseUint(string(l.data[l.pos+1:l.pos+3]), 16, 8); err == nil {
+				b = append(b, byte(v))
+				l.pos += 3
+				continue
+			}
+		}
+		b = append(b, c)
+		l.pos++
+	}
+	return pdfName(b)
+}
+
+func (l *pdfLexer) literalString() pdfString {
+	l.pos++
+	depth := 1
+	var b []byte
+
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return pdfString(b)
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				return pdfString(b)
+			}
+			e := l.data[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				b = append(b, '\n')
+			case 'r':
+				b = append(b, '\r')
+			case 't':
+				b = append(b, '\t')
+			case 'b':
+				b = append(b, '\b')
+			case 'f':
+				b = append(b, '\f')
+			case '\r':
+				// Line continuation
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						v = v*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					b = append(b, byte(v))
+				} else {
+					b = append(b, e)
+				}
+			}
+			continue
+		}
+		b = append(b, c)
+	}
+	return pdfString(b)
+}
+
+func (l *pdfLexer) hexString() pdfString {
+	l.pos++
+	var digits []byte
+	for l.pos < len(l.data) && l.data[l.pos] != '>' {
+		c := l.data[l.pos]
+		if !isPDFSpace(c) {
+			digits = append(digits, c)
+		}
+		l.pos++
+	}
+	l.pos++
+
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	b := make([]byte, len(digits)/2)
+	hex.Decode(b, digits)
+	return pdfString(b)
+}
+
+// object parses a complete object, including arrays, dictionaries and
+// indirect references
+func (l *pdfLexer) object() (interface{}, error) {
+	tok, err := l.token()
+	if err != nil {
+		return nil, err
+	}
+	return l.objectFrom(tok)
+}
+
+func (l *pdfLexer) objectFrom(tok interface{}) (interface{}, error) {
+	switch t := tok.(type) {
+	case float64:
+		// "num gen R" is a reference
+		save := l.pos
+		if t == float64(int(t)) && t >= 0 {
+			if gen, err := l.token(); err == nil {
+				if g, ok := gen.(float64); ok {
+					if r, err := l.token(); err == nil && r == pdfKeyword("R") {
+						return pdfRef{Num: int(t), Gen: int(g)}, nil
+					}
+				}
+			}
+		}
+		l.pos = save
+		return t, nil
+
+	case pdfDelim:
+		switch t {
+		case "[":
+			arr := pdfArray{}
+			for {
+				tok, err := l.token()
+				if err != nil {
+					return arr, err
+				}
+				if tok == pdfDelim("]") {
+					return arr, nil
+				}
+				obj, err := l.objectFrom(tok)
+				if err != nil {
+					return arr, err
+				}
+				arr = append(arr, obj)
+			}
+		case "<<":
+			dict := pdfDict{}
+			for {
+				tok, err := l.token()
+				if err != nil {
+					return dict, err
+				}
+				if tok == pdfDelim(">>") {
+					return dict, nil
+				}
+				key, ok := tok.(pdfName)
+				if !ok {
+					continue
+				}
+				value, err := l.object()
+				if err != nil {
+					return dict, err
+				}
+				dict[key] = value
+			}
+		}
+		return t, nil
+
+	case pdfKeyword:
+		switch t {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+	}
+	return tok, nil
+}
+
+// pdfFile holds every object of a document by object number
+type pdfFile struct {
+	objects  map[int]interface{}
+	trailers []pdfDict
+	fonts    map[pdfRef]*pdfFont
+}
+
+var (
+	pdfObjectHeader = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+	trailerKeyword  = regexp.MustCompile(`\btrailer\b`)
+)
+
+// mustObject parses an object, returning nil on errors
+func mustObject(l *pdfLexer) interface{} {
+	obj, err := l.object()
+	if err != nil {
+		return nil
+	}
+	return obj
+}
+
+// parsePDF indexes all objects, including those inside object streams. The
+// cross-reference table is not used so damaged files can still be read.
+func parsePDF(data []byte) (*pdfFile, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, " \r\n\t"), []byte("%PDF-")) {
+		return nil, errors.New("not a PDF file")
+	}
+
+	f := &pdfFile{objects: map[int]interface{}{}, fonts: map[pdfRef]*pdfFont{}}
+
+	skipUntil := 0
+	for _, m := range pdfObjectHeader.FindAllSubmatchIndex(data, -1) {
+		// Ignore matches inside the binary data of a stream
+		if m[0] < skipUntil {
+			continue
+		}
+
+		num, err := strconv.Atoi(string(data[m[2]:m[3]]))
+		if err != nil {
+			continue
+		}
+
+		l := &pdfLexer{data: data, pos: m[1]}
+		obj, err := l.object()
+		if err != nil && obj == nil {
+			continue
+		}
+
+		if dict, ok := obj.(pdfDict); ok {
+			l.skipSpace()
+			if bytes.HasPrefix(data[l.pos:], []byte("stream")) {
+				raw, end := streamData(data, l.pos+len("stream"), dict)
+				obj = &pdfStream{Dict: dict, Raw: raw}
+				skipUntil = end
+			}
+		}
+
+		// Later definitions come from incremental updates and win
+		f.objects[num] = obj
+	}
+
+	for _, i := range trailerKeyword.FindAllIndex(data, -1) {
+		l := &pdfLexer{data: data, pos: i[1]}
+		if dict, ok := mustObject(l).(pdfDict); ok {
+			f.trailers = append(f.trailers, dict)
+		}
+	}
+
+	if _, ok := f.trailerValue("Encrypt"); ok {
+		return nil, errors.New("encrypted PDF files are not supported")
+	}
+
+	f.loadObjectStreams()
+
+	return f, nil
+}
+
+// streamData returns the raw bytes of a stream starting after its keyword
+// and the offset where they end
+func streamData(data []byte, start int, dict pdfDict) ([]byte, int) {
+	if start < len(data) && data[start] == '\r' {
+		start++
+	}
+	if start < len(data) && data[start] == '\n' {
+		start++
+	}
+	if start > len(data) {
+		return nil, len(data)
+	}
+
+	if length, ok := dict["Length"].(float64); ok {
+		end := start + int(length)
+		if end <= len(data) && end >= start {
+			rest := bytes.TrimLeft(data[end:min(len(data), end+32)], " \r\n\t")
+			if bytes.HasPrefix(rest, []byte("endstream")) {
+				return data[start:end], end
+			}
+		}
+	}
+
+	// The length is indirect or wrong, look for the end marker instead
+	end := bytes.Index(data[start:], []byte("endstream"))
+	if end < 0 {
+		return data[start:], len(data)
+	}
+	return bytes.TrimRight(data[start:start+end], "\r\n"), start + end
+}
+
+// loadObjectStreams adds the objects compressed into object streams
+func (f *pdfFile) loadObjectStreams() {
+	nums := make([]int, 0, len(f.objects))
+	for num := range f.objects {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+
+	for _, num := range nums {
+		stream, ok := f.objects[num].(*pdfStream)
+		if !ok || stream.Dict["Type"] != pdfName("ObjStm") {
+			continue
+		}
+
+		data, err := f.decodeStream(stream)
+		if err != nil {
+			continue
+		}
+		n, _ := f.resolve(stream.Dict["N"]).(float64)
+		first, _ := f.resolve(stream.Dict["First"]).(float64)
+		if int(first) > len(data) {
+			continue
+		}
+
+		header := &pdfLexer{data: data[:int(first)]}
+		for i := 0; i < int(n); i++ {
+			objNum, err1 := header.token()
+			offset, err2 := header.token()
+			if err1 != nil || err2 != nil {
+				break
+			}
+			on, ok1 := objNum.(float64)
+			off, ok2 := offset.(float64)
+			if !ok1 || !ok2 {
+				break
+			}
+			if _, exists := f.objects[int(on)]; exists {
+				continue
+			}
+
+			l := &pdfLexer{data: data, pos: int(first) + int(off)}
+			if obj, err := l.object(); err == nil {
+				f.objects[int(on)] = obj
+			}
+		}
+	}
+}
+
+// resolve follows indirect references
+func (f *pdfFile) resolve(obj interface{}) interface{} {
+	for i := 0; i < 32; i++ {
+		ref, ok := obj.(pdfRef)
+		if !ok {
+			return obj
+		}
+		obj = f.objects[ref.Num]
+	}
+	return nil
+}
+
+// dict resolves obj and returns it as a dictionary, using the dictionary of
+// a stream when obj is one
+func (f *pdfFile) dict(obj interface{}) pdfDict {
+	switch v := f.resolve(obj).(type) {
+	case pdfDict:
+		return v
+	case *pdfStream:
+		return v.Dict
+	}
+	return nil
+}
+
+// trailerValue finds a key in the trailer or cross-reference stream dictionary
+func (f *pdfFile) trailerValue(key pdfName) (interface{}, bool) {
+	for _, trailer := range f.trailers {
+		if v, ok := trailer[key]; ok {
+			return v, true
+		}
+	}
+	for _, obj := range f.objects {
+		if stream, ok := obj.(*pdfStream); ok && stream.Dict["Type"] == pdfName("XRef") {
+			if v, ok := stream.Dict[key]; ok {
+				return v, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// decodeStream applies the stream's filters
+func (f *pdfFile) decodeStream(stream *pdfStream) ([]byte, error) {
+	data := stream.Raw
+
+	var filters []interface{}
+	switch v := f.resolve(stream.Dict["Filter"]).(type) {
+	case pdfName:
+		filters = []interface{}{v}
+	case pdfArray:
+		filters = v
+	}
+
+	for _, filter := range filters {
+		name, _ := f.resolve(filter).(pdfName)
+		var err error
+		switch name {
+		case "FlateDecode", "Fl":
+			data, err = inflate(data)
+		case "ASCIIHexDecode", "AHx":
+			data = []byte(pdfHexDecode(data))
+		case "ASCII85Decode", "A85":
+			data, err = pdfASCII85Decode(data)
+		default:
+			return nil, fmt.Errorf("unsupported filter %s", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// inflate decompresses zlib data, keeping what could be read from streams
+// that are truncated or lack a zlib header
+func inflate(data []byte) ([]byte, error) {
+	var r io.Reader
+	if zr, err := zlib.NewReader(bytes.NewReader(data)); err == nil {
+		r = zr
+	} else {
+		r = flate.NewReader(bytes.NewReader(data))
+	}
+
+	out, err := io.ReadAll(r)
+	if err != nil && len(out) == 0 {
+		return nil, err
+	}
+	return out, nil
+}
+
+func pdfHexDecode(data []byte) pdfString {
+	l := &pdfLexer{data: append(append([]byte("<"), bytes.TrimSuffix(bytes.TrimSpace(data), []byte(">"))...), '>')}
+	return l.hexString()
+}
+
+func pdfASCII85Decode(data []byte) ([]byte, error) {
+	data = bytes.TrimPrefix(bytes.TrimSpace(data), []byte("<~"))
+	if i := bytes.Index(data, []byte("~>")); i >= 0 {
+		data = data[:i]
+	}
+	out := make([]byte, len(data))
+	n, _, err := ascii85.Decode(out, data, true)
+	return out[:n], err
+}
@@ -0,0 +1,144 @@
+// Package pipeline turns stored documents into text chunks ready to be
+// embedded. A pipeline is a list of stages that each add to a Document:
+// extraction fills its sections, chunking splits those into chunks.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrNoExtractor is returned for document types that hold no text
+	ErrNoExtractor = errors.New("pipeline: no text extractor for this document type")
+
+	// ErrNoText is returned when a document yields no text at all, for
+	// example a scanned PDF without a text layer
+	ErrNoText = errors.New("pipeline: document contains no extractable text")
+)
+
+// Separator placed between sections in Document.Text
+const sectionSeparator = "\n\n"
+
+// Section is one block of extracted text, usually a paragraph, a table row
+// or a heading
+type Section struct {
+	Text string
+	// Page is the 1-based page the section starts on, 0 when unknown
+	Page int
+	// HeadingPath lists the headings the section is nested under
+	HeadingPath []string
+	// Start and End are byte offsets of the section in Document.Text
+	Start int
+	End   int
+}
+
+// Chunk is a piece of a document that is embedded on its own
+type Chunk struct {
+	Index       int
+	Text        string
+	Page        int
+	PageEnd     int
+	HeadingPath []string
+	// Start and End are byte offsets of the chunk in Document.Text
+	Start      int
+	End        int
+	TokenCount int
+}
+
+// Document is passed through the stages of a pipeline
+type Document struct {
+	Name string
+	// Type is a document type detected by the sniff package
+	Type   string
+	Source io.ReaderAt
+	Size   int64
+
+	Sections []Section
+	// Text is all sections joined by blank lines, offsets refer to it
+	Text   string
+	Chunks []Chunk
+}
+
+// Stage is one step of a pipeline
+type Stage interface {
+	// Name identifies the stage in progress reports
+	Name() string
+	Process(ctx context.Context, doc *Document) error
+}
+
+// ProgressFunc is called before every stage runs
+type ProgressFunc func(stage string)
+
+// Pipeline runs its stages in order
+type Pipeline struct {
+	Stages   []Stage
+	Progress ProgressFunc
+}
+
+func New(stages ...Stage) *Pipeline {
+	return &Pipeline{Stages: stages}
+}
+
+// Run passes the document through every stage, stopping at the first error
+func (p *Pipeline) Run(ctx context.Context, doc *Document) error {
+	for _, stage := range p.Stages {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if p.Progress != nil {
+			p.Progress(stage.Name())
+		}
+		if err := stage.Process(ctx, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetSections stores extracted sections and builds the document text the
+// section offsets refer to
+func (d *Document) SetSections(sections []Section) {
+	var b strings.Builder
+	d.Sections = d.Sections[:0]
+
+	for _, section := range sections {
+		text := strings.TrimSpace(section.Text)
+		if text == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString(sectionSeparator)
+		}
+		section.Text = text
+		section.Start = b.Len()
+		b.WriteString(text)
+		section.End = b.Len()
+		d.Sections = append(d.Sections, section)
+	}
+
+	d.Text = b.String()
+}
+
+// copyPath returns a copy of a heading path that later changes cannot affect
+func copyPath(path []string) []string {
+	if len(path) == 0 {
+		return nil
+	}
+	return append([]string(nil), path...)
+}
+
+// samePath reports whether two heading paths are equal
+func samePath(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"kdg/be/lab/internal/fileprocessor"
+	"sort"
+	"strings"
+)
+
+// Chunking methods backed by the fileprocessor splitters, their count and
+// overlap are in runes, or in tokens for MethodTiktoken
+const (
+	MethodRecursive = "recursive"
+	MethodMarkdown  = "markdown"
+	MethodWindow    = "window"
+	MethodTiktoken  = "tiktoken"
+)
+
+// SplitStage chunks the document text with a splitter. Pages and heading
+// paths are taken from the sections each chunk overlaps.
+type SplitStage struct {
+	Method    string
+	Splitter  fileprocessor.Splitter
+	Tokenizer Tokenizer
+}
+
+// NewChunkingStage returns the stage for any of the chunking methods
+func NewChunkingStage(method string, count, overlap int) (Stage, error) {
+	switch method {
+	case "", MethodParagraph, MethodSentence, MethodToken:
+		return NewChunkStage(method, count, overlap)
+	}
+
+	stage := &SplitStage{Method: method, Tokenizer: WordTokenizer{}}
+	var err error
+
+	switch method {
+	case MethodRecursive:
+		stage.Splitter, err = fileprocessor.NewRecursiveSplitter(count, overlap)
+	case MethodWindow:
+		stage.Splitter, err = fileprocessor.NewSlidingWindowSplitter(count, overlap)
+	case MethodMarkdown:
+		var inner *fileprocessor.RecursiveSplitter
+		inner, err = fileprocessor.NewRecursiveSplitter(count, overlap)
+		stage.Splitter = fileprocessor.NewMarkdownHeaderSplitter(6, inner)
+	case MethodTiktoken:
+		var splitter *fileprocessor.TokenSplitter
+		splitter, err = fileprocessor.NewTokenSplitter(fileprocessor.DefaultEncoding, count, overlap)
+		stage.Splitter, stage.Tokenizer = splitter, splitter
+	default:
+		return nil, fmt.Errorf("pipeline: unknown chunk method %q", method)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pipeline: %s chunking: %w", method, err)
+	}
+
+	return stage, nil
+}
+
+func (s *SplitStage) Name() string {
+	return "chunk"
+}
+
+func (s *SplitStage) Process(ctx context.Context, doc *Document) error {
+	doc.Chunks = doc.Chunks[:0]
+
+	return s.Splitter.Split(strings.NewReader(doc.Text), func(split fileprocessor.Split) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		start := int(split.Offset)
+		end := start + len(split.Text)
+		chunk := Chunk{
+			Index:       len(doc.Chunks),
+			Text:        split.Text,
+			HeadingPath: split.Headings,
+			Start:       start,
+			End:         end,
+			TokenCount:  s.Tokenizer.Count(split.Text),
+		}
+
+		if first := doc.sectionAt(start); first != nil {
+			chunk.Page = first.Page
+			if len(chunk.HeadingPath) == 0 {
+				chunk.HeadingPath = copyPath(first.HeadingPath)
+			}
+		}
+		if last := doc.sectionAt(end - 1); last != nil {
+			chunk.PageEnd = last.Page
+		}
+
+		doc.Chunks = append(doc.Chunks, chunk)
+		return nil
+	})
+}
+
+// sectionAt returns the section containing a byte offset of Document.Text,
+// or the next section when the offset falls between two sections
+func (d *Document) sectionAt(offset int) *Section {
+	i := sort.Search(len(d.Sections), func(i int) bool {
+		return d.Sections[i].End > offset
+	})
+	if i == len(d.Sections) {
+		return nil
+	}
+	return &d.Sections[i]
+}
@@ -0,0 +1,229 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// TextExtractor splits plain text into paragraphs on blank lines
+type TextExtractor struct{}
+
+func (TextExtractor) Extract(r io.ReaderAt, size int64) ([]Section, error) {
+	data, err := readAll(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	sections := []Section{}
+	for _, paragraph := range splitParagraphs(toUTF8(data)) {
+		sections = append(sections, Section{Text: paragraph})
+	}
+	return sections, nil
+}
+
+// MarkdownExtractor splits Markdown into paragraphs and tracks the ATX and
+// setext headings they are nested under. Fenced code blocks are kept whole.
+type MarkdownExtractor struct{}
+
+func (MarkdownExtractor) Extract(r io.ReaderAt, size int64) ([]Section, error) {
+	data, err := readAll(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.ReplaceAll(toUTF8(data), "\r\n", "\n"), "\n")
+
+	sections := []Section{}
+	headings := []string{}
+	paragraph := []string{}
+	fence := ""
+
+	flush := func() {
+		if text := strings.TrimSpace(strings.Join(paragraph, "\n")); text != "" {
+			sections = append(sections, Section{Text: text, HeadingPath: copyPath(headings)})
+		}
+		paragraph = paragraph[:0]
+	}
+
+	setHeading := func(level int, title string) {
+		flush()
+		if level > len(headings)+1 {
+			level = len(headings) + 1
+		}
+		headings = append(headings[:level-1], title)
+		// Keep the Markdown marker so the text can be split by heading again
+		sections = append(sections, Section{Text: strings.Repeat("#", level) + " " + title, HeadingPath: copyPath(headings)})
+	}
+
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		// Code fences end at the same marker they started with
+		if fence != "" {
+			paragraph = append(paragraph, line)
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+				flush()
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			flush()
+			fence = trimmed[:3]
+			paragraph = append(paragraph, line)
+			continue
+		}
+
+		if level, title, ok := atxHeading(trimmed); ok {
+			setHeading(level, title)
+			continue
+		}
+
+		// A setext underline turns the previous single line into a heading
+		if len(paragraph) == 1 && isSetextUnderline(trimmed) {
+			title := strings.TrimSpace(paragraph[0])
+			paragraph = paragraph[:0]
+			level := 1
+			if trimmed[0] == '-' {
+				level = 2
+			}
+			setHeading(level, title)
+			continue
+		}
+
+		if trimmed == "" {
+			flush()
+			continue
+		}
+		paragraph = append(paragraph, line)
+	}
+	flush()
+
+	return sections, nil
+}
+
+// atxHeading parses headings such as "## Title ##"
+func atxHeading(line string) (int, string, bool) {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 || (level < len(line) && line[level] != ' ' && line[level] != '\t') {
+		return 0, "", false
+	}
+
+	title := strings.TrimSpace(strings.TrimRight(strings.TrimSpace(line[level:]), "#"))
+	if title == "" {
+		return 0, "", false
+	}
+	return level, title, true
+}
+
+func isSetextUnderline(line string) bool {
+	if len(line) < 2 {
+		return false
+	}
+	return strings.Trim(line, "=") == "" || strings.Trim(line, "-") == ""
+}
+
+// CSVExtractor turns every row into a section of "column: value" pairs so
+// each chunk keeps the meaning of its values
+type CSVExtractor struct{}
+
+func (CSVExtractor) Extract(r io.ReaderAt, size int64) ([]Section, error) {
+	data, err := readAll(r, size)
+	if err != nil {
+		return nil, err
+	}
+	text := toUTF8(data)
+
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma = detectDelimiter(text)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	sections := []Section{}
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+
+		fields := []string{}
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+			name := fmt.Sprintf("column %d", i+1)
+			if i < len(header) && strings.TrimSpace(header[i]) != "" {
+				name = strings.TrimSpace(header[i])
+			}
+			fields = append(fields, name+": "+value)
+		}
+		if len(fields) > 0 {
+			sections = append(sections, Section{Text: strings.Join(fields, "; ")})
+		}
+	}
+
+	return sections, nil
+}
+
+// detectDelimiter picks the most frequent delimiter on the first line
+func detectDelimiter(text string) rune {
+	first := text
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		first = text[:i]
+	}
+
+	best, bestCount := ',', 0
+	for _, delim := range []rune{',', ';', '\t', '|'} {
+		if count := strings.Count(first, string(delim)); count > bestCount {
+			best, bestCount = delim, count
+		}
+	}
+	return best
+}
+
+// splitParagraphs splits text on blank lines
+func splitParagraphs(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	paragraphs := []string{}
+	for _, block := range strings.Split(text, "\n\n") {
+		if block = strings.TrimSpace(block); block != "" {
+			paragraphs = append(paragraphs, block)
+		}
+	}
+	return paragraphs
+}
+
+// toUTF8 strips a byte order mark and decodes Latin-1 text that is not valid
+// UTF-8
+func toUTF8(data []byte) string {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if utf8.Valid(data) {
+		return string(data)
+	}
+
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
@@ -0,0 +1,95 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Size of the chunks streamed to clamd, well below its default StreamMaxLength
+const clamdChunkSize = 64 * 1024
+
+// Clamd scans documents with a ClamAV daemon over TCP using the INSTREAM
+// command
+type Clamd struct {
+	Addr    string
+	Timeout time.Duration
+}
+
+func NewClamd(addr string, timeout time.Duration) *Clamd {
+	return &Clamd{Addr: addr, Timeout: timeout}
+}
+
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (Verdict, error) {
+	dialer := net.Dialer{Timeout: c.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.Addr)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("scanner: connecting to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else if c.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return Verdict{}, fmt.Errorf("scanner: sending command: %w", err)
+	}
+
+	// Every chunk is prefixed with its length, a zero length ends the stream
+	buf := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return Verdict{}, fmt.Errorf("scanner: streaming document: %w", err)
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return Verdict{}, fmt.Errorf("scanner: streaming document: %w", err)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Verdict{}, err
+		}
+	}
+
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := conn.Write(size); err != nil {
+		return Verdict{}, fmt.Errorf("scanner: ending stream: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return Verdict{}, fmt.Errorf("scanner: reading reply: %w", err)
+	}
+
+	return parseReply(string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+// parseReply interprets replies such as "stream: OK" and
+// "stream: Eicar-Test-Signature FOUND"
+func parseReply(reply string) (Verdict, error) {
+	result := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+
+	switch {
+	case result == "OK":
+		return Verdict{Clean: true}, nil
+	case strings.HasSuffix(result, " FOUND"):
+		return Verdict{Signature: strings.TrimSuffix(result, " FOUND")}, nil
+	}
+	return Verdict{}, fmt.Errorf("scanner: clamd replied %q", reply)
+}
@@ -0,0 +1,247 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClamd answers INSTREAM commands on a local port with a fixed reply
+type fakeClamd struct {
+	addr string
+	// reply is sent once the stream ends, or once limit bytes were received
+	reply string
+	limit int
+	// silent never replies, to test timeouts
+	silent bool
+
+	command  chan string
+	chunks   chan []int
+	received chan []byte
+}
+
+func newFakeClamd(t *testing.T, reply string) *fakeClamd {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	f := &fakeClamd{
+		addr:     l.Addr().String(),
+		reply:    reply,
+		command:  make(chan string, 1),
+		chunks:   make(chan []int, 1),
+		received: make(chan []byte, 1),
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeClamd) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	command, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+	f.command <- command
+
+	var sizes []int
+	var data []byte
+	defer func() {
+		f.chunks <- sizes
+		f.received <- data
+	}()
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return
+		}
+		if size == 0 {
+			break
+		}
+		sizes = append(sizes, int(size))
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return
+		}
+		data = append(data, chunk...)
+		if f.limit > 0 && len(data) > f.limit {
+			break
+		}
+	}
+
+	if f.silent {
+		io.Copy(io.Discard, r)
+		return
+	}
+	conn.Write([]byte(f.reply + "\x00"))
+}
+
+func TestClamdScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		limit   int
+		want    Verdict
+		wantErr bool
+	}{
+		{"clean", "stream: OK", 0, Verdict{Clean: true}, false},
+		{"found", "stream: Eicar-Test-Signature FOUND", 0, Verdict{Signature: "Eicar-Test-Signature"}, false},
+		{"error", "stream: Can't allocate memory ERROR", 0, Verdict{}, true},
+		{"size limit", "INSTREAM size limit exceeded. ERROR", 1000, Verdict{}, true},
+		{"no reply", "", 0, Verdict{}, true},
+	}
+
+	doc := bytes.Repeat([]byte("document "), 30000)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeClamd(t, tt.reply)
+			f.limit = tt.limit
+
+			got, err := NewClamd(f.addr, 5*time.Second).Scan(context.Background(), bytes.NewReader(doc))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("verdict = %+v, want %+v", got, tt.want)
+			}
+			if command := <-f.command; command != "zINSTREAM\x00" {
+				t.Errorf("command = %q", command)
+			}
+		})
+	}
+}
+
+// Documents are streamed in chunks of at most clamdChunkSize bytes, each
+// prefixed with its length, and the stream ends with a zero length
+func TestClamdChunks(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		reads int // size of the reads from the document, all of it when 0
+		want  []int
+	}{
+		{"empty", 0, 0, nil},
+		{"small", 10, 0, []int{10}},
+		{"exact", clamdChunkSize, 0, []int{clamdChunkSize}},
+		{"large", 2*clamdChunkSize + 10, 0, []int{clamdChunkSize, clamdChunkSize, 10}},
+		{"short reads", 25, 10, []int{10, 10, 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeClamd(t, "stream: OK")
+			doc := make([]byte, tt.size)
+			for i := range doc {
+				doc[i] = byte(i)
+			}
+			var r io.Reader = bytes.NewReader(doc)
+			if tt.reads > 0 {
+				r = &shortReader{r: r, n: tt.reads}
+			}
+
+			if _, err := NewClamd(f.addr, 5*time.Second).Scan(context.Background(), r); err != nil {
+				t.Fatal(err)
+			}
+			<-f.command
+			sizes := <-f.chunks
+			if len(sizes) != len(tt.want) {
+				t.Fatalf("chunks = %v, want %v", sizes, tt.want)
+			}
+			for i := range sizes {
+				if sizes[i] != tt.want[i] {
+					t.Fatalf("chunks = %v, want %v", sizes, tt.want)
+				}
+			}
+			if received := <-f.received; !bytes.Equal(received, doc) {
+				t.Errorf("clamd received %d bytes that differ from the document", len(received))
+			}
+		})
+	}
+}
+
+// shortReader returns at most n bytes per read
+type shortReader struct {
+	r io.Reader
+	n int
+}
+
+func (s *shortReader) Read(p []byte) (int, error) {
+	if len(p) > s.n {
+		p = p[:s.n]
+	}
+	return s.r.Read(p)
+}
+
+func TestClamdTimeout(t *testing.T) {
+	f := newFakeClamd(t, "stream: OK")
+	f.silent = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := NewClamd(f.addr, time.Minute).Scan(ctx, strings.NewReader("document")); err == nil {
+		t.Fatal("scan without a reply succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("scan gave up after %v", elapsed)
+	}
+}
+
+func TestClamdUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	if _, err := NewClamd(addr, time.Second).Scan(context.Background(), strings.NewReader("document")); err == nil {
+		t.Fatal("scan without clamd succeeded")
+	}
+}
+
+func TestParseReply(t *testing.T) {
+	tests := []struct {
+		reply   string
+		want    Verdict
+		wantErr bool
+	}{
+		{"stream: OK", Verdict{Clean: true}, false},
+		{"stream:OK", Verdict{Clean: true}, false},
+		{"stream: Eicar-Test-Signature FOUND", Verdict{Signature: "Eicar-Test-Signature"}, false},
+		{"stream: Win.Test.EICAR_HDB-1 FOUND", Verdict{Signature: "Win.Test.EICAR_HDB-1"}, false},
+		{"stream: Can't allocate memory ERROR", Verdict{}, true},
+		{"INSTREAM size limit exceeded. ERROR", Verdict{}, true},
+		{"UNKNOWN COMMAND", Verdict{}, true},
+		{"", Verdict{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseReply(tt.reply)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseReply(%q) error = %v, want error %v", tt.reply, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("parseReply(%q) = %+v, want %+v", tt.reply, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+// Package scanner checks uploaded documents for malware before they are
+// handed to the processing service.
+package scanner
+
+import (
+	"context"
+	"io"
+)
+
+// Verdict is the outcome of scanning one document
+type Verdict struct {
+	Clean bool
+	// Signature names what was found when the document is not clean
+	Signature string
+}
+
+// Scanner is implemented by every scanning backend
+type Scanner interface {
+	// Scan reads the document from r and reports whether it is clean. An
+	// error means no verdict could be reached.
+	Scan(ctx context.Context, r io.Reader) (Verdict, error)
+}
+
+// Nop accepts every document, it is used when no scanner is configured
+type Nop struct{}
+
+func (Nop) Scan(ctx context.Context, r io.Reader) (Verdict, error) {
+	return Verdict{Clean: true}, nil
+}
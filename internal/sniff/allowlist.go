@@ -0,0 +1,90 @@
+package sniff
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Allowlist lists the document types accepted for every document role
+type Allowlist map[string][]string
+
+// DefaultAllowlist is used when no allowlist file is configured
+func DefaultAllowlist() Allowlist {
+	return Allowlist{
+		"CONTENT":  {PDF, DOCX, CSV, Text},
+		"METADATA": {PDF, DOCX, CSV, Text, GeoJSON, GPKG, Shapefile, KML},
+		"SCHEMA":   {PDF, DOCX, CSV, Text},
+		"SPATIAL":  {GeoJSON, KML, Shapefile, GPKG, CSV},
+	}
+}
+
+// LoadAllowlist reads an allowlist from a TOML file with one key per role:
+//
+//	CONTENT = ["pdf", "docx", "text"]
+//	SCHEMA  = ["csv"]
+func LoadAllowlist(path string) (Allowlist, error) {
+	allowlist := Allowlist{}
+	if _, err := toml.DecodeFile(path, &allowlist); err != nil {
+		return nil, err
+	}
+
+	normalized := Allowlist{}
+	for role, types := range allowlist {
+		for _, t := range types {
+			t = strings.ToLower(t)
+			if _, ok := MimeTypes[t]; !ok || t == Unknown {
+				return nil, fmt.Errorf("sniff: unknown document type %q for role %s", t, role)
+			}
+			normalized[strings.ToUpper(role)] = append(normalized[strings.ToUpper(role)], t)
+		}
+	}
+
+	return normalized, nil
+}
+
+// DefaultRole is checked for documents stored without a known role
+const DefaultRole = "CONTENT"
+
+// Allowed reports whether a document type may be uploaded with every one of
+// the given roles. Unknown types and an empty set of roles are never allowed.
+func (a Allowlist) Allowed(docType string, roles []string) bool {
+	if docType == Unknown || len(roles) == 0 {
+		return false
+	}
+	for _, role := range roles {
+		if !contains(a[strings.ToUpper(role)], docType) {
+			return false
+		}
+	}
+	return true
+}
+
+// Types returns the document types accepted for all of the given roles
+func (a Allowlist) Types(roles []string) []string {
+	types := []string{}
+	for t := range MimeTypes {
+		if t != Unknown && a.Allowed(t, roles) {
+			types = append(types, t)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// KnownRole reports whether the allowlist has an entry for a role
+func (a Allowlist) KnownRole(role string) bool {
+	_, ok := a[strings.ToUpper(role)]
+	return ok
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,28 @@
+package sniff
+
+import "testing"
+
+func TestAllowed(t *testing.T) {
+	a := DefaultAllowlist()
+	tests := []struct {
+		docType string
+		roles   []string
+		want    bool
+	}{
+		{PDF, []string{"CONTENT"}, true},
+		{PDF, []string{"content"}, true},
+		{GeoJSON, []string{"SPATIAL", "METADATA"}, true},
+		{PDF, []string{"CONTENT", "SPATIAL"}, false},
+		{GeoJSON, []string{"CONTENT"}, false},
+		{PDF, []string{"UNKNOWN"}, false},
+		{PDF, nil, false},
+		{Unknown, []string{"CONTENT"}, false},
+		{Unknown, nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := a.Allowed(tt.docType, tt.roles); got != tt.want {
+			t.Errorf("Allowed(%q, %v) = %v, want %v", tt.docType, tt.roles, got, tt.want)
+		}
+	}
+}
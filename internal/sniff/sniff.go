@@ -0,0 +1,311 @@
+// Package sniff detects the type of an uploaded document from its content
+// rather than trusting the file name sent by the client.
+package sniff
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Document types recognised by Detect
+const (
+	PDF       = "pdf"
+	DOCX      = "docx"
+	CSV       = "csv"
+	GeoJSON   = "geojson"
+	GPKG      = "gpkg"
+	Shapefile = "shapefile"
+	KML       = "kml"
+	Text      = "text"
+	Unknown   = "unknown"
+)
+
+// MimeTypes maps every document type to the MIME type stored for it
+var MimeTypes = map[string]string{
+	PDF:       "application/pdf",
+	DOCX:      "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	CSV:       "text/csv",
+	GeoJSON:   "application/geo+json",
+	GPKG:      "application/geopackage+sqlite3",
+	Shapefile: "application/x-shapefile+zip",
+	KML:       "application/vnd.google-earth.kml+xml",
+	Text:      "text/plain",
+	Unknown:   "application/octet-stream",
+}
+
+// TypeOf returns the document type stored with a MIME type
+func TypeOf(mimeType string) string {
+	for t, m := range MimeTypes {
+		if m == mimeType && t != Unknown {
+			return t
+		}
+	}
+	return Unknown
+}
+
+// How much of a file is inspected for the text based formats
+const headSize = 64 * 1024
+
+// GeoPackage application IDs stored at offset 68 of the SQLite header
+var gpkgApplicationIDs = []string{"GPKG", "GP10", "GP11"}
+
+// Detect inspects the content of a file and returns its document type. The
+// reader must cover the whole file, zip archives are opened to look at their
+// entries.
+func Detect(r io.ReaderAt, size int64) (string, error) {
+	head := make([]byte, headSize)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return Unknown, err
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte("%PDF-")):
+		return PDF, nil
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return detectZip(r, size)
+	case bytes.HasPrefix(head, []byte("SQLite format 3\x00")):
+		if len(head) >= 72 {
+			id := string(head[68:72])
+			for _, gpkg := range gpkgApplicationIDs {
+				if id == gpkg {
+					return GPKG, nil
+				}
+			}
+		}
+		return Unknown, nil
+	}
+
+	return detectText(head, size > int64(len(head))), nil
+}
+
+// detectZip tells a Word document apart from a zipped Shapefile
+func detectZip(r io.ReaderAt, size int64) (string, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return Unknown, nil
+	}
+
+	var hasDocument, hasShp, hasShx, hasDbf bool
+	for _, f := range archive.File {
+		switch strings.ToLower(filepath.Ext(f.Name)) {
+		case ".shp":
+			hasShp = true
+		case ".shx":
+			hasShx = true
+		case ".dbf":
+			hasDbf = true
+		}
+		if f.Name == "word/document.xml" {
+			hasDocument = true
+		}
+	}
+
+	switch {
+	case hasDocument:
+		return DOCX, nil
+	case hasShp && hasShx && hasDbf:
+		return Shapefile, nil
+	}
+	return Unknown, nil
+}
+
+// detectText classifies files without a binary signature. truncated is set
+// when head holds only the start of the file.
+func detectText(head []byte, truncated bool) string {
+	if len(head) == 0 || bytes.IndexByte(head, 0) >= 0 {
+		return Unknown
+	}
+
+	// A multi-byte rune may be cut off at the end of the head
+	text := head
+	if truncated {
+		for i := 0; i < utf8.UTFMax && len(text) > 0 && !utf8.Valid(text); i++ {
+			text = text[:len(text)-1]
+		}
+	}
+	if !utf8.Valid(text) {
+		return Unknown
+	}
+
+	text = bytes.TrimPrefix(text, []byte("\xef\xbb\xbf"))
+	trimmed := bytes.TrimSpace(text)
+
+	if bytes.HasPrefix(trimmed, []byte("<")) {
+		if isKML(trimmed) {
+			return KML
+		}
+		return Text
+	}
+
+	if bytes.HasPrefix(trimmed, []byte("{")) && isGeoJSON(trimmed, truncated) {
+		return GeoJSON
+	}
+
+	if isCSV(text, truncated) {
+		return CSV
+	}
+
+	return Text
+}
+
+// isKML looks for a kml root element in the start of an XML document
+func isKML(text []byte) bool {
+	start := strings.ToLower(string(text[:min(len(text), 4096)]))
+	return strings.Contains(start, "<kml")
+}
+
+// geoJSONTypes are the valid values of a GeoJSON object's type member
+var geoJSONTypes = map[string]bool{
+	"FeatureCollection":  true,
+	"Feature":            true,
+	"Point":              true,
+	"MultiPoint":         true,
+	"LineString":         true,
+	"MultiLineString":    true,
+	"Polygon":            true,
+	"MultiPolygon":       true,
+	"GeometryCollection": true,
+}
+
+// isGeoJSON checks the top-level type member of a JSON object. When only the
+// start of the file is available the object is read member by member, see
+// geoJSONMembers.
+func isGeoJSON(text []byte, truncated bool) bool {
+	if !truncated {
+		var object struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(text, &object); err == nil {
+			return geoJSONTypes[object.Type]
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(text))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return false
+	}
+	return geoJSONMembers(dec)
+}
+
+// geoJSONMembers reads the members of an object up to its type member. The
+// type may come after a features array or geometry too large for the start
+// of the file, so an object with a features array, or with a geometry
+// object that is GeoJSON itself, counts as GeoJSON as well.
+func geoJSONMembers(dec *json.Decoder) bool {
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return false
+		}
+
+		switch key {
+		case "type":
+			value, err := dec.Token()
+			if err != nil {
+				return false
+			}
+			name, ok := value.(string)
+			return ok && geoJSONTypes[name]
+		case "features":
+			tok, err := dec.Token()
+			return err == nil && tok == json.Delim('[')
+		case "geometry":
+			tok, err := dec.Token()
+			return err == nil && tok == json.Delim('{') && geoJSONMembers(dec)
+		}
+
+		// Skip the value of any other member
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return false
+		}
+	}
+	return false
+}
+
+// isCSV reports whether the first lines share a delimiter that splits them
+// into the same number of fields
+func isCSV(text []byte, truncated bool) bool {
+	lines := strings.Split(strings.ReplaceAll(string(text), "\r\n", "\n"), "\n")
+	if truncated && len(lines) > 1 {
+		// The last line may be cut off
+		lines = lines[:len(lines)-1]
+	}
+
+	sample := []string{}
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		sample = append(sample, line)
+		if len(sample) == 20 {
+			break
+		}
+	}
+	if len(sample) < 2 {
+		return false
+	}
+
+	for _, delim := range []string{",", ";", "\t", "|"} {
+		fields := countFields(sample[0], delim)
+		if fields < 2 {
+			continue
+		}
+		consistent := true
+		for _, line := range sample[1:] {
+			if countFields(line, delim) != fields {
+				consistent = false
+				break
+			}
+		}
+		if consistent {
+			return true
+		}
+	}
+	return false
+}
+
+// countFields counts delimited fields, ignoring delimiters inside quotes
+func countFields(line, delim string) int {
+	fields := 1
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '"':
+			quoted = !quoted
+		case !quoted && strings.HasPrefix(line[i:], delim):
+			fields++
+		}
+	}
+	return fields
+}
+
+// ByExtension guesses a document type from a file name, so obviously
+// unsupported files can be refused before they are uploaded. Extensions that
+// may hold more than one type return Unknown.
+func ByExtension(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".pdf":
+		return PDF
+	case ".docx":
+		return DOCX
+	case ".csv", ".tsv":
+		return CSV
+	case ".geojson":
+		return GeoJSON
+	case ".gpkg":
+		return GPKG
+	case ".kml":
+		return KML
+	case ".md":
+		return Text
+	}
+	return Unknown
+}
@@ -0,0 +1,134 @@
+package sniff
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// zipped returns an archive holding empty files with the given names
+func zipped(t *testing.T, names ...string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for _, name := range names {
+		if _, err := zw.Create(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// sqlite returns a SQLite header with an application ID at offset 68
+func sqlite(applicationID string) []byte {
+	header := make([]byte, 100)
+	copy(header, "SQLite format 3\x00")
+	copy(header[68:], applicationID)
+	return header
+}
+
+func TestDetect(t *testing.T) {
+	// A GeoJSON document larger than the inspected head, with its type
+	// after the features
+	var large strings.Builder
+	large.WriteString(`{"features":[`)
+	for large.Len() < 2*headSize {
+		large.WriteString(`{"type":"Feature","geometry":{"type":"Point","coordinates":[4.4,51.2]},"properties":{}},`)
+	}
+	large.WriteString(`{"type":"Feature","geometry":null,"properties":{}}],"type":"FeatureCollection"}`)
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"pdf", []byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n1 0 obj"), PDF},
+		{"pdf without version", []byte("%PDF"), Text},
+		{"docx", zipped(t, "[Content_Types].xml", "word/document.xml"), DOCX},
+		{"shapefile", zipped(t, "roads.shp", "roads.shx", "roads.dbf", "roads.prj"), Shapefile},
+		{"shapefile in a folder", zipped(t, "data/ROADS.SHP", "data/ROADS.SHX", "data/ROADS.DBF"), Shapefile},
+		{"shapefile without index", zipped(t, "roads.shp", "roads.dbf"), Unknown},
+		{"other zip", zipped(t, "readme.txt"), Unknown},
+		{"broken zip", []byte("PK\x03\x04 not really a zip"), Unknown},
+		{"gpkg", sqlite("GPKG"), GPKG},
+		{"gpkg 1.0", sqlite("GP10"), GPKG},
+		{"gpkg 1.1", sqlite("GP11"), GPKG},
+		{"plain sqlite", sqlite("\x00\x00\x00\x00"), Unknown},
+		{"short sqlite header", []byte("SQLite format 3\x00GPKG"), Unknown},
+		{"binary", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), Unknown},
+		{"invalid utf-8", []byte("caf\xe9 au lait"), Unknown},
+		{"empty", nil, Unknown},
+		{"kml", []byte(`<?xml version="1.0"?><kml xmlns="http://www.opengis.net/kml/2.2"><Document/></kml>`), KML},
+		{"kml with bom", []byte("\xef\xbb\xbf  <KML><Placemark/></KML>"), KML},
+		{"other xml", []byte(`<?xml version="1.0"?><gpx><trk/></gpx>`), Text},
+		{"geojson", []byte(`{"type":"FeatureCollection","features":[]}`), GeoJSON},
+		{"geojson geometry", []byte(` {"coordinates":[1,2],"type":"Point"}`), GeoJSON},
+		{"large geojson", []byte(large.String()), GeoJSON},
+		{"other json", []byte(`{"type":"Circle","features":[]}`), Text},
+		{"json without type", []byte(`{"name":"x"}`), Text},
+		{"csv", []byte("name,lat,lon\na,51.2,4.4\nb,51.3,4.5\n"), CSV},
+		{"semicolon csv", []byte("naam;lat;lon\r\na;51,2;4,4\r\nb;51,3;4,5\r\n"), CSV},
+		{"quoted csv", []byte("name,note\n\"a, b\",x\nc,\"d, e\"\n"), CSV},
+		{"single line", []byte("name,lat,lon\n"), Text},
+		{"text", []byte("# Title\n\nSome text, and more.\n"), Text},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Detect(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Detect = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// A multi-byte rune cut off at the end of the head is not invalid text
+func TestDetectTruncatedRune(t *testing.T) {
+	data := []byte(strings.Repeat("é", headSize))
+	if got, err := Detect(bytes.NewReader(data), int64(len(data))); err != nil || got != Text {
+		t.Errorf("Detect = %s, %v, want %s", got, err, Text)
+	}
+}
+
+func TestTypeOf(t *testing.T) {
+	for docType, mimeType := range MimeTypes {
+		if got := TypeOf(mimeType); got != docType {
+			t.Errorf("TypeOf(%q) = %s, want %s", mimeType, got, docType)
+		}
+	}
+	if got := TypeOf("image/png"); got != Unknown {
+		t.Errorf("TypeOf(image/png) = %s, want %s", got, Unknown)
+	}
+}
+
+func TestByExtension(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"report.PDF", PDF},
+		{"notes.docx", DOCX},
+		{"points.tsv", CSV},
+		{"parcels.geojson", GeoJSON},
+		{"parcels.gpkg", GPKG},
+		{"route.kml", KML},
+		{"README.md", Text},
+		{"roads.zip", Unknown},
+		{"data.json", Unknown},
+		{"noextension", Unknown},
+	}
+
+	for _, tt := range tests {
+		if got := ByExtension(tt.name); got != tt.want {
+			t.Errorf("ByExtension(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
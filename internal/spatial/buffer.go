@@ -0,0 +1,85 @@
+package spatial
+
+import (
+	"math"
+
+	"kdg/be/lab/internal/geojson"
+)
+
+// circleSegments is the number of segments that approximate a full circle
+const circleSegments = 32
+
+// Buffer returns the area within a distance in metres of a geometry. A
+// point becomes a circular Polygon. Anything else becomes a MultiPolygon of
+// overlapping pieces, a rounded band around every segment plus the polygons
+// themselves, whose union is the buffer: draw it with a nonzero fill rule.
+func Buffer(g *geojson.Geometry, metres float64) *geojson.Geometry {
+	box, ok := BBox(g)
+	if !ok || metres <= 0 {
+		return g
+	}
+	p := newPlane(box)
+	s := p.shape(g)
+	if len(s.points) == 1 && len(s.lines) == 0 && len(s.polygons) == 0 {
+		return &geojson.Geometry{Type: geojson.Polygon, Polygon: [][]geojson.Position{circle(p, s.points[0], metres)}}
+	}
+
+	var pieces [][][]geojson.Position
+	for _, q := range s.points {
+		pieces = append(pieces, [][]geojson.Position{circle(p, q, metres)})
+	}
+	for _, l := range s.lines {
+		if len(l) == 1 {
+			pieces = append(pieces, [][]geojson.Position{circle(p, l[0], metres)})
+		}
+	}
+	s.eachSegment(func(a, b point, _ bool) bool {
+		pieces = append(pieces, [][]geojson.Position{stadium(p, a, b, metres)})
+		return false
+	})
+	for _, rings := range s.polygons {
+		polygon := make([][]geojson.Position, len(rings))
+		for i, r := range rings {
+			polygon[i] = make([]geojson.Position, len(r))
+			for j, q := range r {
+				polygon[i][j] = p.unproject(q)
+			}
+		}
+		pieces = append(pieces, polygon)
+	}
+	return &geojson.Geometry{Type: geojson.MultiPolygon, MultiPolygon: pieces}
+}
+
+// circle is a closed counterclockwise ring around a point
+func circle(p plane, c point, r float64) []geojson.Position {
+	ring := make([]geojson.Position, 0, circleSegments+1)
+	for k := 0; k < circleSegments; k++ {
+		a := 2 * math.Pi * float64(k) / circleSegments
+		ring = append(ring, p.unproject(point{c.x + r*math.Cos(a), c.y + r*math.Sin(a)}))
+	}
+	return append(ring, ring[0])
+}
+
+// stadium is a closed counterclockwise ring around the points within r of
+// the segment from a to b
+func stadium(p plane, a, b point, r float64) []geojson.Position {
+	l := math.Hypot(b.x-a.x, b.y-a.y)
+	if l == 0 {
+		return circle(p, a, r)
+	}
+	// phi is the angle of the normal to the left of a to b
+	phi := math.Atan2(b.x-a.x, -(b.y - a.y))
+	half := circleSegments / 2
+	ring := make([]geojson.Position, 0, 2*half+3)
+	arc := func(c point, start float64) {
+		for k := 0; k <= half; k++ {
+			t := start + math.Pi*float64(k)/float64(half)
+			ring = append(ring, p.unproject(point{c.x + r*math.Cos(t), c.y + r*math.Sin(t)}))
+		}
+	}
+	// from the right of b around its end, then from the left of a around
+	// its start
+	arc(b, phi+math.Pi)
+	arc(a, phi)
+	return append(ring, ring[0])
+}
@@ -0,0 +1,148 @@
+// Package spatial answers questions about GeoJSON geometries in WGS84:
+// whether they intersect or contain each other, how far apart they are,
+// their area and length in metres and their buffer. Distances and buffers
+// are computed in a local plane around the geometries, which is accurate
+// to well under a percent within a few tens of kilometres. Index finds the
+// features of a layer whose bounding box meets a query box.
+package spatial
+
+import (
+	"math"
+
+	"kdg/be/lab/internal/geojson"
+)
+
+// earthRadius is the mean radius of the WGS84 ellipsoid in metres
+const earthRadius = 6371008.8
+
+const deg = math.Pi / 180
+
+// BBox returns the west, south, east and north bounds of a geometry, or
+// false when it has no positions
+func BBox(g *geojson.Geometry) (geojson.BBox, bool) {
+	if g == nil {
+		return geojson.BBox{}, false
+	}
+	return g.Bounds()
+}
+
+// ExpandBBox grows a box by a distance in metres on every side
+func ExpandBBox(box geojson.BBox, metres float64) geojson.BBox {
+	if metres <= 0 {
+		return box
+	}
+	dLat := metres / (earthRadius * deg)
+	lat := math.Max(math.Abs(box[1]), math.Abs(box[3])) + dLat
+	dLon := 180.0
+	if lat < 89 {
+		dLon = math.Min(180, dLat/math.Cos(lat*deg))
+	}
+	return geojson.BBox{
+		math.Max(-180, box[0]-dLon), math.Max(-90, box[1]-dLat),
+		math.Min(180, box[2]+dLon), math.Min(90, box[3]+dLat),
+	}
+}
+
+// Length returns the length of the lines of a geometry, or the perimeter
+// of its polygons, in metres along the great circle
+func Length(g *geojson.Geometry) float64 {
+	if g == nil {
+		return 0
+	}
+	switch g.Type {
+	case geojson.LineString:
+		return lineLength(g.LineString)
+	case geojson.MultiLineString:
+		return linesLength(g.MultiLineString)
+	case geojson.Polygon:
+		return linesLength(g.Polygon)
+	case geojson.MultiPolygon:
+		total := 0.0
+		for _, p := range g.MultiPolygon {
+			total += linesLength(p)
+		}
+		return total
+	case geojson.GeometryCollection:
+		total := 0.0
+		for _, m := range g.Geometries {
+			total += Length(m)
+		}
+		return total
+	}
+	return 0
+}
+
+func linesLength(lines [][]geojson.Position) float64 {
+	total := 0.0
+	for _, l := range lines {
+		total += lineLength(l)
+	}
+	return total
+}
+
+func lineLength(line []geojson.Position) float64 {
+	total := 0.0
+	for i := 1; i < len(line); i++ {
+		total += haversine(line[i-1], line[i])
+	}
+	return total
+}
+
+// haversine is the great circle distance between two positions in metres
+func haversine(a, b geojson.Position) float64 {
+	lat1, lat2 := a[1]*deg, b[1]*deg
+	dLat := lat2 - lat1
+	dLon := (b[0] - a[0]) * deg
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Area returns the area of the polygons of a geometry in square metres on
+// the sphere. Holes are subtracted.
+func Area(g *geojson.Geometry) float64 {
+	if g == nil {
+		return 0
+	}
+	switch g.Type {
+	case geojson.Polygon:
+		return polygonArea(g.Polygon)
+	case geojson.MultiPolygon:
+		total := 0.0
+		for _, p := range g.MultiPolygon {
+			total += polygonArea(p)
+		}
+		return total
+	case geojson.GeometryCollection:
+		total := 0.0
+		for _, m := range g.Geometries {
+			total += Area(m)
+		}
+		return total
+	}
+	return 0
+}
+
+func polygonArea(rings [][]geojson.Position) float64 {
+	if len(rings) == 0 {
+		return 0
+	}
+	area := ringArea(rings[0])
+	for _, hole := range rings[1:] {
+		area -= ringArea(hole)
+	}
+	return math.Max(0, area)
+}
+
+// ringArea is the unsigned spherical area of a closed ring, after Chamberlain
+// and Duquette, "Some algorithms for polygons on a sphere"
+func ringArea(ring []geojson.Position) float64 {
+	if len(ring) < 4 {
+		return 0
+	}
+	sum := 0.0
+	for i := 0; i+1 < len(ring); i++ {
+		a, b := ring[i], ring[i+1]
+		sum += (b[0] - a[0]) * deg * (2 + math.Sin(a[1]*deg) + math.Sin(b[1]*deg))
+	}
+	return math.Abs(sum * earthRadius * earthRadius / 2)
+}
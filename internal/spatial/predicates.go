@@ -0,0 +1,448 @@
+package spatial
+
+import (
+	"math"
+
+	"kdg/be/lab/internal/geojson"
+)
+
+// point is a position in a local plane, in metres
+type point struct{ x, y float64 }
+
+// plane is an equirectangular projection centred on a point. It is affine
+// in longitude and latitude, so it keeps intersections and containment
+// exact; only distances are approximate.
+type plane struct {
+	lon0, lat0 float64
+	kx, ky     float64
+}
+
+func newPlane(box geojson.BBox) plane {
+	lat0 := (box[1] + box[3]) / 2
+	ky := earthRadius * deg
+	return plane{lon0: (box[0] + box[2]) / 2, lat0: lat0, kx: ky * math.Cos(lat0*deg), ky: ky}
+}
+
+func (p plane) project(q geojson.Position) point {
+	return point{(q[0] - p.lon0) * p.kx, (q[1] - p.lat0) * p.ky}
+}
+
+func (p plane) unproject(q point) geojson.Position {
+	return geojson.Position{q.x/p.kx + p.lon0, q.y/p.ky + p.lat0}
+}
+
+// shape is a geometry in a plane, split into its points, lines and polygons
+type shape struct {
+	points   []point
+	lines    [][]point
+	polygons [][][]point
+}
+
+func (p plane) shape(g *geojson.Geometry) *shape {
+	s := &shape{}
+	p.add(s, g)
+	return s
+}
+
+func (p plane) add(s *shape, g *geojson.Geometry) {
+	if g == nil {
+		return
+	}
+	line := func(ps []geojson.Position) []point {
+		out := make([]point, 0, len(ps))
+		for _, q := range ps {
+			if len(q) >= 2 {
+				out = append(out, p.project(q))
+			}
+		}
+		return out
+	}
+	polygon := func(rings [][]geojson.Position) {
+		out := make([][]point, 0, len(rings))
+		for _, r := range rings {
+			out = append(out, line(r))
+		}
+		if len(out) > 0 && len(out[0]) > 0 {
+			s.polygons = append(s.polygons, out)
+		}
+	}
+
+	switch g.Type {
+	case geojson.Point:
+		if len(g.Point) >= 2 {
+			s.points = append(s.points, p.project(g.Point))
+		}
+	case geojson.MultiPoint:
+		s.points = append(s.points, line(g.MultiPoint)...)
+	case geojson.LineString:
+		s.lines = append(s.lines, line(g.LineString))
+	case geojson.MultiLineString:
+		for _, l := range g.MultiLineString {
+			s.lines = append(s.lines, line(l))
+		}
+	case geojson.Polygon:
+		polygon(g.Polygon)
+	case geojson.MultiPolygon:
+		for _, rings := range g.MultiPolygon {
+			polygon(rings)
+		}
+	case geojson.GeometryCollection:
+		for _, m := range g.Geometries {
+			p.add(s, m)
+		}
+	}
+}
+
+func (s *shape) empty() bool {
+	return len(s.points) == 0 && len(s.lines) == 0 && len(s.polygons) == 0
+}
+
+// eachVertex calls fn for every position until it returns true
+func (s *shape) eachVertex(fn func(point) bool) bool {
+	for _, q := range s.points {
+		if fn(q) {
+			return true
+		}
+	}
+	for _, l := range s.lines {
+		for _, q := range l {
+			if fn(q) {
+				return true
+			}
+		}
+	}
+	for _, rings := range s.polygons {
+		for _, r := range rings {
+			for _, q := range r {
+				if fn(q) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// eachSegment calls fn for every segment of the lines and polygon rings
+// until it returns true. ring tells the two apart.
+func (s *shape) eachSegment(fn func(a, b point, ring bool) bool) bool {
+	for _, l := range s.lines {
+		for i := 1; i < len(l); i++ {
+			if fn(l[i-1], l[i], false) {
+				return true
+			}
+		}
+	}
+	for _, rings := range s.polygons {
+		for _, r := range rings {
+			for i := 1; i < len(r); i++ {
+				if fn(r[i-1], r[i], true) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// touches reports whether a point is one of the points of the shape, on
+// one of its segments or inside one of its polygons
+func (s *shape) touches(q point) bool {
+	for _, p := range s.points {
+		if p == q {
+			return true
+		}
+	}
+	if s.eachSegment(func(a, b point, _ bool) bool { return onSegment(q, a, b) }) {
+		return true
+	}
+	for _, rings := range s.polygons {
+		if inPolygon(q, rings) {
+			return true
+		}
+	}
+	return false
+}
+
+// inside reports whether a point lies inside one of the shape's polygons
+// and on none of their rings
+func (s *shape) inside(q point) bool {
+	onRing := s.eachSegment(func(a, b point, ring bool) bool { return ring && onSegment(q, a, b) })
+	if onRing {
+		return false
+	}
+	for _, rings := range s.polygons {
+		if inPolygon(q, rings) {
+			return true
+		}
+	}
+	return false
+}
+
+// inPolygon tests a point against the exterior ring and holes of a
+// polygon by ray casting; points on a ring may go either way
+func inPolygon(q point, rings [][]point) bool {
+	if !inRing(q, rings[0]) {
+		return false
+	}
+	for _, hole := range rings[1:] {
+		if inRing(q, hole) {
+			return false
+		}
+	}
+	return true
+}
+
+func inRing(q point, ring []point) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.y > q.y) != (b.y > q.y) && q.x < (b.x-a.x)*(q.y-a.y)/(b.y-a.y)+a.x {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func cross(o, a, b point) float64 {
+	return (a.x-o.x)*(b.y-o.y) - (a.y-o.y)*(b.x-o.x)
+}
+
+func onSegment(q, a, b point) bool {
+	return cross(a, b, q) == 0 &&
+		q.x >= math.Min(a.x, b.x) && q.x <= math.Max(a.x, b.x) &&
+		q.y >= math.Min(a.y, b.y) && q.y <= math.Max(a.y, b.y)
+}
+
+// segmentsIntersect reports whether two segments share any point
+func segmentsIntersect(a, b, c, d point) bool {
+	d1, d2 := cross(c, d, a), cross(c, d, b)
+	d3, d4 := cross(a, b, c), cross(a, b, d)
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		return true
+	}
+	return onSegment(a, c, d) || onSegment(b, c, d) || onSegment(c, a, b) || onSegment(d, a, b)
+}
+
+// segmentsCross reports whether two segments cross at a point inside both
+func segmentsCross(a, b, c, d point) bool {
+	d1, d2 := cross(c, d, a), cross(c, d, b)
+	d3, d4 := cross(a, b, c), cross(a, b, d)
+	return ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0))
+}
+
+func pointSegmentDistance(q, a, b point) float64 {
+	dx, dy := b.x-a.x, b.y-a.y
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = math.Max(0, math.Min(1, ((q.x-a.x)*dx+(q.y-a.y)*dy)/l))
+	}
+	return math.Hypot(q.x-(a.x+t*dx), q.y-(a.y+t*dy))
+}
+
+// Prepared is a geometry projected once, to be compared with many others
+// in the plane around it
+type Prepared struct {
+	geometry *geojson.Geometry
+	box      geojson.BBox
+	plane    plane
+	shape    *shape
+}
+
+// Prepare projects a geometry for comparisons. A nil or empty geometry
+// meets nothing.
+func Prepare(g *geojson.Geometry) *Prepared {
+	box, _ := BBox(g)
+	p := &Prepared{geometry: g, box: box, plane: newPlane(box)}
+	p.shape = p.plane.shape(g)
+	return p
+}
+
+// BBox returns the bounds of the prepared geometry
+func (p *Prepared) BBox() geojson.BBox {
+	return p.box
+}
+
+// Intersects reports whether the prepared geometry and g share any point
+func (p *Prepared) Intersects(g *geojson.Geometry) bool {
+	box, ok := BBox(g)
+	if !ok || p.shape.empty() || !box.Intersects(p.box) {
+		return false
+	}
+	return intersects(p.shape, p.plane.shape(g))
+}
+
+func intersects(a, b *shape) bool {
+	crossed := a.eachSegment(func(p, q point, _ bool) bool {
+		return b.eachSegment(func(r, s point, _ bool) bool { return segmentsIntersect(p, q, r, s) })
+	})
+	return crossed || a.eachVertex(b.touches) || b.eachVertex(a.touches)
+}
+
+// Contains reports whether g lies inside the prepared geometry, its
+// boundary included
+func (p *Prepared) Contains(g *geojson.Geometry) bool {
+	box, ok := BBox(g)
+	if !ok || p.shape.empty() || !box.Intersects(p.box) {
+		return false
+	}
+	return contains(p.shape, p.plane.shape(g))
+}
+
+// Within reports whether the prepared geometry lies inside g
+func (p *Prepared) Within(g *geojson.Geometry) bool {
+	box, ok := BBox(g)
+	if !ok || p.shape.empty() || !box.Intersects(p.box) {
+		return false
+	}
+	return contains(p.plane.shape(g), p.shape)
+}
+
+// contains checks that every vertex and segment midpoint of b touches a,
+// that no segment of b crosses a polygon ring of a, that no ring of a lies
+// inside a polygon of b, and that the inside of b next to each of its rings
+// touches a. The last two catch polygons of b that span or fill a hole of a
+// or the gap between two of its parts.
+func contains(a, b *shape) bool {
+	if b.empty() {
+		return false
+	}
+	if b.eachVertex(func(q point) bool { return !a.touches(q) }) {
+		return false
+	}
+	crossed := b.eachSegment(func(p, q point, _ bool) bool {
+		if !a.touches(point{(p.x + q.x) / 2, (p.y + q.y) / 2}) {
+			return true
+		}
+		return a.eachSegment(func(r, s point, ring bool) bool { return ring && segmentsCross(p, q, r, s) })
+	})
+	if crossed {
+		return false
+	}
+	if len(b.polygons) == 0 {
+		return true
+	}
+	spanned := a.eachSegment(func(p, q point, ring bool) bool {
+		return ring && (b.inside(p) || b.inside(point{(p.x + q.x) / 2, (p.y + q.y) / 2}))
+	})
+	if spanned {
+		return false
+	}
+	return !b.eachSegment(func(p, q point, ring bool) bool {
+		if !ring {
+			return false
+		}
+		// Points just off the middle of the segment, on both sides
+		m := point{(p.x + q.x) / 2, (p.y + q.y) / 2}
+		nx, ny := (p.y-q.y)*insideOffset, (q.x-p.x)*insideOffset
+		for _, r := range []point{{m.x + nx, m.y + ny}, {m.x - nx, m.y - ny}} {
+			if b.inside(r) && !a.touches(r) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// insideOffset is how far contains looks beside a ring segment, as a
+// fraction of its length
+const insideOffset = 1e-6
+
+// Distance returns the shortest distance between the prepared geometry and
+// g in metres: 0 when they intersect and infinite when either is empty
+func (p *Prepared) Distance(g *geojson.Geometry) float64 {
+	if p.shape.empty() {
+		return math.Inf(1)
+	}
+	other := p.plane.shape(g)
+	if other.empty() {
+		return math.Inf(1)
+	}
+	if intersects(p.shape, other) {
+		return 0
+	}
+	return math.Min(vertexDistance(p.shape, other), vertexDistance(other, p.shape))
+}
+
+// vertexDistance is the shortest distance from a vertex of a to b. For
+// shapes that do not intersect it is reached at a vertex of one of them.
+func vertexDistance(a, b *shape) float64 {
+	best := math.Inf(1)
+	a.eachVertex(func(q point) bool {
+		best = math.Min(best, pointDistance(q, b))
+		return false
+	})
+	return best
+}
+
+// pointDistance is the shortest distance from a point to the points and
+// segments of a shape
+func pointDistance(q point, s *shape) float64 {
+	best := math.Inf(1)
+	for _, r := range s.points {
+		best = math.Min(best, math.Hypot(q.x-r.x, q.y-r.y))
+	}
+	s.eachSegment(func(a, b point, _ bool) bool {
+		best = math.Min(best, pointSegmentDistance(q, a, b))
+		return false
+	})
+	return best
+}
+
+// WithinDistance reports whether g comes within a distance in metres of
+// the prepared geometry
+func (p *Prepared) WithinDistance(g *geojson.Geometry, metres float64) bool {
+	box, ok := BBox(g)
+	if !ok || p.shape.empty() || !box.Intersects(ExpandBBox(p.box, metres)) {
+		return false
+	}
+	return p.Distance(g) <= metres
+}
+
+// maxSamples bounds the points WithinBuffer checks along a segment
+const maxSamples = 256
+
+// WithinBuffer reports whether g lies within a distance in metres of the
+// prepared geometry. It checks the positions of g and points along its
+// segments a quarter of the distance apart, so the inside of a polygon
+// whose boundary is near is taken to be near too.
+func (p *Prepared) WithinBuffer(g *geojson.Geometry, metres float64) bool {
+	if metres <= 0 {
+		return p.Contains(g)
+	}
+	box, ok := BBox(g)
+	if !ok || p.shape.empty() || !box.Intersects(ExpandBBox(p.box, metres)) {
+		return false
+	}
+	near := func(q point) bool { return p.shape.touches(q) || pointDistance(q, p.shape) <= metres }
+
+	other := p.plane.shape(g)
+	if other.eachVertex(func(q point) bool { return !near(q) }) {
+		return false
+	}
+	return !other.eachSegment(func(a, b point, _ bool) bool {
+		n := int(math.Min(maxSamples, math.Ceil(math.Hypot(b.x-a.x, b.y-a.y)/(metres/4))))
+		for k := 1; k < n; k++ {
+			t := float64(k) / float64(n)
+			if !near(point{a.x + t*(b.x-a.x), a.y + t*(b.y-a.y)}) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Intersects reports whether two geometries share any point
+func Intersects(a, b *geojson.Geometry) bool { return Prepare(a).Intersects(b) }
+
+// Contains reports whether b lies inside a
+func Contains(a, b *geojson.Geometry) bool { return Prepare(a).Contains(b) }
+
+// Distance returns the shortest distance between two geometries in metres
+func Distance(a, b *geojson.Geometry) float64 { return Prepare(a).Distance(b) }
+
+// WithinDistance reports whether two geometries come within a distance in
+// metres of each other
+func WithinDistance(a, b *geojson.Geometry, metres float64) bool {
+	return Prepare(a).WithinDistance(b, metres)
+}
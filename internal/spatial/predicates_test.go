@@ -0,0 +1,185 @@
+package spatial
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"kdg/be/lab/internal/geojson"
+)
+
+func geometry(t *testing.T, s string) *geojson.Geometry {
+	t.Helper()
+	g := &geojson.Geometry{}
+	if err := json.Unmarshal([]byte(s), g); err != nil {
+		t.Fatalf("%s: %v", s, err)
+	}
+	return g
+}
+
+const (
+	square     = `{"type":"Polygon","coordinates":[[[0,0],[4,0],[4,4],[0,4],[0,0]]]}`
+	squareHole = `{"type":"Polygon","coordinates":[[[0,0],[4,0],[4,4],[0,4],[0,0]],[[1,1],[1,3],[3,3],[3,1],[1,1]]]}`
+	twoSquares = `{"type":"MultiPolygon","coordinates":[[[[0,0],[1,0],[1,1],[0,1],[0,0]]],[[[2,0],[3,0],[3,1],[2,1],[2,0]]]]}`
+	// lShape leaves the square 2,2 to 4,4 out of its bounding box
+	lShape = `{"type":"Polygon","coordinates":[[[0,0],[4,0],[4,2],[2,2],[2,4],[0,4],[0,0]]]}`
+)
+
+func TestPredicates(t *testing.T) {
+	tests := []struct {
+		name       string
+		a, b       string
+		intersects bool
+		contains   bool // a contains b
+		within     bool // a within b
+	}{
+		{"point inside", square, `{"type":"Point","coordinates":[2,2]}`, true, true, false},
+		{"point on edge", square, `{"type":"Point","coordinates":[4,2]}`, true, true, false},
+		{"point on vertex", square, `{"type":"Point","coordinates":[0,0]}`, true, true, false},
+		{"point outside", square, `{"type":"Point","coordinates":[5,2]}`, false, false, false},
+		{"point in hole", squareHole, `{"type":"Point","coordinates":[2,2]}`, false, false, false},
+		{"point on hole edge", squareHole, `{"type":"Point","coordinates":[1,2]}`, true, true, false},
+		{"point in notch", lShape, `{"type":"Point","coordinates":[3,3]}`, false, false, false},
+		{"shared edge", square, `{"type":"Polygon","coordinates":[[[4,0],[6,0],[6,4],[4,4],[4,0]]]}`, true, false, false},
+		{"shared corner", square, `{"type":"Polygon","coordinates":[[[4,4],[6,4],[6,6],[4,6],[4,4]]]}`, true, false, false},
+		{"overlap", square, `{"type":"Polygon","coordinates":[[[3,3],[6,3],[6,6],[3,6],[3,3]]]}`, true, false, false},
+		{"inner polygon", square, `{"type":"Polygon","coordinates":[[[1,1],[2,1],[2,2],[1,2],[1,1]]]}`, true, true, false},
+		{"inner polygon on edge", square, `{"type":"Polygon","coordinates":[[[0,0],[2,0],[2,2],[0,2],[0,0]]]}`, true, true, false},
+		{"same polygon", square, square, true, true, true},
+		{"outer polygon", `{"type":"Polygon","coordinates":[[[1,1],[2,1],[2,2],[1,2],[1,1]]]}`, square, true, false, true},
+		{"polygon in notch", lShape, `{"type":"Polygon","coordinates":[[[2.5,2.5],[3.5,2.5],[3.5,3.5],[2.5,3.5],[2.5,2.5]]]}`, false, false, false},
+		{"polygon over hole", squareHole, `{"type":"Polygon","coordinates":[[[0.5,0.5],[3.5,0.5],[3.5,3.5],[0.5,3.5],[0.5,0.5]]]}`, true, false, false},
+		{"polygon filling hole", squareHole, `{"type":"Polygon","coordinates":[[[1,1],[3,1],[3,3],[1,3],[1,1]]]}`, true, false, false},
+		{"polygon in hole", squareHole, `{"type":"Polygon","coordinates":[[[1.5,1.5],[2.5,1.5],[2.5,2.5],[1.5,2.5],[1.5,1.5]]]}`, false, false, false},
+		{"polygon over gap", twoSquares, `{"type":"Polygon","coordinates":[[[0.5,0.2],[2.5,0.2],[2.5,0.8],[0.5,0.8],[0.5,0.2]]]}`, true, false, false},
+		{"polygon in one part", twoSquares, `{"type":"Polygon","coordinates":[[[2.2,0.2],[2.8,0.2],[2.8,0.8],[2.2,0.8],[2.2,0.2]]]}`, true, true, false},
+		{"line crossing", square, `{"type":"LineString","coordinates":[[-1,2],[5,2]]}`, true, false, false},
+		{"line inside", square, `{"type":"LineString","coordinates":[[1,1],[3,3]]}`, true, true, false},
+		{"line along edge", square, `{"type":"LineString","coordinates":[[0,0],[4,0]]}`, true, true, false},
+		{"line through hole", squareHole, `{"type":"LineString","coordinates":[[0.5,2],[3.5,2]]}`, true, false, false},
+		{"line across notch", lShape, `{"type":"LineString","coordinates":[[1,3],[3,3]]}`, true, false, false},
+		{"line around notch", lShape, `{"type":"LineString","coordinates":[[1,3],[3,1]]}`, true, true, false},
+		{"line touching", `{"type":"LineString","coordinates":[[0,0],[2,2]]}`, `{"type":"LineString","coordinates":[[2,2],[4,0]]}`, true, false, false},
+		{"lines crossing", `{"type":"LineString","coordinates":[[0,0],[2,2]]}`, `{"type":"LineString","coordinates":[[0,2],[2,0]]}`, true, false, false},
+		{"parallel lines", `{"type":"LineString","coordinates":[[0,0],[2,0]]}`, `{"type":"LineString","coordinates":[[0,1],[2,1]]}`, false, false, false},
+		{"part of line", `{"type":"LineString","coordinates":[[0,0],[4,0]]}`, `{"type":"LineString","coordinates":[[1,0],[3,0]]}`, true, true, false},
+		{"collection", `{"type":"GeometryCollection","geometries":[{"type":"Point","coordinates":[9,9]},` + square + `]}`, `{"type":"Point","coordinates":[1,1]}`, true, true, false},
+		{"far apart", square, `{"type":"Point","coordinates":[-170,50]}`, false, false, false},
+		{"empty", square, `{"type":"MultiPoint","coordinates":[]}`, false, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := geometry(t, tt.a), geometry(t, tt.b)
+			if got := Intersects(a, b); got != tt.intersects {
+				t.Errorf("Intersects = %v, want %v", got, tt.intersects)
+			}
+			if got := Intersects(b, a); got != tt.intersects {
+				t.Errorf("reversed Intersects = %v, want %v", got, tt.intersects)
+			}
+			if got := Contains(a, b); got != tt.contains {
+				t.Errorf("Contains = %v, want %v", got, tt.contains)
+			}
+			if got := Prepare(a).Within(b); got != tt.within {
+				t.Errorf("Within = %v, want %v", got, tt.within)
+			}
+			if got := Prepare(b).Within(a); got != tt.contains {
+				t.Errorf("reversed Within = %v, want %v", got, tt.contains)
+			}
+		})
+	}
+}
+
+func TestBBox(t *testing.T) {
+	tests := []struct {
+		name string
+		g    string
+		want geojson.BBox
+		ok   bool
+	}{
+		{"point", `{"type":"Point","coordinates":[3,-2]}`, geojson.BBox{3, -2, 3, -2}, true},
+		{"polygon", squareHole, geojson.BBox{0, 0, 4, 4}, true},
+		{"west of the antimeridian", `{"type":"LineString","coordinates":[[179,10],[170,-10]]}`, geojson.BBox{170, -10, 179, 10}, true},
+		{"east of the antimeridian", `{"type":"LineString","coordinates":[[-179,10],[-170,-10]]}`, geojson.BBox{-179, -10, -170, 10}, true},
+		{"collection", `{"type":"GeometryCollection","geometries":[{"type":"Point","coordinates":[-5,1]},` + square + `]}`, geojson.BBox{-5, 0, 4, 4}, true},
+		{"empty", `{"type":"LineString","coordinates":[]}`, geojson.BBox{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := BBox(geometry(t, tt.g))
+			if ok != tt.ok || (ok && got != tt.want) {
+				t.Errorf("BBox = %v, %v, want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+// Boxes meet when they share an edge or corner. Boxes never wrap around
+// the antimeridian, so boxes on both sides of it do not meet.
+func TestBBoxFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b geojson.BBox
+		want bool
+	}{
+		{"overlap", geojson.BBox{0, 0, 2, 2}, geojson.BBox{1, 1, 3, 3}, true},
+		{"inside", geojson.BBox{0, 0, 4, 4}, geojson.BBox{1, 1, 2, 2}, true},
+		{"shared edge", geojson.BBox{0, 0, 2, 2}, geojson.BBox{2, 0, 4, 2}, true},
+		{"shared corner", geojson.BBox{0, 0, 2, 2}, geojson.BBox{2, 2, 4, 4}, true},
+		{"apart in x", geojson.BBox{0, 0, 2, 2}, geojson.BBox{2.1, 0, 4, 2}, false},
+		{"apart in y", geojson.BBox{0, 0, 2, 2}, geojson.BBox{0, 2.1, 2, 4}, false},
+		{"point boxes", geojson.BBox{1, 1, 1, 1}, geojson.BBox{1, 1, 1, 1}, true},
+		{"antimeridian", geojson.BBox{170, -10, 180, 10}, geojson.BBox{-180, -10, -170, 10}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.Intersects(tt.b); got != tt.want {
+				t.Errorf("Intersects = %v, want %v", got, tt.want)
+			}
+			if got := tt.b.Intersects(tt.a); got != tt.want {
+				t.Errorf("reversed Intersects = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Points on both sides of the antimeridian are far apart
+	east := geometry(t, `{"type":"Point","coordinates":[179.9,0]}`)
+	west := geometry(t, `{"type":"Point","coordinates":[-179.9,0]}`)
+	if Intersects(east, west) || WithinDistance(east, west, 1000) {
+		t.Error("points on both sides of the antimeridian meet")
+	}
+}
+
+func TestDistance(t *testing.T) {
+	// A degree of latitude is about 111.2 km
+	tests := []struct {
+		name string
+		a, b string
+		want float64
+	}{
+		{"points", `{"type":"Point","coordinates":[4,51]}`, `{"type":"Point","coordinates":[4,52]}`, 111195},
+		{"point to edge", square, `{"type":"Point","coordinates":[2,5]}`, 111195},
+		{"intersecting", square, `{"type":"Point","coordinates":[2,2]}`, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Distance(geometry(t, tt.a), geometry(t, tt.b))
+			if math.Abs(got-tt.want) > 100 {
+				t.Errorf("Distance = %.0f, want %.0f", got, tt.want)
+			}
+			if !WithinDistance(geometry(t, tt.a), geometry(t, tt.b), tt.want+100) {
+				t.Error("not within the distance")
+			}
+			if tt.want > 0 && WithinDistance(geometry(t, tt.a), geometry(t, tt.b), tt.want-1000) {
+				t.Error("within a shorter distance")
+			}
+		})
+	}
+
+	if d := Distance(geometry(t, square), geometry(t, `{"type":"MultiPoint","coordinates":[]}`)); !math.IsInf(d, 1) {
+		t.Errorf("distance to an empty geometry = %v", d)
+	}
+}
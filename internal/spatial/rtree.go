@@ -0,0 +1,95 @@
+package spatial
+
+import (
+	"math"
+	"sort"
+
+	"kdg/be/lab/internal/geojson"
+)
+
+// nodeSize is the number of entries of an index node
+const nodeSize = 16
+
+// Index is a static R-tree over the bounding boxes of the features of a
+// collection, packed with the Sort-Tile-Recursive algorithm
+type Index struct {
+	// levels[0] holds a box per feature and every further level a box per
+	// node, covering a run of entries of the level below
+	levels [][]entry
+}
+
+type entry struct {
+	box        geojson.BBox
+	start, end int
+}
+
+// NewIndex builds an index over the features of a collection. Features
+// without a geometry are left out.
+func NewIndex(fc *geojson.FeatureCollection) *Index {
+	var items []entry
+	for i, f := range fc.Features {
+		if box, ok := BBox(f.Geometry); ok {
+			items = append(items, entry{box: box, start: i, end: i + 1})
+		}
+	}
+	idx := &Index{levels: [][]entry{items}}
+	for level := items; len(level) > nodeSize; {
+		level = pack(level)
+		idx.levels = append(idx.levels, level)
+	}
+	return idx
+}
+
+// pack sorts a level into tiles and returns the level of nodes above it
+func pack(level []entry) []entry {
+	nodes := (len(level) + nodeSize - 1) / nodeSize
+	perSlice := int(math.Ceil(math.Sqrt(float64(nodes)))) * nodeSize
+	centre := func(e entry, axis int) float64 { return e.box[axis] + e.box[axis+2] }
+
+	sort.Slice(level, func(i, j int) bool { return centre(level[i], 0) < centre(level[j], 0) })
+	for s := 0; s < len(level); s += perSlice {
+		slice := level[s:min(s+perSlice, len(level))]
+		sort.Slice(slice, func(i, j int) bool { return centre(slice[i], 1) < centre(slice[j], 1) })
+	}
+
+	parents := make([]entry, 0, nodes)
+	for s := 0; s < len(level); s += nodeSize {
+		e := entry{box: level[s].box, start: s, end: min(s+nodeSize, len(level))}
+		for _, c := range level[s+1 : e.end] {
+			e.box = geojson.BBox{
+				math.Min(e.box[0], c.box[0]), math.Min(e.box[1], c.box[1]),
+				math.Max(e.box[2], c.box[2]), math.Max(e.box[3], c.box[3]),
+			}
+		}
+		parents = append(parents, e)
+	}
+	return parents
+}
+
+// Len returns the number of indexed features
+func (idx *Index) Len() int {
+	return len(idx.levels[0])
+}
+
+// Search returns the positions in the collection of the features whose
+// bounding box meets a box, in collection order
+func (idx *Index) Search(box geojson.BBox) []int {
+	var found []int
+	var visit func(level, start, end int)
+	visit = func(level, start, end int) {
+		for _, e := range idx.levels[level][start:end] {
+			if !e.box.Intersects(box) {
+				continue
+			}
+			if level == 0 {
+				found = append(found, e.start)
+			} else {
+				visit(level-1, e.start, e.end)
+			}
+		}
+	}
+	top := len(idx.levels) - 1
+	visit(top, 0, len(idx.levels[top]))
+	sort.Ints(found)
+	return found
+}
@@ -0,0 +1,93 @@
+package spatial
+
+import (
+	"math/rand"
+	"testing"
+
+	"kdg/be/lab/internal/geojson"
+)
+
+// randomCollection returns points and small polygons spread over an area,
+// with a feature without geometry every so often
+func randomCollection(rng *rand.Rand, n int) *geojson.FeatureCollection {
+	fc := geojson.NewFeatureCollection()
+	for i := 0; i < n; i++ {
+		x, y := rng.Float64()*20-10, rng.Float64()*20-10
+		var g *geojson.Geometry
+		switch i % 5 {
+		case 0:
+		case 1, 2:
+			g = &geojson.Geometry{Type: geojson.Point, Point: geojson.Position{x, y}}
+		default:
+			w, h := rng.Float64(), rng.Float64()
+			g = &geojson.Geometry{Type: geojson.Polygon, Polygon: [][]geojson.Position{{
+				{x, y}, {x + w, y}, {x + w, y + h}, {x, y + h}, {x, y},
+			}}}
+		}
+		fc.Features = append(fc.Features, &geojson.Feature{Geometry: g})
+	}
+	return fc
+}
+
+// bruteForce returns the features whose bounding box meets a box
+func bruteForce(fc *geojson.FeatureCollection, box geojson.BBox) []int {
+	var found []int
+	for i, f := range fc.Features {
+		if b, ok := BBox(f.Geometry); ok && b.Intersects(box) {
+			found = append(found, i)
+		}
+	}
+	return found
+}
+
+func TestIndexSearch(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for _, n := range []int{0, 1, nodeSize, nodeSize + 1, 1000, 5000} {
+		fc := randomCollection(rng, n)
+		idx := NewIndex(fc)
+		if want := len(bruteForce(fc, geojson.BBox{-20, -20, 20, 20})); idx.Len() != want {
+			t.Errorf("%d features: Len = %d, want %d", n, idx.Len(), want)
+		}
+
+		for q := 0; q < 200; q++ {
+			x, y := rng.Float64()*24-12, rng.Float64()*24-12
+			size := rng.Float64() * 5
+			box := geojson.BBox{x, y, x + size, y + size*rng.Float64()}
+			if q%20 == 0 {
+				box = geojson.BBox{x, y, x, y}
+			}
+
+			got, want := idx.Search(box), bruteForce(fc, box)
+			if len(got) != len(want) {
+				t.Fatalf("%d features, box %v: %d results, brute force finds %d", n, box, len(got), len(want))
+			}
+			for i := range got {
+				if got[i] != want[i] {
+					t.Fatalf("%d features, box %v: results %v, brute force finds %v", n, box, got, want)
+				}
+			}
+		}
+	}
+}
+
+// Features that only touch the search box on an edge are found
+func TestIndexSearchEdges(t *testing.T) {
+	fc := geojson.NewFeatureCollection()
+	for i := 0; i < 100; i++ {
+		x := float64(i)
+		fc.Features = append(fc.Features, &geojson.Feature{Geometry: &geojson.Geometry{
+			Type:       geojson.LineString,
+			LineString: []geojson.Position{{x, 0}, {x + 1, 1}},
+		}})
+	}
+	idx := NewIndex(fc)
+
+	got := idx.Search(geojson.BBox{10, 1, 10, 5})
+	if len(got) != 2 || got[0] != 9 || got[1] != 10 {
+		t.Errorf("Search = %v, want [9 10]", got)
+	}
+	if got := idx.Search(geojson.BBox{-5, -5, -0.5, -0.5}); len(got) != 0 {
+		t.Errorf("Search outside = %v", got)
+	}
+}
@@ -0,0 +1,78 @@
+package vectorstore
+
+import (
+	"context"
+	"hash/fnv"
+	"strings"
+	"unicode"
+
+	"github.com/tmc/langchaingo/llms/ollama"
+)
+
+// Embedder turns texts into vectors
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// OllamaEmbedder embeds with an Ollama embedding model
+type OllamaEmbedder struct {
+	llm *ollama.LLM
+}
+
+// NewOllamaEmbedder uses the model on the Ollama server at serverURL, or on
+// the default server when serverURL is empty
+func NewOllamaEmbedder(model, serverURL string) (*OllamaEmbedder, error) {
+	options := []ollama.Option{ollama.WithModel(model)}
+	if serverURL != "" {
+		options = append(options, ollama.WithServerURL(serverURL))
+	}
+
+	llm, err := ollama.New(options...)
+	if err != nil {
+		return nil, err
+	}
+	return &OllamaEmbedder{llm: llm}, nil
+}
+
+func (e *OllamaEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return e.llm.CreateEmbedding(ctx, texts)
+}
+
+// HashEmbedder is a deterministic embedder for tests and development. Every
+// word is hashed to one dimension, so texts sharing words are similar.
+type HashEmbedder struct {
+	Dimensions int
+}
+
+func NewHashEmbedder(dimensions int) *HashEmbedder {
+	if dimensions <= 0 {
+		dimensions = 256
+	}
+	return &HashEmbedder{Dimensions: dimensions}
+}
+
+func (e *HashEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+
+	for i, text := range texts {
+		v := make([]float32, e.Dimensions)
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, word := range words {
+			h := fnv.New64a()
+			h.Write([]byte(word))
+			sum := h.Sum64()
+
+			// The top bit picks the sign so collisions tend to cancel out
+			if sum>>63 == 0 {
+				v[sum%uint64(e.Dimensions)]++
+			} else {
+				v[sum%uint64(e.Dimensions)]--
+			}
+		}
+		vectors[i] = normalize(v)
+	}
+
+	return vectors, nil
+}
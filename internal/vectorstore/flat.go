@@ -0,0 +1,196 @@
+package vectorstore
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// FlatStore compares a query with every vector of a project. Each project is
+// kept in memory once used and saved to its own file in the store directory.
+type FlatStore struct {
+	dir string
+
+	mu       sync.RWMutex
+	projects map[uuid.UUID]*flatProject
+}
+
+// flatProject is the saved form of a project's vectors
+type flatProject struct {
+	Dimensions int
+	Entries    []Entry
+
+	// positions maps chunk IDs to their index in Entries
+	positions map[uuid.UUID]int
+}
+
+func NewFlatStore(dir string) (*FlatStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FlatStore{dir: dir, projects: map[uuid.UUID]*flatProject{}}, nil
+}
+
+func (s *FlatStore) path(projectID uuid.UUID) string {
+	return filepath.Join(s.dir, projectID.String()+".gob")
+}
+
+// project returns the vectors of a project, loading them on first use. The
+// caller must hold the write lock.
+func (s *FlatStore) project(projectID uuid.UUID) (*flatProject, error) {
+	if p, ok := s.projects[projectID]; ok {
+		return p, nil
+	}
+
+	p := &flatProject{}
+	f, err := os.Open(s.path(projectID))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		err = gob.NewDecoder(f).Decode(p)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p.positions = make(map[uuid.UUID]int, len(p.Entries))
+	for i, e := range p.Entries {
+		p.positions[e.ChunkID] = i
+	}
+
+	s.projects[projectID] = p
+	return p, nil
+}
+
+// save writes a project to a temporary file and renames it into place
+func (s *FlatStore) save(projectID uuid.UUID, p *flatProject) error {
+	f, err := os.CreateTemp(s.dir, "vectors-*")
+	if err != nil {
+		return err
+	}
+
+	if err := gob.NewEncoder(f).Encode(p); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), s.path(projectID))
+}
+
+func (s *FlatStore) Upsert(ctx context.Context, entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := map[uuid.UUID]*flatProject{}
+	for _, e := range entries {
+		p, err := s.project(e.ProjectID)
+		if err != nil {
+			return err
+		}
+		if p.Dimensions == 0 || len(p.Entries) == 0 {
+			p.Dimensions = len(e.Vector)
+		}
+		if len(e.Vector) != p.Dimensions {
+			return ErrDimensions
+		}
+
+		e.Vector = normalize(e.Vector)
+		if i, ok := p.positions[e.ChunkID]; ok {
+			p.Entries[i] = e
+		} else {
+			p.positions[e.ChunkID] = len(p.Entries)
+			p.Entries = append(p.Entries, e)
+		}
+		changed[e.ProjectID] = p
+	}
+
+	for projectID, p := range changed {
+		if err := s.save(projectID, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FlatStore) DeleteFile(ctx context.Context, projectID, fileID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, err := s.project(projectID)
+	if err != nil {
+		return err
+	}
+
+	kept := p.Entries[:0]
+	for _, e := range p.Entries {
+		if e.FileID != fileID {
+			kept = append(kept, e)
+		}
+	}
+	if len(kept) == len(p.Entries) {
+		return nil
+	}
+	p.Entries = kept
+
+	p.positions = make(map[uuid.UUID]int, len(p.Entries))
+	for i, e := range p.Entries {
+		p.positions[e.ChunkID] = i
+	}
+
+	return s.save(projectID, p)
+}
+
+func (s *FlatStore) Search(ctx context.Context, projectID uuid.UUID, vector []float32, k int, filter Filter) ([]Result, error) {
+	// Loading a project changes the cache, so it needs the write lock
+	s.mu.Lock()
+	p, err := s.project(projectID)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(p.Entries) == 0 || k <= 0 {
+		return []Result{}, nil
+	}
+	if len(vector) != p.Dimensions {
+		return nil, ErrDimensions
+	}
+
+	query := normalize(vector)
+	results := []Result{}
+	for _, e := range p.Entries {
+		if !filter.matchFile(e.FileID) {
+			continue
+		}
+		score := dot(query, e.Vector)
+		if score < filter.MinScore {
+			continue
+		}
+		results = append(results, Result{ChunkID: e.ChunkID, FileID: e.FileID, Score: score})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results, nil
+}
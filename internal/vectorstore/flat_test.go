@@ -0,0 +1,201 @@
+package vectorstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// testIndex returns a flat index in a temporary directory with the hash
+// embedder, holding texts of two projects
+func testIndex(t *testing.T, dir string) (*Index, map[string]Entry) {
+	t.Helper()
+	store, err := NewFlatStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix := New(NewHashEmbedder(512), store)
+
+	projectA, projectB := uuid.New(), uuid.New()
+	fileA1, fileA2, fileB := uuid.New(), uuid.New(), uuid.New()
+	texts := []struct {
+		name    string
+		project uuid.UUID
+		file    uuid.UUID
+		text    string
+	}{
+		{"flood", projectA, fileA1, "river flood risk map of the river delta"},
+		{"river", projectA, fileA1, "the river runs through the old town"},
+		{"soil", projectA, fileA2, "soil samples taken near the motorway"},
+		{"budget", projectA, fileA2, "annual budget and staff costs"},
+		{"otherFlood", projectB, fileB, "river flood risk map of the river delta"},
+	}
+
+	entries := map[string]Entry{}
+	var batch []Entry
+	var batchTexts []string
+	for _, tt := range texts {
+		e := Entry{ChunkID: uuid.New(), FileID: tt.file, ProjectID: tt.project}
+		entries[tt.name] = e
+		batch = append(batch, e)
+		batchTexts = append(batchTexts, tt.text)
+	}
+	if err := ix.Add(context.Background(), batch, batchTexts); err != nil {
+		t.Fatal(err)
+	}
+	return ix, entries
+}
+
+func TestFlatSearch(t *testing.T) {
+	ix, entries := testIndex(t, t.TempDir())
+	projectA := entries["flood"].ProjectID
+
+	tests := []struct {
+		name   string
+		query  string
+		k      int
+		filter Filter
+		want   []string
+	}{
+		{"ordering", "river flood risk", 4, Filter{}, []string{"flood", "river"}},
+		{"top k", "river flood risk", 1, Filter{}, []string{"flood"}},
+		{"zero k", "river flood risk", 0, Filter{}, nil},
+		{"file filter", "river soil", 4, Filter{FileIDs: []uuid.UUID{entries["soil"].FileID}}, []string{"soil"}},
+		{"min score", "river flood risk map delta", 4, Filter{MinScore: 0.5}, []string{"flood"}},
+		{"no match", "zebra", 4, Filter{MinScore: 0.01}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := ix.Search(context.Background(), projectA, tt.query, tt.k, tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Chunks that share no words score 0 and follow the matches
+			var got []string
+			for _, r := range results {
+				if r.Score <= 0 {
+					continue
+				}
+				for name, e := range entries {
+					if e.ChunkID == r.ChunkID {
+						got = append(got, name)
+					}
+				}
+				if r.FileID == entries["otherFlood"].FileID {
+					t.Errorf("result of another project: %+v", r)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("results = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("results = %v, want %v", got, tt.want)
+				}
+			}
+			for i := 1; i < len(results); i++ {
+				if results[i].Score > results[i-1].Score {
+					t.Errorf("results are not ordered by score: %+v", results)
+				}
+			}
+			if len(results) > tt.k {
+				t.Errorf("%d results, want at most %d", len(results), tt.k)
+			}
+		})
+	}
+}
+
+func TestFlatProjects(t *testing.T) {
+	dir := t.TempDir()
+	ix, entries := testIndex(t, dir)
+	ctx := context.Background()
+
+	results, err := ix.Search(ctx, entries["otherFlood"].ProjectID, "river flood", 10, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ChunkID != entries["otherFlood"].ChunkID {
+		t.Errorf("project B results = %+v", results)
+	}
+
+	results, err = ix.Search(ctx, uuid.New(), "river flood", 10, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Errorf("unknown project results = %+v", results)
+	}
+
+	// Projects are saved and loaded again by a new store
+	store, err := NewFlatStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded := New(NewHashEmbedder(512), store)
+	results, err = reloaded.Search(ctx, entries["flood"].ProjectID, "river flood", 10, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 || results[0].ChunkID != entries["flood"].ChunkID {
+		t.Errorf("reloaded results = %+v", results)
+	}
+
+	// Deleting a file removes only its entries
+	flood := entries["flood"]
+	if err := store.DeleteFile(ctx, flood.ProjectID, flood.FileID); err != nil {
+		t.Fatal(err)
+	}
+	results, err = reloaded.Search(ctx, flood.ProjectID, "river flood", 10, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.FileID == flood.FileID {
+			t.Errorf("deleted file found: %+v", r)
+		}
+	}
+	if len(results) != 2 {
+		t.Errorf("%d results after deleting a file, want 2", len(results))
+	}
+}
+
+func TestFlatDimensions(t *testing.T) {
+	store, err := NewFlatStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	projectID := uuid.New()
+
+	if err := store.Upsert(ctx, []Entry{{ChunkID: uuid.New(), ProjectID: projectID, Vector: []float32{1, 0, 0}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Upsert(ctx, []Entry{{ChunkID: uuid.New(), ProjectID: projectID, Vector: []float32{1, 0}}}); err != ErrDimensions {
+		t.Errorf("upsert error = %v, want ErrDimensions", err)
+	}
+	if _, err := store.Search(ctx, projectID, []float32{1, 0}, 1, Filter{}); err != ErrDimensions {
+		t.Errorf("search error = %v, want ErrDimensions", err)
+	}
+}
+
+func TestHashEmbedder(t *testing.T) {
+	e := NewHashEmbedder(64)
+	vectors, err := e.Embed(context.Background(), []string{"River flood", "river, FLOOD!", "budget", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dot(vectors[0], vectors[1]) < 0.999 {
+		t.Error("case and punctuation change the embedding")
+	}
+	if dot(vectors[0], vectors[0]) < 0.999 {
+		t.Error("embeddings are not unit length")
+	}
+	for _, x := range vectors[3] {
+		if x != 0 {
+			t.Fatal("empty text has a non-zero embedding")
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package vectorstore
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// PGVectorStore keeps embeddings in Postgres with the pgvector extension
+type PGVectorStore struct {
+	DB *sql.DB
+}
+
+// NewPGVectorStore enables the vector extension and creates the embeddings
+// table. It returns ErrUnavailable when the extension is not installed.
+func NewPGVectorStore(db *sql.DB) (*PGVectorStore, error) {
+	var available bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM pg_available_extensions WHERE name = 'vector')`).Scan(&available)
+	if err != nil {
+		return nil, err
+	}
+	if !available {
+		return nil, ErrUnavailable
+	}
+
+	stmts := []string{
+		`CREATE EXTENSION IF NOT EXISTS vector`,
+		`CREATE TABLE IF NOT EXISTS chunk_embeddings (
+			chunk_id UUID PRIMARY KEY,
+			file_id UUID NOT NULL,
+			project_id UUID NOT NULL,
+			embedding vector NOT NULL,
+			created TIMESTAMP NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_chunk_embeddings_project_id ON chunk_embeddings(project_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_chunk_embeddings_file_id ON chunk_embeddings(file_id)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, err
+		}
+	}
+
+	return &PGVectorStore{DB: db}, nil
+}
+
+func (s *PGVectorStore) Upsert(ctx context.Context, entries []Entry) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO chunk_embeddings (chunk_id, file_id, project_id, embedding, created)
+		VALUES ($1, $2, $3, $4::vector, NOW())
+		ON CONFLICT (chunk_id) DO UPDATE
+		SET file_id = EXCLUDED.file_id, project_id = EXCLUDED.project_id,
+			embedding = EXCLUDED.embedding, created = NOW()
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, e := range entries {
+		if _, err := stmt.ExecContext(ctx, e.ChunkID, e.FileID, e.ProjectID, vectorLiteral(e.Vector)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *PGVectorStore) DeleteFile(ctx context.Context, projectID, fileID uuid.UUID) error {
+	_, err := s.DB.ExecContext(ctx, `DELETE FROM chunk_embeddings WHERE project_id = $1 AND file_id = $2`, projectID, fileID)
+	return err
+}
+
+func (s *PGVectorStore) Search(ctx context.Context, projectID uuid.UUID, vector []float32, k int, filter Filter) ([]Result, error) {
+	// Vectors of another size cannot be compared, so they are skipped
+	stmt := `
+		SELECT chunk_id, file_id, 1 - (embedding <=> $2::vector) AS score
+		FROM chunk_embeddings
+		WHERE project_id = $1 AND vector_dims(embedding) = $3
+		  AND (cardinality($4::uuid[]) = 0 OR file_id = ANY($4::uuid[]))
+		ORDER BY embedding <=> $2::vector
+		LIMIT $5
+	`
+
+	fileIDs := make([]string, len(filter.FileIDs))
+	for i, id := range filter.FileIDs {
+		fileIDs[i] = id.String()
+	}
+
+	rows, err := s.DB.QueryContext(ctx, stmt, projectID, vectorLiteral(vector), len(vector), pq.Array(fileIDs), k)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []Result{}
+	for rows.Next() {
+		var r Result
+		if err := rows.Scan(&r.ChunkID, &r.FileID, &r.Score); err != nil {
+			return nil, err
+		}
+		// Results are ordered by score, so the rest scores lower still
+		if r.Score < filter.MinScore {
+			break
+		}
+		results = append(results, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// vectorLiteral formats a vector as pgvector's text input, e.g. [1,2.5,3]
+func vectorLiteral(v []float32) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, x := range v {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(float64(x), 'g', -1, 32))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
@@ -0,0 +1,143 @@
+// Package vectorstore embeds document chunks and searches them by cosine
+// similarity, scoped to a project. Vectors are kept in a Store: a flat index
+// persisted to disk, or pgvector when the Postgres extension is installed.
+package vectorstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrUnavailable is returned when a store's backend cannot be used
+	ErrUnavailable = errors.New("vectorstore: backend unavailable")
+
+	// ErrDimensions is returned when vectors of different sizes are mixed
+	ErrDimensions = errors.New("vectorstore: vector dimensions do not match")
+)
+
+// Number of texts sent to the embedder in one call
+const batchSize = 32
+
+// Entry is the embedding of one document chunk
+type Entry struct {
+	ChunkID   uuid.UUID
+	FileID    uuid.UUID
+	ProjectID uuid.UUID
+	Vector    []float32
+}
+
+// Filter narrows a search
+type Filter struct {
+	// FileIDs limits the search to these files when not empty
+	FileIDs []uuid.UUID
+	// MinScore drops results less similar than this
+	MinScore float32
+}
+
+func (f Filter) matchFile(fileID uuid.UUID) bool {
+	if len(f.FileIDs) == 0 {
+		return true
+	}
+	for _, id := range f.FileIDs {
+		if id == fileID {
+			return true
+		}
+	}
+	return false
+}
+
+// Result is a chunk found by a search, Score is its cosine similarity
+type Result struct {
+	ChunkID uuid.UUID
+	FileID  uuid.UUID
+	Score   float32
+}
+
+// Store keeps embeddings and finds the nearest ones
+type Store interface {
+	// Upsert adds entries, replacing entries with the same chunk ID
+	Upsert(ctx context.Context, entries []Entry) error
+	// DeleteFile removes every entry of a file
+	DeleteFile(ctx context.Context, projectID, fileID uuid.UUID) error
+	// Search returns the k entries of a project most similar to vector
+	Search(ctx context.Context, projectID uuid.UUID, vector []float32, k int, filter Filter) ([]Result, error)
+}
+
+// Index embeds texts with an Embedder and keeps them in a Store
+type Index struct {
+	Embedder Embedder
+	Store    Store
+}
+
+func New(embedder Embedder, store Store) *Index {
+	return &Index{Embedder: embedder, Store: store}
+}
+
+// Add embeds the texts of entries, in batches, and stores them. texts[i] is
+// the text of entries[i].
+func (ix *Index) Add(ctx context.Context, entries []Entry, texts []string) error {
+	if len(entries) != len(texts) {
+		return fmt.Errorf("vectorstore: %d entries but %d texts", len(entries), len(texts))
+	}
+
+	for start := 0; start < len(entries); start += batchSize {
+		end := min(start+batchSize, len(entries))
+
+		vectors, err := ix.Embedder.Embed(ctx, texts[start:end])
+		if err != nil {
+			return err
+		}
+		if len(vectors) != end-start {
+			return fmt.Errorf("vectorstore: embedder returned %d vectors for %d texts", len(vectors), end-start)
+		}
+		for i, v := range vectors {
+			entries[start+i].Vector = v
+		}
+	}
+
+	return ix.Store.Upsert(ctx, entries)
+}
+
+// Search embeds the query and returns the k most similar chunks of a project
+func (ix *Index) Search(ctx context.Context, projectID uuid.UUID, query string, k int, filter Filter) ([]Result, error) {
+	vectors, err := ix.Embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("vectorstore: embedder returned %d vectors for the query", len(vectors))
+	}
+
+	return ix.Store.Search(ctx, projectID, vectors[0], k, filter)
+}
+
+// normalize scales a vector to unit length so a dot product is its cosine
+// similarity
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	out := make([]float32, len(v))
+	if sum == 0 {
+		return out
+	}
+	scale := float32(1 / math.Sqrt(sum))
+	for i, x := range v {
+		out[i] = x * scale
+	}
+	return out
+}
+
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}